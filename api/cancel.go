@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/stats"
+)
+
+// queries run with a context that is cancelled when the client disconnects, or once they exceed the query-timeout.
+// the context is passed down to the reads from the cache and store, and to the requests to peers, which are aborted
+// once it is cancelled. peers in turn cancel their own reads when the requesting node closes the connection.
+// within a query, the pending reads and peer requests are cancelled as soon as one of them fails the query.
+
+// StatusClientClosedRequest is the status of requests whose client disconnected before the response was written
+const StatusClientClosedRequest = 499
+
+var (
+	// metric api.request.render.timeout is the number of /render requests that were cancelled because they exceeded the query-timeout
+	reqRenderTimeout = stats.NewCounter32("api.request.render.timeout")
+	// metric api.request.render.canceled is the number of /render requests that were cancelled because the client disconnected
+	reqRenderCanceled = stats.NewCounter32("api.request.render.canceled")
+)
+
+// withQueryTimeout returns a context for a query that is cancelled once the query-timeout is exceeded
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if queryTimeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
+// queryError returns the error to respond with for a failed query.
+// queries that were cancelled fail with the cause of the cancellation, rather than with whichever error their reads returned.
+func queryError(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		reqRenderTimeout.Inc()
+		return response.NewError(http.StatusGatewayTimeout, fmt.Sprintf("query exceeded the timeout of %s", queryTimeout))
+	case context.Canceled:
+		reqRenderCanceled.Inc()
+		return response.NewError(StatusClientClosedRequest, "client closed the request")
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/test"
+)
+
+func TestGetTargetsLocalCanceled(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	srv, _ := NewServer()
+	store := mdata.NewMockStore()
+	srv.BindBackendStore(store)
+	srv.BindMemoryStore(mdata.NewAggMetrics(store, &cache.MockCache{}, false, 0, 0, 0))
+	srv.BindCache(cache.NewCCache())
+
+	ctx, cancel := context.WithCancel(test.NewContext())
+	cancel()
+	var reqs []models.Req
+	for _, name := range []string{"foo", "bar"} {
+		req := models.NewReq(name, name, name, 0, 100, 1000, 10, consolidation.Avg, 0, cluster.Manager.ThisNode(), 0, 0)
+		req.ArchInterval = 10
+		reqs = append(reqs, req)
+	}
+	// the series are not in memory, so they would be read from the store
+	series, err := srv.getTargetsLocal(ctx, reqs)
+	if err != context.Canceled || len(series) != 0 {
+		t.Fatalf("expected the reads to be cancelled, got %d series and error %v", len(series), err)
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	defer func(timeout time.Duration) { queryTimeout = timeout }(queryTimeout)
+	queryTimeout = time.Millisecond
+	errRead := errors.New("read failed")
+
+	ctx, cancel := withQueryTimeout(context.Background())
+	defer cancel()
+	if err := queryError(ctx, errRead); err != errRead {
+		t.Fatalf("expected the error of a query that was not cancelled to be returned as is, got %v", err)
+	}
+	<-ctx.Done()
+	if err := queryError(ctx, errRead); response.WrapError(err).Code() != 504 {
+		t.Fatalf("expected a query that timed out to fail with a 504, got %v", err)
+	}
+
+	ctx, cancel = withQueryTimeout(context.Background())
+	cancel()
+	if err := queryError(ctx, errRead); response.WrapError(err).Code() != StatusClientClosedRequest {
+		t.Fatalf("expected a cancelled query to fail with a %d, got %v", StatusClientClosedRequest, err)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/api/response"
 	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/expr"
 	"github.com/raintank/worldping-api/pkg/log"
 	"github.com/tinylib/msgp/msgp"
 )
@@ -29,6 +31,10 @@ func (s *Server) setNodeStatus(ctx *middleware.Context, status models.NodeStatus
 		)
 		return
 	}
+	if cluster.PrimaryElection() {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, "the primary is elected automatically, see cluster.primary-election"))
+		return
+	}
 	cluster.Manager.SetPrimary(primary)
 	ctx.PlainText(200, []byte("OK"))
 }
@@ -129,15 +135,77 @@ func (s *Server) indexList(ctx *middleware.Context, req models.IndexList) {
 }
 
 func (s *Server) getData(ctx *middleware.Context, request models.GetData) {
+	if stream := cluster.NewStreamWriter(ctx.Resp, ctx.Req.Request); stream != nil {
+		s.streamData(ctx.Req.Context(), stream, request.Requests)
+		return
+	}
 	series, err := s.getTargetsLocal(ctx.Req.Context(), request.Requests)
 	if err != nil {
+		if ctx.Req.Context().Err() != nil {
+			// the requesting node cancelled the request. nobody reads the response
+			log.Debug("HTTP getData() cancelled: %s", err.Error())
+			return
+		}
 		// the only errors returned are from us catching panics, so we should treat them
 		// all as internalServerErrors
 		log.Error(3, "HTTP getData() %s", err.Error())
 		response.Write(ctx, response.WrapError(err))
 		return
 	}
-	response.Write(ctx, response.NewMsgp(200, &models.GetDataResp{Series: series}))
+	series, partials := aggregateLocal(request.Requests, series)
+	response.Write(ctx, response.NewMsgp(200, &models.GetDataResp{Series: series, Partials: partials}))
+}
+
+// streamData streams the series of the requests to the requesting node as they are read, one per frame.
+// the series of requests with an Aggregate are sent last, as partial aggregates. see pushdown.go
+func (s *Server) streamData(ctx context.Context, stream *cluster.StreamWriter, reqs []models.Req) {
+	aggs := localAggregates(reqs)
+	var aggregated []models.Series
+	var writeErr error
+	err := s.streamTargetsLocal(ctx, reqs, func(series models.Series) {
+		if _, ok := aggs[expr.NewReq(series.QueryPatt, series.QueryFrom, series.QueryTo, series.QueryCons)]; ok {
+			aggregated = append(aggregated, series)
+			return
+		}
+		if writeErr == nil {
+			writeErr = stream.Write(&models.GetDataResp{Series: []models.Series{series}})
+		}
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			// the requesting node cancelled the request. nobody reads the stream
+			log.Debug("HTTP getData() cancelled: %s", err.Error())
+			return
+		}
+		// the only errors returned are from us catching panics
+		log.Error(3, "HTTP getData() %s", err.Error())
+		stream.Error(err)
+		return
+	}
+	if len(aggregated) > 0 && writeErr == nil {
+		series, partials := aggregateLocal(reqs, aggregated)
+		writeErr = stream.Write(&models.GetDataResp{Series: series, Partials: partials})
+	}
+	if writeErr == nil {
+		writeErr = stream.Close()
+	}
+	if writeErr != nil {
+		log.Warn("HTTP getData() failed to stream the response: %s", writeErr)
+	}
+}
+
+func (s *Server) indexDeleteIds(ctx *middleware.Context, req models.IndexDeleteIds) {
+	defs := s.MetricIndex.DeleteIds(req.OrgId, req.Ids)
+	resp := models.MetricsDeleteResp{
+		DeletedDefs: len(defs),
+	}
+	response.Write(ctx, response.NewMsgp(200, &resp))
+}
+
+// indexAdd adds the given definitions to the index, if they belong to a partition we consume
+func (s *Server) indexAdd(ctx *middleware.Context, req models.IndexAdd) {
+	s.indexAddLocal(req.OrgId, req.Defs)
+	ctx.PlainText(200, []byte("OK"))
 }
 
 func (s *Server) indexDelete(ctx *middleware.Context, req models.IndexDelete) {
@@ -147,6 +215,9 @@ func (s *Server) indexDelete(ctx *middleware.Context, req models.IndexDelete) {
 		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
 		return
 	}
+	if req.Purge {
+		s.purgeLocal(req.JobId, req.OrgId, defs)
+	}
 
 	resp := models.MetricsDeleteResp{
 		DeletedDefs: len(defs),
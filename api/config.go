@@ -15,6 +15,7 @@ import (
 var (
 	maxPointsPerReqSoft int
 	maxPointsPerReqHard int
+	maxFindResults      int
 	logMinDurStr        string
 	logMinDur           uint32
 
@@ -27,6 +28,19 @@ var (
 	fallbackGraphite string
 	timeZoneStr      string
 
+	partialResultsEnabled bool
+	aggregationPushDown   bool
+	queryTimeout          time.Duration
+
+	maxConcurrentRenders       int
+	maxConcurrentRendersPerOrg int
+	maxConcurrentFetches       int
+	maxConcurrentFetchesPerOrg int
+	maxQueueWait               time.Duration
+
+	renderCacheSize   int
+	renderCacheMaxAge time.Duration
+
 	graphiteProxy *httputil.ReverseProxy
 	timeZone      *time.Location
 )
@@ -35,6 +49,7 @@ func ConfigSetup() {
 	apiCfg := flag.NewFlagSet("http", flag.ExitOnError)
 	apiCfg.IntVar(&maxPointsPerReqSoft, "max-points-per-req-soft", 1000000, "lower resolution rollups will be used to try and keep requests below this number of datapoints. (0 disables limit)")
 	apiCfg.IntVar(&maxPointsPerReqHard, "max-points-per-req-hard", 20000000, "limit of number of datapoints a request can return. Requests that exceed this limit will be rejected. (0 disables limit)")
+	apiCfg.IntVar(&maxFindResults, "max-find-results", 0, "limit of number of nodes a /metrics/find or /metrics/expand request can return. Requests that exceed this limit will be rejected. (0 disables limit)")
 	apiCfg.StringVar(&logMinDurStr, "log-min-dur", "5min", "only log incoming requests if their timerange is at least this duration. Use 0 to disable")
 
 	apiCfg.StringVar(&Addr, "listen", ":6060", "http listener address.")
@@ -44,6 +59,16 @@ func ConfigSetup() {
 	apiCfg.StringVar(&keyFile, "key-file", "", "SSL key file")
 	apiCfg.BoolVar(&multiTenant, "multi-tenant", true, "require x-org-id authentication to auth as a specific org. otherwise orgId 1 is assumed")
 	apiCfg.StringVar(&fallbackGraphite, "fallback-graphite-addr", "http://localhost:8080", "in case our /render endpoint does not support the requested processing, proxy the request to this graphite")
+	apiCfg.BoolVar(&partialResultsEnabled, "partial-results", false, "return the data that is available for /render requests when shards or peers are unavailable, rather than failing them. requests can override this with the partial parameter")
+	apiCfg.BoolVar(&aggregationPushDown, "aggregation-push-down", true, "have peers aggregate their series for functions like sumSeries, and return only the partial aggregate rather than all series")
+	apiCfg.IntVar(&maxConcurrentRenders, "max-concurrent-renders", 0, "max number of /render requests fetching data at once. others wait in a queue where the smallest go first. (0 disables limit)")
+	apiCfg.IntVar(&maxConcurrentRendersPerOrg, "max-concurrent-renders-per-org", 0, "max number of /render requests of an org fetching data at once. (0 disables limit)")
+	apiCfg.IntVar(&maxConcurrentFetches, "max-concurrent-fetches", 0, "max number of series being fetched at once, for local and peer requests. others wait in a queue where the series of the smallest requests go first. (0 disables limit)")
+	apiCfg.IntVar(&maxConcurrentFetchesPerOrg, "max-concurrent-fetches-per-org", 0, "max number of series of an org being fetched at once. (0 disables limit)")
+	apiCfg.DurationVar(&maxQueueWait, "max-queue-wait", time.Second*5, "how long /render requests and series fetches may wait in the queue before they are rejected with a http 429 too many requests")
+	apiCfg.IntVar(&renderCacheSize, "render-cache-size", 0, "max number of /render results to cache for repeated requests, such as from dashboards. results are served from the cache for as long as their finest interval. (0 disables the cache)")
+	apiCfg.DurationVar(&renderCacheMaxAge, "render-cache-max-age", time.Minute*10, "how long cached /render results are kept to only compute the tail of later requests whose time range slid forward. data that arrives later than one interval is only seen by requests once the results they are built on are older than this")
+	apiCfg.DurationVar(&queryTimeout, "query-timeout", time.Second*60, "how long /render requests may take before they are cancelled, along with their pending reads and peer requests, and fail with a http 504 gateway timeout. (0 disables the timeout)")
 	apiCfg.StringVar(&timeZoneStr, "time-zone", "local", "timezone for interpreting from/until values when needed, specified using [zoneinfo name](https://en.wikipedia.org/wiki/Tz_database#Names_of_time_zones) e.g. 'America/New_York', 'UTC' or 'local' to use local server timezone")
 	globalconf.Register("http", apiCfg)
 }
@@ -63,6 +88,10 @@ func ConfigProcess() {
 	}
 	graphiteProxy = NewGraphiteProxy(u)
 
+	renderScheduler.setLimits(maxConcurrentRenders, maxConcurrentRendersPerOrg, maxQueueWait)
+	fetchScheduler.setLimits(maxConcurrentFetches, maxConcurrentFetchesPerOrg, maxQueueWait)
+	renderCache.setLimits(renderCacheSize, renderCacheMaxAge)
+
 	if timeZoneStr == "local" {
 		timeZone = time.Local
 	} else {
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/api/middleware"
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/cluster/partitioner"
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/stats"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/worldping-api/pkg/log"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// metric api.copy.series is the number of series whose data has been copied to a new name
+var copiedSeries = stats.NewCounter32("api.copy.series")
+
+// seriesCopy describes the copy of an existing series to a new name
+type seriesCopy struct {
+	src idx.Archive
+	dst schema.MetricDefinition
+}
+
+// copyPlan determines the new definitions of the given series, by replacing all matches of re
+// in their name with replacement. series of other orgs and series whose name doesn't change are skipped.
+// the partitions of the new definitions are determined by part, out of numPartitions.
+// if part is nil, they keep the partition of the original definitions.
+func copyPlan(series []Series, orgId int, re *regexp.Regexp, replacement string, part partitioner.Partitioner, numPartitions int32) ([]seriesCopy, error) {
+	plan := make([]seriesCopy, 0)
+	seen := make(map[string]struct{})
+	dstIds := make(map[string]string)
+	for _, s := range series {
+		for _, node := range s.Series {
+			for _, def := range node.Defs {
+				if def.OrgId != orgId {
+					continue
+				}
+				if _, ok := seen[def.Id]; ok {
+					continue
+				}
+				seen[def.Id] = struct{}{}
+				name := re.ReplaceAllString(def.Name, replacement)
+				if name == def.Name {
+					continue
+				}
+				dst := def.MetricDefinition
+				dst.Name = name
+				dst.Metric = re.ReplaceAllString(def.Metric, replacement)
+				dst.Tags = append([]string(nil), def.Tags...)
+				dst.SetId()
+				if part != nil {
+					partition, err := part.Partition(&dst, numPartitions)
+					if err != nil {
+						return nil, err
+					}
+					dst.Partition = partition
+				}
+				if other, ok := dstIds[dst.Id]; ok {
+					return nil, fmt.Errorf("series %s and %s would both be copied to %s", other, def.Name, dst.Name)
+				}
+				dstIds[dst.Id] = def.Name
+				plan = append(plan, seriesCopy{def, dst})
+			}
+		}
+	}
+	return plan, nil
+}
+
+// metricsCopy copies the series matching the query to new names.
+// the new series are added to the index right away, their data is copied in a background job.
+// with req.Delete, the original series are deleted once copied, effectively renaming them.
+func (s *Server) metricsCopy(ctx *middleware.Context, req models.MetricsCopy) {
+	re, err := regexp.Compile(req.Pattern)
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("invalid pattern: %s", err)))
+		return
+	}
+	series, err := s.findSeries(ctx.Req.Context(), ctx.OrgId, []string{req.Query}, 0)
+	if err != nil {
+		response.Write(ctx, response.WrapError(err))
+		return
+	}
+	// with byOrg, the new names are in the partition of the original ones
+	scheme := req.PartitionScheme
+	if scheme == "" {
+		scheme = "byOrg"
+	}
+	var part partitioner.Partitioner
+	if scheme != "byOrg" || req.NumPartitions > 0 {
+		if req.NumPartitions <= 0 {
+			response.Write(ctx, response.NewError(http.StatusBadRequest, "numPartitions must be specified to partition the new names"))
+			return
+		}
+		part, err = partitioner.NewKafka(scheme)
+		if err != nil {
+			response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+	}
+	plan, err := copyPlan(series, ctx.OrgId, re, req.Replacement, part, req.NumPartitions)
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if err := checkFindLimit(len(plan)); err != nil {
+		response.Write(ctx, err)
+		return
+	}
+
+	resp := models.MetricsCopyResp{
+		Series: make([]models.SeriesCopy, 0, len(plan)),
+	}
+	defs := make([]schema.MetricDefinition, 0, len(plan))
+	for _, c := range plan {
+		resp.Series = append(resp.Series, models.SeriesCopy{
+			From:   c.src.Name,
+			FromId: c.src.Id,
+			To:     c.dst.Name,
+			ToId:   c.dst.Id,
+		})
+		defs = append(defs, c.dst)
+	}
+	if req.DryRun || len(plan) == 0 {
+		response.Write(ctx, response.NewJson(200, resp, ""))
+		return
+	}
+
+	log.Debug("HTTP metricsCopy copying %d series matching %q", len(plan), req.Query)
+	if err := s.indexAddCluster(ctx.Req.Context(), ctx.OrgId, defs); err != nil {
+		response.Write(ctx, response.WrapError(err))
+		return
+	}
+	resp.JobId = newJobId()
+	s.copyLocal(resp.JobId, ctx.OrgId, plan, req.Delete)
+	response.Write(ctx, response.NewJson(200, resp, ""))
+}
+
+// indexAddCluster adds the given definitions to the index of all cluster nodes
+func (s *Server) indexAddCluster(ctx context.Context, orgId int, defs []schema.MetricDefinition) error {
+	peers := cluster.Manager.MemberList()
+	peers = append(peers, cluster.Manager.ThisNode())
+	errors := make([]error, 0)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		if peer.IsLocal() {
+			s.indexAddLocal(orgId, defs)
+			continue
+		}
+		wg.Add(1)
+		go func(peer cluster.Node) {
+			_, err := peer.Post(ctx, "indexAddCluster", "/index/add", models.IndexAdd{OrgId: orgId, Defs: defs})
+			if err != nil {
+				log.Error(4, "HTTP metricsCopy error querying %s/index/add: %q", peer.Name, err)
+				mu.Lock()
+				errors = append(errors, err)
+				mu.Unlock()
+			}
+			wg.Done()
+		}(peer)
+	}
+	wg.Wait()
+	if len(errors) > 0 {
+		return errors[0]
+	}
+	return nil
+}
+
+// indexAddLocal adds the definitions that belong to a partition we consume to the index.
+// it returns the number of definitions added.
+func (s *Server) indexAddLocal(orgId int, defs []schema.MetricDefinition) int {
+	partitions := make(map[int32]struct{})
+	for _, p := range cluster.Manager.GetPartitions() {
+		partitions[p] = struct{}{}
+	}
+	added := 0
+	for _, def := range defs {
+		if def.OrgId != orgId {
+			continue
+		}
+		if _, ok := partitions[def.Partition]; !ok {
+			continue
+		}
+		md := schema.MetricData{
+			Id:       def.Id,
+			OrgId:    def.OrgId,
+			Name:     def.Name,
+			Metric:   def.Metric,
+			Interval: def.Interval,
+			Unit:     def.Unit,
+			Time:     def.LastUpdate,
+			Mtype:    def.Mtype,
+			Tags:     def.Tags,
+		}
+		s.MetricIndex.AddOrUpdate(&md, def.Partition)
+		added++
+	}
+	return added
+}
+
+// copyLocal starts a job that copies the raw and rollup chunks of the series in the plan
+// to the keys of their new definitions. if del is set, the original series are then deleted
+// from the index of all cluster nodes, and their chunks from the backend store.
+func (s *Server) copyLocal(jobId string, orgId int, plan []seriesCopy, del bool) {
+	j := newJob(jobId, "copy", orgId, len(plan))
+	jobs.Add(j)
+	go func() {
+		// the store expects a span in the context
+		span := s.Tracer.StartSpan("copy job")
+		span.SetTag("job", jobId)
+		defer span.Finish()
+		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		for _, c := range plan {
+			err := s.copySeries(ctx, orgId, c, del)
+			if err == nil {
+				copiedSeries.Inc()
+			}
+			j.progress(err)
+		}
+		j.finish()
+		log.Info("HTTP copy: job %s finished copying %d series", jobId, len(plan))
+	}()
+}
+
+// copyWriteTimeout is how long a copy job waits for the copied chunks to be saved, before it gives up on deleting the original series
+var copyWriteTimeout = 10 * time.Minute
+
+func (s *Server) copySeries(ctx context.Context, orgId int, c seriesCopy, del bool) error {
+	schemaId, _ := mdata.MatchSchema(c.dst.Name, c.dst.Interval)
+	aggId, _ := mdata.MatchAgg(c.dst.Name, c.dst.Mtype)
+
+	// raw and rollup keys are matched up by their suffix, e.g. "_sum_600".
+	// archives that only exist for one of both names are not copied.
+	dstKeys := make(map[string]mdata.StoreKey)
+	for _, key := range mdata.StoreKeys(c.dst.Id, schemaId, aggId) {
+		dstKeys[strings.TrimPrefix(key.Key, c.dst.Id)] = key
+	}
+	srcKeys := mdata.StoreKeys(c.src.Id, c.src.SchemaId, c.src.AggId)
+	copied := make(map[string][]uint32)
+	for _, src := range srcKeys {
+		dst, ok := dstKeys[strings.TrimPrefix(src.Key, c.src.Id)]
+		if !ok {
+			continue
+		}
+		t0s, end, err := mdata.CopyChunks(ctx, s.BackendStore, src, dst)
+		if err != nil {
+			log.Error(3, "HTTP copy: failed to copy %s to %s: %s", src.Key, dst.Key, err)
+			return fmt.Errorf("failed to copy %s to %s: %s", c.src.Id, c.dst.Id, err)
+		}
+		// the points that are still held in memory were not copied. they are only saved once their chunk is closed,
+		// so the series can't be deleted before then. see chunk-max-stale
+		if del && end <= src.LastPoint(uint32(c.src.LastUpdate)) {
+			return fmt.Errorf("not deleting %s: its latest data is not saved yet, retry once it is", c.src.Id)
+		}
+		copied[dst.Key] = t0s
+	}
+	if !del {
+		return nil
+	}
+
+	// the copied chunks are only queued for saving, the originals are only deleted once they are saved.
+	for _, dst := range dstKeys {
+		t0s, ok := copied[dst.Key]
+		if !ok {
+			continue
+		}
+		if err := mdata.WaitForChunks(ctx, s.BackendStore, dst, t0s, copyWriteTimeout); err != nil {
+			log.Error(3, "HTTP copy: not deleting %s: %s", c.src.Id, err)
+			return fmt.Errorf("not deleting %s: %s", c.src.Id, err)
+		}
+	}
+	if err := s.indexDeleteCluster(ctx, orgId, []string{c.src.Id}); err != nil {
+		return fmt.Errorf("failed to delete %s from the index: %s", c.src.Id, err)
+	}
+	for _, src := range srcKeys {
+		if err := s.BackendStore.Delete(ctx, src.Key, src.TTL); err != nil {
+			log.Error(3, "HTTP copy: failed to delete %s from the store: %s", src.Key, err)
+			return fmt.Errorf("failed to delete %s: %s", c.src.Id, err)
+		}
+	}
+	return nil
+}
+
+// indexDeleteCluster deletes the definitions with the given ids from the index of all cluster nodes
+func (s *Server) indexDeleteCluster(ctx context.Context, orgId int, ids []string) error {
+	peers := cluster.Manager.MemberList()
+	peers = append(peers, cluster.Manager.ThisNode())
+	for _, peer := range peers {
+		if peer.IsLocal() {
+			s.MetricIndex.DeleteIds(orgId, ids)
+			continue
+		}
+		_, err := peer.Post(ctx, "indexDeleteCluster", "/index/delete-ids", models.IndexDeleteIds{OrgId: orgId, Ids: ids})
+		if err != nil {
+			log.Error(4, "HTTP copy error querying %s/index/delete-ids: %q", peer.Name, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/grafana/metrictank/cluster/partitioner"
+	"github.com/grafana/metrictank/idx"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func testArchive(orgId int, name string) idx.Archive {
+	def := schema.MetricDefinition{
+		OrgId:    orgId,
+		Name:     name,
+		Metric:   name,
+		Interval: 10,
+		Mtype:    "gauge",
+	}
+	def.SetId()
+	return idx.Archive{MetricDefinition: def}
+}
+
+func TestCopyPlan(t *testing.T) {
+	fooA := testArchive(1, "foo.a")
+	fooB := testArchive(1, "foo.b")
+	barA := testArchive(1, "bar.a")
+	public := testArchive(-1, "foo.c")
+	series := []Series{
+		{
+			Pattern: "*.*",
+			Series: []idx.Node{
+				{Path: "foo.a", Leaf: true, Defs: []idx.Archive{fooA}},
+				{Path: "bar.a", Leaf: true, Defs: []idx.Archive{barA}},
+				{Path: "foo.c", Leaf: true, Defs: []idx.Archive{public}},
+			},
+		},
+		{
+			// as found on another cluster node
+			Pattern: "*.*",
+			Series: []idx.Node{
+				{Path: "foo.a", Leaf: true, Defs: []idx.Archive{fooA}},
+				{Path: "foo.b", Leaf: true, Defs: []idx.Archive{fooB}},
+			},
+		},
+	}
+
+	plan, err := copyPlan(series, 1, regexp.MustCompile(`^foo\.(.*)$`), "baz.$1", nil, 0)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	exp := map[string]string{
+		"foo.a": "baz.a",
+		"foo.b": "baz.b",
+	}
+	if len(plan) != len(exp) {
+		t.Fatalf("expected %d copies, got %d: %v", len(exp), len(plan), plan)
+	}
+	for _, c := range plan {
+		if exp[c.src.Name] != c.dst.Name || c.dst.Metric != c.dst.Name {
+			t.Fatalf("expected %s to be copied to %s, got name %s metric %s", c.src.Name, exp[c.src.Name], c.dst.Name, c.dst.Metric)
+		}
+		expDef := testArchive(1, c.dst.Name)
+		if c.dst.Id != expDef.Id {
+			t.Fatalf("expected copy of %s to have id %s, got %s", c.src.Name, expDef.Id, c.dst.Id)
+		}
+	}
+
+	_, err = copyPlan(series, 1, regexp.MustCompile(`^(foo|bar)\.`), "baz.", nil, 0)
+	if err == nil {
+		t.Fatalf("expected error when two series are copied to the same name")
+	}
+}
+
+func TestCopyPlanPartition(t *testing.T) {
+	fooA := testArchive(1, "foo.a")
+	fooA.Partition = 3
+	series := []Series{{Pattern: "foo.a", Series: []idx.Node{{Path: "foo.a", Leaf: true, Defs: []idx.Archive{fooA}}}}}
+	part, _ := partitioner.NewKafka("bySeries")
+
+	plan, err := copyPlan(series, 1, regexp.MustCompile(`^foo\.`), "bar.", part, 8)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	exp, _ := part.Partition(&plan[0].dst, 8)
+	if plan[0].dst.Partition != exp {
+		t.Fatalf("expected the copy to be in partition %d of its new name, got %d", exp, plan[0].dst.Partition)
+	}
+
+	plan, _ = copyPlan(series, 1, regexp.MustCompile(`^foo\.`), "bar.", nil, 0)
+	if plan[0].dst.Partition != 3 {
+		t.Fatalf("expected the copy to keep the partition of the original, got %d", plan[0].dst.Partition)
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"runtime"
 	"sync"
 	"time"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/sketch"
 	"github.com/grafana/metrictank/tracing"
 	"github.com/grafana/metrictank/util"
 	opentracing "github.com/opentracing/opentracing-go"
@@ -112,7 +115,12 @@ func divide(pointsA, pointsB []schema.Point) []schema.Point {
 	return pointsA
 }
 
-func (s *Server) getTargets(ctx context.Context, reqs []models.Req) ([]models.Series, error) {
+// peers may return partial aggregates for requests with an Aggregate, rather than their series. see pushdown.go
+// once the local or remote requests fail, the others are cancelled.
+func (s *Server) getTargets(ctx context.Context, reqs []models.Req) ([]models.Series, []models.PartialSeries, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// split reqs into local and remote.
 	localReqs := make([]models.Req, 0)
 	remoteReqs := make(map[string][]models.Req)
@@ -128,6 +136,7 @@ func (s *Server) getTargets(ctx context.Context, reqs []models.Req) ([]models.Se
 	var wg sync.WaitGroup
 
 	out := make([]models.Series, 0)
+	var partials []models.PartialSeries
 	errs := make([]error, 0)
 
 	if len(localReqs) > 0 {
@@ -139,6 +148,7 @@ func (s *Server) getTargets(ctx context.Context, reqs []models.Req) ([]models.Se
 			mu.Lock()
 			if err != nil {
 				errs = append(errs, err)
+				cancel()
 			}
 			if len(series) > 0 {
 				out = append(out, series...)
@@ -151,14 +161,16 @@ func (s *Server) getTargets(ctx context.Context, reqs []models.Req) ([]models.Se
 		wg.Add(1)
 		go func() {
 			// all errors returned returned are *response.Error.
-			series, err := s.getTargetsRemote(ctx, remoteReqs)
+			series, remotePartials, err := s.getTargetsRemote(ctx, remoteReqs)
 			mu.Lock()
 			if err != nil {
 				errs = append(errs, err)
+				cancel()
 			}
 			if len(series) > 0 {
 				out = append(out, series...)
 			}
+			partials = remotePartials
 			mu.Unlock()
 			wg.Done()
 		}()
@@ -168,12 +180,17 @@ func (s *Server) getTargets(ctx context.Context, reqs []models.Req) ([]models.Se
 	if len(errs) > 0 {
 		err = errs[0]
 	}
-	log.Debug("DP getTargets: %d series found on cluster", len(out))
-	return out, err
+	log.Debug("DP getTargets: %d series and %d partial aggregates found on cluster", len(out), len(partials))
+	return out, partials, err
 }
 
-func (s *Server) getTargetsRemote(ctx context.Context, remoteReqs map[string][]models.Req) ([]models.Series, error) {
-	seriesChan := make(chan []models.Series, len(remoteReqs))
+// if the context allows partial results, peers that fail are skipped rather than failing the whole request. see partial.go
+// otherwise the requests to the other peers are cancelled once one fails. peers are never skipped once the context is cancelled.
+func (s *Server) getTargetsRemote(ctx context.Context, remoteReqs map[string][]models.Req) ([]models.Series, []models.PartialSeries, error) {
+	partial := partialResultsFrom(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	respChan := make(chan models.GetDataResp, len(remoteReqs))
 	errorsChan := make(chan error, len(remoteReqs))
 	wg := sync.WaitGroup{}
 	wg.Add(len(remoteReqs))
@@ -181,92 +198,149 @@ func (s *Server) getTargetsRemote(ctx context.Context, remoteReqs map[string][]m
 		log.Debug("DP getTargetsRemote: handling %d reqs from %s", len(nodeReqs), nodeReqs[0].Node.Name)
 		go func(ctx context.Context, reqs []models.Req) {
 			defer wg.Done()
-			node := reqs[0].Node
-			buf, err := node.Post(ctx, "getTargetsRemote", "/getdata", models.GetData{Requests: reqs})
-			if err != nil {
+			if err := ctx.Err(); err != nil {
 				errorsChan <- err
 				return
 			}
-			var resp models.GetDataResp
-			_, err = resp.UnmarshalMsg(buf)
+			// a replica may answer instead of the peer, when the peer is slow or fails
+			peer := reqs[0].Node
+			stream, node, err := cluster.PostStreamHedged(ctx, peer, "getTargetsRemote", "/getdata", models.GetData{Requests: reqs})
 			if err != nil {
-				log.Error(3, "DP getTargetsRemote: error unmarshaling body from %s/getdata: %q", node.Name, err)
+				if partial != nil && ctx.Err() == nil {
+					log.Warn("DP getTargetsRemote: skipping %s for partial results: %s", peer.Name, err)
+					partial.addFailedPeer(peer.Name)
+					return
+				}
+				errorsChan <- err
+				cancel()
+				return
+			}
+			defer stream.Close()
+			// the series are passed on as they are read, rather than once the peer sent all of them
+			var numSeries, numPartials int
+			for {
+				buf, err := stream.Next()
+				if err == io.EOF {
+					break
+				}
+				if err == nil {
+					var resp models.GetDataResp
+					_, err = resp.UnmarshalMsg(buf)
+					if err == nil {
+						numSeries += len(resp.Series)
+						numPartials += len(resp.Partials)
+						respChan <- resp
+						continue
+					}
+					log.Error(3, "DP getTargetsRemote: error unmarshaling body from %s/getdata: %q", node.Name, err)
+				}
+				if partial != nil && ctx.Err() == nil {
+					log.Warn("DP getTargetsRemote: skipping the rest of %s for partial results: %s", node.Name, err)
+					partial.addFailedPeer(node.Name)
+					return
+				}
 				errorsChan <- err
+				cancel()
 				return
 			}
-			log.Debug("DP getTargetsRemote: %s returned %d series", node.Name, len(resp.Series))
-			seriesChan <- resp.Series
+			log.Debug("DP getTargetsRemote: %s returned %d series and %d partial aggregates", node.Name, numSeries, numPartials)
 		}(ctx, nodeReqs)
 	}
 	go func() {
 		wg.Wait()
-		close(seriesChan)
+		close(respChan)
 		close(errorsChan)
 	}()
 	out := make([]models.Series, 0)
+	var partials []models.PartialSeries
 	var err error
-	for series := range seriesChan {
-		out = append(out, series...)
+	for resp := range respChan {
+		out = append(out, resp.Series...)
+		partials = append(partials, resp.Partials...)
 	}
-	log.Debug("DP getTargetsRemote: total of %d series found on peers", len(out))
+	log.Debug("DP getTargetsRemote: total of %d series and %d partial aggregates found on peers", len(out), len(partials))
 	for e := range errorsChan {
 		err = e
 		break
 	}
-	return out, err
+	return out, partials, err
 }
 
 // error is the error of the first failing target request
 func (s *Server) getTargetsLocal(ctx context.Context, reqs []models.Req) ([]models.Series, error) {
+	out := make([]models.Series, 0, len(reqs))
+	err := s.streamTargetsLocal(ctx, reqs, func(series models.Series) {
+		out = append(out, series)
+	})
+	log.Debug("DP getTargetsLocal: %d series found locally", len(out))
+	return out, err
+}
+
+// streamTargetsLocal calls fn for each series as soon as it is read. fn is not called concurrently.
+// error is the error of the first failing target request. the other target requests are cancelled once one fails.
+func (s *Server) streamTargetsLocal(ctx context.Context, reqs []models.Req, fn func(models.Series)) error {
 	log.Debug("DP getTargetsLocal: handling %d reqs locally", len(reqs))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	seriesChan := make(chan models.Series, len(reqs))
 	errorsChan := make(chan error, len(reqs))
-	// TODO: abort pending requests on error, maybe use context, maybe timeouts too
-	wg := sync.WaitGroup{}
-	wg.Add(len(reqs))
-	for _, req := range reqs {
-		go func(ctx context.Context, wg *sync.WaitGroup, req models.Req) {
-			ctx, span := tracing.NewSpan(ctx, s.Tracer, "getTargetsLocal")
-			req.Trace(span)
-			defer span.Finish()
-			pre := time.Now()
-			points, interval, err := s.getTarget(ctx, req)
+	go func() {
+		wg := sync.WaitGroup{}
+		for _, req := range reqs {
+			// the fetches of all queries are admitted by the fetch scheduler, the fetches of the smallest queries first.
+			// see scheduler.go
+			org := orgFromKey(req.Key)
+			err := ctx.Err()
+			if err == nil {
+				err = fetchScheduler.acquire(ctx, org, len(reqs))
+			}
 			if err != nil {
-				tags.Error.Set(span, true)
 				errorsChan <- err
-			} else {
-				getTargetDuration.Value(time.Now().Sub(pre))
-				seriesChan <- models.Series{
-					Target:       req.Target, // always simply the metric name from index
-					Datapoints:   points,
-					Interval:     interval,
-					QueryPatt:    req.Pattern, // foo.* or foo.bar whatever the etName arg was
-					QueryFrom:    req.From,
-					QueryTo:      req.To,
-					QueryCons:    req.ConsReq,
-					Consolidator: req.Consolidator,
-				}
+				cancel()
+				break
 			}
-			wg.Done()
-		}(ctx, &wg, req)
-	}
-	go func() {
+			wg.Add(1)
+			go func(ctx context.Context, wg *sync.WaitGroup, req models.Req) {
+				defer fetchScheduler.release(org)
+				ctx, span := tracing.NewSpan(ctx, s.Tracer, "getTargetsLocal")
+				req.Trace(span)
+				defer span.Finish()
+				pre := time.Now()
+				points, interval, err := s.getTarget(ctx, req)
+				if err != nil {
+					tags.Error.Set(span, true)
+					errorsChan <- err
+					cancel()
+				} else {
+					getTargetDuration.Value(time.Now().Sub(pre))
+					seriesChan <- models.Series{
+						Target:       req.Target, // always simply the metric name from index
+						Datapoints:   points,
+						Interval:     interval,
+						QueryPatt:    req.Pattern, // foo.* or foo.bar whatever the etName arg was
+						QueryFrom:    req.From,
+						QueryTo:      req.To,
+						QueryCons:    req.ConsReq,
+						Consolidator: req.Consolidator,
+						XFilesFactor: mdata.Aggregations.Get(req.AggId).XFilesFactor,
+					}
+				}
+				wg.Done()
+			}(ctx, &wg, req)
+		}
 		wg.Wait()
 		close(seriesChan)
 		close(errorsChan)
 	}()
-	out := make([]models.Series, 0, len(reqs))
 	var err error
 	for series := range seriesChan {
-		out = append(out, series)
+		fn(series)
 	}
-	log.Debug("DP getTargetsLocal: %d series found locally", len(out))
 	for e := range errorsChan {
 		err = e
 		break
 	}
-	return out, err
-
+	return err
 }
 
 func (s *Server) getTarget(ctx context.Context, req models.Req) (points []schema.Point, interval uint32, err error) {
@@ -284,10 +358,28 @@ func (s *Server) getTarget(ctx context.Context, req models.Req) (points []schema
 		}
 	}
 
+	// rollups honor xFilesFactor when they are generated. runtime consolidation honors it as well,
+	// whether it consolidates raw or rollup points.
+	xFilesFactor := mdata.Aggregations.Get(req.AggId).XFilesFactor
+
+	if readRollup && req.Consolidator.IsPercentile() {
+		// percentile rollups can't be consolidated from their points, only by merging their sketches
+		return s.getSeriesQuantiles(ctx, req, xFilesFactor), req.OutInterval, nil
+	}
+
+	if !readRollup && req.Consolidator == consolidation.Inc {
+		// the raw data are the values of the counter, rollups store its increase
+		points := consolidation.Increase(s.getSeriesFixed(ctx, req, consolidation.None))
+		if normalize {
+			points = consolidation.Consolidate(points, req.AggNum, consolidation.Inc, xFilesFactor)
+		}
+		return points, req.OutInterval, nil
+	}
+
 	if !readRollup && !normalize {
 		return s.getSeriesFixed(ctx, req, consolidation.None), req.OutInterval, nil
 	} else if !readRollup && normalize {
-		return consolidation.Consolidate(s.getSeriesFixed(ctx, req, consolidation.None), req.AggNum, req.Consolidator), req.OutInterval, nil
+		return consolidation.Consolidate(s.getSeriesFixed(ctx, req, consolidation.None), req.AggNum, req.Consolidator, xFilesFactor), req.OutInterval, nil
 	} else if readRollup && !normalize {
 		if req.Consolidator == consolidation.Avg {
 			return divide(
@@ -301,12 +393,12 @@ func (s *Server) getTarget(ctx context.Context, req models.Req) (points []schema
 		// readRollup && normalize
 		if req.Consolidator == consolidation.Avg {
 			return divide(
-				consolidation.Consolidate(s.getSeriesFixed(ctx, req, consolidation.Sum), req.AggNum, consolidation.Sum),
-				consolidation.Consolidate(s.getSeriesFixed(ctx, req, consolidation.Cnt), req.AggNum, consolidation.Sum),
+				consolidation.Consolidate(s.getSeriesFixed(ctx, req, consolidation.Sum), req.AggNum, consolidation.Sum, xFilesFactor),
+				consolidation.Consolidate(s.getSeriesFixed(ctx, req, consolidation.Cnt), req.AggNum, consolidation.Sum, xFilesFactor),
 			), req.OutInterval, nil
 		} else {
 			return consolidation.Consolidate(
-				s.getSeriesFixed(ctx, req, req.Consolidator), req.AggNum, req.Consolidator), req.OutInterval, nil
+				s.getSeriesFixed(ctx, req, req.Consolidator), req.AggNum, req.Consolidator, xFilesFactor), req.OutInterval, nil
 		}
 	}
 }
@@ -328,6 +420,45 @@ func (s *Server) getSeriesFixed(ctx context.Context, req models.Req, consolidato
 	return Fix(res.Points, req.From, req.To, req.ArchInterval)
 }
 
+// getSeriesQuantiles returns the quantile requested by the consolidator of req, from the sketches of the percentile rollup.
+// when normalizing, the sketches of req.AggNum points are merged, which gives the quantile of all the raw values they represent.
+func (s *Server) getSeriesQuantiles(ctx context.Context, req models.Req, xFilesFactor float64) []schema.Point {
+	q, _ := req.Consolidator.Quantile()
+	rctx := newRequestContext(ctx, &req, req.Consolidator)
+	res := s.getSeries(rctx)
+	// the values of the points are indexes into sketches, so that they stay associated through Fix()
+	var sketches []*sketch.Sketch
+	var points []schema.Point
+	for _, iter := range res.Iters {
+		for iter.Next() {
+			ts, _ := iter.Values()
+			if ts < rctx.From || ts >= rctx.To {
+				continue
+			}
+			sk, err := iter.Sketch()
+			if err != nil {
+				log.Error(3, "DP getSeriesQuantiles: failed to get sketch of %s at %d: %s", rctx.AggKey, ts, err)
+				continue
+			}
+			points = append(points, schema.Point{Val: float64(len(sketches)), Ts: ts})
+			sketches = append(sketches, sk)
+		}
+	}
+	aggNum := req.AggNum
+	if aggNum == 0 {
+		aggNum = 1
+	}
+	return consolidation.ConsolidateFunc(Fix(points, req.From, req.To, req.ArchInterval), aggNum, func(in []schema.Point) float64 {
+		merged := sketch.New()
+		for _, p := range in {
+			if !math.IsNaN(p.Val) {
+				merged.Merge(sketches[int(p.Val)])
+			}
+		}
+		return merged.Quantile(q)
+	}, xFilesFactor)
+}
+
 func (s *Server) getSeries(ctx *requestContext) mdata.Result {
 	res := s.getSeriesAggMetrics(ctx)
 	log.Debug("oldest from aggmetrics is %d", res.Oldest)
@@ -394,10 +525,15 @@ func (s *Server) getSeriesAggMetrics(ctx *requestContext) mdata.Result {
 }
 
 // will only fetch until until, but uses ctx.To for debug logging
+// panics with the context's error if the request was cancelled or timed out
 func (s *Server) getSeriesCachedStore(ctx *requestContext, until uint32) []chunk.Iter {
 	var iters []chunk.Iter
 	var prevts uint32
 
+	if err := ctx.ctx.Err(); err != nil {
+		panic(err)
+	}
+
 	key := ctx.Key
 	if ctx.Cons != consolidation.None {
 		key = ctx.AggKey
@@ -431,6 +567,10 @@ func (s *Server) getSeriesCachedStore(ctx *requestContext, until uint32) []chunk
 	// the request cannot completely be served from cache, it will require cassandra involvement
 	if !cacheRes.Complete {
 		if cacheRes.From != cacheRes.Until {
+			// don't queue reads to the store for a request that nobody waits for anymore
+			if err := ctx.ctx.Err(); err != nil {
+				panic(err)
+			}
 			storeIterGens, err := s.BackendStore.Search(ctx.ctx, key, ctx.Req.TTL, cacheRes.From, cacheRes.Until)
 			if err != nil {
 				panic(err)
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/batch"
 	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/consolidation"
@@ -15,6 +16,7 @@ import (
 	"github.com/grafana/metrictank/mdata/cache"
 	"github.com/grafana/metrictank/mdata/cache/accnt"
 	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/sketch"
 	"github.com/grafana/metrictank/test"
 	"gopkg.in/raintank/schema.v1"
 )
@@ -360,7 +362,7 @@ func TestGetSeriesFixed(t *testing.T) {
 			for to := uint32(31); to <= 40; to++ { // should always yield result with last point at 30 (because to is exclusive)
 				name := fmt.Sprintf("case.data.offset.%d.query:%d-%d", offset, from, to)
 
-				metric := metrics.GetOrCreate(name, name, 0, 0)
+				metric := metrics.GetOrCreate(name, name, 0, 0, 0, 0)
 				metric.Add(offset, 10)    // this point will always be quantized to 10
 				metric.Add(10+offset, 20) // this point will always be quantized to 20, so it should be selected
 				metric.Add(20+offset, 30) // this point will always be quantized to 30, so it should be selected
@@ -377,6 +379,112 @@ func TestGetSeriesFixed(t *testing.T) {
 	}
 }
 
+func TestGetSeriesQuantiles(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	store := mdata.NewDevnullStore()
+
+	mdata.SetSingleAgg(conf.P95, conf.Max)
+	mdata.SetSingleSchema(conf.NewRetentionMT(10, 1000, 600, 10, true), conf.NewRetentionMT(60, 10000, 3600, 2, true))
+
+	metrics := mdata.NewAggMetrics(store, &cache.MockCache{}, false, 0, 0, 0)
+	srv, _ := NewServer()
+	srv.BindBackendStore(store)
+	srv.BindMemoryStore(metrics)
+
+	var raw []schema.Point
+	metric := metrics.GetOrCreate("a", "a", 0, 0, 0, 0)
+	for ts := uint32(10); ts <= 1200; ts += 10 {
+		val := float64(ts%70 + ts%130)
+		metric.Add(ts, val)
+		raw = append(raw, schema.Point{Val: val, Ts: ts})
+	}
+
+	// the requests cover the 8 rollup points from 120 to 540
+	for _, aggNum := range []uint32{1, 2, 3} {
+		req := reqOut("a", 61, 541, 100, 10, consolidation.P95, 0, 0, 1, 60, 10000, 60*aggNum, aggNum)
+		points := srv.getSeriesQuantiles(test.NewContext(), req, 0)
+		if len(points) != int((8+aggNum-1)/aggNum) {
+			t.Fatalf("aggNum %d: expected %d points, got %v", aggNum, (8+aggNum-1)/aggNum, points)
+		}
+		for i, p := range points {
+			// the point covers the raw data of the aggNum rollup points that end at its timestamp
+			end := uint32(120) + (uint32(i+1)*aggNum-1)*60
+			if p.Ts != end {
+				t.Fatalf("aggNum %d: expected point %d to have ts %d, got %d", aggNum, i, end, p.Ts)
+			}
+			if end > 540 {
+				// the partial group only has the rollup points up to 540
+				end = 540
+			}
+			var in []schema.Point
+			for _, r := range raw {
+				if r.Ts > uint32(120)+uint32(i)*aggNum*60-60 && r.Ts <= end {
+					in = append(in, r)
+				}
+			}
+			exp := batch.Percentile(0.95)(in)
+			if math.Abs(p.Val-exp) > sketch.RelativeAccuracy*exp {
+				t.Fatalf("aggNum %d: expected point %d to be within %f of %f, got %f", aggNum, i, sketch.RelativeAccuracy, exp, p.Val)
+			}
+		}
+	}
+}
+
+func TestGetTargetIncrease(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	// as primary, we serve the first chunk from memory
+	cluster.Manager.SetPrimary(true)
+	defer cluster.Manager.SetPrimary(false)
+	store := mdata.NewDevnullStore()
+
+	mdata.SetSingleAgg(conf.Max, conf.Inc)
+	mdata.SetSingleSchema(conf.NewRetentionMT(10, 1000, 600, 10, true), conf.NewRetentionMT(60, 10000, 3600, 2, true))
+
+	metrics := mdata.NewAggMetrics(store, &cache.MockCache{}, false, 0, 0, 0)
+	srv, _ := NewServer()
+	srv.BindBackendStore(store)
+	srv.BindMemoryStore(metrics)
+
+	// a counter that goes up by 1 every 10s, and gets reset to 0 at 150
+	metric := metrics.GetOrCreate("a", "a", 0, 0, 0, 0)
+	val := float64(100)
+	for ts := uint32(10); ts <= 600; ts += 10 {
+		val++
+		if ts == 150 {
+			val = 0
+		}
+		metric.Add(ts, val)
+	}
+
+	cases := []struct {
+		req models.Req
+		exp []float64
+	}{
+		// raw data, from 60 to 240
+		{reqOut("a", 51, 241, 100, 10, consolidation.Inc, 0, 0, 0, 10, 1000, 10, 1), []float64{math.NaN(), 1, 1, 1, 1, 1, 1, 1, 1, 0, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+		// raw data normalized to 60s, from 120 to 240. the increase from 60 to 70 is unknown
+		{reqOut("a", 61, 241, 100, 10, consolidation.Inc, 0, 0, 0, 10, 1000, 60, 6), []float64{5, 5, 6}},
+		// the rollup, which also knows the increase from the point before the requested range
+		{reqOut("a", 61, 241, 100, 10, consolidation.Inc, 0, 0, 1, 60, 10000, 60, 1), []float64{6, 5, 6}},
+		// the rollup normalized to 120s
+		{reqOut("a", 61, 241, 100, 10, consolidation.Inc, 0, 0, 1, 60, 10000, 120, 2), []float64{11, 6}},
+	}
+	for i, c := range cases {
+		points, _, err := srv.getTarget(test.NewContext(), c.req)
+		if err != nil {
+			t.Fatalf("case %d: failed to get target: %s", i, err)
+		}
+		if len(points) != len(c.exp) {
+			t.Fatalf("case %d: expected %d points, got %v", i, len(c.exp), points)
+		}
+		for j, p := range points {
+			if math.IsNaN(p.Val) != math.IsNaN(c.exp[j]) || (!math.IsNaN(p.Val) && p.Val != c.exp[j]) {
+				t.Fatalf("case %d: expected point %d to be %f, got %v", i, j, c.exp[j], points)
+			}
+		}
+	}
+}
+
 func reqRaw(key string, from, to, maxPoints, rawInterval uint32, consolidator consolidation.Consolidator, schemaId, aggId uint16) models.Req {
 	req := models.NewReq(key, key, key, from, to, maxPoints, rawInterval, consolidator, 0, cluster.Manager.ThisNode(), schemaId, aggId)
 	return req
@@ -762,7 +870,7 @@ func TestGetSeriesAggMetrics(t *testing.T) {
 	req.ArchInterval = archInterval
 	ctx := newRequestContext(test.NewContext(), &req, consolidation.None)
 
-	metric := metrics.GetOrCreate(metricKey, metricKey, 0, 0)
+	metric := metrics.GetOrCreate(metricKey, metricKey, 0, 0, 0, 0)
 	for i := uint32(50); i < 3000; i++ {
 		metric.Add(i, float64(i^2))
 	}
@@ -3,8 +3,10 @@ package api
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +17,7 @@ import (
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/api/response"
 	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/expr"
 	"github.com/grafana/metrictank/idx"
@@ -34,6 +37,9 @@ var InvalidFormatErr = errors.New("invalid format specified")
 var InvalidTimeRangeErr = errors.New("invalid time range requested")
 var renderReqProxied = stats.NewCounter32("api.request.render.proxied")
 
+// metric api.purge.series is the number of deleted series whose chunks have been deleted from the store
+var purgedSeries = stats.NewCounter32("api.purge.series")
+
 var (
 	// metric api.request.render.series is the number of series a /render request is handling.  This is the number
 	// of metrics after all of the targets in the request have expanded by searching the index.
@@ -52,11 +58,22 @@ type Series struct {
 	Node    cluster.Node
 }
 
+// findSeries finds the series matching the patterns on the cluster.
+// if the context allows partial results, it skips the partitions and peers that are not available. see partial.go
 func (s *Server) findSeries(ctx context.Context, orgId int, patterns []string, seenAfter int64) ([]Series, error) {
-	peers, err := cluster.MembersForQuery()
-	if err != nil {
-		log.Error(3, "HTTP findSeries unable to get peers, %s", err)
-		return nil, err
+	partial := partialResultsFrom(ctx)
+	var peers []cluster.Node
+	var err error
+	if partial != nil {
+		var missing []int32
+		peers, missing = cluster.MembersForPartialQuery()
+		partial.addMissingPartitions(missing)
+	} else {
+		peers, err = cluster.MembersForQuery()
+		if err != nil {
+			log.Error(3, "HTTP findSeries unable to get peers, %s", err)
+			return nil, err
+		}
 	}
 	log.Debug("HTTP findSeries for %v across %d instances", patterns, len(peers))
 	errors := make([]error, 0)
@@ -82,7 +99,9 @@ func (s *Server) findSeries(ctx context.Context, orgId int, patterns []string, s
 			go func(peer cluster.Node) {
 				result, err := s.findSeriesRemote(ctx, orgId, patterns, seenAfter, peer)
 				mu.Lock()
-				if err != nil {
+				if err != nil && partial != nil {
+					partial.addFailedPeer(peer.Name)
+				} else if err != nil {
 					errors = append(errors, err)
 				}
 				series = append(series, result...)
@@ -214,6 +233,14 @@ func (s *Server) renderMetrics(ctx *middleware.Context, request models.GraphiteR
 		// as graphite needs high-res data to perform its processing.
 		mdp = 0
 	}
+	allowPartial := request.Partial == "true" || (request.Partial == "" && partialResultsEnabled)
+	cached := renderCache.enabled()
+	var key renderKey
+	if cached {
+		// the time range is aligned such that requests that only differ by less than the finest interval share a result. see rendercache.go
+		key = renderCache.key(ctx.OrgId, request.Targets, fromUnix, toUnix, mdp, stable, allowPartial)
+		fromUnix, toUnix = key.from, key.to
+	}
 	plan, err := expr.NewPlan(exprs, fromUnix, toUnix, mdp, stable, nil)
 	if err != nil {
 		if fun, ok := err.(expr.ErrUnknownFunction); ok {
@@ -240,14 +267,38 @@ func (s *Server) renderMetrics(ctx *middleware.Context, request models.GraphiteR
 
 	newctx, span := tracing.NewSpan(ctx.Req.Context(), s.Tracer, "executePlan")
 	defer span.Finish()
+	newctx, cancel := withQueryTimeout(newctx)
+	defer cancel()
+	var partial *partialResults
+	if allowPartial {
+		newctx, partial = withPartialResults(newctx)
+	}
 	ctx.Req = macaron.Request{ctx.Req.WithContext(newctx)}
-	out, err := s.executePlan(ctx.Req.Context(), ctx.OrgId, plan)
+	var res renderResult
+	if cached {
+		res, err = renderCache.get(ctx.Req.Context(), key, plan.Pointwise(), func(execCtx context.Context, from, to uint32) (renderResult, error) {
+			return s.executeCached(execCtx, ctx.OrgId, plan, request.Targets, stable, from, to)
+		})
+	} else {
+		var series []models.Series
+		series, err = s.executePlan(ctx.Req.Context(), ctx.OrgId, plan)
+		res = partial.renderResult(series)
+	}
+	out := res.series
 	if err != nil {
+		err = queryError(newctx, err)
 		tracing.Failure(span)
 		tracing.Error(span, err)
 		response.Write(ctx, response.WrapError(err))
 		return
 	}
+	if res.warning != "" {
+		span.SetTag("partial", true)
+		reqRenderPartial.Inc()
+		partialMissingPartitions.Add(res.missingPartitions)
+		partialFailedPeers.Add(res.failedPeers)
+		ctx.Resp.Header().Set(PartialResultsHeader, res.warning)
+	}
 
 	noDataPoints := true
 	for _, o := range out {
@@ -298,6 +349,10 @@ func (s *Server) metricsFind(ctx *middleware.Context, request models.GraphiteFin
 			}
 		}
 	}
+	if err := checkFindLimit(len(nodes)); err != nil {
+		response.Write(ctx, err)
+		return
+	}
 
 	switch request.Format {
 	case "", "treejson", "json":
@@ -309,6 +364,78 @@ func (s *Server) metricsFind(ctx *middleware.Context, request models.GraphiteFin
 	}
 }
 
+func (s *Server) metricsExpand(ctx *middleware.Context, request models.GraphiteExpand) {
+	series, err := s.findSeries(ctx.Req.Context(), ctx.OrgId, request.Query, 0)
+	if err != nil {
+		response.Write(ctx, response.WrapError(err))
+		return
+	}
+	results, count := findExpand(request.Query, series, request.LeavesOnly)
+	if err := checkFindLimit(count); err != nil {
+		response.Write(ctx, err)
+		return
+	}
+
+	if request.GroupByExpr {
+		response.Write(ctx, response.NewJson(200, models.SeriesExpand{Results: results}, request.Jsonp))
+		return
+	}
+	seenPaths := make(map[string]struct{})
+	paths := make([]string, 0, count)
+	for _, queryPaths := range results {
+		for _, p := range queryPaths {
+			if _, ok := seenPaths[p]; !ok {
+				paths = append(paths, p)
+				seenPaths[p] = struct{}{}
+			}
+		}
+	}
+	sort.Strings(paths)
+	response.Write(ctx, response.NewJson(200, models.SeriesExpand{Results: paths}, request.Jsonp))
+}
+
+// findExpand groups the paths found for each query, sorted and deduplicated.
+// like metricsFind, paths seen on multiple cluster nodes are only included once.
+// it also returns the number of unique paths across all queries
+func findExpand(queries []string, series []Series, leavesOnly bool) (map[string][]string, int) {
+	results := make(map[string][]string)
+	seen := make(map[string]map[string]struct{})
+	seenAll := make(map[string]struct{})
+	for _, q := range queries {
+		results[q] = make([]string, 0)
+		seen[q] = make(map[string]struct{})
+	}
+	for _, s := range series {
+		seenPaths, ok := seen[s.Pattern]
+		if !ok {
+			seenPaths = make(map[string]struct{})
+			seen[s.Pattern] = seenPaths
+		}
+		for _, n := range s.Series {
+			if leavesOnly && !n.Leaf {
+				continue
+			}
+			if _, ok := seenPaths[n.Path]; !ok {
+				results[s.Pattern] = append(results[s.Pattern], n.Path)
+				seenPaths[n.Path] = struct{}{}
+				seenAll[n.Path] = struct{}{}
+			}
+		}
+	}
+	for _, paths := range results {
+		sort.Strings(paths)
+	}
+	return results, len(seenAll)
+}
+
+// checkFindLimit returns an error if the number of nodes exceeds the configured max-find-results
+func checkFindLimit(count int) *response.ErrorResp {
+	if maxFindResults > 0 && count > maxFindResults {
+		return response.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("query matched %d nodes, which exceeds the limit of %d. please refine your query", count, maxFindResults))
+	}
+	return nil
+}
+
 func (s *Server) listLocal(orgId int) []idx.Archive {
 	return s.MetricIndex.List(orgId)
 }
@@ -476,6 +603,10 @@ func (s *Server) metricsDelete(ctx *middleware.Context, req models.MetricsDelete
 	peers := cluster.Manager.MemberList()
 	peers = append(peers, cluster.Manager.ThisNode())
 	log.Debug("HTTP metricsDelete for %v across %d instances", req.Query, len(peers))
+	var jobId string
+	if req.Purge {
+		jobId = newJobId()
+	}
 	errors := make([]error, 0)
 	deleted := 0
 	var mu sync.Mutex
@@ -485,7 +616,7 @@ func (s *Server) metricsDelete(ctx *middleware.Context, req models.MetricsDelete
 		wg.Add(1)
 		if peer.IsLocal() {
 			go func() {
-				result, err := s.metricsDeleteLocal(ctx.OrgId, req.Query)
+				result, err := s.metricsDeleteLocal(ctx.OrgId, req.Query, jobId)
 				mu.Lock()
 				if err != nil {
 					// errors can be due to bad user input or corrupt index.
@@ -501,7 +632,7 @@ func (s *Server) metricsDelete(ctx *middleware.Context, req models.MetricsDelete
 			}()
 		} else {
 			go func(peer cluster.Node) {
-				result, err := s.metricsDeleteRemote(ctx.Req.Context(), ctx.OrgId, req.Query, peer)
+				result, err := s.metricsDeleteRemote(ctx.Req.Context(), ctx.OrgId, req.Query, jobId, peer)
 				mu.Lock()
 				if err != nil {
 					errors = append(errors, err)
@@ -513,9 +644,18 @@ func (s *Server) metricsDelete(ctx *middleware.Context, req models.MetricsDelete
 		}
 	}
 	wg.Wait()
-	var err error
 	if len(errors) > 0 {
-		response.Write(ctx, response.WrapError(err))
+		response.Write(ctx, response.WrapError(errors[0]))
+		return
+	}
+
+	if req.Purge {
+		resp := models.MetricsPurgeResp{
+			DeletedDefs: deleted,
+			JobId:       jobId,
+		}
+		response.Write(ctx, response.NewJson(200, resp, ""))
+		return
 	}
 
 	resp := models.MetricsDeleteResp{
@@ -525,17 +665,24 @@ func (s *Server) metricsDelete(ctx *middleware.Context, req models.MetricsDelete
 	response.Write(ctx, response.NewJson(200, resp, ""))
 }
 
-func (s *Server) metricsDeleteLocal(orgId int, query string) (int, error) {
+// metricsDeleteLocal deletes the matching series from the local index.
+// if a jobId is given, their data is also purged in a background job
+func (s *Server) metricsDeleteLocal(orgId int, query, jobId string) (int, error) {
 	defs, err := s.MetricIndex.Delete(orgId, query)
+	if err == nil && jobId != "" {
+		s.purgeLocal(jobId, orgId, defs)
+	}
 	return len(defs), err
 }
 
-func (s *Server) metricsDeleteRemote(ctx context.Context, orgId int, query string, peer cluster.Node) (int, error) {
+func (s *Server) metricsDeleteRemote(ctx context.Context, orgId int, query, jobId string, peer cluster.Node) (int, error) {
 	log.Debug("HTTP metricDelete calling %s/index/delete for %d:%q", peer.Name, orgId, query)
 
 	body := models.IndexDelete{
 		Query: query,
 		OrgId: orgId,
+		Purge: jobId != "",
+		JobId: jobId,
 	}
 	buf, err := peer.Post(ctx, "metricsDeleteRemote", "/index/delete", body)
 	if err != nil {
@@ -552,9 +699,53 @@ func (s *Server) metricsDeleteRemote(ctx context.Context, orgId int, query strin
 	return resp.DeletedDefs, nil
 }
 
+// purgeLocal starts a job that removes the data of the given (already deleted) series
+// from memory and the chunk cache.  For the series whose partition we are primary for,
+// it also deletes their raw and rollup chunks from the backend store. the other series
+// are counted as skipped in the job status, as their chunks are deleted by the primaries.
+func (s *Server) purgeLocal(jobId string, orgId int, defs []idx.Archive) {
+	j := newJob(jobId, "purge", orgId, len(defs))
+	jobs.Add(j)
+	go func() {
+		// the store expects a span in the context
+		span := s.Tracer.StartSpan("purge job")
+		span.SetTag("job", jobId)
+		defer span.Finish()
+		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		var purged, skipped int
+		for _, def := range defs {
+			s.MemoryStore.Delete(def.Id)
+			keys := mdata.StoreKeys(def.Id, def.SchemaId, def.AggId)
+			for _, key := range keys {
+				s.Cache.DelMetric(key.Key)
+			}
+			if !cluster.Manager.IsPrimaryFor(def.Partition) {
+				skipped++
+				j.skip()
+				continue
+			}
+			var err error
+			for _, key := range keys {
+				if err = s.BackendStore.Delete(ctx, key.Key, key.TTL); err != nil {
+					log.Error(3, "HTTP purge: failed to delete %s from the store: %s", key.Key, err)
+					err = fmt.Errorf("failed to delete %s: %s", def.Id, err)
+					break
+				}
+			}
+			if err == nil {
+				purged++
+				purgedSeries.Inc()
+			}
+			j.progress(err)
+		}
+		j.finish()
+		log.Info("HTTP purge: job %s finished: purged %d of %d series, skipped %d series of partitions we are not primary for", jobId, purged, len(defs), skipped)
+	}()
+}
+
 // executePlan looks up the needed data, retrieves it, and then invokes the processing
 // note if you do something like sum(foo.*) and all of those metrics happen to be on another node,
-// we will collect all the indidividual series from the peer, and then sum here. that could be optimized
+// the peer sums them, and we combine its sum with the other series. see pushdown.go
 func (s *Server) executePlan(ctx context.Context, orgId int, plan expr.Plan) ([]models.Series, error) {
 
 	minFrom := uint32(math.MaxUint32)
@@ -587,6 +778,12 @@ func (s *Server) executePlan(ctx context.Context, orgId int, plan expr.Plan) ([]
 						// * a pattern may expand to multiple series, each of which can have their own aggregation method.
 						fn := mdata.Aggregations.Get(archive.AggId).AggregationMethod[0]
 						cons = consolidation.Consolidator(fn) // we use the same number assignments so we can cast them
+					} else if consReq == consolidation.Inc && !hasMethod(mdata.Aggregations.Get(archive.AggId), conf.Inc) {
+						// functions with rate semantics such as perSecond ask for the increase of counters.
+						// series without increase rollups are not counters, or not configured as such: they get their primary method,
+						// and the function falls back to computing the rate from the values.
+						fn := mdata.Aggregations.Get(archive.AggId).AggregationMethod[0]
+						cons = consolidation.Consolidator(fn)
 					}
 					newReq := models.NewReq(
 						archive.Id, archive.Name, r.Query, r.From, r.To, plan.MaxDataPoints, uint32(archive.Interval), cons, consReq, s.Node, archive.SchemaId, archive.AggId)
@@ -617,9 +814,18 @@ func (s *Server) executePlan(ctx context.Context, orgId int, plan expr.Plan) ([]
 		}
 	}
 
-	out, err := s.getTargets(ctx, reqs)
+	// render requests are admitted by the render scheduler, the ones that fetch the fewest points first. see scheduler.go
+	if err := renderScheduler.acquire(ctx, orgId, int(pointsFetch)); err != nil {
+		return nil, err
+	}
+	defer renderScheduler.release(orgId)
+
+	reqs = pushDown(reqs, plan.PushDown())
+	out, partials, err := s.getTargets(ctx, reqs)
 	if err != nil {
-		log.Error(3, "HTTP Render %s", err.Error())
+		if ctx.Err() == nil {
+			log.Error(3, "HTTP Render %s", err.Error())
+		}
 		return nil, err
 	}
 	out = mergeSeries(out)
@@ -632,6 +838,7 @@ func (s *Server) executePlan(ctx context.Context, orgId int, plan expr.Plan) ([]
 		q := expr.NewReq(serie.QueryPatt, serie.QueryFrom, serie.QueryTo, serie.QueryCons)
 		data[q] = append(data[q], serie)
 	}
+	combinePartials(data, partials)
 
 	preRun := time.Now()
 	out, err = plan.Run(data)
@@ -639,6 +846,37 @@ func (s *Server) executePlan(ctx context.Context, orgId int, plan expr.Plan) ([]
 	return out, err
 }
 
+// executeCached executes the plan, or the plan of its targets for another time range, for the render cache.
+// the series are copied out of the pool, such that the cache can keep them.
+func (s *Server) executeCached(ctx context.Context, orgId int, plan expr.Plan, targets []string, stable bool, from, to uint32) (renderResult, error) {
+	if from != plan.From || to != plan.To {
+		exprs, err := expr.ParseMany(targets)
+		if err != nil {
+			return renderResult{}, err
+		}
+		plan, err = expr.NewPlan(exprs, from, to, plan.MaxDataPoints, stable, nil)
+		if err != nil {
+			return renderResult{}, err
+		}
+		defer plan.Clean()
+	}
+	out, err := s.executePlan(ctx, orgId, plan)
+	if err != nil {
+		return renderResult{}, err
+	}
+	return partialResultsFrom(ctx).renderResult(copySeries(out)), nil
+}
+
+// hasMethod returns whether the aggregation has rollups for the given method
+func hasMethod(agg conf.Aggregation, method conf.Method) bool {
+	for _, m := range agg.AggregationMethod {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
 func getFromTo(ft models.FromTo, now time.Time, defaultFrom, defaultTo uint32) (uint32, uint32, error) {
 	loc, err := getLocation(ft.Tz)
 	if err != nil {
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/idx"
+)
+
+func TestFindExpand(t *testing.T) {
+	series := []Series{
+		{
+			Pattern: "foo.*",
+			Series: []idx.Node{
+				{Path: "foo.c", Leaf: true},
+				{Path: "foo.a", HasChildren: true},
+			},
+		},
+		{
+			// same pattern, as found on another cluster node
+			Pattern: "foo.*",
+			Series: []idx.Node{
+				{Path: "foo.a", HasChildren: true},
+				{Path: "foo.b", Leaf: true},
+			},
+		},
+		{
+			Pattern: "foo.{a,b}",
+			Series: []idx.Node{
+				{Path: "foo.b", Leaf: true},
+				{Path: "foo.a", HasChildren: true},
+			},
+		},
+	}
+	cases := []struct {
+		queries    []string
+		leavesOnly bool
+		expResults map[string][]string
+		expCount   int
+	}{
+		{
+			[]string{"foo.*", "foo.{a,b}"},
+			false,
+			map[string][]string{
+				"foo.*":     {"foo.a", "foo.b", "foo.c"},
+				"foo.{a,b}": {"foo.a", "foo.b"},
+			},
+			3,
+		},
+		{
+			[]string{"foo.*", "foo.{a,b}"},
+			true,
+			map[string][]string{
+				"foo.*":     {"foo.b", "foo.c"},
+				"foo.{a,b}": {"foo.b"},
+			},
+			2,
+		},
+		{
+			[]string{"foo.*", "foo.{a,b}", "bar"},
+			false,
+			map[string][]string{
+				"foo.*":     {"foo.a", "foo.b", "foo.c"},
+				"foo.{a,b}": {"foo.a", "foo.b"},
+				"bar":       {},
+			},
+			3,
+		},
+	}
+	for i, c := range cases {
+		results, count := findExpand(c.queries, series, c.leavesOnly)
+		if !reflect.DeepEqual(results, c.expResults) {
+			t.Errorf("case %d: expected results %v, got %v", i, c.expResults, results)
+		}
+		if count != c.expCount {
+			t.Errorf("case %d: expected count %d, got %d", i, c.expCount, count)
+		}
+	}
+}
+
+func TestCheckFindLimit(t *testing.T) {
+	defer func(orig int) { maxFindResults = orig }(maxFindResults)
+
+	maxFindResults = 0
+	if err := checkFindLimit(1000000); err != nil {
+		t.Fatalf("expected no error when limit disabled, got %q", err)
+	}
+	maxFindResults = 10
+	if err := checkFindLimit(10); err != nil {
+		t.Fatalf("expected no error at the limit, got %q", err)
+	}
+	err := checkFindLimit(11)
+	if err == nil {
+		t.Fatal("expected error when exceeding the limit")
+	}
+	if err.Code() != 413 {
+		t.Fatalf("expected status 413, got %d", err.Code())
+	}
+}
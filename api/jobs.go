@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/api/middleware"
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/raintank/worldping-api/pkg/log"
+)
+
+// how long to keep the status of finished jobs around
+const jobRetention = 24 * time.Hour
+
+// job tracks the progress of a background job running on this node.
+// the same job may run on several cluster nodes, each processing the series they own.
+type job struct {
+	sync.Mutex
+	orgId  int
+	status models.JobStatus
+}
+
+func newJob(id, kind string, orgId, total int) *job {
+	return &job{
+		orgId: orgId,
+		status: models.JobStatus{
+			Id:      id,
+			Kind:    kind,
+			State:   models.JobRunning,
+			Total:   total,
+			Errors:  make([]string, 0),
+			Started: time.Now().Unix(),
+		},
+	}
+}
+
+// progress marks one item as processed, optionally with an error
+func (j *job) progress(err error) {
+	j.Lock()
+	j.status.Done++
+	if err != nil {
+		j.status.Errors = append(j.status.Errors, err.Error())
+	}
+	j.Unlock()
+}
+
+// skip marks one item as processed, without it needing any work on this node
+func (j *job) skip() {
+	j.Lock()
+	j.status.Done++
+	j.status.Skipped++
+	j.Unlock()
+}
+
+func (j *job) finish() {
+	j.Lock()
+	j.status.State = models.JobDone
+	if len(j.status.Errors) > 0 {
+		j.status.State = models.JobFailed
+	}
+	j.status.Finished = time.Now().Unix()
+	j.Unlock()
+}
+
+func (j *job) Status() models.JobStatus {
+	j.Lock()
+	status := j.status
+	status.Errors = append([]string(nil), j.status.Errors...)
+	j.Unlock()
+	return status
+}
+
+type jobRegistry struct {
+	sync.Mutex
+	jobs map[string]*job
+}
+
+var jobs = jobRegistry{
+	jobs: make(map[string]*job),
+}
+
+// Add registers the job and drops jobs that finished more than jobRetention ago
+func (r *jobRegistry) Add(j *job) {
+	cutoff := time.Now().Add(-jobRetention).Unix()
+	r.Lock()
+	for id, old := range r.jobs {
+		status := old.Status()
+		if status.State != models.JobRunning && status.Finished < cutoff {
+			delete(r.jobs, id)
+		}
+	}
+	r.jobs[j.status.Id] = j
+	r.Unlock()
+}
+
+func (r *jobRegistry) Get(id string) (*job, bool) {
+	r.Lock()
+	j, ok := r.jobs[id]
+	r.Unlock()
+	return j, ok
+}
+
+// newJobId returns an id that is unique across the cluster
+func newJobId() string {
+	return fmt.Sprintf("%s-%d", cluster.Manager.ThisNode().Name, time.Now().UnixNano())
+}
+
+// getJob returns the status of a job, merged across all cluster nodes it runs on
+func (s *Server) getJob(ctx *middleware.Context, req models.JobGet) {
+	peers := cluster.Manager.MemberList()
+	peers = append(peers, cluster.Manager.ThisNode())
+	var status *models.JobStatus
+	errors := make([]error, 0)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer cluster.Node) {
+			var st models.JobStatus
+			var ok bool
+			var err error
+			if peer.IsLocal() {
+				st, ok = getJobLocal(ctx.OrgId, req.Id)
+			} else {
+				st, ok, err = s.getJobRemote(ctx.Req.Context(), ctx.OrgId, req.Id, peer)
+			}
+			mu.Lock()
+			if err != nil {
+				errors = append(errors, err)
+			}
+			if ok {
+				if status == nil {
+					status = &st
+				} else {
+					status.Merge(st)
+				}
+			}
+			mu.Unlock()
+			wg.Done()
+		}(peer)
+	}
+	wg.Wait()
+	if len(errors) > 0 {
+		response.Write(ctx, response.WrapError(errors[0]))
+		return
+	}
+	if status == nil {
+		response.Write(ctx, response.NewError(http.StatusNotFound, "job not found"))
+		return
+	}
+	response.Write(ctx, response.NewJson(200, status, ""))
+}
+
+func getJobLocal(orgId int, id string) (models.JobStatus, bool) {
+	j, ok := jobs.Get(id)
+	if !ok || j.orgId != orgId {
+		return models.JobStatus{}, false
+	}
+	return j.Status(), true
+}
+
+func (s *Server) getJobRemote(ctx context.Context, orgId int, id string, peer cluster.Node) (models.JobStatus, bool, error) {
+	var status models.JobStatus
+	buf, err := peer.Post(ctx, "getJobRemote", "/jobs/local", models.JobGetLocal{Id: id, OrgId: orgId})
+	if err != nil {
+		if e, ok := err.(*cluster.Error); ok && e.Code() == http.StatusNotFound {
+			return status, false, nil
+		}
+		log.Error(4, "HTTP getJob error querying %s/jobs/local: %q", peer.Name, err)
+		return status, false, err
+	}
+	_, err = status.UnmarshalMsg(buf)
+	if err != nil {
+		log.Error(4, "HTTP getJob error unmarshaling body from %s/jobs/local: %q", peer.Name, err)
+		return status, false, err
+	}
+	return status, true, nil
+}
+
+// jobLocal returns the msgp encoded status of a job running on this node
+func (s *Server) jobLocal(ctx *middleware.Context, req models.JobGetLocal) {
+	status, ok := getJobLocal(req.OrgId, req.Id)
+	if !ok {
+		response.Write(ctx, response.NewError(http.StatusNotFound, "job not found"))
+		return
+	}
+	response.Write(ctx, response.NewMsgp(200, &status))
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/idx"
 )
 
@@ -18,7 +19,18 @@ func NewIndexFindResp() *IndexFindResp {
 
 //go:generate msgp
 type GetDataResp struct {
-	Series []Series
+	Series   []Series
+	Partials []PartialSeries
+}
+
+// PartialSeries is the aggregate of several series of the same request, computed by the peer that has them,
+// for the node coordinating the query to combine with the other series of the request.
+//
+//go:generate msgp
+type PartialSeries struct {
+	Aggregate consolidation.Consolidator // the aggregation applied to the series. for Avg, Series holds the sums
+	Series    Series                     // the aggregated series, tied back to the request like the series it aggregates
+	Counts    []uint32                   // for Avg, the number of non-null points that were summed into each point
 }
 
 type MetricsDeleteResp struct {
@@ -13,31 +13,48 @@ import (
 func (z *GetDataResp) DecodeMsg(dc *msgp.Reader) (err error) {
 	var field []byte
 	_ = field
-	var zbzg uint32
-	zbzg, err = dc.ReadMapHeader()
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
 	if err != nil {
 		return
 	}
-	for zbzg > 0 {
-		zbzg--
+	for zb0001 > 0 {
+		zb0001--
 		field, err = dc.ReadMapKeyPtr()
 		if err != nil {
 			return
 		}
 		switch msgp.UnsafeString(field) {
 		case "Series":
-			var zbai uint32
-			zbai, err = dc.ReadArrayHeader()
+			var zb0002 uint32
+			zb0002, err = dc.ReadArrayHeader()
 			if err != nil {
 				return
 			}
-			if cap(z.Series) >= int(zbai) {
-				z.Series = (z.Series)[:zbai]
+			if cap(z.Series) >= int(zb0002) {
+				z.Series = (z.Series)[:zb0002]
 			} else {
-				z.Series = make([]Series, zbai)
+				z.Series = make([]Series, zb0002)
 			}
-			for zxvk := range z.Series {
-				err = z.Series[zxvk].DecodeMsg(dc)
+			for za0001 := range z.Series {
+				err = z.Series[za0001].DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		case "Partials":
+			var zb0003 uint32
+			zb0003, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Partials) >= int(zb0003) {
+				z.Partials = (z.Partials)[:zb0003]
+			} else {
+				z.Partials = make([]PartialSeries, zb0003)
+			}
+			for za0002 := range z.Partials {
+				err = z.Partials[za0002].DecodeMsg(dc)
 				if err != nil {
 					return
 				}
@@ -54,18 +71,33 @@ func (z *GetDataResp) DecodeMsg(dc *msgp.Reader) (err error) {
 
 // EncodeMsg implements msgp.Encodable
 func (z *GetDataResp) EncodeMsg(en *msgp.Writer) (err error) {
-	// map header, size 1
+	// map header, size 2
 	// write "Series"
-	err = en.Append(0x81, 0xa6, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73)
+	err = en.Append(0x82, 0xa6, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteArrayHeader(uint32(len(z.Series)))
 	if err != nil {
 		return
 	}
-	for zxvk := range z.Series {
-		err = z.Series[zxvk].EncodeMsg(en)
+	for za0001 := range z.Series {
+		err = z.Series[za0001].EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "Partials"
+	err = en.Append(0xa8, 0x50, 0x61, 0x72, 0x74, 0x69, 0x61, 0x6c, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Partials)))
+	if err != nil {
+		return
+	}
+	for za0002 := range z.Partials {
+		err = z.Partials[za0002].EncodeMsg(en)
 		if err != nil {
 			return
 		}
@@ -76,12 +108,21 @@ func (z *GetDataResp) EncodeMsg(en *msgp.Writer) (err error) {
 // MarshalMsg implements msgp.Marshaler
 func (z *GetDataResp) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
-	// map header, size 1
+	// map header, size 2
 	// string "Series"
-	o = append(o, 0x81, 0xa6, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73)
+	o = append(o, 0x82, 0xa6, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73)
 	o = msgp.AppendArrayHeader(o, uint32(len(z.Series)))
-	for zxvk := range z.Series {
-		o, err = z.Series[zxvk].MarshalMsg(o)
+	for za0001 := range z.Series {
+		o, err = z.Series[za0001].MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	// string "Partials"
+	o = append(o, 0xa8, 0x50, 0x61, 0x72, 0x74, 0x69, 0x61, 0x6c, 0x73)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Partials)))
+	for za0002 := range z.Partials {
+		o, err = z.Partials[za0002].MarshalMsg(o)
 		if err != nil {
 			return
 		}
@@ -93,31 +134,48 @@ func (z *GetDataResp) MarshalMsg(b []byte) (o []byte, err error) {
 func (z *GetDataResp) UnmarshalMsg(bts []byte) (o []byte, err error) {
 	var field []byte
 	_ = field
-	var zcmr uint32
-	zcmr, bts, err = msgp.ReadMapHeaderBytes(bts)
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
 	if err != nil {
 		return
 	}
-	for zcmr > 0 {
-		zcmr--
+	for zb0001 > 0 {
+		zb0001--
 		field, bts, err = msgp.ReadMapKeyZC(bts)
 		if err != nil {
 			return
 		}
 		switch msgp.UnsafeString(field) {
 		case "Series":
-			var zajw uint32
-			zajw, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Series) >= int(zb0002) {
+				z.Series = (z.Series)[:zb0002]
+			} else {
+				z.Series = make([]Series, zb0002)
+			}
+			for za0001 := range z.Series {
+				bts, err = z.Series[za0001].UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		case "Partials":
+			var zb0003 uint32
+			zb0003, bts, err = msgp.ReadArrayHeaderBytes(bts)
 			if err != nil {
 				return
 			}
-			if cap(z.Series) >= int(zajw) {
-				z.Series = (z.Series)[:zajw]
+			if cap(z.Partials) >= int(zb0003) {
+				z.Partials = (z.Partials)[:zb0003]
 			} else {
-				z.Series = make([]Series, zajw)
+				z.Partials = make([]PartialSeries, zb0003)
 			}
-			for zxvk := range z.Series {
-				bts, err = z.Series[zxvk].UnmarshalMsg(bts)
+			for za0002 := range z.Partials {
+				bts, err = z.Partials[za0002].UnmarshalMsg(bts)
 				if err != nil {
 					return
 				}
@@ -136,8 +194,12 @@ func (z *GetDataResp) UnmarshalMsg(bts []byte) (o []byte, err error) {
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *GetDataResp) Msgsize() (s int) {
 	s = 1 + 7 + msgp.ArrayHeaderSize
-	for zxvk := range z.Series {
-		s += z.Series[zxvk].Msgsize()
+	for za0001 := range z.Series {
+		s += z.Series[za0001].Msgsize()
+	}
+	s += 9 + msgp.ArrayHeaderSize
+	for za0002 := range z.Partials {
+		s += z.Partials[za0002].Msgsize()
 	}
 	return
 }
@@ -146,56 +208,56 @@ func (z *GetDataResp) Msgsize() (s int) {
 func (z *IndexFindResp) DecodeMsg(dc *msgp.Reader) (err error) {
 	var field []byte
 	_ = field
-	var zxhx uint32
-	zxhx, err = dc.ReadMapHeader()
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
 	if err != nil {
 		return
 	}
-	for zxhx > 0 {
-		zxhx--
+	for zb0001 > 0 {
+		zb0001--
 		field, err = dc.ReadMapKeyPtr()
 		if err != nil {
 			return
 		}
 		switch msgp.UnsafeString(field) {
 		case "Nodes":
-			var zlqf uint32
-			zlqf, err = dc.ReadMapHeader()
+			var zb0002 uint32
+			zb0002, err = dc.ReadMapHeader()
 			if err != nil {
 				return
 			}
-			if z.Nodes == nil && zlqf > 0 {
-				z.Nodes = make(map[string][]idx.Node, zlqf)
+			if z.Nodes == nil {
+				z.Nodes = make(map[string][]idx.Node, zb0002)
 			} else if len(z.Nodes) > 0 {
 				for key := range z.Nodes {
 					delete(z.Nodes, key)
 				}
 			}
-			for zlqf > 0 {
-				zlqf--
-				var zwht string
-				var zhct []idx.Node
-				zwht, err = dc.ReadString()
+			for zb0002 > 0 {
+				zb0002--
+				var za0001 string
+				var za0002 []idx.Node
+				za0001, err = dc.ReadString()
 				if err != nil {
 					return
 				}
-				var zdaf uint32
-				zdaf, err = dc.ReadArrayHeader()
+				var zb0003 uint32
+				zb0003, err = dc.ReadArrayHeader()
 				if err != nil {
 					return
 				}
-				if cap(zhct) >= int(zdaf) {
-					zhct = (zhct)[:zdaf]
+				if cap(za0002) >= int(zb0003) {
+					za0002 = (za0002)[:zb0003]
 				} else {
-					zhct = make([]idx.Node, zdaf)
+					za0002 = make([]idx.Node, zb0003)
 				}
-				for zcua := range zhct {
-					err = zhct[zcua].DecodeMsg(dc)
+				for za0003 := range za0002 {
+					err = za0002[za0003].DecodeMsg(dc)
 					if err != nil {
 						return
 					}
 				}
-				z.Nodes[zwht] = zhct
+				z.Nodes[za0001] = za0002
 			}
 		default:
 			err = dc.Skip()
@@ -213,23 +275,23 @@ func (z *IndexFindResp) EncodeMsg(en *msgp.Writer) (err error) {
 	// write "Nodes"
 	err = en.Append(0x81, 0xa5, 0x4e, 0x6f, 0x64, 0x65, 0x73)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteMapHeader(uint32(len(z.Nodes)))
 	if err != nil {
 		return
 	}
-	for zwht, zhct := range z.Nodes {
-		err = en.WriteString(zwht)
+	for za0001, za0002 := range z.Nodes {
+		err = en.WriteString(za0001)
 		if err != nil {
 			return
 		}
-		err = en.WriteArrayHeader(uint32(len(zhct)))
+		err = en.WriteArrayHeader(uint32(len(za0002)))
 		if err != nil {
 			return
 		}
-		for zcua := range zhct {
-			err = zhct[zcua].EncodeMsg(en)
+		for za0003 := range za0002 {
+			err = za0002[za0003].EncodeMsg(en)
 			if err != nil {
 				return
 			}
@@ -245,11 +307,11 @@ func (z *IndexFindResp) MarshalMsg(b []byte) (o []byte, err error) {
 	// string "Nodes"
 	o = append(o, 0x81, 0xa5, 0x4e, 0x6f, 0x64, 0x65, 0x73)
 	o = msgp.AppendMapHeader(o, uint32(len(z.Nodes)))
-	for zwht, zhct := range z.Nodes {
-		o = msgp.AppendString(o, zwht)
-		o = msgp.AppendArrayHeader(o, uint32(len(zhct)))
-		for zcua := range zhct {
-			o, err = zhct[zcua].MarshalMsg(o)
+	for za0001, za0002 := range z.Nodes {
+		o = msgp.AppendString(o, za0001)
+		o = msgp.AppendArrayHeader(o, uint32(len(za0002)))
+		for za0003 := range za0002 {
+			o, err = za0002[za0003].MarshalMsg(o)
 			if err != nil {
 				return
 			}
@@ -262,56 +324,56 @@ func (z *IndexFindResp) MarshalMsg(b []byte) (o []byte, err error) {
 func (z *IndexFindResp) UnmarshalMsg(bts []byte) (o []byte, err error) {
 	var field []byte
 	_ = field
-	var zpks uint32
-	zpks, bts, err = msgp.ReadMapHeaderBytes(bts)
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
 	if err != nil {
 		return
 	}
-	for zpks > 0 {
-		zpks--
+	for zb0001 > 0 {
+		zb0001--
 		field, bts, err = msgp.ReadMapKeyZC(bts)
 		if err != nil {
 			return
 		}
 		switch msgp.UnsafeString(field) {
 		case "Nodes":
-			var zjfb uint32
-			zjfb, bts, err = msgp.ReadMapHeaderBytes(bts)
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadMapHeaderBytes(bts)
 			if err != nil {
 				return
 			}
-			if z.Nodes == nil && zjfb > 0 {
-				z.Nodes = make(map[string][]idx.Node, zjfb)
+			if z.Nodes == nil {
+				z.Nodes = make(map[string][]idx.Node, zb0002)
 			} else if len(z.Nodes) > 0 {
 				for key := range z.Nodes {
 					delete(z.Nodes, key)
 				}
 			}
-			for zjfb > 0 {
-				var zwht string
-				var zhct []idx.Node
-				zjfb--
-				zwht, bts, err = msgp.ReadStringBytes(bts)
+			for zb0002 > 0 {
+				var za0001 string
+				var za0002 []idx.Node
+				zb0002--
+				za0001, bts, err = msgp.ReadStringBytes(bts)
 				if err != nil {
 					return
 				}
-				var zcxo uint32
-				zcxo, bts, err = msgp.ReadArrayHeaderBytes(bts)
+				var zb0003 uint32
+				zb0003, bts, err = msgp.ReadArrayHeaderBytes(bts)
 				if err != nil {
 					return
 				}
-				if cap(zhct) >= int(zcxo) {
-					zhct = (zhct)[:zcxo]
+				if cap(za0002) >= int(zb0003) {
+					za0002 = (za0002)[:zb0003]
 				} else {
-					zhct = make([]idx.Node, zcxo)
+					za0002 = make([]idx.Node, zb0003)
 				}
-				for zcua := range zhct {
-					bts, err = zhct[zcua].UnmarshalMsg(bts)
+				for za0003 := range za0002 {
+					bts, err = za0002[za0003].UnmarshalMsg(bts)
 					if err != nil {
 						return
 					}
 				}
-				z.Nodes[zwht] = zhct
+				z.Nodes[za0001] = za0002
 			}
 		default:
 			bts, err = msgp.Skip(bts)
@@ -328,11 +390,11 @@ func (z *IndexFindResp) UnmarshalMsg(bts []byte) (o []byte, err error) {
 func (z *IndexFindResp) Msgsize() (s int) {
 	s = 1 + 6 + msgp.MapHeaderSize
 	if z.Nodes != nil {
-		for zwht, zhct := range z.Nodes {
-			_ = zhct
-			s += msgp.StringPrefixSize + len(zwht) + msgp.ArrayHeaderSize
-			for zcua := range zhct {
-				s += zhct[zcua].Msgsize()
+		for za0001, za0002 := range z.Nodes {
+			_ = za0002
+			s += msgp.StringPrefixSize + len(za0001) + msgp.ArrayHeaderSize
+			for za0003 := range za0002 {
+				s += za0002[za0003].Msgsize()
 			}
 		}
 	}
@@ -343,13 +405,13 @@ func (z *IndexFindResp) Msgsize() (s int) {
 func (z *MetricsDeleteResp) DecodeMsg(dc *msgp.Reader) (err error) {
 	var field []byte
 	_ = field
-	var zeff uint32
-	zeff, err = dc.ReadMapHeader()
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
 	if err != nil {
 		return
 	}
-	for zeff > 0 {
-		zeff--
+	for zb0001 > 0 {
+		zb0001--
 		field, err = dc.ReadMapKeyPtr()
 		if err != nil {
 			return
@@ -376,7 +438,7 @@ func (z MetricsDeleteResp) EncodeMsg(en *msgp.Writer) (err error) {
 	// write "DeletedDefs"
 	err = en.Append(0x81, 0xab, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x44, 0x65, 0x66, 0x73)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteInt(z.DeletedDefs)
 	if err != nil {
@@ -399,13 +461,13 @@ func (z MetricsDeleteResp) MarshalMsg(b []byte) (o []byte, err error) {
 func (z *MetricsDeleteResp) UnmarshalMsg(bts []byte) (o []byte, err error) {
 	var field []byte
 	_ = field
-	var zrsw uint32
-	zrsw, bts, err = msgp.ReadMapHeaderBytes(bts)
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
 	if err != nil {
 		return
 	}
-	for zrsw > 0 {
-		zrsw--
+	for zb0001 > 0 {
+		zb0001--
 		field, bts, err = msgp.ReadMapKeyZC(bts)
 		if err != nil {
 			return
@@ -432,3 +494,180 @@ func (z MetricsDeleteResp) Msgsize() (s int) {
 	s = 1 + 12 + msgp.IntSize
 	return
 }
+
+// DecodeMsg implements msgp.Decodable
+func (z *PartialSeries) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Aggregate":
+			err = z.Aggregate.DecodeMsg(dc)
+			if err != nil {
+				return
+			}
+		case "Series":
+			err = z.Series.DecodeMsg(dc)
+			if err != nil {
+				return
+			}
+		case "Counts":
+			var zb0002 uint32
+			zb0002, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Counts) >= int(zb0002) {
+				z.Counts = (z.Counts)[:zb0002]
+			} else {
+				z.Counts = make([]uint32, zb0002)
+			}
+			for za0001 := range z.Counts {
+				z.Counts[za0001], err = dc.ReadUint32()
+				if err != nil {
+					return
+				}
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *PartialSeries) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 3
+	// write "Aggregate"
+	err = en.Append(0x83, 0xa9, 0x41, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x65)
+	if err != nil {
+		return
+	}
+	err = z.Aggregate.EncodeMsg(en)
+	if err != nil {
+		return
+	}
+	// write "Series"
+	err = en.Append(0xa6, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73)
+	if err != nil {
+		return
+	}
+	err = z.Series.EncodeMsg(en)
+	if err != nil {
+		return
+	}
+	// write "Counts"
+	err = en.Append(0xa6, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Counts)))
+	if err != nil {
+		return
+	}
+	for za0001 := range z.Counts {
+		err = en.WriteUint32(z.Counts[za0001])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z *PartialSeries) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.Require(b, z.Msgsize())
+	// map header, size 3
+	// string "Aggregate"
+	o = append(o, 0x83, 0xa9, 0x41, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x65)
+	o, err = z.Aggregate.MarshalMsg(o)
+	if err != nil {
+		return
+	}
+	// string "Series"
+	o = append(o, 0xa6, 0x53, 0x65, 0x72, 0x69, 0x65, 0x73)
+	o, err = z.Series.MarshalMsg(o)
+	if err != nil {
+		return
+	}
+	// string "Counts"
+	o = append(o, 0xa6, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x73)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Counts)))
+	for za0001 := range z.Counts {
+		o = msgp.AppendUint32(o, z.Counts[za0001])
+	}
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *PartialSeries) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Aggregate":
+			bts, err = z.Aggregate.UnmarshalMsg(bts)
+			if err != nil {
+				return
+			}
+		case "Series":
+			bts, err = z.Series.UnmarshalMsg(bts)
+			if err != nil {
+				return
+			}
+		case "Counts":
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Counts) >= int(zb0002) {
+				z.Counts = (z.Counts)[:zb0002]
+			} else {
+				z.Counts = make([]uint32, zb0002)
+			}
+			for za0001 := range z.Counts {
+				z.Counts[za0001], bts, err = msgp.ReadUint32Bytes(bts)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			bts, err = msgp.Skip(bts)
+			if err != nil {
+				return
+			}
+		}
+	}
+	o = bts
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *PartialSeries) Msgsize() (s int) {
+	s = 1 + 10 + z.Aggregate.Msgsize() + 7 + z.Series.Msgsize() + 7 + msgp.ArrayHeaderSize + (len(z.Counts) * (msgp.Uint32Size))
+	return
+}
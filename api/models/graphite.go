@@ -26,6 +26,7 @@ type GraphiteRender struct {
 	Format        string   `json:"format" form:"format" binding:"In(,json,msgp,pickle)"`
 	NoProxy       bool     `json:"local" form:"local"` //this is set to true by graphite-web when it passes request to cluster servers
 	Process       string   `json:"process" form:"process" binding:"In(,none,stable,any);Default(stable)"`
+	Partial       string   `json:"partial" form:"partial" binding:"In(,true,false)"` // return the data that is available when not all shards or peers are. defaults to the partial-results setting
 }
 
 func (gr GraphiteRender) Validate(ctx *macaron.Context, errs binding.Errors) binding.Errors {
@@ -59,8 +60,57 @@ type GraphiteFind struct {
 	Jsonp  string `json:"jsonp" form:"jsonp"`
 }
 
+type GraphiteExpand struct {
+	Query       []string `json:"query" form:"query" binding:"Required"`
+	GroupByExpr bool     `json:"groupByExpr" form:"groupByExpr"`
+	LeavesOnly  bool     `json:"leavesOnly" form:"leavesOnly"`
+	Jsonp       string   `json:"jsonp" form:"jsonp"`
+}
+
 type MetricsDelete struct {
 	Query string `json:"query" form:"query" binding:"Required"`
+	Purge bool   `json:"purge" form:"purge"`
+}
+
+type MetricsPurgeResp struct {
+	DeletedDefs int    `json:"deletedDefs"`
+	JobId       string `json:"jobId"`
+}
+
+type MetricsCopy struct {
+	Query       string `json:"query" form:"query" binding:"Required"`
+	Pattern     string `json:"pattern" form:"pattern" binding:"Required"`
+	Replacement string `json:"replacement" form:"replacement"`
+	Delete      bool   `json:"delete" form:"delete"`
+	DryRun      bool   `json:"dryRun" form:"dryRun"`
+	// how the producers partition the metrics, which determines the partitions of the new names
+	PartitionScheme string `json:"partitionScheme" form:"partitionScheme"`
+	NumPartitions   int32  `json:"numPartitions" form:"numPartitions"`
+}
+
+type SeriesCopy struct {
+	From   string `json:"from"`
+	FromId string `json:"fromId"`
+	To     string `json:"to"`
+	ToId   string `json:"toId"`
+}
+
+type MetricsCopyResp struct {
+	Series []SeriesCopy `json:"series"`
+	JobId  string       `json:"jobId,omitempty"`
+}
+
+type MetricsRebuild struct {
+	FromTo
+	Query  string  `json:"query" form:"query" binding:"Required"`
+	Rate   float64 `json:"rate" form:"rate"`
+	Offset int     `json:"offset" form:"offset"`
+	DryRun bool    `json:"dryRun" form:"dryRun"`
+}
+
+type MetricsRebuildResp struct {
+	Series int    `json:"series"`
+	JobId  string `json:"jobId,omitempty"`
 }
 
 type MetricNames []idx.Archive
@@ -94,6 +144,13 @@ func (defs MetricNames) MarshalJSON() ([]byte, error) {
 	return defs.MarshalJSONFast(nil)
 }
 
+// SeriesExpand is the response to a /metrics/expand request.
+// Results is either a sorted list of paths, or, when grouping by expression,
+// a map of each query to its sorted list of paths.
+type SeriesExpand struct {
+	Results interface{} `json:"results"`
+}
+
 type SeriesCompleter map[string][]SeriesCompleterItem
 
 func NewSeriesCompleter() SeriesCompleter {
@@ -0,0 +1,43 @@
+package models
+
+// job states
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+//go:generate msgp
+type JobStatus struct {
+	Id       string   `json:"id"`
+	Kind     string   `json:"kind"`
+	State    string   `json:"state"`
+	Total    int      `json:"total"`   // number of items the job has to process
+	Done     int      `json:"done"`    // number of items processed so far
+	Skipped  int      `json:"skipped"` // number of processed items that needed no work on this node
+	Errors   []string `json:"errors"`  // errors encountered while processing items
+	Started  int64    `json:"started"`
+	Finished int64    `json:"finished"`
+}
+
+// Merge merges the status of the same job, as running on another node, into j.
+// the merged job only counts as done once it's done on all nodes.
+func (j *JobStatus) Merge(o JobStatus) {
+	j.Total += o.Total
+	j.Done += o.Done
+	j.Skipped += o.Skipped
+	j.Errors = append(j.Errors, o.Errors...)
+	if o.Started < j.Started {
+		j.Started = o.Started
+	}
+	switch {
+	case j.State == JobRunning || o.State == JobRunning:
+		j.State = JobRunning
+		j.Finished = 0
+	case j.State == JobFailed || o.State == JobFailed:
+		j.State = JobFailed
+	}
+	if j.State != JobRunning && o.Finished > j.Finished {
+		j.Finished = o.Finished
+	}
+}
@@ -0,0 +1,316 @@
+package models
+
+// NOTE: THIS FILE WAS PRODUCED BY THE
+// MSGP CODE GENERATION TOOL (github.com/tinylib/msgp)
+// DO NOT EDIT
+
+import (
+	"github.com/tinylib/msgp/msgp"
+)
+
+// DecodeMsg implements msgp.Decodable
+func (z *JobStatus) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Id":
+			z.Id, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "Kind":
+			z.Kind, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "State":
+			z.State, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "Total":
+			z.Total, err = dc.ReadInt()
+			if err != nil {
+				return
+			}
+		case "Done":
+			z.Done, err = dc.ReadInt()
+			if err != nil {
+				return
+			}
+		case "Skipped":
+			z.Skipped, err = dc.ReadInt()
+			if err != nil {
+				return
+			}
+		case "Errors":
+			var zb0002 uint32
+			zb0002, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Errors) >= int(zb0002) {
+				z.Errors = (z.Errors)[:zb0002]
+			} else {
+				z.Errors = make([]string, zb0002)
+			}
+			for za0001 := range z.Errors {
+				z.Errors[za0001], err = dc.ReadString()
+				if err != nil {
+					return
+				}
+			}
+		case "Started":
+			z.Started, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		case "Finished":
+			z.Finished, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *JobStatus) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 9
+	// write "Id"
+	err = en.Append(0x89, 0xa2, 0x49, 0x64)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Id)
+	if err != nil {
+		return
+	}
+	// write "Kind"
+	err = en.Append(0xa4, 0x4b, 0x69, 0x6e, 0x64)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Kind)
+	if err != nil {
+		return
+	}
+	// write "State"
+	err = en.Append(0xa5, 0x53, 0x74, 0x61, 0x74, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.State)
+	if err != nil {
+		return
+	}
+	// write "Total"
+	err = en.Append(0xa5, 0x54, 0x6f, 0x74, 0x61, 0x6c)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.Total)
+	if err != nil {
+		return
+	}
+	// write "Done"
+	err = en.Append(0xa4, 0x44, 0x6f, 0x6e, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.Done)
+	if err != nil {
+		return
+	}
+	// write "Skipped"
+	err = en.Append(0xa7, 0x53, 0x6b, 0x69, 0x70, 0x70, 0x65, 0x64)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.Skipped)
+	if err != nil {
+		return
+	}
+	// write "Errors"
+	err = en.Append(0xa6, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Errors)))
+	if err != nil {
+		return
+	}
+	for za0001 := range z.Errors {
+		err = en.WriteString(z.Errors[za0001])
+		if err != nil {
+			return
+		}
+	}
+	// write "Started"
+	err = en.Append(0xa7, 0x53, 0x74, 0x61, 0x72, 0x74, 0x65, 0x64)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt64(z.Started)
+	if err != nil {
+		return
+	}
+	// write "Finished"
+	err = en.Append(0xa8, 0x46, 0x69, 0x6e, 0x69, 0x73, 0x68, 0x65, 0x64)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt64(z.Finished)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z *JobStatus) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.Require(b, z.Msgsize())
+	// map header, size 9
+	// string "Id"
+	o = append(o, 0x89, 0xa2, 0x49, 0x64)
+	o = msgp.AppendString(o, z.Id)
+	// string "Kind"
+	o = append(o, 0xa4, 0x4b, 0x69, 0x6e, 0x64)
+	o = msgp.AppendString(o, z.Kind)
+	// string "State"
+	o = append(o, 0xa5, 0x53, 0x74, 0x61, 0x74, 0x65)
+	o = msgp.AppendString(o, z.State)
+	// string "Total"
+	o = append(o, 0xa5, 0x54, 0x6f, 0x74, 0x61, 0x6c)
+	o = msgp.AppendInt(o, z.Total)
+	// string "Done"
+	o = append(o, 0xa4, 0x44, 0x6f, 0x6e, 0x65)
+	o = msgp.AppendInt(o, z.Done)
+	// string "Skipped"
+	o = append(o, 0xa7, 0x53, 0x6b, 0x69, 0x70, 0x70, 0x65, 0x64)
+	o = msgp.AppendInt(o, z.Skipped)
+	// string "Errors"
+	o = append(o, 0xa6, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x73)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Errors)))
+	for za0001 := range z.Errors {
+		o = msgp.AppendString(o, z.Errors[za0001])
+	}
+	// string "Started"
+	o = append(o, 0xa7, 0x53, 0x74, 0x61, 0x72, 0x74, 0x65, 0x64)
+	o = msgp.AppendInt64(o, z.Started)
+	// string "Finished"
+	o = append(o, 0xa8, 0x46, 0x69, 0x6e, 0x69, 0x73, 0x68, 0x65, 0x64)
+	o = msgp.AppendInt64(o, z.Finished)
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *JobStatus) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Id":
+			z.Id, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				return
+			}
+		case "Kind":
+			z.Kind, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				return
+			}
+		case "State":
+			z.State, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				return
+			}
+		case "Total":
+			z.Total, bts, err = msgp.ReadIntBytes(bts)
+			if err != nil {
+				return
+			}
+		case "Done":
+			z.Done, bts, err = msgp.ReadIntBytes(bts)
+			if err != nil {
+				return
+			}
+		case "Skipped":
+			z.Skipped, bts, err = msgp.ReadIntBytes(bts)
+			if err != nil {
+				return
+			}
+		case "Errors":
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Errors) >= int(zb0002) {
+				z.Errors = (z.Errors)[:zb0002]
+			} else {
+				z.Errors = make([]string, zb0002)
+			}
+			for za0001 := range z.Errors {
+				z.Errors[za0001], bts, err = msgp.ReadStringBytes(bts)
+				if err != nil {
+					return
+				}
+			}
+		case "Started":
+			z.Started, bts, err = msgp.ReadInt64Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Finished":
+			z.Finished, bts, err = msgp.ReadInt64Bytes(bts)
+			if err != nil {
+				return
+			}
+		default:
+			bts, err = msgp.Skip(bts)
+			if err != nil {
+				return
+			}
+		}
+	}
+	o = bts
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *JobStatus) Msgsize() (s int) {
+	s = 1 + 3 + msgp.StringPrefixSize + len(z.Id) + 5 + msgp.StringPrefixSize + len(z.Kind) + 6 + msgp.StringPrefixSize + len(z.State) + 6 + msgp.IntSize + 5 + msgp.IntSize + 8 + msgp.IntSize + 7 + msgp.ArrayHeaderSize
+	for za0001 := range z.Errors {
+		s += msgp.StringPrefixSize + len(z.Errors[za0001])
+	}
+	s += 8 + msgp.Int64Size + 9 + msgp.Int64Size
+	return
+}
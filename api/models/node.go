@@ -3,6 +3,7 @@ package models
 import (
 	"github.com/grafana/metrictank/cluster"
 	opentracing "github.com/opentracing/opentracing-go"
+	"gopkg.in/raintank/schema.v1"
 )
 
 type NodeStatus struct {
@@ -77,12 +78,60 @@ func (g GetData) TraceDebug(span opentracing.Span) {
 type IndexDelete struct {
 	Query string `json:"query" form:"query" binding:"Required"`
 	OrgId int    `json:"orgId" form:"orgId" binding:"Required"`
+	Purge bool   `json:"purge" form:"purge"`
+	JobId string `json:"jobId" form:"jobId"`
 }
 
 func (i IndexDelete) Trace(span opentracing.Span) {
 	span.SetTag("q", i.Query)
 	span.SetTag("org", i.OrgId)
+	span.SetTag("purge", i.Purge)
 }
 
 func (i IndexDelete) TraceDebug(span opentracing.Span) {
 }
+
+// IndexDeleteIds deletes the definitions with the given ids from the index, but not the other definitions with the same name
+type IndexDeleteIds struct {
+	OrgId int      `json:"orgId" form:"orgId" binding:"Required"`
+	Ids   []string `json:"ids" form:"ids"`
+}
+
+func (i IndexDeleteIds) Trace(span opentracing.Span) {
+	span.SetTag("org", i.OrgId)
+	span.SetTag("num_ids", len(i.Ids))
+}
+
+func (i IndexDeleteIds) TraceDebug(span opentracing.Span) {
+}
+
+// IndexAdd adds the given definitions to the index of the nodes that consume their partition
+type IndexAdd struct {
+	OrgId int                       `json:"orgId" form:"orgId" binding:"Required"`
+	Defs  []schema.MetricDefinition `json:"defs"`
+}
+
+func (i IndexAdd) Trace(span opentracing.Span) {
+	span.SetTag("org", i.OrgId)
+	span.SetTag("num_defs", len(i.Defs))
+}
+
+func (i IndexAdd) TraceDebug(span opentracing.Span) {
+}
+
+type JobGet struct {
+	Id string `json:"id" form:"id" binding:"Required"`
+}
+
+type JobGetLocal struct {
+	Id    string `json:"id" form:"id" binding:"Required"`
+	OrgId int    `json:"orgId" form:"orgId" binding:"Required"`
+}
+
+func (j JobGetLocal) Trace(span opentracing.Span) {
+	span.SetTag("job", j.Id)
+	span.SetTag("org", j.OrgId)
+}
+
+func (j JobGetLocal) TraceDebug(span opentracing.Span) {
+}
@@ -34,6 +34,9 @@ type Req struct {
 	TTL          uint32 `json:"ttl"`          // the ttl of the archive we'll fetch
 	OutInterval  uint32 `json:"outInterval"`  // the interval of the output data, after any runtime consolidation
 	AggNum       uint32 `json:"aggNum"`       // how many points to consolidate together at runtime, after fetching from the archive
+
+	// the aggregation the query applies to all series of the request, if the peer may return a partial aggregate instead of the series
+	Aggregate consolidation.Consolidator `json:"aggregate"`
 }
 
 func NewReq(key, target, patt string, from, to, maxPoints, rawInterval uint32, cons, consReq consolidation.Consolidator, node cluster.Node, schemaId, aggId uint16) Req {
@@ -55,6 +58,7 @@ func NewReq(key, target, patt string, from, to, maxPoints, rawInterval uint32, c
 		0,  // this is supposed to be updated still
 		0,  // this is supposed to be updated still
 		0,  // this is supposed to be updated still
+		0,  // this is supposed to be updated still
 	}
 }
 
@@ -20,6 +20,7 @@ type Series struct {
 	QueryTo      uint32                     // to tie series back to request it came from
 	QueryCons    consolidation.Consolidator // to tie series back to request it came from (may be 0 to mean use configured default)
 	Consolidator consolidation.Consolidator // consolidator to actually use (for fetched series this may not be 0, default must be resolved. if series created by function, may be 0)
+	XFilesFactor float64                    // minimum ratio of non-null points for runtime consolidation to not result in null. see conf.Aggregation
 }
 
 type SeriesByTarget []Series
@@ -13,13 +13,13 @@ import (
 func (z *Series) DecodeMsg(dc *msgp.Reader) (err error) {
 	var field []byte
 	_ = field
-	var zbzg uint32
-	zbzg, err = dc.ReadMapHeader()
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
 	if err != nil {
 		return
 	}
-	for zbzg > 0 {
-		zbzg--
+	for zb0001 > 0 {
+		zb0001--
 		field, err = dc.ReadMapKeyPtr()
 		if err != nil {
 			return
@@ -31,18 +31,18 @@ func (z *Series) DecodeMsg(dc *msgp.Reader) (err error) {
 				return
 			}
 		case "Datapoints":
-			var zbai uint32
-			zbai, err = dc.ReadArrayHeader()
+			var zb0002 uint32
+			zb0002, err = dc.ReadArrayHeader()
 			if err != nil {
 				return
 			}
-			if cap(z.Datapoints) >= int(zbai) {
-				z.Datapoints = (z.Datapoints)[:zbai]
+			if cap(z.Datapoints) >= int(zb0002) {
+				z.Datapoints = (z.Datapoints)[:zb0002]
 			} else {
-				z.Datapoints = make([]schema.Point, zbai)
+				z.Datapoints = make([]schema.Point, zb0002)
 			}
-			for zxvk := range z.Datapoints {
-				err = z.Datapoints[zxvk].DecodeMsg(dc)
+			for za0001 := range z.Datapoints {
+				err = z.Datapoints[za0001].DecodeMsg(dc)
 				if err != nil {
 					return
 				}
@@ -77,6 +77,11 @@ func (z *Series) DecodeMsg(dc *msgp.Reader) (err error) {
 			if err != nil {
 				return
 			}
+		case "XFilesFactor":
+			z.XFilesFactor, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
 		default:
 			err = dc.Skip()
 			if err != nil {
@@ -89,11 +94,11 @@ func (z *Series) DecodeMsg(dc *msgp.Reader) (err error) {
 
 // EncodeMsg implements msgp.Encodable
 func (z *Series) EncodeMsg(en *msgp.Writer) (err error) {
-	// map header, size 8
+	// map header, size 9
 	// write "Target"
-	err = en.Append(0x88, 0xa6, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74)
+	err = en.Append(0x89, 0xa6, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteString(z.Target)
 	if err != nil {
@@ -102,14 +107,14 @@ func (z *Series) EncodeMsg(en *msgp.Writer) (err error) {
 	// write "Datapoints"
 	err = en.Append(0xaa, 0x44, 0x61, 0x74, 0x61, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x73)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteArrayHeader(uint32(len(z.Datapoints)))
 	if err != nil {
 		return
 	}
-	for zxvk := range z.Datapoints {
-		err = z.Datapoints[zxvk].EncodeMsg(en)
+	for za0001 := range z.Datapoints {
+		err = z.Datapoints[za0001].EncodeMsg(en)
 		if err != nil {
 			return
 		}
@@ -117,7 +122,7 @@ func (z *Series) EncodeMsg(en *msgp.Writer) (err error) {
 	// write "Interval"
 	err = en.Append(0xa8, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteUint32(z.Interval)
 	if err != nil {
@@ -126,7 +131,7 @@ func (z *Series) EncodeMsg(en *msgp.Writer) (err error) {
 	// write "QueryPatt"
 	err = en.Append(0xa9, 0x51, 0x75, 0x65, 0x72, 0x79, 0x50, 0x61, 0x74, 0x74)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteString(z.QueryPatt)
 	if err != nil {
@@ -135,7 +140,7 @@ func (z *Series) EncodeMsg(en *msgp.Writer) (err error) {
 	// write "QueryFrom"
 	err = en.Append(0xa9, 0x51, 0x75, 0x65, 0x72, 0x79, 0x46, 0x72, 0x6f, 0x6d)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteUint32(z.QueryFrom)
 	if err != nil {
@@ -144,7 +149,7 @@ func (z *Series) EncodeMsg(en *msgp.Writer) (err error) {
 	// write "QueryTo"
 	err = en.Append(0xa7, 0x51, 0x75, 0x65, 0x72, 0x79, 0x54, 0x6f)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteUint32(z.QueryTo)
 	if err != nil {
@@ -153,7 +158,7 @@ func (z *Series) EncodeMsg(en *msgp.Writer) (err error) {
 	// write "QueryCons"
 	err = en.Append(0xa9, 0x51, 0x75, 0x65, 0x72, 0x79, 0x43, 0x6f, 0x6e, 0x73)
 	if err != nil {
-		return err
+		return
 	}
 	err = z.QueryCons.EncodeMsg(en)
 	if err != nil {
@@ -162,27 +167,36 @@ func (z *Series) EncodeMsg(en *msgp.Writer) (err error) {
 	// write "Consolidator"
 	err = en.Append(0xac, 0x43, 0x6f, 0x6e, 0x73, 0x6f, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x6f, 0x72)
 	if err != nil {
-		return err
+		return
 	}
 	err = z.Consolidator.EncodeMsg(en)
 	if err != nil {
 		return
 	}
+	// write "XFilesFactor"
+	err = en.Append(0xac, 0x58, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x46, 0x61, 0x63, 0x74, 0x6f, 0x72)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.XFilesFactor)
+	if err != nil {
+		return
+	}
 	return
 }
 
 // MarshalMsg implements msgp.Marshaler
 func (z *Series) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
-	// map header, size 8
+	// map header, size 9
 	// string "Target"
-	o = append(o, 0x88, 0xa6, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74)
+	o = append(o, 0x89, 0xa6, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74)
 	o = msgp.AppendString(o, z.Target)
 	// string "Datapoints"
 	o = append(o, 0xaa, 0x44, 0x61, 0x74, 0x61, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x73)
 	o = msgp.AppendArrayHeader(o, uint32(len(z.Datapoints)))
-	for zxvk := range z.Datapoints {
-		o, err = z.Datapoints[zxvk].MarshalMsg(o)
+	for za0001 := range z.Datapoints {
+		o, err = z.Datapoints[za0001].MarshalMsg(o)
 		if err != nil {
 			return
 		}
@@ -211,6 +225,9 @@ func (z *Series) MarshalMsg(b []byte) (o []byte, err error) {
 	if err != nil {
 		return
 	}
+	// string "XFilesFactor"
+	o = append(o, 0xac, 0x58, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x46, 0x61, 0x63, 0x74, 0x6f, 0x72)
+	o = msgp.AppendFloat64(o, z.XFilesFactor)
 	return
 }
 
@@ -218,13 +235,13 @@ func (z *Series) MarshalMsg(b []byte) (o []byte, err error) {
 func (z *Series) UnmarshalMsg(bts []byte) (o []byte, err error) {
 	var field []byte
 	_ = field
-	var zcmr uint32
-	zcmr, bts, err = msgp.ReadMapHeaderBytes(bts)
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
 	if err != nil {
 		return
 	}
-	for zcmr > 0 {
-		zcmr--
+	for zb0001 > 0 {
+		zb0001--
 		field, bts, err = msgp.ReadMapKeyZC(bts)
 		if err != nil {
 			return
@@ -236,18 +253,18 @@ func (z *Series) UnmarshalMsg(bts []byte) (o []byte, err error) {
 				return
 			}
 		case "Datapoints":
-			var zajw uint32
-			zajw, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadArrayHeaderBytes(bts)
 			if err != nil {
 				return
 			}
-			if cap(z.Datapoints) >= int(zajw) {
-				z.Datapoints = (z.Datapoints)[:zajw]
+			if cap(z.Datapoints) >= int(zb0002) {
+				z.Datapoints = (z.Datapoints)[:zb0002]
 			} else {
-				z.Datapoints = make([]schema.Point, zajw)
+				z.Datapoints = make([]schema.Point, zb0002)
 			}
-			for zxvk := range z.Datapoints {
-				bts, err = z.Datapoints[zxvk].UnmarshalMsg(bts)
+			for za0001 := range z.Datapoints {
+				bts, err = z.Datapoints[za0001].UnmarshalMsg(bts)
 				if err != nil {
 					return
 				}
@@ -282,6 +299,11 @@ func (z *Series) UnmarshalMsg(bts []byte) (o []byte, err error) {
 			if err != nil {
 				return
 			}
+		case "XFilesFactor":
+			z.XFilesFactor, bts, err = msgp.ReadFloat64Bytes(bts)
+			if err != nil {
+				return
+			}
 		default:
 			bts, err = msgp.Skip(bts)
 			if err != nil {
@@ -296,27 +318,27 @@ func (z *Series) UnmarshalMsg(bts []byte) (o []byte, err error) {
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *Series) Msgsize() (s int) {
 	s = 1 + 7 + msgp.StringPrefixSize + len(z.Target) + 11 + msgp.ArrayHeaderSize
-	for zxvk := range z.Datapoints {
-		s += z.Datapoints[zxvk].Msgsize()
+	for za0001 := range z.Datapoints {
+		s += z.Datapoints[za0001].Msgsize()
 	}
-	s += 9 + msgp.Uint32Size + 10 + msgp.StringPrefixSize + len(z.QueryPatt) + 10 + msgp.Uint32Size + 8 + msgp.Uint32Size + 10 + z.QueryCons.Msgsize() + 13 + z.Consolidator.Msgsize()
+	s += 9 + msgp.Uint32Size + 10 + msgp.StringPrefixSize + len(z.QueryPatt) + 10 + msgp.Uint32Size + 8 + msgp.Uint32Size + 10 + z.QueryCons.Msgsize() + 13 + z.Consolidator.Msgsize() + 13 + msgp.Float64Size
 	return
 }
 
 // DecodeMsg implements msgp.Decodable
 func (z *SeriesByTarget) DecodeMsg(dc *msgp.Reader) (err error) {
-	var zcua uint32
-	zcua, err = dc.ReadArrayHeader()
+	var zb0002 uint32
+	zb0002, err = dc.ReadArrayHeader()
 	if err != nil {
 		return
 	}
-	if cap((*z)) >= int(zcua) {
-		(*z) = (*z)[:zcua]
+	if cap((*z)) >= int(zb0002) {
+		(*z) = (*z)[:zb0002]
 	} else {
-		(*z) = make(SeriesByTarget, zcua)
+		(*z) = make(SeriesByTarget, zb0002)
 	}
-	for zhct := range *z {
-		err = (*z)[zhct].DecodeMsg(dc)
+	for zb0001 := range *z {
+		err = (*z)[zb0001].DecodeMsg(dc)
 		if err != nil {
 			return
 		}
@@ -330,8 +352,8 @@ func (z SeriesByTarget) EncodeMsg(en *msgp.Writer) (err error) {
 	if err != nil {
 		return
 	}
-	for zxhx := range z {
-		err = z[zxhx].EncodeMsg(en)
+	for zb0003 := range z {
+		err = z[zb0003].EncodeMsg(en)
 		if err != nil {
 			return
 		}
@@ -343,8 +365,8 @@ func (z SeriesByTarget) EncodeMsg(en *msgp.Writer) (err error) {
 func (z SeriesByTarget) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
 	o = msgp.AppendArrayHeader(o, uint32(len(z)))
-	for zxhx := range z {
-		o, err = z[zxhx].MarshalMsg(o)
+	for zb0003 := range z {
+		o, err = z[zb0003].MarshalMsg(o)
 		if err != nil {
 			return
 		}
@@ -354,18 +376,18 @@ func (z SeriesByTarget) MarshalMsg(b []byte) (o []byte, err error) {
 
 // UnmarshalMsg implements msgp.Unmarshaler
 func (z *SeriesByTarget) UnmarshalMsg(bts []byte) (o []byte, err error) {
-	var zdaf uint32
-	zdaf, bts, err = msgp.ReadArrayHeaderBytes(bts)
+	var zb0002 uint32
+	zb0002, bts, err = msgp.ReadArrayHeaderBytes(bts)
 	if err != nil {
 		return
 	}
-	if cap((*z)) >= int(zdaf) {
-		(*z) = (*z)[:zdaf]
+	if cap((*z)) >= int(zb0002) {
+		(*z) = (*z)[:zb0002]
 	} else {
-		(*z) = make(SeriesByTarget, zdaf)
+		(*z) = make(SeriesByTarget, zb0002)
 	}
-	for zlqf := range *z {
-		bts, err = (*z)[zlqf].UnmarshalMsg(bts)
+	for zb0001 := range *z {
+		bts, err = (*z)[zb0001].UnmarshalMsg(bts)
 		if err != nil {
 			return
 		}
@@ -377,8 +399,8 @@ func (z *SeriesByTarget) UnmarshalMsg(bts []byte) (o []byte, err error) {
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z SeriesByTarget) Msgsize() (s int) {
 	s = msgp.ArrayHeaderSize
-	for zpks := range z {
-		s += z[zpks].Msgsize()
+	for zb0003 := range z {
+		s += z[zb0003].Msgsize()
 	}
 	return
 }
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/stats"
+)
+
+// PartialResultsHeader is the header that lists what is missing from partial results
+const PartialResultsHeader = "X-Metrictank-Warning"
+
+var (
+	// metric api.request.render.partial is the number of /render requests that returned partial results
+	reqRenderPartial = stats.NewCounter32("api.request.render.partial")
+	// metric api.partial.missing_partitions is the number of partitions that were missing from partial results, because no ready node consumes them
+	partialMissingPartitions = stats.NewCounter32("api.partial.missing_partitions")
+	// metric api.partial.failed_peers is the number of peers that failed to respond for partial results
+	partialFailedPeers = stats.NewCounter32("api.partial.failed_peers")
+)
+
+type partialKey struct{}
+
+// partialResults tracks what is missing from the results of a query that may return partial results,
+// rather than failing when not all data is available.
+type partialResults struct {
+	sync.Mutex
+	missingPartitions []int32
+	failedPeers       []string
+}
+
+// withPartialResults returns a context for a query that may return partial results
+func withPartialResults(ctx context.Context) (context.Context, *partialResults) {
+	p := &partialResults{}
+	return context.WithValue(ctx, partialKey{}, p), p
+}
+
+// partialResultsFrom returns what is missing from the results of the query, or nil if the query may not return partial results
+func partialResultsFrom(ctx context.Context) *partialResults {
+	p, _ := ctx.Value(partialKey{}).(*partialResults)
+	return p
+}
+
+func (p *partialResults) addMissingPartitions(partitions []int32) {
+	p.Lock()
+	defer p.Unlock()
+	for _, part := range partitions {
+		if !containsPartition(p.missingPartitions, part) {
+			p.missingPartitions = append(p.missingPartitions, part)
+		}
+	}
+}
+
+func (p *partialResults) addFailedPeer(name string) {
+	p.Lock()
+	defer p.Unlock()
+	for _, n := range p.failedPeers {
+		if n == name {
+			return
+		}
+	}
+	p.failedPeers = append(p.failedPeers, name)
+}
+
+// renderResult returns the result of a render request with the given series, with what is missing from it.
+// the warning is "" if nothing is missing, or if the results may not be partial.
+func (p *partialResults) renderResult(series []models.Series) renderResult {
+	res := renderResult{series: series}
+	if p == nil {
+		return res
+	}
+	p.Lock()
+	defer p.Unlock()
+	res.warning = p.describe()
+	res.missingPartitions = len(p.missingPartitions)
+	res.failedPeers = len(p.failedPeers)
+	return res
+}
+
+// describe describes what is missing from the results. it must be called with the lock held.
+func (p *partialResults) describe() string {
+	if len(p.missingPartitions) == 0 && len(p.failedPeers) == 0 {
+		return ""
+	}
+	sort.Slice(p.missingPartitions, func(i, j int) bool { return p.missingPartitions[i] < p.missingPartitions[j] })
+	sort.Strings(p.failedPeers)
+	var parts []string
+	if len(p.missingPartitions) > 0 {
+		parts = append(parts, fmt.Sprintf("missing partitions: %s", strings.Trim(fmt.Sprint(p.missingPartitions), "[]")))
+	}
+	if len(p.failedPeers) > 0 {
+		parts = append(parts, fmt.Sprintf("failed peers: %s", strings.Join(p.failedPeers, " ")))
+	}
+	return "partial results; " + strings.Join(parts, "; ")
+}
+
+func containsPartition(partitions []int32, p int32) bool {
+	for _, part := range partitions {
+		if part == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/cluster"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+func TestPartialResultsWarning(t *testing.T) {
+	if p := partialResultsFrom(context.Background()); p != nil {
+		t.Fatalf("expected no partial results by default, got %+v", p)
+	}
+	ctx, p := withPartialResults(context.Background())
+	if partialResultsFrom(ctx) != p {
+		t.Fatalf("expected the context to carry the partial results")
+	}
+	if res := p.renderResult(nil); res.warning != "" || res.missingPartitions != 0 || res.failedPeers != 0 {
+		t.Fatalf("expected no warning when nothing is missing, got %+v", res)
+	}
+	p.addMissingPartitions([]int32{4, 3})
+	p.addMissingPartitions([]int32{3})
+	p.addFailedPeer("mt-2")
+	p.addFailedPeer("mt-1")
+	p.addFailedPeer("mt-2")
+	exp := "partial results; missing partitions: 3 4; failed peers: mt-1 mt-2"
+	res := p.renderResult(nil)
+	if res.warning != exp {
+		t.Fatalf("expected warning %q, got %q", exp, res.warning)
+	}
+	if res.missingPartitions != 2 || res.failedPeers != 2 {
+		t.Fatalf("expected 2 missing partitions and 2 failed peers, got %d and %d", res.missingPartitions, res.failedPeers)
+	}
+}
+
+func TestGetTargetsRemotePartial(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "timeout", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	apiPort, _ := strconv.Atoi(port)
+	peer := cluster.Node{Name: "mt-2", RemoteAddr: host, ApiPort: apiPort, ApiScheme: "http"}
+
+	cluster.Tracer = opentracing.NoopTracer{}
+	ctx := opentracing.ContextWithSpan(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+	reqs := map[string][]models.Req{
+		"mt-2": {models.NewReq("1.0123456789abcdef0123456789abcdef", "a", "a", 0, 10, 800, 10, 0, 0, peer, 0, 0)},
+	}
+
+	srv := &Server{}
+	if _, _, err := srv.getTargetsRemote(ctx, reqs); err == nil {
+		t.Fatalf("expected the failing peer to fail the request")
+	}
+
+	ctx, p := withPartialResults(ctx)
+	series, _, err := srv.getTargetsRemote(ctx, reqs)
+	if err != nil {
+		t.Fatalf("expected the failing peer to be skipped for partial results, got %s", err)
+	}
+	if len(series) != 0 {
+		t.Fatalf("expected no series, got %d", len(series))
+	}
+	exp := "partial results; failed peers: mt-2"
+	if w := p.renderResult(nil).warning; w != exp {
+		t.Fatalf("expected warning %q, got %q", exp, w)
+	}
+}
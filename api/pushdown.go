@@ -0,0 +1,124 @@
+package api
+
+import (
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/expr"
+	"github.com/grafana/metrictank/stats"
+)
+
+var (
+	// metric api.pushdown.series is the number of series requested from peers as part of a partial aggregate, rather than as series
+	pushDownSeries = stats.NewCounter32("api.pushdown.series")
+	// metric api.pushdown.partials is the number of partial aggregates received from peers, each of which replaces several series
+	pushDownPartials = stats.NewCounter32("api.pushdown.partials")
+	// metric api.pushdown.aggregated is the number of local series that were aggregated into partial aggregates for peers
+	pushDownAggregated = stats.NewCounter32("api.pushdown.aggregated")
+)
+
+// pushDown marks the remote requests whose series only feed into aggregations that can be pushed down,
+// such that the peers can return partial aggregates rather than all the series. see expr.Plan.PushDown
+// requests are not pushed down if the coordinator would merge some of their series with series of the same name,
+// as is the case for series whose data is spread over several nodes.
+func pushDown(reqs []models.Req, aggs map[expr.Req]consolidation.Consolidator) []models.Req {
+	if !aggregationPushDown || len(aggs) == 0 {
+		return reqs
+	}
+	type group struct {
+		ok      bool
+		targets map[string]models.Req
+	}
+	groups := make(map[expr.Req]*group)
+	for _, req := range reqs {
+		q := expr.NewReq(req.Pattern, req.From, req.To, req.ConsReq)
+		if _, ok := aggs[q]; !ok {
+			continue
+		}
+		g, ok := groups[q]
+		if !ok {
+			g = &group{ok: true, targets: make(map[string]models.Req)}
+			groups[q] = g
+		}
+		if prev, ok := g.targets[req.Target]; ok && (prev.Key != req.Key || prev.Node.Name != req.Node.Name) {
+			g.ok = false
+		}
+		g.targets[req.Target] = req
+	}
+
+	out := reqs[:0]
+	seen := make(map[expr.Req]map[string]struct{})
+	for _, req := range reqs {
+		q := expr.NewReq(req.Pattern, req.From, req.To, req.ConsReq)
+		g, ok := groups[q]
+		if !ok || !g.ok || req.Node.IsLocal() {
+			out = append(out, req)
+			continue
+		}
+		// the same pattern may be requested several times. the merged series would be aggregated only once
+		if _, ok := seen[q][req.Target]; ok {
+			continue
+		}
+		if seen[q] == nil {
+			seen[q] = make(map[string]struct{})
+		}
+		seen[q][req.Target] = struct{}{}
+		req.Aggregate = aggs[q]
+		pushDownSeries.Inc()
+		out = append(out, req)
+	}
+	return out
+}
+
+// aggregateLocal replaces the local series of the requests that have an Aggregate by partial aggregates,
+// for each request that has several series.
+func aggregateLocal(reqs []models.Req, series []models.Series) ([]models.Series, []models.PartialSeries) {
+	aggs := localAggregates(reqs)
+	if len(aggs) == 0 {
+		return series, nil
+	}
+	groups := make(map[expr.Req][]models.Series)
+	out := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		q := expr.NewReq(serie.QueryPatt, serie.QueryFrom, serie.QueryTo, serie.QueryCons)
+		if _, ok := aggs[q]; ok {
+			groups[q] = append(groups[q], serie)
+			continue
+		}
+		out = append(out, serie)
+	}
+	var partials []models.PartialSeries
+	for q, group := range groups {
+		// a single series is returned as is, for the function to treat it like it would when not pushed down
+		if len(group) == 1 {
+			out = append(out, group[0])
+			continue
+		}
+		partials = append(partials, expr.Aggregate(aggs[q], group))
+		pushDownAggregated.Add(len(group))
+	}
+	return out, partials
+}
+
+// localAggregates returns the aggregations that the requests ask to apply to their series
+func localAggregates(reqs []models.Req) map[expr.Req]consolidation.Consolidator {
+	aggs := make(map[expr.Req]consolidation.Consolidator)
+	for _, req := range reqs {
+		if req.Aggregate != 0 {
+			aggs[expr.NewReq(req.Pattern, req.From, req.To, req.ConsReq)] = req.Aggregate
+		}
+	}
+	return aggs
+}
+
+// combinePartials combines the partial aggregates received from peers with the other series of their request
+func combinePartials(data map[expr.Req][]models.Series, partials []models.PartialSeries) {
+	byReq := make(map[expr.Req][]models.PartialSeries)
+	for _, p := range partials {
+		q := expr.NewReq(p.Series.QueryPatt, p.Series.QueryFrom, p.Series.QueryTo, p.Series.QueryCons)
+		byReq[q] = append(byReq[q], p)
+	}
+	for q, p := range byReq {
+		pushDownPartials.Add(len(p))
+		data[q] = expr.Combine(p[0].Aggregate, data[q], p)
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/expr"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestPushDown(t *testing.T) {
+	aggregationPushDown = true
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	local := cluster.Manager.ThisNode()
+	peer1 := cluster.Node{Name: "peer1"}
+	peer2 := cluster.Node{Name: "peer2"}
+	req := func(key, target, patt string, node cluster.Node) models.Req {
+		return models.NewReq(key, target, patt, 0, 10, 800, 10, consolidation.Avg, 0, node, 0, 0)
+	}
+	reqs := []models.Req{
+		req("1.a", "foo.a", "foo.*", local),
+		req("1.b", "foo.b", "foo.*", peer1),
+		req("1.c", "foo.c", "foo.*", peer1),
+		req("1.c", "foo.c", "foo.*", peer1), // requested twice, for another target
+		req("1.d", "bar.d", "bar.*", peer1),
+		req("1.e", "bar.e", "bar.*", peer2), // also on peer1, under another key
+		req("2.e", "bar.e", "bar.*", peer1),
+		req("1.f", "baz.f", "baz.*", peer2),
+	}
+	aggs := map[expr.Req]consolidation.Consolidator{
+		expr.NewReq("foo.*", 0, 10, 0): consolidation.Sum,
+		expr.NewReq("bar.*", 0, 10, 0): consolidation.Sum,
+	}
+	got := pushDown(reqs, aggs)
+	exp := []struct {
+		key string
+		agg consolidation.Consolidator
+	}{
+		{"1.a", 0},
+		{"1.b", consolidation.Sum},
+		{"1.c", consolidation.Sum},
+		{"1.d", 0},
+		{"1.e", 0},
+		{"2.e", 0},
+		{"1.f", 0},
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("expected %d reqs, got %d: %v", len(exp), len(got), got)
+	}
+	for i, e := range exp {
+		if got[i].Key != e.key || got[i].Aggregate != e.agg {
+			t.Fatalf("req %d: expected %s with aggregate %d, got %s with aggregate %d", i, e.key, e.agg, got[i].Key, got[i].Aggregate)
+		}
+	}
+}
+
+func TestAggregateLocal(t *testing.T) {
+	serie := func(target, patt string, val float64) models.Series {
+		return models.Series{
+			Target:     target,
+			QueryPatt:  patt,
+			QueryTo:    10,
+			Datapoints: []schema.Point{{Val: val, Ts: 10}},
+			Interval:   10,
+		}
+	}
+	reqs := []models.Req{
+		{Pattern: "foo.*", To: 10, Aggregate: consolidation.Avg},
+		{Pattern: "bar.*", To: 10, Aggregate: consolidation.Avg},
+		{Pattern: "baz.*", To: 10},
+	}
+	series, partials := aggregateLocal(reqs, []models.Series{
+		serie("foo.a", "foo.*", 1),
+		serie("foo.b", "foo.*", 2),
+		serie("bar.a", "bar.*", 3),
+		serie("baz.a", "baz.*", 4),
+		serie("baz.b", "baz.*", 5),
+	})
+	if len(series) != 3 || len(partials) != 1 {
+		t.Fatalf("expected 3 series and 1 partial aggregate, got %d series and %d partial aggregates", len(series), len(partials))
+	}
+	p := partials[0]
+	if p.Series.QueryPatt != "foo.*" || p.Series.Datapoints[0].Val != 3 || !reflect.DeepEqual(p.Counts, []uint32{2}) {
+		t.Fatalf("expected the sum and count of foo.*, got %+v", p)
+	}
+
+	// the coordinator combines the partial aggregate with the other series of the request
+	data := map[expr.Req][]models.Series{
+		expr.NewReq("foo.*", 0, 10, 0): {serie("foo.c", "foo.*", 6)},
+	}
+	combinePartials(data, partials)
+	combined := data[expr.NewReq("foo.*", 0, 10, 0)]
+	if len(combined) != 1 || combined[0].Datapoints[0].Val != 3 {
+		t.Fatalf("expected the average of foo.*, got %+v", combined)
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/grafana/metrictank/api/middleware"
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/stats"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/worldping-api/pkg/log"
+)
+
+// metric api.rebuild.series is the number of series whose rollups have been rebuilt from their raw data
+var rebuiltSeries = stats.NewCounter32("api.rebuild.series")
+
+// how often rebuild jobs log their progress, so that an interrupted job can be resumed even if its status is lost,
+// e.g. because the node restarted
+const rebuildLogInterval = time.Minute
+
+// rebuildPlan returns the series of the given org to rebuild, deduplicated and ordered by id,
+// so that a job can be resumed by skipping the series that were already processed.
+func rebuildPlan(series []Series, orgId int) []idx.Archive {
+	plan := make([]idx.Archive, 0)
+	seen := make(map[string]struct{})
+	for _, s := range series {
+		for _, node := range s.Series {
+			for _, def := range node.Defs {
+				if def.OrgId != orgId {
+					continue
+				}
+				if _, ok := seen[def.Id]; ok {
+					continue
+				}
+				seen[def.Id] = struct{}{}
+				plan = append(plan, def)
+			}
+		}
+	}
+	sort.Slice(plan, func(i, j int) bool { return plan[i].Id < plan[j].Id })
+	return plan
+}
+
+// metricsRebuild recomputes the rollups of the series matching the query from their raw data in the backend store,
+// according to the current storage-schemas and storage-aggregation settings, in a background job.
+func (s *Server) metricsRebuild(ctx *middleware.Context, req models.MetricsRebuild) {
+	now := time.Now()
+	from, to, err := getFromTo(req.FromTo, now, 0, uint32(now.Unix()))
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if from >= to {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, "to must be higher than from"))
+		return
+	}
+	if req.Rate < 0 || req.Offset < 0 {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, "rate and offset can't be negative"))
+		return
+	}
+	series, err := s.findSeries(ctx.Req.Context(), ctx.OrgId, []string{req.Query}, 0)
+	if err != nil {
+		response.Write(ctx, response.WrapError(err))
+		return
+	}
+	plan := rebuildPlan(series, ctx.OrgId)
+	if err := checkFindLimit(len(plan)); err != nil {
+		response.Write(ctx, err)
+		return
+	}
+	if req.Offset > len(plan) {
+		response.Write(ctx, response.NewError(http.StatusBadRequest, fmt.Sprintf("offset %d exceeds the number of series %d", req.Offset, len(plan))))
+		return
+	}
+
+	resp := models.MetricsRebuildResp{
+		Series: len(plan),
+	}
+	if req.DryRun || len(plan) == req.Offset {
+		response.Write(ctx, response.NewJson(200, resp, ""))
+		return
+	}
+
+	log.Debug("HTTP metricsRebuild rebuilding %d series matching %q", len(plan)-req.Offset, req.Query)
+	resp.JobId = newJobId()
+	s.rebuildLocal(resp.JobId, ctx.OrgId, plan, req.Offset, from, to, req.Rate)
+	response.Write(ctx, response.NewJson(200, resp, ""))
+}
+
+// rebuildLocal starts a job that rebuilds the rollups of the series in the plan, skipping the first offset series.
+// the job counts the skipped series as done, so that the done count of an interrupted job is the offset to resume it with.
+// if rate is not 0, at most rate series are rebuilt per second.
+func (s *Server) rebuildLocal(jobId string, orgId int, plan []idx.Archive, offset int, from, to uint32, rate float64) {
+	j := newJob(jobId, "rebuild", orgId, len(plan))
+	j.status.Done = offset
+	jobs.Add(j)
+	go func() {
+		// the store expects a span in the context
+		span := s.Tracer.StartSpan("rebuild job")
+		span.SetTag("job", jobId)
+		defer span.Finish()
+		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		var throttle <-chan time.Time
+		if rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+			defer ticker.Stop()
+			throttle = ticker.C
+		}
+		lastLog := time.Now()
+		for i, def := range plan[offset:] {
+			if throttle != nil {
+				<-throttle
+			}
+			if time.Since(lastLog) >= rebuildLogInterval {
+				log.Info("HTTP rebuild: job %s rebuilt %d of %d series. to resume it, use offset %d", jobId, i, len(plan)-offset, offset+i)
+				lastLog = time.Now()
+			}
+			err := s.rebuildSeries(ctx, def, from, to)
+			if err == nil {
+				rebuiltSeries.Inc()
+			}
+			j.progress(err)
+		}
+		j.finish()
+		log.Info("HTTP rebuild: job %s finished rebuilding %d series", jobId, len(plan)-offset)
+	}()
+}
+
+func (s *Server) rebuildSeries(ctx context.Context, def idx.Archive, from, to uint32) error {
+	schemaId, schema := mdata.MatchSchema(def.Name, def.Interval)
+	aggId, agg := mdata.MatchAgg(def.Name, def.Mtype)
+	if _, err := mdata.RebuildRollups(ctx, s.BackendStore, def.Id, uint32(def.Interval), schema.Retentions, agg, from, to); err != nil {
+		log.Error(3, "HTTP rebuild: failed to rebuild the rollups of %s: %s", def.Id, err)
+		return fmt.Errorf("failed to rebuild %s: %s", def.Id, err)
+	}
+	// the cache may still have chunks of the old rollups
+	for _, key := range mdata.StoreKeys(def.Id, schemaId, aggId)[1:] {
+		s.Cache.DelMetric(key.Key)
+	}
+	return nil
+}
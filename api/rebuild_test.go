@@ -0,0 +1,45 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/idx"
+)
+
+func TestRebuildPlan(t *testing.T) {
+	fooA := testArchive(1, "foo.a")
+	fooB := testArchive(1, "foo.b")
+	fooC := testArchive(1, "foo.c")
+	public := testArchive(-1, "foo.d")
+	series := []Series{
+		{
+			Pattern: "foo.*",
+			Series: []idx.Node{
+				{Path: "foo.c", Leaf: true, Defs: []idx.Archive{fooC}},
+				{Path: "foo.a", Leaf: true, Defs: []idx.Archive{fooA}},
+				{Path: "foo.d", Leaf: true, Defs: []idx.Archive{public}},
+			},
+		},
+		{
+			// as found on another cluster node
+			Pattern: "foo.*",
+			Series: []idx.Node{
+				{Path: "foo.b", Leaf: true, Defs: []idx.Archive{fooB}},
+				{Path: "foo.a", Leaf: true, Defs: []idx.Archive{fooA}},
+			},
+		},
+	}
+
+	plan := rebuildPlan(series, 1)
+	if len(plan) != 3 {
+		t.Fatalf("expected 3 series to rebuild, got %d: %v", len(plan), plan)
+	}
+	for i := range plan {
+		if plan[i].OrgId != 1 {
+			t.Fatalf("expected only series of org 1, got %s of org %d", plan[i].Name, plan[i].OrgId)
+		}
+		if i > 0 && plan[i-1].Id >= plan[i].Id {
+			t.Fatalf("expected series ordered by id, got %s before %s", plan[i-1].Id, plan[i].Id)
+		}
+	}
+}
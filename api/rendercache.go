@@ -0,0 +1,350 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/stats"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// the render cache caches the results of /render requests, for dashboards that repeat the same requests.
+// requests have the same shape if they are of the same org, for the same targets, over time ranges of the same length,
+// and with the same maxDataPoints. the cache keeps the latest result of each shape.
+// the time ranges of requests are aligned to the finest interval of the latest result of their shape,
+// such that requests that only differ by less than the interval are identical, and share a result.
+// identical requests that are in flight at the same time are only executed once.
+// results are served as is for one interval, after which they may have new points.
+// when the time range of a request slid forward since the latest result, and its output points only depend on the input
+// points of the same timestamp, only the tail of the range is recomputed and the rest is taken from the latest result,
+// as long as the latest result is no older than the render-cache-max-age.
+
+var (
+	// metric api.render_cache.hits is the number of /render requests served from the render cache
+	renderCacheHits = stats.NewCounter32("api.render_cache.hits")
+	// metric api.render_cache.shared is the number of /render requests that shared the result of an identical request in flight
+	renderCacheShared = stats.NewCounter32("api.render_cache.shared")
+	// metric api.render_cache.tails is the number of /render requests of which only the tail of the time range was computed
+	renderCacheTails = stats.NewCounter32("api.render_cache.tails")
+	// metric api.render_cache.misses is the number of /render requests that were computed in full
+	renderCacheMisses = stats.NewCounter32("api.render_cache.misses")
+	// metric api.render_cache.entries is the number of results in the render cache
+	renderCacheEntries = stats.NewGauge32("api.render_cache.entries")
+
+	renderCache = newResultCache()
+)
+
+// renderShape is what identifies the requests whose results the render cache may reuse for each other
+type renderShape struct {
+	org     int
+	targets string // the targets, without insignificant whitespace
+	span    uint32 // the length of the time range
+	mdp     uint32
+	stable  bool
+	partial bool
+}
+
+type renderKey struct {
+	renderShape
+	from uint32
+	to   uint32
+}
+
+type renderResult struct {
+	series  []models.Series
+	warning string // what is missing from partial results. see partial.go
+
+	// the number of missing partitions and failed peers of partial results.
+	// like the warning, they are those of the execution that computed the result, which may be shared by several requests.
+	missingPartitions int
+	failedPeers       int
+}
+
+type renderEntry struct {
+	key     renderKey
+	series  []models.Series // the series are shared by all requests they are served to, and must not be modified
+	step    uint32          // the finest interval of the series
+	created time.Time       // the time the result was computed from
+	full    time.Time       // the time the head of the result was computed from. it is older than created if only the tail was computed
+}
+
+// fresh returns whether the result can be served as is at the given time
+func (e *renderEntry) fresh(now time.Time) bool {
+	return now.Before(e.created.Add(time.Duration(e.step) * time.Second))
+}
+
+// renderCall is the execution of a request in flight, which identical requests wait for
+type renderCall struct {
+	done     chan struct{}
+	res      renderResult
+	err      error
+	canceled bool // the request was cancelled, so the requests waiting for it must be executed by themselves
+}
+
+// resultCache caches the latest result of each shape of /render requests. see renderCache
+type resultCache struct {
+	sync.Mutex
+	size     int           // max number of entries. 0 disables the cache
+	maxAge   time.Duration // how long entries may be used to compute only the tail of the time range of later requests
+	entries  map[renderShape]*renderEntry
+	inflight map[renderKey]*renderCall
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{
+		entries:  make(map[renderShape]*renderEntry),
+		inflight: make(map[renderKey]*renderCall),
+	}
+}
+
+// setLimits sets the limits of the cache. see renderCache
+func (c *resultCache) setLimits(size int, maxAge time.Duration) {
+	c.Lock()
+	c.size = size
+	c.maxAge = maxAge
+	c.Unlock()
+}
+
+func (c *resultCache) enabled() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.size > 0
+}
+
+// key returns the key of a request, of which the time range is aligned to the finest interval of its shape.
+// from is inclusive and to is exclusive, such that aligning them up to the next multiple of the interval
+// doesn't change which points fall in the range.
+func (c *resultCache) key(org int, targets []string, from, to, mdp uint32, stable, partial bool) renderKey {
+	normalized := make([]string, len(targets))
+	for i, target := range targets {
+		normalized[i] = normalizeTarget(target)
+	}
+	key := renderKey{
+		renderShape: renderShape{
+			org:     org,
+			targets: strings.Join(normalized, "\n"),
+			span:    to - from,
+			mdp:     mdp,
+			stable:  stable,
+			partial: partial,
+		},
+		from: from,
+		to:   to,
+	}
+	c.Lock()
+	if e, ok := c.entries[key.renderShape]; ok {
+		key.from = alignUp(from, e.step)
+		key.to = alignUp(to, e.step)
+	}
+	c.Unlock()
+	return key
+}
+
+// get returns the result of the request, served from the cache, shared with an identical request in flight,
+// or computed by exec. exec computes the result for the given time range, into series that are not pooled.
+// if pointwise, only the tail of the time range may be computed, and the rest taken from the latest result of the shape.
+func (c *resultCache) get(ctx context.Context, key renderKey, pointwise bool, exec func(ctx context.Context, from, to uint32) (renderResult, error)) (renderResult, error) {
+	now := time.Now()
+	c.Lock()
+	base := c.entries[key.renderShape]
+	if base != nil && base.key == key && base.fresh(now) {
+		c.Unlock()
+		renderCacheHits.Inc()
+		return renderResult{series: base.series}, nil
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return renderResult{}, ctx.Err()
+		}
+		if !call.canceled {
+			renderCacheShared.Inc()
+			return call.res, call.err
+		}
+		renderCacheMisses.Inc()
+		return exec(ctx, key.from, key.to)
+	}
+	call := &renderCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	tailFrom := c.tailFrom(base, key, now)
+	c.Unlock()
+
+	var tail bool
+	call.res, tail, call.err = c.compute(ctx, key, pointwise, base, tailFrom, exec)
+	call.canceled = call.err != nil && ctx.Err() != nil
+
+	c.Lock()
+	delete(c.inflight, key)
+	if call.err == nil && call.res.warning == "" {
+		full := now
+		if tail {
+			full = base.full
+		}
+		c.add(key, call.res.series, now, full)
+	}
+	c.Unlock()
+	close(call.done)
+	return call.res, call.err
+}
+
+// tailFrom returns where the tail of the time range of the request starts, if the points before it can be taken from the base entry,
+// or 0 if they can't. the points of the last interval of the base entry may have been incomplete, and are recomputed.
+// the points are not taken from an entry whose head is older than the max age, such that late data is eventually seen.
+func (c *resultCache) tailFrom(base *renderEntry, key renderKey, now time.Time) uint32 {
+	if base == nil || now.Sub(base.full) > c.maxAge {
+		return 0
+	}
+	complete := uint32(base.created.Unix())
+	if base.key.to < complete {
+		complete = base.key.to
+	}
+	tailFrom := complete / base.step * base.step
+	if tailFrom <= base.step {
+		return 0
+	}
+	tailFrom -= base.step
+	if key.from < base.key.from || tailFrom <= key.from || tailFrom >= key.to {
+		return 0
+	}
+	return tailFrom
+}
+
+// compute computes the result of the request, or only its tail if it can. see tailFrom
+// it returns whether it only computed the tail.
+func (c *resultCache) compute(ctx context.Context, key renderKey, pointwise bool, base *renderEntry, tailFrom uint32, exec func(ctx context.Context, from, to uint32) (renderResult, error)) (renderResult, bool, error) {
+	if pointwise && tailFrom != 0 {
+		res, err := exec(ctx, tailFrom, key.to)
+		if err != nil {
+			return res, false, err
+		}
+		if series, ok := stitch(base.series, key.from, tailFrom, res.series); ok {
+			renderCacheTails.Inc()
+			res.series = series
+			return res, true, nil
+		}
+	}
+	renderCacheMisses.Inc()
+	res, err := exec(ctx, key.from, key.to)
+	return res, false, err
+}
+
+// add adds the result of the request as the latest result of its shape. it must be called with the lock held.
+func (c *resultCache) add(key renderKey, series []models.Series, created, full time.Time) {
+	var step uint32
+	for _, s := range series {
+		if s.Interval != 0 && (step == 0 || s.Interval < step) {
+			step = s.Interval
+		}
+	}
+	if step == 0 {
+		return
+	}
+	if _, ok := c.entries[key.renderShape]; !ok && len(c.entries) >= c.size {
+		c.evict(created)
+	}
+	c.entries[key.renderShape] = &renderEntry{
+		key:     key,
+		series:  series,
+		step:    step,
+		created: created,
+		full:    full,
+	}
+	renderCacheEntries.Set(len(c.entries))
+}
+
+// evict removes the entries that are too old to be used, or the oldest entry if none is.
+// it must be called with the lock held.
+func (c *resultCache) evict(now time.Time) {
+	var oldest *renderEntry
+	for shape, e := range c.entries {
+		if now.Sub(e.created) > c.maxAge {
+			delete(c.entries, shape)
+			continue
+		}
+		if oldest == nil || e.created.Before(oldest.created) {
+			oldest = e
+		}
+	}
+	if len(c.entries) >= c.size && oldest != nil {
+		delete(c.entries, oldest.key.renderShape)
+	}
+}
+
+// stitch returns the series of the base up to tailFrom, followed by the tail series from there on,
+// or false if the tail series don't line up with the series of the base.
+func stitch(base []models.Series, from, tailFrom uint32, tail []models.Series) ([]models.Series, bool) {
+	if len(base) != len(tail) {
+		return nil, false
+	}
+	byTarget := make(map[string]models.Series, len(base))
+	for _, s := range base {
+		if _, ok := byTarget[s.Target]; ok {
+			return nil, false
+		}
+		byTarget[s.Target] = s
+	}
+	out := make([]models.Series, 0, len(tail))
+	for _, t := range tail {
+		b, ok := byTarget[t.Target]
+		if !ok || b.Interval != t.Interval {
+			return nil, false
+		}
+		points := make([]schema.Point, 0, len(b.Datapoints)+len(t.Datapoints))
+		for _, p := range b.Datapoints {
+			if p.Ts >= from && p.Ts < tailFrom {
+				points = append(points, p)
+			}
+		}
+		t.Datapoints = append(points, t.Datapoints...)
+		if t.QueryFrom != 0 {
+			t.QueryFrom = from
+		}
+		out = append(out, t)
+	}
+	return out, true
+}
+
+// copySeries returns a copy of the series with their own datapoints, such that they can be kept after the plan they
+// were computed by returns its datapoints to the pool.
+func copySeries(in []models.Series) []models.Series {
+	out := make([]models.Series, len(in))
+	for i, s := range in {
+		s.Datapoints = append([]schema.Point(nil), s.Datapoints...)
+		out[i] = s
+	}
+	return out
+}
+
+// normalizeTarget removes the whitespace of the target that is not quoted
+func normalizeTarget(target string) string {
+	var b bytes.Buffer
+	var quote rune
+	for _, r := range target {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// alignUp returns the smallest multiple of step that is not smaller than ts
+func alignUp(ts, step uint32) uint32 {
+	if step == 0 || ts%step == 0 {
+		return ts
+	}
+	return ts + step - ts%step
+}
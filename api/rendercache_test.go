@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// rangeExec returns an exec func that computes a series with a point for each 10s in the time range, and records the time ranges it computed
+func rangeExec(calls *[][2]uint32) func(ctx context.Context, from, to uint32) (renderResult, error) {
+	return func(ctx context.Context, from, to uint32) (renderResult, error) {
+		*calls = append(*calls, [2]uint32{from, to})
+		var points []schema.Point
+		for ts := alignUp(from, 10); ts < to; ts += 10 {
+			points = append(points, schema.Point{Val: float64(ts), Ts: ts})
+		}
+		return renderResult{series: []models.Series{{Target: "foo", Interval: 10, Datapoints: points}}}, nil
+	}
+}
+
+func TestRenderCache(t *testing.T) {
+	c := newResultCache()
+	c.setLimits(10, time.Hour)
+	var calls [][2]uint32
+	exec := rangeExec(&calls)
+
+	key := c.key(1, []string{"sum(foo.*)"}, 1001, 2001, 800, true, false)
+	if key.from != 1001 || key.to != 2001 {
+		t.Fatalf("expected the time range of an unknown shape not to be aligned, got %d-%d", key.from, key.to)
+	}
+	c.get(context.Background(), key, true, exec)
+
+	// the time range is aligned to the interval of the series
+	key = c.key(1, []string{"sum( foo.* )"}, 1003, 2003, 800, true, false)
+	if key.from != 1010 || key.to != 2010 {
+		t.Fatalf("expected the time range to be aligned to 1010-2010, got %d-%d", key.from, key.to)
+	}
+	c.get(context.Background(), key, true, exec)
+	c.get(context.Background(), key, true, exec)
+	if len(calls) != 2 {
+		t.Fatalf("expected the identical request to be served from the cache, got calls %v", calls)
+	}
+
+	// once the window slid, only the tail is computed, starting at the last interval before the previous result was computed
+	entry := c.entries[key.renderShape]
+	entry.created = time.Unix(1800, 0)
+	entry.full = time.Now()
+	key = c.key(1, []string{"sum(foo.*)"}, 1500, 2500, 800, true, false)
+	res, err := c.get(context.Background(), key, true, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := [2]uint32{1790, 2500}; calls[len(calls)-1] != exp {
+		t.Fatalf("expected the tail %v to be computed, got %v", exp, calls[len(calls)-1])
+	}
+	full, _ := exec(context.Background(), 1500, 2500)
+	if !reflect.DeepEqual(res.series, full.series) {
+		t.Fatalf("expected the stitched result to equal the full result\nexp %v\ngot %v", full.series, res.series)
+	}
+
+	// the results of requests that are not pointwise are computed in full
+	c.entries[key.renderShape].created = time.Unix(2400, 0)
+	key = c.key(1, []string{"sum(foo.*)"}, 1600, 2600, 800, true, false)
+	c.get(context.Background(), key, false, exec)
+	if exp := [2]uint32{1600, 2600}; calls[len(calls)-1] != exp {
+		t.Fatalf("expected the full range %v to be computed, got %v", exp, calls[len(calls)-1])
+	}
+}
+
+func TestRenderCacheShared(t *testing.T) {
+	c := newResultCache()
+	c.setLimits(10, time.Hour)
+	key := c.key(1, []string{"foo.*"}, 1000, 2000, 800, true, false)
+
+	var mu sync.Mutex
+	var calls int
+	release := make(chan struct{})
+	exec := func(ctx context.Context, from, to uint32) (renderResult, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		return renderResult{series: []models.Series{{Target: "foo", Interval: 10}}, warning: "partial results; failed peers: mt-1", failedPeers: 1}, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.get(context.Background(), key, true, exec)
+			if err != nil || len(res.series) != 1 {
+				t.Errorf("expected the shared result, got %v (%v)", res.series, err)
+			}
+			if res.warning == "" || res.failedPeers != 1 {
+				t.Errorf("expected the shared result to carry its partial stats, got %q with %d failed peers", res.warning, res.failedPeers)
+			}
+		}()
+	}
+	// wait for the requests to be in flight, for them to share the execution
+	for {
+		c.Lock()
+		n := len(c.inflight)
+		c.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("expected the identical requests to be executed once, got %d executions", calls)
+	}
+}
+
+// the stats of partial results are those of the execution that computed them, also for requests that only computed the tail
+func TestRenderCachePartialTail(t *testing.T) {
+	c := newResultCache()
+	c.setLimits(10, time.Hour)
+	var calls [][2]uint32
+	full := rangeExec(&calls)
+	key := c.key(1, []string{"foo.*"}, 1000, 2000, 800, true, false)
+	c.get(context.Background(), key, true, full)
+
+	partial := func(ctx context.Context, from, to uint32) (renderResult, error) {
+		res, err := full(ctx, from, to)
+		res.warning = "partial results; missing partitions: 3"
+		res.missingPartitions = 1
+		return res, err
+	}
+	entry := c.entries[key.renderShape]
+	entry.created = time.Unix(1800, 0)
+	entry.full = time.Now()
+	key = c.key(1, []string{"foo.*"}, 1500, 2500, 800, true, false)
+	res, err := c.get(context.Background(), key, true, partial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := [2]uint32{1790, 2500}; calls[len(calls)-1] != exp {
+		t.Fatalf("expected the tail %v to be computed, got %v", exp, calls[len(calls)-1])
+	}
+	if res.warning == "" || res.missingPartitions != 1 || res.failedPeers != 0 {
+		t.Fatalf("expected the stitched result to keep the partial stats of the tail, got %q with %d missing partitions and %d failed peers", res.warning, res.missingPartitions, res.failedPeers)
+	}
+}
+
+func TestStitchMismatch(t *testing.T) {
+	base := []models.Series{{Target: "foo", Interval: 10}, {Target: "bar", Interval: 10}}
+	cases := map[string][]models.Series{
+		"other series":    {{Target: "foo", Interval: 10}, {Target: "baz", Interval: 10}},
+		"fewer series":    {{Target: "foo", Interval: 10}},
+		"other intervals": {{Target: "foo", Interval: 10}, {Target: "bar", Interval: 60}},
+	}
+	for name, tail := range cases {
+		if _, ok := stitch(base, 1000, 1500, tail); ok {
+			t.Fatalf("%s: expected the tail not to line up with the base", name)
+		}
+	}
+}
+
+func TestNormalizeTarget(t *testing.T) {
+	cases := map[string]string{
+		"sumSeries( foo.* , bar )":      "sumSeries(foo.*,bar)",
+		"alias(foo, 'foo bar')":         "alias(foo,'foo bar')",
+		`aliasSub(foo, "a b", ' c " ')`: `aliasSub(foo,"a b",' c " ')`,
+	}
+	for in, exp := range cases {
+		if got := normalizeTarget(in); got != exp {
+			t.Fatalf("%q: expected %q, got %q", in, exp, got)
+		}
+	}
+}
@@ -38,7 +38,10 @@ func (s *Server) RegisterRoutes() {
 	r.Combo("/index/find", ready, bind(models.IndexFind{})).Get(s.indexFind).Post(s.indexFind)
 	r.Combo("/index/list", ready, bind(models.IndexList{})).Get(s.indexList).Post(s.indexList)
 	r.Combo("/index/delete", ready, bind(models.IndexDelete{})).Get(s.indexDelete).Post(s.indexDelete)
+	r.Combo("/index/delete-ids", ready, bind(models.IndexDeleteIds{})).Get(s.indexDeleteIds).Post(s.indexDeleteIds)
+	r.Combo("/index/add", ready, bind(models.IndexAdd{})).Get(s.indexAdd).Post(s.indexAdd)
 	r.Combo("/index/get", ready, bind(models.IndexGet{})).Get(s.indexGet).Post(s.indexGet)
+	r.Combo("/jobs/local", ready, bind(models.JobGetLocal{})).Get(s.jobLocal).Post(s.jobLocal)
 
 	r.Options("/*", func(ctx *macaron.Context) {
 		ctx.Write(nil)
@@ -47,7 +50,11 @@ func (s *Server) RegisterRoutes() {
 	// Graphite endpoints
 	r.Combo("/render", cBody, withOrg, ready, bind(models.GraphiteRender{})).Get(s.renderMetrics).Post(s.renderMetrics)
 	r.Combo("/metrics/find", withOrg, ready, bind(models.GraphiteFind{})).Get(s.metricsFind).Post(s.metricsFind)
+	r.Combo("/metrics/expand", withOrg, ready, bind(models.GraphiteExpand{})).Get(s.metricsExpand).Post(s.metricsExpand)
 	r.Get("/metrics/index.json", withOrg, ready, s.metricsIndex)
 	r.Post("/metrics/delete", withOrg, ready, bind(models.MetricsDelete{}), s.metricsDelete)
+	r.Post("/metrics/copy", withOrg, ready, bind(models.MetricsCopy{}), s.metricsCopy)
+	r.Post("/metrics/rebuild", withOrg, ready, bind(models.MetricsRebuild{}), s.metricsRebuild)
+	r.Get("/jobs/status", withOrg, ready, bind(models.JobGet{}), s.getJob)
 
 }
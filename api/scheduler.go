@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/stats"
+)
+
+// render requests and the series fetches they fan out to are admitted by schedulers, which limit how many of them
+// run concurrently, globally and per org. requests beyond the limits wait in a queue, where the smallest ones go first,
+// and are rejected with 429 Too Many Requests if they don't get to run within the max-queue-wait.
+
+var (
+	// metric api.scheduler.render.queued is the number of render requests waiting to be admitted
+	renderQueued = stats.NewGauge32("api.scheduler.render.queued")
+	// metric api.scheduler.render.wait is how long render requests waited to be admitted
+	renderWait = stats.NewLatencyHistogram15s32("api.scheduler.render.wait")
+	// metric api.scheduler.render.rejected is the number of render requests rejected because they waited too long to be admitted
+	renderRejected = stats.NewCounter32("api.scheduler.render.rejected")
+	// metric api.scheduler.fetch.queued is the number of series fetches waiting to be admitted
+	fetchQueued = stats.NewGauge32("api.scheduler.fetch.queued")
+	// metric api.scheduler.fetch.wait is how long series fetches waited to be admitted
+	fetchWait = stats.NewLatencyHistogram15s32("api.scheduler.fetch.wait")
+	// metric api.scheduler.fetch.rejected is the number of series fetches rejected because they waited too long to be admitted
+	fetchRejected = stats.NewCounter32("api.scheduler.fetch.rejected")
+
+	renderScheduler = newScheduler(renderQueued, renderWait, renderRejected)
+	fetchScheduler  = newScheduler(fetchQueued, fetchWait, fetchRejected)
+)
+
+var errTooManyRequests = response.NewError(http.StatusTooManyRequests, "too many concurrent queries, try again later")
+
+// scheduler limits how many tasks run concurrently, globally and per org
+type scheduler struct {
+	sync.Mutex
+	limit    int           // max tasks running at once. 0 means unlimited
+	orgLimit int           // max tasks of an org running at once. 0 means unlimited
+	maxWait  time.Duration // how long tasks may wait to run before they are rejected
+	running  int
+	orgs     map[int]int // number of running tasks per org
+	queue    []*waiter
+	seq      uint64
+
+	queued   *stats.Gauge32
+	wait     *stats.LatencyHistogram15s32
+	rejected *stats.Counter32
+}
+
+// waiter is a task waiting to run. ready is closed once it may run.
+type waiter struct {
+	org     int
+	cost    int
+	seq     uint64
+	ready   chan struct{}
+	granted bool
+}
+
+func newScheduler(queued *stats.Gauge32, wait *stats.LatencyHistogram15s32, rejected *stats.Counter32) *scheduler {
+	return &scheduler{
+		orgs:     make(map[int]int),
+		queued:   queued,
+		wait:     wait,
+		rejected: rejected,
+	}
+}
+
+// setLimits sets the limits of the scheduler. see scheduler
+func (s *scheduler) setLimits(limit, orgLimit int, maxWait time.Duration) {
+	s.Lock()
+	s.limit = limit
+	s.orgLimit = orgLimit
+	s.maxWait = maxWait
+	s.Unlock()
+}
+
+// acquire waits until a task of the org may run, and returns nil once it may. the task must then release it.
+// cost is the size of the task: the cheapest waiting tasks run first.
+// it returns errTooManyRequests if the task waited for longer than the max wait, or the context's error if it is cancelled.
+func (s *scheduler) acquire(ctx context.Context, org, cost int) error {
+	s.Lock()
+	if s.canRun(org) {
+		s.run(org)
+		s.Unlock()
+		return nil
+	}
+	s.seq++
+	w := &waiter{org: org, cost: cost, seq: s.seq, ready: make(chan struct{})}
+	s.queue = append(s.queue, w)
+	s.queued.Inc()
+	s.Unlock()
+
+	pre := time.Now()
+	timer := time.NewTimer(s.maxWait)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+	case <-timer.C:
+		err = errTooManyRequests
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	s.wait.Value(time.Since(pre))
+
+	s.Lock()
+	defer s.Unlock()
+	if w.granted {
+		// it got to run just as it gave up
+		return nil
+	}
+	s.remove(w)
+	if err == errTooManyRequests {
+		s.rejected.Inc()
+	}
+	return err
+}
+
+// release marks a task of the org as complete, letting the next waiting tasks run
+func (s *scheduler) release(org int) {
+	s.Lock()
+	defer s.Unlock()
+	s.running--
+	s.orgs[org]--
+	if s.orgs[org] == 0 {
+		delete(s.orgs, org)
+	}
+	for {
+		w := s.next()
+		if w == nil {
+			return
+		}
+		s.remove(w)
+		s.run(w.org)
+		w.granted = true
+		close(w.ready)
+	}
+}
+
+func (s *scheduler) canRun(org int) bool {
+	return (s.limit == 0 || s.running < s.limit) && (s.orgLimit == 0 || s.orgs[org] < s.orgLimit)
+}
+
+func (s *scheduler) run(org int) {
+	s.running++
+	s.orgs[org]++
+}
+
+// next returns the cheapest waiting task that may run, the oldest one first among equally cheap ones, or nil if none may.
+func (s *scheduler) next() *waiter {
+	var next *waiter
+	for _, w := range s.queue {
+		if !s.canRun(w.org) {
+			continue
+		}
+		if next == nil || w.cost < next.cost || (w.cost == next.cost && w.seq < next.seq) {
+			next = w
+		}
+	}
+	return next
+}
+
+func (s *scheduler) remove(w *waiter) {
+	for i, q := range s.queue {
+		if q == w {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			s.queued.Dec()
+			return
+		}
+	}
+}
+
+// orgFromKey returns the org of a metric key like <orgid>.<hash>, or 0 if the key has no org
+func orgFromKey(key string) int {
+	i := strings.Index(key, ".")
+	if i < 0 {
+		return 0
+	}
+	org, _ := strconv.Atoi(key[:i])
+	return org
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/stats"
+)
+
+func newTestScheduler(limit, orgLimit int, maxWait time.Duration) *scheduler {
+	s := newScheduler(stats.NewGauge32("test.queued"), stats.NewLatencyHistogram15s32("test.wait"), stats.NewCounter32("test.rejected"))
+	s.setLimits(limit, orgLimit, maxWait)
+	return s
+}
+
+// acquireAsync acquires the scheduler in the background, and sends the cost of the task once it may run
+func acquireAsync(t *testing.T, s *scheduler, org, cost int, admitted chan int) {
+	s.Lock()
+	queued := len(s.queue)
+	s.Unlock()
+	go func() {
+		if err := s.acquire(context.Background(), org, cost); err != nil {
+			t.Errorf("task of cost %d: expected to be admitted, got %s", cost, err)
+			return
+		}
+		admitted <- cost
+	}()
+	// wait for the task to be queued, so that the order of the queue is known
+	for {
+		s.Lock()
+		n := len(s.queue)
+		s.Unlock()
+		if n > queued {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	s := newTestScheduler(1, 0, time.Minute)
+	if err := s.acquire(context.Background(), 1, 100); err != nil {
+		t.Fatalf("expected the first task to run at once, got %s", err)
+	}
+	admitted := make(chan int, 3)
+	acquireAsync(t, s, 1, 30, admitted)
+	acquireAsync(t, s, 2, 10, admitted)
+	acquireAsync(t, s, 1, 20, admitted)
+
+	for _, exp := range []int{10, 20, 30} {
+		s.release(1)
+		if got := <-admitted; got != exp {
+			t.Fatalf("expected the task of cost %d to run next, got the task of cost %d", exp, got)
+		}
+	}
+}
+
+func TestSchedulerOrgLimit(t *testing.T) {
+	s := newTestScheduler(0, 1, time.Minute)
+	if err := s.acquire(context.Background(), 1, 10); err != nil {
+		t.Fatalf("expected the first task of org 1 to run at once, got %s", err)
+	}
+	if err := s.acquire(context.Background(), 2, 10); err != nil {
+		t.Fatalf("expected the task of org 2 to run at once, got %s", err)
+	}
+	admitted := make(chan int, 1)
+	acquireAsync(t, s, 1, 20, admitted)
+	// the tasks of other orgs don't let the org run more tasks
+	s.release(2)
+	select {
+	case <-admitted:
+		t.Fatalf("expected the second task of org 1 to wait for the first one")
+	case <-time.After(10 * time.Millisecond):
+	}
+	s.release(1)
+	if got := <-admitted; got != 20 {
+		t.Fatalf("expected the second task of org 1 to run, got %d", got)
+	}
+}
+
+func TestSchedulerReject(t *testing.T) {
+	s := newTestScheduler(1, 0, 10*time.Millisecond)
+	if err := s.acquire(context.Background(), 1, 10); err != nil {
+		t.Fatalf("expected the first task to run at once, got %s", err)
+	}
+	if err := s.acquire(context.Background(), 1, 10); err != errTooManyRequests {
+		t.Fatalf("expected the second task to be rejected, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.acquire(ctx, 1, 10); err != context.Canceled {
+		t.Fatalf("expected the cancelled task to fail with its context's error, got %v", err)
+	}
+	if len(s.queue) != 0 {
+		t.Fatalf("expected the rejected tasks to leave the queue, got %d waiting tasks", len(s.queue))
+	}
+	s.release(1)
+	if err := s.acquire(context.Background(), 1, 10); err != nil {
+		t.Fatalf("expected a task to run once the first one completed, got %s", err)
+	}
+}
+
+func TestOrgFromKey(t *testing.T) {
+	cases := map[string]int{
+		"12.a1b2c3": 12,
+		"1.foo":     1,
+		"foo":       0,
+		"foo.bar":   0,
+	}
+	for key, exp := range cases {
+		if got := orgFromKey(key); got != exp {
+			t.Fatalf("key %q: expected org %d, got %d", key, exp, got)
+		}
+	}
+}
@@ -6,6 +6,7 @@ package batch
 import (
 	"gopkg.in/raintank/schema.v1"
 	"math"
+	"sort"
 )
 
 type AggFunc func(in []schema.Point) float64
@@ -108,3 +109,21 @@ func Sum(in []schema.Point) float64 {
 	}
 	return sum
 }
+
+// Percentile returns a function that computes the given quantile, between 0 and 1, of the non-null values.
+// it returns the value with rank floor(q * (n-1)) amongst the n sorted values, like the percentile rollups do.
+func Percentile(q float64) AggFunc {
+	return func(in []schema.Point) float64 {
+		vals := make([]float64, 0, len(in))
+		for _, v := range in {
+			if !math.IsNaN(v.Val) {
+				vals = append(vals, v.Val)
+			}
+		}
+		if len(vals) == 0 {
+			return math.NaN()
+		}
+		sort.Float64s(vals)
+		return vals[int(q*float64(len(vals)-1))]
+	}
+}
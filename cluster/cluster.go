@@ -4,6 +4,7 @@ import (
 	"errors"
 	"math/rand"
 	"net/http"
+	"sort"
 	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
@@ -32,13 +33,15 @@ var (
 )
 
 func Init(name, version string, started time.Time, apiScheme string, apiPort int) {
+	// with primary election, nodes only become primary once elected
+	isPrimary := primary && !PrimaryElection()
 	thisNode := Node{
 		Name:          name,
 		ApiPort:       apiPort,
 		ApiScheme:     apiScheme,
 		Started:       started,
 		Version:       version,
-		Primary:       primary,
+		Primary:       isPrimary,
 		Priority:      10000,
 		PrimaryChange: time.Now(),
 		StateChange:   time.Now(),
@@ -51,7 +54,12 @@ func Init(name, version string, started time.Time, apiScheme string, apiPort int
 		Manager = NewSingleNodeManager(thisNode)
 	}
 	// initialize our "primary" state metric.
-	nodePrimary.Set(primary)
+	nodePrimary.Set(isPrimary)
+}
+
+// PrimaryElection returns whether the primary nodes are elected automatically, rather than configured
+func PrimaryElection() bool {
+	return Mode == ModeMulti && primaryElection
 }
 
 func Stop() {
@@ -75,10 +83,23 @@ type partitionCandidates struct {
 // has the lowest prio, otherwise using a random selection from all
 // nodes with the lowest prio.
 func MembersForQuery() ([]Node, error) {
+	members, _, err := membersForQuery(false)
+	return members, err
+}
+
+// MembersForPartialQuery is like MembersForQuery, but for queries that may return partial results:
+// it doesn't fail when less than min-available-shards shards are available, but also returns
+// the partitions that are consumed by nodes in the cluster, but not by any ready node, and hence can't be queried.
+func MembersForPartialQuery() ([]Node, []int32) {
+	members, missing, _ := membersForQuery(true)
+	return members, missing
+}
+
+func membersForQuery(partial bool) ([]Node, []int32, error) {
 	thisNode := Manager.ThisNode()
 	// If we are running in single mode, just return thisNode
 	if Mode == ModeSingle {
-		return []Node{thisNode}, nil
+		return []Node{thisNode}, nil, nil
 	}
 
 	// store the available nodes for each partition, grouped by
@@ -93,7 +114,9 @@ func MembersForQuery() ([]Node, error) {
 		}
 	}
 
+	var known []int32 // all partitions consumed by a node in the cluster, ready or not
 	for _, member := range Manager.MemberList() {
+		known = append(known, member.Partitions...)
 		if !member.IsReady() || member.Name == thisNode.Name {
 			continue
 		}
@@ -117,8 +140,16 @@ func MembersForQuery() ([]Node, error) {
 		}
 	}
 
-	if len(membersMap) < minAvailableShards {
-		return nil, InsufficientShardsAvailable
+	var missing []int32
+	for _, part := range known {
+		if _, ok := membersMap[part]; !ok && !contains(missing, part) {
+			missing = append(missing, part)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+
+	if len(membersMap) < minAvailableShards && !partial {
+		return nil, missing, InsufficientShardsAvailable
 	}
 	selectedMembers := make(map[string]struct{})
 	answer := make([]Node, 0)
@@ -147,5 +178,5 @@ LOOP:
 		answer = append(answer, selected)
 	}
 
-	return answer, nil
+	return answer, missing, nil
 }
@@ -96,3 +96,43 @@ func TestPeersForQueryMulti(t *testing.T) {
 		So(selected, ShouldHaveLength, 0)
 	})
 }
+
+func TestMembersForPartialQuery(t *testing.T) {
+	Mode = ModeMulti
+	Init("node1", "test", time.Now(), "http", 6060)
+	Manager.SetPartitions([]int32{1, 2})
+	maxPrio = 10
+	Manager.SetPriority(10)
+	Manager.SetReady()
+	thisNode := Manager.ThisNode()
+	Manager.(*MemberlistManager).Lock()
+	Manager.(*MemberlistManager).members = map[string]Node{
+		thisNode.Name: thisNode,
+		"node2": {
+			Name:       "node2",
+			Partitions: []int32{4, 3},
+			State:      NodeNotReady,
+			Priority:   10,
+		},
+		"node3": {
+			Name:       "node3",
+			Partitions: []int32{3, 5},
+			State:      NodeReady,
+			Priority:   10,
+		},
+	}
+	Manager.(*MemberlistManager).Unlock()
+	minAvailableShards = 5
+	defer func() { minAvailableShards = 0 }()
+
+	if _, err := MembersForQuery(); err != InsufficientShardsAvailable {
+		t.Fatalf("expected MembersForQuery to fail with %q, got %v", InsufficientShardsAvailable, err)
+	}
+	selected, missing := MembersForPartialQuery()
+	if len(selected) != 2 {
+		t.Fatalf("expected the 2 ready nodes to be selected, got %v", selected)
+	}
+	if len(missing) != 1 || missing[0] != 4 {
+		t.Fatalf("expected partition 4 to be missing, got %v", missing)
+	}
+}
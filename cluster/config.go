@@ -22,6 +22,13 @@ var (
 	clusterBindAddr    string
 	httpTimeout        time.Duration
 	minAvailableShards int
+	primaryElection    bool
+	primaryLease       time.Duration
+	peersDnsStr        string
+	peersFile          string
+	peersRefresh       time.Duration
+	hedgePercentile    float64
+	dataCompression    string
 
 	client http.Client
 )
@@ -32,10 +39,17 @@ func ConfigSetup() {
 	clusterCfg.BoolVar(&primary, "primary-node", false, "the primary node writes data to cassandra. There should only be 1 primary node per shardGroup.")
 	clusterCfg.StringVar(&clusterBindAddr, "bind-addr", "0.0.0.0:7946", "TCP Address to listen on for cluster communication")
 	clusterCfg.StringVar(&peersStr, "peers", "", "TCP addresses of other nodes, comma separated. use this if you shard your data and want to query other instances")
+	clusterCfg.StringVar(&peersDnsStr, "peers-dns", "", "dns names to resolve the addresses of other nodes from, comma separated. names starting with _ are looked up as SRV records, others as A records, with an optional port. they are resolved again every peers-refresh-interval")
+	clusterCfg.StringVar(&peersFile, "peers-file", "", "file with the addresses of other nodes, one per line. it is read again every peers-refresh-interval")
+	clusterCfg.DurationVar(&peersRefresh, "peers-refresh-interval", time.Second*30, "how often to resolve peers-dns and read peers-file, to join nodes whose address is new")
 	clusterCfg.StringVar(&mode, "mode", "single", "Operating mode of cluster. (single|multi)")
 	clusterCfg.DurationVar(&httpTimeout, "http-timeout", time.Second*60, "How long to wait before aborting http requests to cluster peers and returning a http 503 service unavailable")
+	clusterCfg.Float64Var(&hedgePercentile, "hedge-percentile", 95, "when a peer doesn't answer a data request within this percentile of its latencies, also send the request to a replica and use whichever answers first. 0 disables hedging")
+	clusterCfg.StringVar(&dataCompression, "data-compression", "snappy", "compression of the data that peers stream to us: snappy or none. peers that don't support streaming send it uncompressed")
 	clusterCfg.IntVar(&maxPrio, "max-priority", 10, "maximum priority before a node should be considered not-ready.")
 	clusterCfg.IntVar(&minAvailableShards, "min-available-shards", 0, "minimum number of shards that must be available for a query to be handled.")
+	clusterCfg.BoolVar(&primaryElection, "primary-election", false, "elect a primary node for each partition automatically, instead of using primary-node. only in multi mode.")
+	clusterCfg.DurationVar(&primaryLease, "primary-lease", time.Second*15, "how long an elected primary stays primary without renewing its lease. another node is elected when the lease of the primary expires.")
 	globalconf.Register("cluster", clusterCfg)
 }
 
@@ -53,6 +67,22 @@ func ConfigProcess() {
 		log.Fatal(4, "CLU Config: http-timeout must be a non-zero duration string like 60s")
 	}
 
+	if (peersDnsStr != "" || peersFile != "") && peersRefresh <= 0 {
+		log.Fatal(4, "CLU Config: peers-refresh-interval must be a non-zero duration string like 30s")
+	}
+
+	if hedgePercentile < 0 || hedgePercentile >= 100 {
+		log.Fatal(4, "CLU Config: hedge-percentile must be between 0 and 100")
+	}
+
+	if dataCompression != "snappy" && dataCompression != "none" {
+		log.Fatal(4, "CLU Config: data-compression must be snappy or none")
+	}
+
+	if primaryElection && primaryLease < time.Second {
+		log.Fatal(4, "CLU Config: primary-lease must be at least 1s")
+	}
+
 	clusterHost = addr.IP
 	clusterPort = addr.Port
 
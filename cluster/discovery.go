@@ -0,0 +1,181 @@
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/worldping-api/pkg/log"
+)
+
+var (
+	// metric cluster.discovery.dns.failed is how many times resolving a peers-dns name failed
+	discoveryDnsFailed = stats.NewCounter32("cluster.discovery.dns.failed")
+	// metric cluster.discovery.file.failed is how many times reading the peers-file failed
+	discoveryFileFailed = stats.NewCounter32("cluster.discovery.file.failed")
+	// metric cluster.discovery.join.failed is how many times joining a discovered peer failed
+	discoveryJoinFailed = stats.NewCounter32("cluster.discovery.join.failed")
+	// metric cluster.discovery.joined is how many discovered peers this node joined
+	discoveryJoined = stats.NewCounter32("cluster.discovery.joined")
+	// metric cluster.discovery.peers is the number of peer addresses found by the last discovery round
+	discoveryPeers = stats.NewGauge32("cluster.discovery.peers")
+)
+
+// Resolver looks up the addresses behind dns names. net.DefaultResolver satisfies it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// discovery finds the addresses of peers to join, via dns names and a peers file,
+// which get resolved and read again every round, so that peers whose address changes are found again.
+// * dns names starting with an underscore are looked up as SRV records, e.g. _gossip._tcp.metrictank.svc.cluster.local
+// * other dns names are looked up as A/AAAA records. they may have a port, otherwise the port of this node is used.
+// * the peers file has one address per line. empty lines and lines starting with # are ignored.
+type discovery struct {
+	names    []string
+	file     string
+	port     int
+	resolver Resolver
+	joined   map[string]struct{} // addresses we joined. addresses we failed to join are tried again the next round.
+}
+
+func newDiscovery(names []string, file string, port int, resolver Resolver) *discovery {
+	return &discovery{
+		names:    names,
+		file:     file,
+		port:     port,
+		resolver: resolver,
+		joined:   make(map[string]struct{}),
+	}
+}
+
+func (d *discovery) enabled() bool {
+	return len(d.names) > 0 || d.file != ""
+}
+
+// peers returns the addresses currently found, sorted and deduplicated.
+// names that fail to resolve, or a file that can't be read, are skipped.
+func (d *discovery) peers(ctx context.Context) []string {
+	seen := make(map[string]struct{})
+	for _, name := range d.names {
+		addrs, err := d.resolve(ctx, name)
+		if err != nil {
+			log.Warn("CLU discovery: failed to resolve %q: %s", name, err)
+			discoveryDnsFailed.Inc()
+			continue
+		}
+		for _, addr := range addrs {
+			seen[addr] = struct{}{}
+		}
+	}
+	if d.file != "" {
+		addrs, err := readPeersFile(d.file)
+		if err != nil {
+			log.Warn("CLU discovery: failed to read peers file %q: %s", d.file, err)
+			discoveryFileFailed.Inc()
+		}
+		for _, addr := range addrs {
+			seen[addr] = struct{}{}
+		}
+	}
+	peers := make([]string, 0, len(seen))
+	for addr := range seen {
+		peers = append(peers, addr)
+	}
+	sort.Strings(peers)
+	discoveryPeers.Set(len(peers))
+	return peers
+}
+
+func (d *discovery) resolve(ctx context.Context, name string) ([]string, error) {
+	if strings.HasPrefix(name, "_") {
+		_, srvs, err := d.resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]string, 0, len(srvs))
+		for _, srv := range srvs {
+			addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+		return addrs, nil
+	}
+	host, port := name, strconv.Itoa(d.port)
+	if h, p, err := net.SplitHostPort(name); err == nil {
+		host, port = h, p
+	}
+	hosts, err := d.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		addrs = append(addrs, net.JoinHostPort(h, port))
+	}
+	return addrs, nil
+}
+
+func readPeersFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var addrs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, scanner.Err()
+}
+
+// refresh joins the peers that were found and that we didn't join yet.
+// peers that disappeared are forgotten, so that we join them again if they come back.
+func (d *discovery) refresh(ctx context.Context, join func(addr string) error) {
+	peers := d.peers(ctx)
+	found := make(map[string]struct{}, len(peers))
+	for _, addr := range peers {
+		found[addr] = struct{}{}
+		if _, ok := d.joined[addr]; ok {
+			continue
+		}
+		if err := join(addr); err != nil {
+			log.Warn("CLU discovery: failed to join %s: %s", addr, err)
+			discoveryJoinFailed.Inc()
+			continue
+		}
+		log.Info("CLU discovery: joined %s", addr)
+		discoveryJoined.Inc()
+		d.joined[addr] = struct{}{}
+	}
+	for addr := range d.joined {
+		if _, ok := found[addr]; !ok {
+			delete(d.joined, addr)
+		}
+	}
+}
+
+// discoverLoop runs a discovery round every interval, and joins the peers that were found
+func (c *MemberlistManager) discoverLoop(d *discovery, interval time.Duration) {
+	join := func(addr string) error {
+		_, err := c.list.Join([]string{addr})
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		d.refresh(ctx, join)
+		cancel()
+		<-ticker.C
+	}
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type fakeResolver struct {
+	hosts map[string][]string
+	srvs  map[string][]*net.SRV
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return addrs, nil
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	srvs, ok := r.srvs[name]
+	if !ok {
+		return "", nil, errors.New("no such host")
+	}
+	return name, srvs, nil
+}
+
+func TestDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "peers")
+	if err := ioutil.WriteFile(file, []byte("# static peers\n10.0.1.1:7946\n\n10.0.0.1:7946\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &fakeResolver{
+		hosts: map[string][]string{
+			"mt.local":    {"10.0.0.1", "10.0.0.2"},
+			"other.local": {"10.0.2.1"},
+		},
+		srvs: map[string][]*net.SRV{
+			"_gossip._tcp.mt.local": {{Target: "mt-3.mt.local.", Port: 7000}},
+		},
+	}
+	d := newDiscovery([]string{"mt.local", "other.local:8000", "_gossip._tcp.mt.local", "missing.local"}, file, 7946, resolver)
+
+	var joins []string
+	failing := map[string]bool{"10.0.0.2:7946": true}
+	join := func(addr string) error {
+		joins = append(joins, addr)
+		if failing[addr] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	d.refresh(context.Background(), join)
+	exp := []string{"10.0.0.1:7946", "10.0.0.2:7946", "10.0.1.1:7946", "10.0.2.1:8000", "mt-3.mt.local:7000"}
+	if !reflect.DeepEqual(joins, exp) {
+		t.Fatalf("first round: expected to join %v, got %v", exp, joins)
+	}
+
+	// only new addresses, and those we failed to join, are joined again
+	joins = nil
+	delete(failing, "10.0.0.2:7946")
+	resolver.hosts["mt.local"] = []string{"10.0.0.2", "10.0.0.3"}
+	d.refresh(context.Background(), join)
+	exp = []string{"10.0.0.2:7946", "10.0.0.3:7946"}
+	if !reflect.DeepEqual(joins, exp) {
+		t.Fatalf("second round: expected to join %v, got %v", exp, joins)
+	}
+
+	// a peer that disappeared is joined again when it comes back
+	joins = nil
+	if err := ioutil.WriteFile(file, []byte("10.0.0.4:7946\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.refresh(context.Background(), join)
+	exp = []string{"10.0.0.4:7946"}
+	if !reflect.DeepEqual(joins, exp) {
+		t.Fatalf("third round: expected to join %v, got %v", exp, joins)
+	}
+	joins = nil
+	if err := ioutil.WriteFile(file, []byte("10.0.1.1:7946\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.refresh(context.Background(), join)
+	exp = []string{"10.0.1.1:7946"}
+	if !reflect.DeepEqual(joins, exp) {
+		t.Fatalf("fourth round: expected to join %v, got %v", exp, joins)
+	}
+}
@@ -0,0 +1,181 @@
+package cluster
+
+import (
+	"sort"
+	"time"
+
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/worldping-api/pkg/log"
+)
+
+var (
+	// metric cluster.election.promoted is how many times this node was elected primary for a partition
+	electionPromoted = stats.NewCounter32("cluster.election.promoted")
+	// metric cluster.election.stepped_down is how many times this node stepped down as primary because it was not ready anymore, or was shutting down
+	electionSteppedDown = stats.NewCounter32("cluster.election.stepped_down")
+	// metric cluster.election.fenced is how many times this node stopped being primary for a partition because another node was elected for it with a higher term
+	electionFenced = stats.NewCounter32("cluster.election.fenced")
+	// metric cluster.election.term is the term of this node, as a primary. it goes up with every election the node wins
+	electionTerm = stats.NewGauge32("cluster.election.term")
+)
+
+// lease is the right of a node to be the primary of a partition, which it has to renew before it expires.
+// the term goes up with every election, and fences off older primaries: the lease with the highest term wins.
+type lease struct {
+	holder string
+	term   uint64
+	expiry time.Time // in local time, so that we don't depend on the clocks of other nodes
+}
+
+func (l lease) held(now time.Time) bool {
+	return l.holder != "" && now.Before(l.expiry)
+}
+
+// supersedes returns whether a lease with the given term, held by the given node, wins over l
+func (l lease) supersedes(holder string, term uint64) bool {
+	return term > l.term || (term == l.term && (l.holder == "" || holder <= l.holder))
+}
+
+// electLoop runs an election round every third of the lease, so that primaries renew their lease well before it expires
+func (c *MemberlistManager) electLoop() {
+	ticker := time.NewTicker(primaryLease / 3)
+	for now := range ticker.C {
+		c.Lock()
+		c.elect(now)
+		c.Unlock()
+	}
+}
+
+// observeLease updates the known leases with the state of a member, as received via gossip.
+// a primary renews its leases by broadcasting its state, so we consider them valid for primaryLease from now.
+// it is assumed that the lock is acquired before calling this method.
+func (c *MemberlistManager) observeLease(member Node, now time.Time) {
+	if member.Name == c.nodeName {
+		return
+	}
+	self := c.members[c.nodeName]
+	var fenced []int32
+	for _, p := range member.Partitions {
+		l := c.leases[p]
+		if !member.IsPrimaryFor(p) {
+			if l.holder == member.Name {
+				// the primary stepped down. we keep the term, so that the next primary gets a higher one
+				c.leases[p] = lease{term: l.term}
+			}
+			continue
+		}
+		if !l.supersedes(member.Name, member.PrimaryTerm) {
+			continue
+		}
+		c.leases[p] = lease{member.Name, member.PrimaryTerm, now.Add(primaryLease)}
+		if contains(self.PrimaryPartitions, p) {
+			fenced = append(fenced, p)
+		}
+	}
+	if len(fenced) > 0 {
+		log.Info("CLU election: node %s was elected primary for partitions %v with term %d, stepping down for them", member.Name, fenced, member.PrimaryTerm)
+		electionFenced.Add(len(fenced))
+		c.setPrimaryPartitions(without(self.PrimaryPartitions, fenced), self.PrimaryTerm, now)
+	}
+}
+
+// elect runs an election round for the partitions of this node.
+// it renews the leases of the partitions this node is primary for, or steps down if it is not ready anymore.
+// for each partition that has no primary, or whose primary's lease expired, the ready node with the best priority that consumes it promotes itself.
+// it is assumed that the lock is acquired before calling this method.
+func (c *MemberlistManager) elect(now time.Time) {
+	self := c.members[c.nodeName]
+	if !self.IsReady() || c.leaving {
+		if len(self.PrimaryPartitions) > 0 {
+			log.Info("CLU election: stepping down as primary for partitions %v, node is not ready or leaving", self.PrimaryPartitions)
+			electionSteppedDown.Inc()
+			for _, p := range self.PrimaryPartitions {
+				c.leases[p] = lease{term: c.leases[p].term}
+			}
+			c.setPrimaryPartitions(nil, self.PrimaryTerm, now)
+		}
+		return
+	}
+
+	term := self.PrimaryTerm
+	var won []int32
+	if !now.Before(c.electionStart) {
+		for _, p := range self.Partitions {
+			l := c.leases[p]
+			if contains(self.PrimaryPartitions, p) || l.held(now) || !c.bestCandidate(p) {
+				continue
+			}
+			won = append(won, p)
+			if l.term >= term {
+				term = l.term + 1
+			}
+		}
+	}
+	primaryFor := append(append([]int32(nil), self.PrimaryPartitions...), won...)
+	for _, p := range primaryFor {
+		c.leases[p] = lease{c.nodeName, term, now.Add(primaryLease)}
+	}
+	if len(won) > 0 {
+		sort.Slice(primaryFor, func(i, j int) bool { return primaryFor[i] < primaryFor[j] })
+		log.Info("CLU election: no primary for partitions %v, promoting this node with term %d", won, term)
+		electionPromoted.Add(len(won))
+		c.setPrimaryPartitions(primaryFor, term, now)
+		return
+	}
+	if len(primaryFor) > 0 {
+		// broadcasting our state renews our leases with the other nodes
+		self.Updated = now
+		c.members[c.nodeName] = self
+		c.BroadcastUpdate()
+	}
+}
+
+// bestCandidate returns whether this node is the ready node with the best priority, and then the lowest name, that consumes the partition.
+// it is assumed that the lock is acquired before calling this method.
+func (c *MemberlistManager) bestCandidate(partition int32) bool {
+	self := c.members[c.nodeName]
+	for _, m := range c.members {
+		if m.Name == c.nodeName || !m.IsReady() || !contains(m.Partitions, partition) {
+			continue
+		}
+		if m.Priority < self.Priority || (m.Priority == self.Priority && m.Name < self.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// setPrimaryPartitions sets the partitions this node is primary for, and its term, and broadcasts them.
+// it is assumed that the lock is acquired before calling this method.
+func (c *MemberlistManager) setPrimaryPartitions(partitions []int32, term uint64, now time.Time) {
+	node := c.members[c.nodeName]
+	node.PrimaryPartitions = partitions
+	node.PrimaryTerm = term
+	node.PrimaryChange = now
+	node.Updated = now
+	c.members[c.nodeName] = node
+	nodePrimary.Set(node.IsPrimary())
+	nodePrimaryPartitions.Set(len(partitions))
+	electionTerm.Set(int(term))
+	c.BroadcastUpdate()
+}
+
+func contains(partitions []int32, p int32) bool {
+	for _, part := range partitions {
+		if part == p {
+			return true
+		}
+	}
+	return false
+}
+
+// without returns the partitions that are not in remove
+func without(partitions, remove []int32) []int32 {
+	var out []int32
+	for _, p := range partitions {
+		if !contains(remove, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package cluster
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestElection(now time.Time) *MemberlistManager {
+	primaryLease = 15 * time.Second
+	maxPrio = 10
+	c := NewMemberlistManager(Node{Name: "a", Partitions: []int32{1, 2}, State: NodeReady, local: true}, "test", net.ParseIP("127.0.0.1"), 7946)
+	c.members["b"] = Node{Name: "b", Partitions: []int32{2, 3}, State: NodeReady}
+	c.members["c"] = Node{Name: "c", Partitions: []int32{4}, State: NodeReady, PrimaryPartitions: []int32{4}, PrimaryTerm: 5}
+	c.observeLease(c.members["c"], now)
+	c.electionStart = now.Add(time.Second)
+	return c
+}
+
+func checkPrimary(t *testing.T, c *MemberlistManager, step string, partitions []int32, term uint64) {
+	self := c.members[c.nodeName]
+	if !reflect.DeepEqual(self.PrimaryPartitions, partitions) || self.PrimaryTerm != term {
+		t.Fatalf("%s: expected primary for %v with term %d, got %v with term %d", step, partitions, term, self.PrimaryPartitions, self.PrimaryTerm)
+	}
+	for _, p := range []int32{1, 2, 3, 4} {
+		if c.IsPrimaryFor(p) != contains(partitions, p) {
+			t.Fatalf("%s: expected IsPrimaryFor(%d) to be %t", step, p, contains(partitions, p))
+		}
+	}
+}
+
+func TestElection(t *testing.T) {
+	now := time.Now()
+	c := newTestElection(now)
+
+	c.elect(now)
+	checkPrimary(t, c, "before the election start", nil, 0)
+
+	// a and b have the same priority and share partition 2: a wins it by name. partition 3 is not ours
+	now = now.Add(time.Second)
+	c.elect(now)
+	checkPrimary(t, c, "elected", []int32{1, 2}, 1)
+
+	// b got elected for partition 2 with a higher term, e.g. after a network partition
+	b := c.members["b"]
+	b.PrimaryPartitions = []int32{2, 3}
+	b.PrimaryTerm = 2
+	c.members["b"] = b
+	c.observeLease(b, now)
+	checkPrimary(t, c, "fenced", []int32{1}, 1)
+
+	now = now.Add(10 * time.Second)
+	c.elect(now)
+	checkPrimary(t, c, "while b holds the lease", []int32{1}, 1)
+
+	// b disappeared without stepping down: we wait for its lease to expire
+	delete(c.members, "b")
+	c.elect(now)
+	checkPrimary(t, c, "before the lease of b expired", []int32{1}, 1)
+	now = now.Add(6 * time.Second)
+	c.elect(now)
+	checkPrimary(t, c, "after the lease of b expired", []int32{1, 2}, 3)
+
+	// a primary that is not ready steps down for all its partitions
+	self := c.members["a"]
+	self.Priority = maxPrio + 1
+	c.members["a"] = self
+	c.elect(now)
+	checkPrimary(t, c, "not ready", nil, 3)
+
+	// b is back, with a better priority than us: b gets elected for partition 2, we for partition 1
+	self = c.members["a"]
+	self.Priority = 1
+	c.members["a"] = self
+	c.members["b"] = Node{Name: "b", Partitions: []int32{2, 3}, State: NodeReady, Priority: 0}
+	now = now.Add(time.Second)
+	c.elect(now)
+	checkPrimary(t, c, "b is a better candidate for partition 2", []int32{1}, 4)
+	b = c.members["b"]
+	b.PrimaryPartitions = []int32{2, 3}
+	b.PrimaryTerm = 5
+	c.observeLease(b, now)
+	if l := c.leases[2]; l.holder != "b" || l.term != 5 {
+		t.Fatalf("expected b to hold the lease of partition 2 with term 5, got %+v", l)
+	}
+	checkPrimary(t, c, "b elected", []int32{1}, 4)
+
+	// b steps down gracefully: we get elected for partition 2 right away
+	b.PrimaryPartitions = nil
+	b.State = NodeNotReady
+	c.members["b"] = b
+	c.observeLease(b, now)
+	c.elect(now)
+	checkPrimary(t, c, "after b stepped down", []int32{1, 2}, 6)
+
+	// the primary of the other partitions is unaffected
+	if l := c.leases[4]; l.holder != "c" || l.term != 5 {
+		t.Fatalf("expected c to hold the lease of partition 4 with term 5, got %+v", l)
+	}
+}
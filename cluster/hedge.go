@@ -0,0 +1,237 @@
+package cluster
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/worldping-api/pkg/log"
+)
+
+// latencyWindowSize is how many of the latest latencies of each peer we keep, to compute percentiles from
+const latencyWindowSize = 100
+
+// latencyMinSamples is how many latencies of a peer we need before we hedge requests to it
+const latencyMinSamples = 20
+
+var (
+	// metric cluster.hedge.sent is how many requests were also sent to a replica, because the peer didn't answer within its hedge-percentile latency
+	hedgeSent = stats.NewCounter32("cluster.hedge.sent")
+	// metric cluster.hedge.won is how many hedged requests were answered by the replica first
+	hedgeWon = stats.NewCounter32("cluster.hedge.won")
+	// metric cluster.retry.sent is how many requests were retried on a replica, because the peer failed
+	retrySent = stats.NewCounter32("cluster.retry.sent")
+
+	peerLatencies = newLatencies()
+)
+
+// latencies tracks the latencies of the successful requests to each peer
+type latencies struct {
+	sync.Mutex
+	peers map[string]*latencyWindow
+}
+
+type latencyWindow struct {
+	samples []time.Duration
+	pos     int
+}
+
+func newLatencies() *latencies {
+	return &latencies{
+		peers: make(map[string]*latencyWindow),
+	}
+}
+
+func (l *latencies) add(peer string, d time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	w, ok := l.peers[peer]
+	if !ok {
+		w = &latencyWindow{samples: make([]time.Duration, 0, latencyWindowSize)}
+		l.peers[peer] = w
+	}
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.pos] = d
+	w.pos = (w.pos + 1) % latencyWindowSize
+}
+
+// percentile returns the given percentile of the latest latencies of the peer,
+// or false if there are not enough of them.
+func (l *latencies) percentile(peer string, p float64) (time.Duration, bool) {
+	l.Lock()
+	w, ok := l.peers[peer]
+	if !ok || len(w.samples) < latencyMinSamples {
+		l.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, len(w.samples))
+	copy(sorted, w.samples)
+	l.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p / 100 * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i], true
+}
+
+// PeerLatency returns the given percentile of the latencies of the latest successful requests to the peer,
+// or false if not enough requests to it are known.
+func PeerLatency(peer string, percentile float64) (time.Duration, bool) {
+	return peerLatencies.percentile(peer, percentile)
+}
+
+// Replicas returns the ready peers, other than the given node and this node, that have the same priority
+// and consume all the partitions of the given node, and can hence answer the same requests.
+func Replicas(node Node) []Node {
+	var replicas []Node
+	for _, m := range Manager.MemberList() {
+		if m.Name == node.Name || m.IsLocal() || !m.IsReady() || m.Priority != node.Priority {
+			continue
+		}
+		consumesAll := true
+		for _, p := range node.Partitions {
+			if !contains(m.Partitions, p) {
+				consumesAll = false
+				break
+			}
+		}
+		if consumesAll {
+			replicas = append(replicas, m)
+		}
+	}
+	return replicas
+}
+
+// retryable returns whether a request that failed with the given error may succeed on another replica
+func retryable(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.Code() >= 500
+	}
+	return true
+}
+
+type postResult struct {
+	res    interface{}
+	node   Node
+	err    error
+	cancel context.CancelFunc
+}
+
+// PostHedged posts the body to the node like Post, but if the node doesn't answer within the hedge-percentile
+// of its latencies, it also posts it to a replica, and returns whichever answer comes first.
+// if the node fails, the request is retried on a replica. It returns the node that answered.
+func PostHedged(ctx context.Context, node Node, name, path string, body Traceable) ([]byte, Node, error) {
+	res, n, cancel, err := hedge(ctx, node, path, func(ctx context.Context, n Node) (interface{}, error) {
+		return n.Post(ctx, name, path, body)
+	})
+	cancel()
+	buf, _ := res.([]byte)
+	return buf, n, err
+}
+
+// PostStreamHedged is like PostHedged, but returns the response as a stream, like PostStream.
+// a stream answers once its first frame is read. failures after that are not retried.
+func PostStreamHedged(ctx context.Context, node Node, name, path string, body Traceable) (*Stream, Node, error) {
+	res, n, cancel, err := hedge(ctx, node, path, func(ctx context.Context, n Node) (interface{}, error) {
+		return n.PostStream(ctx, name, path, body)
+	})
+	if err != nil {
+		cancel()
+		return nil, n, err
+	}
+	s := res.(*Stream)
+	s.cancel = cancel
+	return s, n, nil
+}
+
+// hedge runs the post for the node, and if needed for a replica, as described for PostHedged.
+// it returns the result of the node that answered first, along with the function to cancel the context of its post,
+// which must be called once the result is consumed. the results of the other posts are aborted.
+func hedge(ctx context.Context, node Node, path string, post func(ctx context.Context, n Node) (interface{}, error)) (interface{}, Node, context.CancelFunc, error) {
+	replicas := Replicas(node)
+	if len(replicas) == 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		res, err := post(ctx, node)
+		return res, node, cancel, err
+	}
+	replica := replicas[rand.Intn(len(replicas))]
+
+	// cancelling the context of a post aborts it when another one answered first
+	results := make(chan postResult, 2)
+	cancels := make(map[string]context.CancelFunc)
+	pending := 0
+	start := func(n Node) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancels[n.Name] = cancel
+		pending++
+		go func() {
+			res, err := post(ctx, n)
+			results <- postResult{res, n, err, cancel}
+		}()
+	}
+	start(node)
+
+	var hedge <-chan time.Time
+	if hedgePercentile > 0 {
+		if delay, ok := PeerLatency(node.Name, hedgePercentile); ok {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			hedge = timer.C
+		}
+	}
+	var hedged, retried bool
+	for {
+		select {
+		case <-hedge:
+			hedge = nil
+			log.Debug("CLU PostHedged: %s didn't answer %s within %.1fth percentile latency, also sending it to %s", node.Name, path, hedgePercentile, replica.Name)
+			hedgeSent.Inc()
+			hedged = true
+			start(replica)
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if hedged && res.node.Name == replica.Name {
+					hedgeWon.Inc()
+				}
+				for name, cancel := range cancels {
+					if name != res.node.Name {
+						cancel()
+					}
+				}
+				go discard(results, pending)
+				return res.res, res.node, res.cancel, nil
+			}
+			res.cancel()
+			if !hedged && !retried && ctx.Err() == nil && retryable(res.err) {
+				log.Warn("CLU PostHedged: %s failed to answer %s, retrying on %s: %s", node.Name, path, replica.Name, res.err)
+				retrySent.Inc()
+				retried = true
+				hedge = nil
+				start(replica)
+				continue
+			}
+			if pending == 0 {
+				return nil, res.node, res.cancel, res.err
+			}
+		}
+	}
+}
+
+// discard closes the results of the posts that didn't answer first
+func discard(results chan postResult, pending int) {
+	for ; pending > 0; pending-- {
+		res := <-results
+		if c, ok := res.res.(interface{ Close() }); ok && res.err == nil {
+			c.Close()
+		}
+		res.cancel()
+	}
+}
@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+type testBody struct{}
+
+func (b testBody) Trace(span opentracing.Span)      {}
+func (b testBody) TraceDebug(span opentracing.Span) {}
+
+// newTestPeer returns a ready peer consuming partitions 1 and 2, that answers with its name after the given delay,
+// or fails with the given status code if it is not 200.
+func newTestPeer(name string, delay time.Duration, code int) (Node, func()) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server only notices aborted requests once the body is read
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		if code != http.StatusOK {
+			http.Error(w, "failed", code)
+			return
+		}
+		w.Write([]byte(name))
+	}))
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	apiPort, _ := strconv.Atoi(port)
+	return Node{Name: name, RemoteAddr: host, ApiPort: apiPort, ApiScheme: "http", Partitions: []int32{1, 2}, State: NodeReady}, ts.Close
+}
+
+func setTestPeers(peers ...Node) {
+	Mode = ModeMulti
+	Tracer = opentracing.NoopTracer{}
+	maxPrio = 10
+	Manager = NewMemberlistManager(Node{Name: "self", Partitions: []int32{3}, State: NodeReady, local: true}, "test", net.ParseIP("127.0.0.1"), 7946)
+	for _, p := range peers {
+		Manager.(*MemberlistManager).members[p.Name] = p
+	}
+}
+
+func testContext() context.Context {
+	return opentracing.ContextWithSpan(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	l := newLatencies()
+	for i := 1; i < latencyMinSamples; i++ {
+		l.add("a", time.Duration(i)*time.Millisecond)
+	}
+	if _, ok := l.percentile("a", 95); ok {
+		t.Fatalf("expected no percentile with fewer than %d samples", latencyMinSamples)
+	}
+	// the window only keeps the latest latencies
+	for i := 0; i < 2*latencyWindowSize; i++ {
+		l.add("a", time.Duration(i%latencyWindowSize)*time.Millisecond)
+	}
+	if d, ok := l.percentile("a", 95); !ok || d != 95*time.Millisecond {
+		t.Fatalf("expected p95 of 95ms, got %s (%t)", d, ok)
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	a := Node{Name: "a", Partitions: []int32{1, 2}, State: NodeReady}
+	setTestPeers(
+		a,
+		Node{Name: "b", Partitions: []int32{2, 1, 5}, State: NodeReady},
+		Node{Name: "c", Partitions: []int32{1}, State: NodeReady},
+		Node{Name: "d", Partitions: []int32{1, 2}, State: NodeReady, Priority: 1},
+		Node{Name: "e", Partitions: []int32{1, 2}, State: NodeNotReady},
+	)
+	replicas := Replicas(a)
+	if len(replicas) != 1 || replicas[0].Name != "b" {
+		t.Fatalf("expected b to be the only replica of a, got %v", replicas)
+	}
+}
+
+func TestPostHedged(t *testing.T) {
+	slow, closeSlow := newTestPeer("slow", 5*time.Second, http.StatusOK)
+	defer closeSlow()
+	fast, closeFast := newTestPeer("fast", 0, http.StatusOK)
+	defer closeFast()
+	setTestPeers(slow, fast)
+	hedgePercentile = 95
+	for i := 0; i < latencyMinSamples; i++ {
+		peerLatencies.add("slow", 10*time.Millisecond)
+	}
+
+	pre := time.Now()
+	buf, node, err := PostHedged(testContext(), slow, "test", "/getdata", testBody{})
+	if err != nil {
+		t.Fatalf("expected the hedged request to succeed, got %s", err)
+	}
+	if node.Name != "fast" || string(buf) != "fast" {
+		t.Fatalf("expected the replica to answer, got %s: %q", node.Name, buf)
+	}
+	if time.Since(pre) > time.Second {
+		t.Fatalf("expected the replica to answer right after the hedge delay, took %s", time.Since(pre))
+	}
+}
+
+func TestPostHedgedRetry(t *testing.T) {
+	failing, closeFailing := newTestPeer("failing", 0, http.StatusServiceUnavailable)
+	defer closeFailing()
+	replica, closeReplica := newTestPeer("replica", 0, http.StatusOK)
+	defer closeReplica()
+	setTestPeers(failing, replica)
+
+	buf, node, err := PostHedged(testContext(), failing, "test", "/getdata", testBody{})
+	if err != nil {
+		t.Fatalf("expected the request to be retried on the replica, got %s", err)
+	}
+	if node.Name != "replica" || string(buf) != "replica" {
+		t.Fatalf("expected the replica to answer, got %s: %q", node.Name, buf)
+	}
+
+	// requests that can't succeed elsewhere are not retried
+	bad, closeBad := newTestPeer("bad", 0, http.StatusBadRequest)
+	defer closeBad()
+	setTestPeers(bad, replica)
+	if _, node, err = PostHedged(testContext(), bad, "test", "/getdata", testBody{}); err == nil || node.Name != "bad" {
+		t.Fatalf("expected the bad request to fail on bad, got %s from %s", err, node.Name)
+	}
+}
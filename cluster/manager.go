@@ -25,6 +25,8 @@ var (
 	nodeReady = stats.NewBool("cluster.self.state.ready")
 	// metric cluster.self.state.primary is whether this instance is a primary
 	nodePrimary = stats.NewBool("cluster.self.state.primary")
+	// metric cluster.self.primary-partitions is the number of partitions this instance is primary for, when elected
+	nodePrimaryPartitions = stats.NewGauge32("cluster.self.primary-partitions")
 	// metric cluster.self.partitions is the number of partitions this instance consumes
 	nodePartitions = stats.NewGauge32("cluster.self.partitions")
 	// metric cluster.self.priority is the priority of the node. A lower number gives higher priority
@@ -51,6 +53,7 @@ var (
 
 type ClusterManager interface {
 	IsPrimary() bool
+	IsPrimaryFor(int32) bool
 	SetPrimary(bool)
 	IsReady() bool
 	SetReady()
@@ -67,10 +70,14 @@ type ClusterManager interface {
 
 type MemberlistManager struct {
 	sync.RWMutex
-	members  map[string]Node // all members in the cluster, including this node.
-	nodeName string
-	list     *memberlist.Memberlist
-	cfg      *memberlist.Config
+	members       map[string]Node // all members in the cluster, including this node.
+	nodeName      string
+	list          *memberlist.Memberlist
+	cfg           *memberlist.Config
+	leases        map[int32]lease // the primary lease of each partition we know of. see election.go
+	electionStart time.Time       // we don't try to get elected before this, so that we first learn about the current primary
+	leaving       bool            // whether we are leaving the cluster, and shouldn't be elected anymore
+	resolver      Resolver        // resolves peers-dns. see discovery.go
 }
 
 func NewMemberlistManager(thisNode Node, clusterName string, clusterHost net.IP, clusterPort int) *MemberlistManager {
@@ -79,6 +86,8 @@ func NewMemberlistManager(thisNode Node, clusterName string, clusterHost net.IP,
 			thisNode.Name: thisNode,
 		},
 		nodeName: thisNode.Name,
+		leases:   make(map[int32]lease),
+		resolver: net.DefaultResolver,
 	}
 	mgr.cfg = memberlist.DefaultLANConfig()
 	mgr.cfg.BindPort = clusterPort
@@ -101,6 +110,22 @@ func (c *MemberlistManager) Start() {
 	}
 	c.setList(list)
 
+	if primaryElection {
+		c.Lock()
+		c.electionStart = time.Now().Add(primaryLease)
+		c.Unlock()
+		go c.electLoop()
+	}
+
+	var names []string
+	if peersDnsStr != "" {
+		names = strings.Split(peersDnsStr, ",")
+	}
+	d := newDiscovery(names, peersFile, clusterPort, c.resolver)
+	if d.enabled() {
+		go c.discoverLoop(d, peersRefresh)
+	}
+
 	if peersStr == "" {
 		return
 	}
@@ -148,7 +173,7 @@ func (c *MemberlistManager) clusterStats() {
 	secNotReady := 0
 	partitions := make(map[int32]int)
 	for _, p := range c.members {
-		if p.Primary {
+		if p.IsPrimary() {
 			if p.IsReady() {
 				primReady++
 			} else {
@@ -194,6 +219,7 @@ func (c *MemberlistManager) NotifyJoin(node *memberlist.Node) {
 		member.local = true
 	}
 	c.members[node.Name] = member
+	c.observeLease(member, time.Now())
 	c.clusterStats()
 }
 
@@ -234,6 +260,7 @@ func (c *MemberlistManager) NotifyUpdate(node *memberlist.Node) {
 	}
 	c.members[node.Name] = member
 	log.Info("CLU manager: Node %s at %s has been updated - %s", node.Name, node.Addr.String(), node.Meta)
+	c.observeLease(member, time.Now())
 	c.clusterStats()
 }
 
@@ -321,11 +348,19 @@ func (c *MemberlistManager) SetState(state NodeState) {
 	c.BroadcastUpdate()
 }
 
-// Returns true if the this node is a set as a primary node that should write data to cassandra.
+// Returns true if the this node is a set as a primary node that should write data to cassandra,
+// for all or some of its partitions.
 func (c *MemberlistManager) IsPrimary() bool {
 	c.RLock()
 	defer c.RUnlock()
-	return c.members[c.nodeName].Primary
+	return c.members[c.nodeName].IsPrimary()
+}
+
+// Returns true if this node should write the data of the given partition to cassandra.
+func (c *MemberlistManager) IsPrimaryFor(partition int32) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.members[c.nodeName].IsPrimaryFor(partition)
 }
 
 // SetPrimary sets the primary status of this node
@@ -382,6 +417,17 @@ func (c *MemberlistManager) SetPriority(prio int) {
 }
 
 func (c *MemberlistManager) Stop() {
+	c.Lock()
+	c.leaving = true
+	stepDown := primaryElection && c.members[c.nodeName].IsPrimary()
+	if stepDown {
+		// so that another node can be elected right away, rather than once our lease expires
+		c.elect(time.Now())
+	}
+	c.Unlock()
+	if stepDown {
+		c.list.UpdateNode(time.Second)
+	}
 	c.list.Leave(time.Second)
 }
 
@@ -403,7 +449,13 @@ func (m *SingleNodeManager) Start() {
 func (m *SingleNodeManager) IsPrimary() bool {
 	m.RLock()
 	defer m.RUnlock()
-	return m.node.Primary
+	return m.node.IsPrimary()
+}
+
+func (m *SingleNodeManager) IsPrimaryFor(partition int32) bool {
+	m.RLock()
+	defer m.RUnlock()
+	return m.node.IsPrimaryFor(partition)
 }
 
 func (m *SingleNodeManager) SetPrimary(primary bool) {
@@ -94,16 +94,19 @@ type Node struct {
 	Version       string    `json:"version"`
 	Primary       bool      `json:"primary"`
 	PrimaryChange time.Time `json:"primaryChange"`
-	State         NodeState `json:"state"`
-	Priority      int       `json:"priority"`
-	Started       time.Time `json:"started"`
-	StateChange   time.Time `json:"stateChange"`
-	Partitions    []int32   `json:"partitions"`
-	ApiPort       int       `json:"apiPort"`
-	ApiScheme     string    `json:"apiScheme"`
-	Updated       time.Time `json:"updated"`
-	RemoteAddr    string    `json:"remoteAddr"`
-	local         bool
+	PrimaryTerm   uint64    `json:"primaryTerm"` // term of the primary leases, when elected. see election.go
+	// partitions the node is elected primary for. see election.go
+	PrimaryPartitions []int32   `json:"primaryPartitions"`
+	State             NodeState `json:"state"`
+	Priority          int       `json:"priority"`
+	Started           time.Time `json:"started"`
+	StateChange       time.Time `json:"stateChange"`
+	Partitions        []int32   `json:"partitions"`
+	ApiPort           int       `json:"apiPort"`
+	ApiScheme         string    `json:"apiScheme"`
+	Updated           time.Time `json:"updated"`
+	RemoteAddr        string    `json:"remoteAddr"`
+	local             bool
 }
 
 func (n Node) RemoteURL() string {
@@ -114,17 +117,23 @@ func (n Node) IsReady() bool {
 	return n.State == NodeReady && n.Priority <= maxPrio
 }
 
+// IsPrimary returns whether the node is primary for all or some of its partitions
+func (n Node) IsPrimary() bool {
+	return n.Primary || len(n.PrimaryPartitions) > 0
+}
+
+// IsPrimaryFor returns whether the node is primary for the given partition:
+// either because it is primary for all its partitions, or because it was elected primary for it.
+func (n Node) IsPrimaryFor(partition int32) bool {
+	return n.Primary || contains(n.PrimaryPartitions, partition)
+}
+
 func (n Node) IsLocal() bool {
 	return n.local
 }
 
 func (n Node) Post(ctx context.Context, name, path string, body Traceable) (ret []byte, err error) {
-	ctx, span := tracing.NewSpan(ctx, Tracer, name)
-	tags.SpanKindRPCClient.Set(span)
-	tags.PeerService.Set(span, "metrictank")
-	tags.PeerAddress.Set(span, n.RemoteAddr)
-	tags.PeerHostname.Set(span, n.Name)
-	body.Trace(span)
+	ctx, span := n.newSpan(ctx, name, body)
 	defer func(pre time.Time) {
 		if err != nil {
 			tags.Error.Set(span, true)
@@ -132,9 +141,32 @@ func (n Node) Post(ctx context.Context, name, path string, body Traceable) (ret
 		if err != nil || time.Since(pre) > 10*time.Second {
 			body.TraceDebug(span)
 		}
+		if err == nil {
+			peerLatencies.add(n.Name, time.Since(pre))
+		}
 		span.Finish()
 	}(time.Now())
 
+	rsp, err := n.post(ctx, span, path, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	return handleResp(rsp)
+}
+
+// newSpan starts the span of a request to the node
+func (n Node) newSpan(ctx context.Context, name string, body Traceable) (context.Context, opentracing.Span) {
+	ctx, span := tracing.NewSpan(ctx, Tracer, name)
+	tags.SpanKindRPCClient.Set(span)
+	tags.PeerService.Set(span, "metrictank")
+	tags.PeerAddress.Set(span, n.RemoteAddr)
+	tags.PeerHostname.Set(span, n.Name)
+	body.Trace(span)
+	return ctx, span
+}
+
+// post posts the body to the node, with the given additional headers, and returns the response
+func (n Node) post(ctx context.Context, span opentracing.Span, path string, body Traceable, header http.Header) (*http.Response, error) {
 	b, err := json.Marshal(body)
 	if err != nil {
 		return nil, NewError(http.StatusInternalServerError, err)
@@ -146,18 +178,26 @@ func (n Node) Post(ctx context.Context, name, path string, body Traceable) (ret
 	if err != nil {
 		return nil, NewError(http.StatusInternalServerError, err)
 	}
+	req = req.WithContext(ctx)
 	carrier := opentracing.HTTPHeadersCarrier(req.Header)
 	err = Tracer.Inject(span.Context(), opentracing.HTTPHeaders, carrier)
 	if err != nil {
 		log.Error(3, "CLU failed to inject span into headers: %s", err)
 	}
 	req.Header.Add("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
 	rsp, err := client.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			// the request was aborted, e.g. because a replica answered first
+			return nil, ctx.Err()
+		}
 		log.Error(3, "CLU Node: %s unreachable. %s", n.Name, err.Error())
 		return nil, NewError(http.StatusServiceUnavailable, fmt.Errorf("cluster node unavailable"))
 	}
-	return handleResp(rsp)
+	return rsp, nil
 }
 
 func handleResp(rsp *http.Response) ([]byte, error) {
@@ -0,0 +1,287 @@
+package cluster
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/grafana/metrictank/stats"
+	opentracing "github.com/opentracing/opentracing-go"
+	tags "github.com/opentracing/opentracing-go/ext"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// data responses between nodes can be streamed as a sequence of frames, rather than as a single msgp message,
+// so that the requesting node can process them while they are being read, without holding the whole response in memory.
+// the requesting node lists the stream versions it supports in the StreamHeader request header, and may accept snappy
+// compression via the Accept-Encoding header. a node that supports one of the versions responds with the version it
+// uses in the StreamHeader response header, and with "snappy" as Content-Encoding if it compressed the stream.
+// nodes that don't support streaming ignore the header and respond with a single message, which is read as the only frame.
+//
+// version 1 frames consist of a 1 byte frame type, the 4 byte big endian length of the payload, and the payload.
+// the stream ends with an end frame, so that truncated streams are detected.
+
+const (
+	// StreamHeader is the header nodes negotiate the stream version of data responses with
+	StreamHeader = "X-Metrictank-Stream"
+	// StreamVersion is the latest stream version supported
+	StreamVersion = 1
+)
+
+const (
+	frameData  byte = 1 // the payload is a msgp message
+	frameError byte = 2 // the payload is the error that aborted the stream
+	frameEnd   byte = 3 // the stream is complete. there is no payload
+
+	frameHeaderSize = 5
+	maxFrameSize    = 1 << 30
+)
+
+var (
+	// metric cluster.stream.legacy is how many data responses were read from peers that don't support streaming
+	streamLegacy = stats.NewCounter32("cluster.stream.legacy")
+	// metric cluster.stream.compressed is how many streamed data responses from peers were snappy compressed
+	streamCompressed = stats.NewCounter32("cluster.stream.compressed")
+)
+
+// negotiateStream returns the highest stream version supported both by us and by the requesting node, or 0 if none is.
+func negotiateStream(header http.Header) int {
+	version := 0
+	for _, v := range strings.Split(header.Get(StreamHeader), ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err == nil && i <= StreamVersion && i > version {
+			version = i
+		}
+	}
+	return version
+}
+
+func acceptsSnappy(header http.Header) bool {
+	for _, enc := range strings.Split(header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(enc) == "snappy" {
+			return true
+		}
+	}
+	return false
+}
+
+// Stream reads the frames of a data response. see PostStream
+type Stream struct {
+	node   Node
+	span   opentracing.Span
+	pre    time.Time
+	body   io.ReadCloser
+	r      io.Reader
+	framed bool
+	done   bool
+	header [frameHeaderSize]byte
+	buf    []byte
+	peeked bool
+	err    error
+	cancel context.CancelFunc
+}
+
+// PostStream posts the body to the node like Post, and returns the response as a stream of frames.
+// it returns once the first frame has been read. The stream must be closed.
+func (n Node) PostStream(ctx context.Context, name, path string, body Traceable) (*Stream, error) {
+	ctx, span := n.newSpan(ctx, name, body)
+	header := http.Header{}
+	header.Set(StreamHeader, strconv.Itoa(StreamVersion))
+	if dataCompression == "snappy" {
+		header.Set("Accept-Encoding", "snappy")
+	}
+	pre := time.Now()
+	rsp, err := n.post(ctx, span, path, body, header)
+	if err == nil && rsp.StatusCode != 200 {
+		rsp.Body.Close()
+		err = NewError(rsp.StatusCode, fmt.Errorf("%s", rsp.Status))
+	}
+	if err != nil {
+		tags.Error.Set(span, true)
+		body.TraceDebug(span)
+		span.Finish()
+		return nil, err
+	}
+
+	s := &Stream{
+		node:   n,
+		span:   span,
+		pre:    pre,
+		body:   rsp.Body,
+		r:      rsp.Body,
+		framed: rsp.Header.Get(StreamHeader) != "",
+	}
+	if !s.framed {
+		streamLegacy.Inc()
+	} else if rsp.Header.Get("Content-Encoding") == "snappy" {
+		streamCompressed.Inc()
+		s.r = snappy.NewReader(rsp.Body)
+	}
+	s.buf, s.err = s.read()
+	if s.err != nil && s.err != io.EOF {
+		err := s.err
+		s.Close()
+		return nil, err
+	}
+	s.peeked = true
+	return s, nil
+}
+
+// Next returns the payload of the next frame, which is only valid until the next call.
+// It returns io.EOF once the stream is complete.
+func (s *Stream) Next() ([]byte, error) {
+	if s.peeked {
+		s.peeked = false
+		return s.buf, s.err
+	}
+	s.buf, s.err = s.read()
+	return s.buf, s.err
+}
+
+func (s *Stream) read() ([]byte, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	if !s.framed {
+		// the whole response is a single message
+		s.done = true
+		buf, err := ioutil.ReadAll(s.r)
+		if err != nil {
+			return nil, s.readErr(err)
+		}
+		s.complete()
+		return buf, nil
+	}
+	if _, err := io.ReadFull(s.r, s.header[:]); err != nil {
+		return nil, s.readErr(err)
+	}
+	size := binary.BigEndian.Uint32(s.header[1:])
+	if size > maxFrameSize {
+		return nil, NewError(http.StatusInternalServerError, fmt.Errorf("frame of %d bytes from %s exceeds the maximum frame size", size, s.node.Name))
+	}
+	if cap(s.buf) < int(size) {
+		s.buf = make([]byte, size)
+	}
+	buf := s.buf[:size]
+	if _, err := io.ReadFull(s.r, buf); err != nil {
+		return nil, s.readErr(err)
+	}
+	switch s.header[0] {
+	case frameData:
+		return buf, nil
+	case frameError:
+		s.done = true
+		return nil, NewError(http.StatusInternalServerError, fmt.Errorf("%s failed while streaming: %s", s.node.Name, buf))
+	case frameEnd:
+		s.done = true
+		s.complete()
+		return nil, io.EOF
+	}
+	return nil, NewError(http.StatusInternalServerError, fmt.Errorf("unknown frame type %d from %s", s.header[0], s.node.Name))
+}
+
+// readErr returns the error for a failure to read the stream
+func (s *Stream) readErr(err error) error {
+	s.done = true
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return NewError(http.StatusServiceUnavailable, fmt.Errorf("failed to read stream from %s: %s", s.node.Name, err))
+}
+
+// complete tracks the latency of the stream once it has been fully read
+func (s *Stream) complete() {
+	peerLatencies.add(s.node.Name, time.Since(s.pre))
+}
+
+// Close closes the stream, aborting it if it was not fully read.
+func (s *Stream) Close() {
+	if s.body == nil {
+		return
+	}
+	s.body.Close()
+	s.body = nil
+	if s.err != nil && s.err != io.EOF {
+		tags.Error.Set(s.span, true)
+	}
+	s.span.Finish()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// StreamWriter writes a data response as a stream of frames. see NewStreamWriter
+type StreamWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+	buf     []byte
+	err     error
+}
+
+// NewStreamWriter starts a streamed response to the request, if the requesting node supports streaming.
+// otherwise it returns nil, and the response must be a single message.
+// The writer must be closed once all messages are written, or aborted with an error.
+func NewStreamWriter(w http.ResponseWriter, r *http.Request) *StreamWriter {
+	version := negotiateStream(r.Header)
+	if version == 0 {
+		return nil
+	}
+	s := &StreamWriter{
+		w: w,
+	}
+	s.flusher, _ = w.(http.Flusher)
+	w.Header().Set(StreamHeader, strconv.Itoa(version))
+	w.Header().Set("Content-Type", "application/x-metrictank-stream")
+	if acceptsSnappy(r.Header) {
+		w.Header().Set("Content-Encoding", "snappy")
+		s.w = snappy.NewWriter(w)
+	}
+	w.WriteHeader(http.StatusOK)
+	return s
+}
+
+// Write writes the message as a frame, and flushes it to the requesting node
+func (s *StreamWriter) Write(m msgp.Marshaler) error {
+	buf, err := m.MarshalMsg(s.frame())
+	if err != nil {
+		return err
+	}
+	return s.write(frameData, buf)
+}
+
+// Error aborts the stream with the error
+func (s *StreamWriter) Error(err error) error {
+	return s.write(frameError, append(s.frame(), err.Error()...))
+}
+
+// Close completes the stream
+func (s *StreamWriter) Close() error {
+	return s.write(frameEnd, s.frame())
+}
+
+// frame returns the buffer to marshal the payload of a frame into, after its header
+func (s *StreamWriter) frame() []byte {
+	return append(s.buf[:0], 0, 0, 0, 0, 0)
+}
+
+func (s *StreamWriter) write(typ byte, buf []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	buf[0] = typ
+	binary.BigEndian.PutUint32(buf[1:frameHeaderSize], uint32(len(buf)-frameHeaderSize))
+	// each frame is written at once, so that it is compressed as a whole
+	_, s.err = s.w.Write(buf)
+	s.buf = buf
+	if s.err == nil && s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return s.err
+}
@@ -0,0 +1,142 @@
+package cluster
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+type rawMsg []byte
+
+func (m rawMsg) MarshalMsg(b []byte) ([]byte, error) {
+	return append(b, m...), nil
+}
+
+// newStreamPeer returns a ready peer whose responses are written by the given handler
+func newStreamPeer(name string, handler http.HandlerFunc) (Node, func()) {
+	ts := httptest.NewServer(handler)
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	apiPort, _ := strconv.Atoi(port)
+	return Node{Name: name, RemoteAddr: host, ApiPort: apiPort, ApiScheme: "http", State: NodeReady}, ts.Close
+}
+
+// readStream reads all frames of the stream, and returns them along with the error that ended the stream, if any
+func readStream(t *testing.T, s *Stream) ([]string, error) {
+	defer s.Close()
+	var frames []string
+	for {
+		buf, err := s.Next()
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, string(buf))
+	}
+}
+
+func TestNegotiateStream(t *testing.T) {
+	cases := map[string]int{
+		"":     0,
+		"1":    1,
+		"1, 2": 1,
+		"2":    0,
+		"foo":  0,
+	}
+	for in, exp := range cases {
+		header := http.Header{}
+		header.Set(StreamHeader, in)
+		if got := negotiateStream(header); got != exp {
+			t.Fatalf("header %q: expected version %d, got %d", in, exp, got)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	setTestPeers()
+	for _, compression := range []string{"snappy", "none"} {
+		dataCompression = compression
+		var encoding string
+		peer, closePeer := newStreamPeer("peer", func(w http.ResponseWriter, r *http.Request) {
+			s := NewStreamWriter(w, r)
+			if s == nil {
+				t.Errorf("expected the request to support streaming")
+				return
+			}
+			encoding = w.Header().Get("Content-Encoding")
+			s.Write(rawMsg("foo"))
+			s.Write(rawMsg(""))
+			s.Write(rawMsg("bar"))
+			s.Close()
+		})
+		stream, err := peer.PostStream(testContext(), "test", "/getdata", testBody{})
+		if err != nil {
+			t.Fatalf("%s: expected the stream to succeed, got %s", compression, err)
+		}
+		frames, err := readStream(t, stream)
+		closePeer()
+		if err != nil || len(frames) != 3 || frames[0] != "foo" || frames[1] != "" || frames[2] != "bar" {
+			t.Fatalf("%s: expected frames foo, empty and bar, got %q (%v)", compression, frames, err)
+		}
+		if (compression == "snappy") != (encoding == "snappy") {
+			t.Fatalf("%s: unexpected content encoding %q", compression, encoding)
+		}
+	}
+}
+
+func TestStreamLegacy(t *testing.T) {
+	setTestPeers()
+	// peers that don't support streaming respond with a single message
+	peer, closePeer := newStreamPeer("peer", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("foobar"))
+	})
+	defer closePeer()
+	stream, err := peer.PostStream(testContext(), "test", "/getdata", testBody{})
+	if err != nil {
+		t.Fatalf("expected the request to succeed, got %s", err)
+	}
+	frames, err := readStream(t, stream)
+	if err != nil || len(frames) != 1 || frames[0] != "foobar" {
+		t.Fatalf("expected the message as the only frame, got %q (%v)", frames, err)
+	}
+}
+
+func TestStreamFailure(t *testing.T) {
+	setTestPeers()
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			"error",
+			func(w http.ResponseWriter, r *http.Request) {
+				s := NewStreamWriter(w, r)
+				s.Write(rawMsg("foo"))
+				s.Error(io.ErrShortWrite)
+			},
+		},
+		{
+			"truncated",
+			func(w http.ResponseWriter, r *http.Request) {
+				s := NewStreamWriter(w, r)
+				s.Write(rawMsg("foo"))
+			},
+		},
+	}
+	for _, c := range cases {
+		peer, closePeer := newStreamPeer("peer", c.handler)
+		stream, err := peer.PostStream(testContext(), "test", "/getdata", testBody{})
+		if err != nil {
+			t.Fatalf("%s: expected the stream to start, got %s", c.name, err)
+		}
+		frames, err := readStream(t, stream)
+		closePeer()
+		if err == nil || len(frames) != 1 || frames[0] != "foo" {
+			t.Fatalf("%s: expected the stream to fail after the first frame, got %q (%v)", c.name, frames, err)
+		}
+	}
+}
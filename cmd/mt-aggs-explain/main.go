@@ -15,6 +15,8 @@ var (
 	GitHash     = "(none)"
 	showVersion = flag.Bool("version", false, "print version string")
 	metric      = flag.String("metric", "", "specify a metric name to see which aggregation rule it matches")
+	mtype       = flag.String("mtype", "gauge", "mtype of the metric specified with -metric")
+	counterAgg  = flag.Bool("counter-aggregation", false, "like the counter-aggregation setting of metrictank: counters that don't match any rule get the default counter aggregation")
 )
 
 func main() {
@@ -47,10 +49,11 @@ func main() {
 	if err != nil {
 		log.Fatalf("can't read aggregations file %q: %s", aggsFile, err.Error())
 	}
+	aggs.CounterDefault = *counterAgg
 
 	if *metric != "" {
-		aggI, agg := aggs.Match(*metric)
-		fmt.Printf("metric %q gets aggI %d\n", *metric, aggI)
+		aggI, agg := aggs.Match(*metric, *mtype)
+		fmt.Printf("metric %q of mtype %q gets aggI %d\n", *metric, *mtype, aggI)
 		show(agg)
 		fmt.Println()
 		fmt.Println()
@@ -64,12 +67,16 @@ func main() {
 	}
 	fmt.Println("default:")
 	fmt.Println(aggs.DefaultAggregation)
+	if aggs.CounterDefault {
+		fmt.Println("default for counters:")
+		fmt.Println(aggs.CounterAggregation)
+	}
 }
 
 func show(agg conf.Aggregation) {
 	fmt.Println("#", agg.Name)
 	fmt.Printf("pattern:   %10s\n", agg.Pattern)
-	fmt.Printf("priority:  %10f\n", agg.XFilesFactor)
+	fmt.Printf("xFilesFactor: %7f\n", agg.XFilesFactor)
 	fmt.Printf("methods:\n")
 	for i, method := range agg.AggregationMethod {
 		consolidator := consolidation.Consolidator(method)
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+var (
+	GitHash     string
+	showVersion = flag.Bool("version", false, "print version string")
+	addr        = flag.String("addr", "http://localhost:6060", "metrictank address")
+	orgId       = flag.Int("org-id", 0, "org-id to send as x-org-id header (0 to not send it, only needed in multi-tenant setups)")
+	query       = flag.String("query", "", "graphite pattern of the series to copy")
+	pattern     = flag.String("pattern", "", "regular expression to match against the names of the series")
+	replacement = flag.String("replacement", "", "replacement for the matches of pattern. may refer to submatches as $1, ${name} etc")
+	del         = flag.Bool("delete", false, "delete the original series once copied, effectively renaming them")
+	dryRun      = flag.Bool("dry-run", false, "only show which series would be copied to which names")
+	wait        = flag.Bool("wait", true, "wait for the copy job to finish and report its progress")
+	interval    = flag.Duration("interval", 5*time.Second, "how often to check the job status when waiting")
+
+	partitionScheme = flag.String("partition-scheme", "byOrg", "method used for partitioning metrics. This should match the settings of tsdb-gw. (byOrg|bySeries)")
+	numPartitions   = flag.Int("num-partitions", 0, "number of partitions. required with -partition-scheme bySeries")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "mt-copy-series")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Copies (or with -delete, renames) series and their historical data to new names, using the metrictank /metrics/copy api.")
+		fmt.Fprintln(os.Stderr, "The new name of each series matching the query is its name with all matches of the pattern replaced.")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Example:")
+		fmt.Fprintln(os.Stderr, "  mt-copy-series -query 'servers.web*.cpu.*' -pattern '^servers\\.web(\\d+)\\.' -replacement 'servers.frontend$1.' -delete")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Flags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("mt-copy-series (built with %s, git hash %s)\n", runtime.Version(), GitHash)
+		return
+	}
+	if *query == "" || *pattern == "" {
+		fmt.Fprintln(os.Stderr, "both -query and -pattern must be specified")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	form := url.Values{}
+	form.Set("query", *query)
+	form.Set("pattern", *pattern)
+	form.Set("replacement", *replacement)
+	form.Set("delete", strconv.FormatBool(*del))
+	form.Set("dryRun", strconv.FormatBool(*dryRun))
+	form.Set("partitionScheme", *partitionScheme)
+	form.Set("numPartitions", strconv.Itoa(*numPartitions))
+
+	var resp models.MetricsCopyResp
+	err := do("POST", "/metrics/copy", form, &resp)
+	if err != nil {
+		log.Fatalf("copy request failed: %s", err)
+	}
+	for _, s := range resp.Series {
+		fmt.Printf("%s (%s) -> %s (%s)\n", s.From, s.FromId, s.To, s.ToId)
+	}
+	if resp.JobId == "" {
+		fmt.Printf("%d series to copy\n", len(resp.Series))
+		return
+	}
+	fmt.Printf("copying %d series in job %s\n", len(resp.Series), resp.JobId)
+	if !*wait {
+		return
+	}
+
+	for {
+		time.Sleep(*interval)
+		var status models.JobStatus
+		err := do("GET", "/jobs/status", url.Values{"id": {resp.JobId}}, &status)
+		if err != nil {
+			log.Fatalf("job status request failed: %s", err)
+		}
+		fmt.Printf("job %s: %s, %d/%d series done, %d errors\n", status.Id, status.State, status.Done, status.Total, len(status.Errors))
+		if status.State == models.JobRunning {
+			continue
+		}
+		for _, e := range status.Errors {
+			fmt.Println("error:", e)
+		}
+		if status.State != models.JobDone {
+			os.Exit(2)
+		}
+		return
+	}
+}
+
+// do executes the request against metrictank and decodes the json response into out
+func do(method, path string, params url.Values, out interface{}) error {
+	var req *http.Request
+	var err error
+	if method == "GET" {
+		req, err = http.NewRequest(method, *addr+path+"?"+params.Encode(), nil)
+	} else {
+		req, err = http.NewRequest(method, *addr+path, strings.NewReader(params.Encode()))
+	}
+	if err != nil {
+		return err
+	}
+	if method != "GET" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if *orgId != 0 {
+		req.Header.Set("x-org-id", strconv.Itoa(*orgId))
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", res.Status, buf)
+	}
+	return json.Unmarshal(buf, out)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+var (
+	GitHash     string
+	showVersion = flag.Bool("version", false, "print version string")
+	addr        = flag.String("addr", "http://localhost:6060", "metrictank address")
+	orgId       = flag.Int("org-id", 0, "org-id to send as x-org-id header (0 to not send it, only needed in multi-tenant setups)")
+	query       = flag.String("query", "", "graphite pattern of the series to rebuild")
+	from        = flag.String("from", "", "rebuild the rollups of the data from this time on. (defaults to all raw data retained)")
+	to          = flag.String("to", "", "rebuild the rollups of the data up to this time. (defaults to now)")
+	rate        = flag.Float64("rate", 0, "maximum number of series to rebuild per second. (0 means no limit)")
+	offset      = flag.Int("offset", 0, "number of series to skip, to resume an interrupted job")
+	dryRun      = flag.Bool("dry-run", false, "only show how many series would be rebuilt")
+	wait        = flag.Bool("wait", true, "wait for the rebuild job to finish and report its progress")
+	interval    = flag.Duration("interval", 5*time.Second, "how often to check the job status when waiting")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "mt-rebuild-rollups")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Rebuilds the rollups of series from their raw data, using the metrictank /metrics/rebuild api.")
+		fmt.Fprintln(os.Stderr, "Use this after changing storage-aggregation.conf, to apply the new settings to historical data.")
+		fmt.Fprintln(os.Stderr, "If the job gets interrupted, rerun with -offset set to the number of series done, to resume it.")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Example:")
+		fmt.Fprintln(os.Stderr, "  mt-rebuild-rollups -query 'servers.*.latency.*' -from -30d -rate 100")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Flags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("mt-rebuild-rollups (built with %s, git hash %s)\n", runtime.Version(), GitHash)
+		return
+	}
+	if *query == "" {
+		fmt.Fprintln(os.Stderr, "-query must be specified")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	form := url.Values{}
+	form.Set("query", *query)
+	form.Set("from", *from)
+	form.Set("to", *to)
+	form.Set("rate", strconv.FormatFloat(*rate, 'f', -1, 64))
+	form.Set("offset", strconv.Itoa(*offset))
+	form.Set("dryRun", strconv.FormatBool(*dryRun))
+
+	var resp models.MetricsRebuildResp
+	err := do("POST", "/metrics/rebuild", form, &resp)
+	if err != nil {
+		log.Fatalf("rebuild request failed: %s", err)
+	}
+	if resp.JobId == "" {
+		fmt.Printf("%d series to rebuild\n", resp.Series-*offset)
+		return
+	}
+	fmt.Printf("rebuilding %d series in job %s\n", resp.Series-*offset, resp.JobId)
+	if !*wait {
+		return
+	}
+
+	for {
+		time.Sleep(*interval)
+		var status models.JobStatus
+		err := do("GET", "/jobs/status", url.Values{"id": {resp.JobId}}, &status)
+		if err != nil {
+			log.Fatalf("job status request failed: %s", err)
+		}
+		fmt.Printf("job %s: %s, %d/%d series done, %d errors\n", status.Id, status.State, status.Done, status.Total, len(status.Errors))
+		if status.State == models.JobRunning {
+			continue
+		}
+		for _, e := range status.Errors {
+			fmt.Println("error:", e)
+		}
+		if status.State != models.JobDone {
+			os.Exit(2)
+		}
+		return
+	}
+}
+
+// do executes the request against metrictank and decodes the json response into out
+func do(method, path string, params url.Values, out interface{}) error {
+	var req *http.Request
+	var err error
+	if method == "GET" {
+		req, err = http.NewRequest(method, *addr+path+"?"+params.Encode(), nil)
+	} else {
+		req, err = http.NewRequest(method, *addr+path, strings.NewReader(params.Encode()))
+	}
+	if err != nil {
+		return err
+	}
+	if method != "GET" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if *orgId != 0 {
+		req.Header.Set("x-org-id", strconv.Itoa(*orgId))
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", res.Status, buf)
+	}
+	return json.Unmarshal(buf, out)
+}
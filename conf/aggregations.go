@@ -13,6 +13,10 @@ import (
 type Aggregations struct {
 	Data               []Aggregation
 	DefaultAggregation Aggregation
+	// CounterAggregation is the default for metrics of mtype counter, for which averages and last values are misleading.
+	// it is only used if CounterDefault is set, otherwise counters get DefaultAggregation like other metrics
+	CounterAggregation Aggregation
+	CounterDefault     bool
 }
 
 type Aggregation struct {
@@ -32,6 +36,12 @@ func NewAggregations() Aggregations {
 			XFilesFactor:      0.5,
 			AggregationMethod: []Method{Avg},
 		},
+		CounterAggregation: Aggregation{
+			Name:              "default-counter",
+			Pattern:           regexp.MustCompile(".*"),
+			XFilesFactor:      0.5,
+			AggregationMethod: []Method{Max, Inc},
+		},
 	}
 }
 
@@ -80,6 +90,18 @@ func ReadAggregations(file string) (Aggregations, error) {
 				item.AggregationMethod = append(item.AggregationMethod, Max)
 			case "min":
 				item.AggregationMethod = append(item.AggregationMethod, Min)
+			case "p50", "median":
+				item.AggregationMethod = append(item.AggregationMethod, P50)
+			case "p75":
+				item.AggregationMethod = append(item.AggregationMethod, P75)
+			case "p90":
+				item.AggregationMethod = append(item.AggregationMethod, P90)
+			case "p95":
+				item.AggregationMethod = append(item.AggregationMethod, P95)
+			case "p99":
+				item.AggregationMethod = append(item.AggregationMethod, P99)
+			case "increase":
+				item.AggregationMethod = append(item.AggregationMethod, Inc)
 			default:
 				return result, fmt.Errorf("[%s]: unknown aggregation method %q", item.Name, methodStr)
 			}
@@ -91,20 +113,26 @@ func ReadAggregations(file string) (Aggregations, error) {
 	return result, nil
 }
 
-// Match returns the correct aggregation setting for the given metric
-// it can always find a valid setting, because there's a default catch all
-// also returns the index of the setting, to efficiently reference it
-func (a Aggregations) Match(metric string) (uint16, Aggregation) {
+// Match returns the correct aggregation setting for the given metric and its mtype
+// it can always find a valid setting, because there's a default catch all, which for counters is CounterAggregation
+// if CounterDefault is set. also returns the index of the setting, to efficiently reference it
+func (a Aggregations) Match(metric, mtype string) (uint16, Aggregation) {
 	for i, s := range a.Data {
 		if s.Pattern.MatchString(metric) {
 			return uint16(i), s
 		}
 	}
+	if mtype == "counter" && a.CounterDefault {
+		return uint16(len(a.Data)) + 1, a.CounterAggregation
+	}
 	return uint16(len(a.Data)), a.DefaultAggregation
 }
 
 // Get returns the aggregation setting corresponding to the given index
 func (a Aggregations) Get(i uint16) Aggregation {
+	if i == uint16(len(a.Data))+1 {
+		return a.CounterAggregation
+	}
 	if i+1 > uint16(len(a.Data)) {
 		return a.DefaultAggregation
 	}
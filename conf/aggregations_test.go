@@ -0,0 +1,45 @@
+package conf
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestAggregationsMatch(t *testing.T) {
+	aggs := NewAggregations()
+	aggs.Data = append(aggs.Data, Aggregation{
+		Name:              "sums",
+		Pattern:           regexp.MustCompile(`\.sum$`),
+		XFilesFactor:      0,
+		AggregationMethod: []Method{Sum},
+	})
+	cases := []struct {
+		metric string
+		mtype  string
+		id     uint16
+		name   string
+	}{
+		{"a.sum", "gauge", 0, "sums"},
+		{"a.sum", "counter", 0, "sums"}, // explicit rules take precedence over the mtype
+		{"a.b", "gauge", 1, "default"},
+		{"a.b", "rate", 1, "default"},
+		{"a.b", "counter", 1, "default"}, // counters only get their own default when enabled
+	}
+	check := func() {
+		for _, c := range cases {
+			id, agg := aggs.Match(c.metric, c.mtype)
+			if id != c.id || agg.Name != c.name {
+				t.Fatalf("%s of mtype %s: expected aggregation %d %q, got %d %q", c.metric, c.mtype, c.id, c.name, id, agg.Name)
+			}
+			if got := aggs.Get(id); got.Name != c.name {
+				t.Fatalf("%s of mtype %s: expected Get(%d) to return %q, got %q", c.metric, c.mtype, id, c.name, got.Name)
+			}
+		}
+	}
+	check()
+
+	aggs.CounterDefault = true
+	cases[len(cases)-1].id = 2
+	cases[len(cases)-1].name = "default-counter"
+	check()
+}
@@ -8,4 +8,16 @@ const (
 	Lst
 	Max
 	Min
+	_ // consolidation.Cnt, which can't be configured
+	P50
+	P75
+	P90
+	P95
+	P99
+	Inc // increase of a counter, corrected for counter resets
 )
+
+// IsPercentile returns whether the method is a percentile, which is computed from the sketch rollup
+func (m Method) IsPercentile() bool {
+	return m >= P50 && m <= P99
+}
@@ -31,6 +31,7 @@ type Schema struct {
 	Retentions    Retentions
 	Priority      int64
 	ReorderWindow uint32
+	Backfill      bool
 }
 
 func NewSchemas(schemas []Schema) Schemas {
@@ -55,20 +56,24 @@ func (s *Schemas) BuildIndex() {
 	for _, schema := range s.raw {
 		for pos := range schema.Retentions {
 			s.index = append(s.index, Schema{
-				Name:       schema.Name,
-				Pattern:    schema.Pattern,
-				Retentions: schema.Retentions[pos:],
-				Priority:   schema.Priority,
+				Name:          schema.Name,
+				Pattern:       schema.Pattern,
+				Retentions:    schema.Retentions[pos:],
+				Priority:      schema.Priority,
+				ReorderWindow: schema.ReorderWindow,
+				Backfill:      schema.Backfill,
 			})
 		}
 	}
 	// add the default schema
 	for pos := range s.DefaultSchema.Retentions {
 		s.index = append(s.index, Schema{
-			Name:       s.DefaultSchema.Name,
-			Pattern:    s.DefaultSchema.Pattern,
-			Retentions: s.DefaultSchema.Retentions[pos:],
-			Priority:   s.DefaultSchema.Priority,
+			Name:          s.DefaultSchema.Name,
+			Pattern:       s.DefaultSchema.Pattern,
+			Retentions:    s.DefaultSchema.Retentions[pos:],
+			Priority:      s.DefaultSchema.Priority,
+			ReorderWindow: s.DefaultSchema.ReorderWindow,
+			Backfill:      s.DefaultSchema.Backfill,
 		})
 	}
 }
@@ -130,6 +135,14 @@ func ReadSchemas(file string) (Schemas, error) {
 			}
 		}
 
+		backfillStr := sec.ValueOf("backfill")
+		if len(backfillStr) > 0 {
+			schema.Backfill, err = strconv.ParseBool(backfillStr)
+			if err != nil {
+				return Schemas{}, fmt.Errorf("[%s]: Failed to parse backfill conf, expected a boolean: %s", schema.Name, backfillStr)
+			}
+		}
+
 		schemas = append(schemas, schema)
 	}
 
@@ -159,15 +172,16 @@ func ReadSchemas(file string) (Schemas, error) {
 //
 // When evaluating a match we start with the first schema in the index and
 // compare the regex pattern.
-// - If it matches we then just find the retention set with the best fit. The
-//   best fit is when the interval is >= the rawInterval (first retention) and
-//   less then the interval of the next rollup.
-// - If the pattern doesnt match, then we skip ahead to the next pattern.
+//   - If it matches we then just find the retention set with the best fit. The
+//     best fit is when the interval is >= the rawInterval (first retention) and
+//     less then the interval of the next rollup.
+//   - If the pattern doesnt match, then we skip ahead to the next pattern.
 //
 // eg. from the above diagram we would compare the pattern for schame0
-//     (pattern1), if it doesnt match we will then compare the pattern of
-//     schema2 (pattern2) and if that doesnt match we would try schema5
-//     (pattern3).
+//
+//	(pattern1), if it doesnt match we will then compare the pattern of
+//	schema2 (pattern2) and if that doesnt match we would try schema5
+//	(pattern3).
 func (s Schemas) Match(metric string, interval int) (uint16, Schema) {
 	i := 0
 	for i < len(s.index) {
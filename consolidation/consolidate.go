@@ -1,14 +1,44 @@
 package consolidation
 
 import (
+	"math"
+
+	"github.com/grafana/metrictank/batch"
 	"gopkg.in/raintank/schema.v1"
 )
 
+// xFilesFactorFunc wraps aggFunc so that it returns null when the ratio of non-null input points
+// is below xFilesFactor, like graphite does.
+func xFilesFactorFunc(aggFunc batch.AggFunc, xFilesFactor float64) batch.AggFunc {
+	if xFilesFactor <= 0 {
+		return aggFunc
+	}
+	return func(in []schema.Point) float64 {
+		var known int
+		for _, p := range in {
+			if !math.IsNaN(p.Val) {
+				known++
+			}
+		}
+		if float64(known)/float64(len(in)) < xFilesFactor {
+			return math.NaN()
+		}
+		return aggFunc(in)
+	}
+}
+
 // Consolidate consolidates `in`, aggNum points at a time via the given function
+// groups of points of which the ratio of non-null points is below xFilesFactor result in a null.
 // note: the returned slice repurposes in's backing array.
-func Consolidate(in []schema.Point, aggNum uint32, consolidator Consolidator) []schema.Point {
+func Consolidate(in []schema.Point, aggNum uint32, consolidator Consolidator, xFilesFactor float64) []schema.Point {
+	return ConsolidateFunc(in, aggNum, GetAggFunc(consolidator), xFilesFactor)
+}
+
+// ConsolidateFunc is like Consolidate, but aggregates the groups of points with aggFunc.
+// this is for aggregations that need more than the point values, like merging the sketches of percentile rollups.
+func ConsolidateFunc(in []schema.Point, aggNum uint32, aggFunc batch.AggFunc, xFilesFactor float64) []schema.Point {
 	num := int(aggNum)
-	aggFunc := GetAggFunc(consolidator)
+	aggFunc = xFilesFactorFunc(aggFunc, xFilesFactor)
 
 	// let's see if the input data is a perfect fit for the requested aggNum
 	// (e.g. no remainder). This case is the easiest to handle
@@ -51,6 +81,34 @@ func Consolidate(in []schema.Point, aggNum uint32, consolidator Consolidator) []
 	return out
 }
 
+// CounterDelta returns how much a counter increased from prev to val.
+// a decrease is a counter reset: the counter restarted from 0, so it increased by val.
+func CounterDelta(prev, val float64) float64 {
+	if val < prev {
+		return val
+	}
+	return val - prev
+}
+
+// Increase replaces the values of the counter in by how much it increased since the previous non-null point.
+// the first non-null point becomes null, because its increase is unknown.
+// note: the returned slice repurposes in's backing array.
+func Increase(in []schema.Point) []schema.Point {
+	prev := math.NaN()
+	for i, p := range in {
+		if math.IsNaN(p.Val) {
+			continue
+		}
+		if math.IsNaN(prev) {
+			in[i].Val = math.NaN()
+		} else {
+			in[i].Val = CounterDelta(prev, p.Val)
+		}
+		prev = p.Val
+	}
+	return in
+}
+
 // returns how many points should be aggregated together so that you end up with as many points as possible,
 // but never more than maxPoints
 func AggEvery(numPoints, maxPoints uint32) uint32 {
@@ -63,7 +121,7 @@ func AggEvery(numPoints, maxPoints uint32) uint32 {
 // ConsolidateStable consolidates points in a "stable" way, meaning if you run the same function again so that the input
 // receives new points at the end and old points get removed at the beginning, we keep picking the same points to consolidate together
 // interval is the interval between the input points
-func ConsolidateStable(points []schema.Point, interval, maxDataPoints uint32, consolidator Consolidator) ([]schema.Point, uint32) {
+func ConsolidateStable(points []schema.Point, interval, maxDataPoints uint32, consolidator Consolidator, xFilesFactor float64) ([]schema.Point, uint32) {
 	aggNum := AggEvery(uint32(len(points)), maxDataPoints)
 	// note that the amount of points to strip is always < 1 postAggInterval's worth.
 	// there's 2 important considerations here:
@@ -89,7 +147,7 @@ func ConsolidateStable(points []schema.Point, interval, maxDataPoints uint32, co
 		_, num := nudge(points[0].Ts, interval, aggNum)
 		points = points[num:]
 	}
-	points = Consolidate(points, aggNum, consolidator)
+	points = Consolidate(points, aggNum, consolidator, xFilesFactor)
 	interval *= aggNum
 	return points, interval
 }
@@ -1,6 +1,7 @@
 package consolidation
 
 import (
+	"math"
 	"testing"
 
 	"github.com/grafana/metrictank/test"
@@ -16,7 +17,7 @@ type testCase struct {
 
 func validate(cases []testCase, t *testing.T) {
 	for i, c := range cases {
-		out := Consolidate(c.in, c.num, c.consol)
+		out := Consolidate(c.in, c.num, c.consol, 0)
 		if len(out) != len(c.out) {
 			t.Fatalf("output for testcase %d mismatch: expected: %v, got: %v", i, c.out, out)
 
@@ -194,6 +195,71 @@ func TestConsolidationFunctions(t *testing.T) {
 	validate(cases, t)
 }
 
+func TestConsolidateXFilesFactor(t *testing.T) {
+	nan := math.NaN()
+	in := func() []schema.Point {
+		return []schema.Point{
+			{Val: 1, Ts: 10},
+			{Val: nan, Ts: 20},
+			{Val: 3, Ts: 30},
+			{Val: nan, Ts: 40},
+			{Val: nan, Ts: 50},
+			{Val: 6, Ts: 60},
+			{Val: 7, Ts: 70},
+			{Val: nan, Ts: 80},
+			{Val: nan, Ts: 90},
+			{Val: 10, Ts: 100},
+		}
+	}
+	cases := []struct {
+		xFilesFactor float64
+		out          []float64
+	}{
+		{0, []float64{4, 6, 7, 10}},
+		{0.3, []float64{4, 6, 7, 10}},
+		{0.5, []float64{4, nan, nan, 10}},
+		{1, []float64{nan, nan, nan, 10}},
+	}
+	for _, c := range cases {
+		out := Consolidate(in(), 3, Sum, c.xFilesFactor)
+		if len(out) != len(c.out) {
+			t.Fatalf("xFilesFactor %f: expected %d points, got %v", c.xFilesFactor, len(c.out), out)
+		}
+		for i, p := range out {
+			exp := c.out[i]
+			if p.Ts != uint32(30*(i+1)) || (math.IsNaN(exp) != math.IsNaN(p.Val)) || (!math.IsNaN(exp) && p.Val != exp) {
+				t.Fatalf("xFilesFactor %f: expected point %d to be %f, got %v", c.xFilesFactor, i, exp, p)
+			}
+		}
+	}
+}
+
+func TestIncrease(t *testing.T) {
+	nan := math.NaN()
+	in := []schema.Point{
+		{Val: nan, Ts: 10},
+		{Val: 5, Ts: 20},
+		{Val: 8, Ts: 30},
+		{Val: nan, Ts: 40},
+		{Val: 12, Ts: 50},
+		{Val: 2, Ts: 60}, // reset
+		{Val: 2, Ts: 70},
+		{Val: 6, Ts: 80},
+	}
+	exp := []float64{nan, nan, 3, nan, 4, 2, 0, 4}
+	out := Increase(in)
+	for i, p := range out {
+		if p.Ts != uint32(10*(i+1)) || (math.IsNaN(exp[i]) != math.IsNaN(p.Val)) || (!math.IsNaN(exp[i]) && p.Val != exp[i]) {
+			t.Fatalf("expected point %d to be %f, got %v", i, exp[i], p)
+		}
+	}
+	// the increase over a group of points is their sum
+	out = Consolidate(out, 4, Inc, 0)
+	if len(out) != 2 || out[0].Val != 3 || out[1].Val != 10 {
+		t.Fatalf("expected the consolidated increases to be 3 and 10, got %v", out)
+	}
+}
+
 func TestConsolidateStableNoAgg(t *testing.T) {
 	testConsolidateStable(
 		[]schema.Point{
@@ -338,7 +404,7 @@ func TestConsolidateStableABitMoreDataEven(t *testing.T) {
 		t)
 }
 func testConsolidateStable(in []schema.Point, inInt uint32, mdp uint32, expOut []schema.Point, expOutInt uint32, t *testing.T) {
-	out, outInt := ConsolidateStable(in, inInt, mdp, Sum)
+	out, outInt := ConsolidateStable(in, inInt, mdp, Sum, 0)
 	if outInt != expOutInt {
 		t.Fatalf("output interval mismatch: expected: %v, got: %v", expOutInt, outInt)
 	}
@@ -501,7 +567,7 @@ func benchmarkConsolidate(fn func() []schema.Point, aggNum uint32, consolidator
 		in := fn()
 		l = len(in)
 		b.StartTimer()
-		ret := Consolidate(in, aggNum, consolidator)
+		ret := Consolidate(in, aggNum, consolidator, 0)
 		dummy = ret
 	}
 	b.SetBytes(int64(l * 12))
@@ -23,6 +23,12 @@ const (
 	Max
 	Min
 	Cnt // not available through http api
+	P50
+	P75
+	P90
+	P95
+	P99
+	Inc // increase of a counter. not available through http api
 )
 
 // String provides human friendly names
@@ -42,6 +48,18 @@ func (c Consolidator) String() string {
 		return "MaximumConsolidator"
 	case Sum:
 		return "SumConsolidator"
+	case P50:
+		return "P50Consolidator"
+	case P75:
+		return "P75Consolidator"
+	case P90:
+		return "P90Consolidator"
+	case P95:
+		return "P95Consolidator"
+	case P99:
+		return "P99Consolidator"
+	case Inc:
+		return "IncreaseConsolidator"
 	}
 	panic(fmt.Sprintf("Consolidator.String(): unknown consolidator %d", c))
 }
@@ -64,6 +82,11 @@ func (c Consolidator) Archive() string {
 		return "max"
 	case Sum:
 		return "sum"
+	case P50, P75, P90, P95, P99:
+		// all percentiles are computed from the same sketches
+		return "sketch"
+	case Inc:
+		return "inc"
 	}
 	panic(fmt.Sprintf("Consolidator.Archive(): unknown consolidator %q", c))
 }
@@ -80,6 +103,11 @@ func FromArchive(archive string) Consolidator {
 		return Max
 	case "sum":
 		return Sum
+	case "sketch":
+		// any percentile will do, as they share the archive
+		return P50
+	case "inc":
+		return Inc
 	}
 	return None
 }
@@ -98,10 +126,43 @@ func FromConsolidateBy(c string) Consolidator {
 		return Max
 	case "sum":
 		return Sum
+	case "p50", "median":
+		return P50
+	case "p75":
+		return P75
+	case "p90":
+		return P90
+	case "p95":
+		return P95
+	case "p99":
+		return P99
 	}
 	return None
 }
 
+// IsPercentile returns whether the consolidator is a percentile, which is read from the sketch rollup
+func (c Consolidator) IsPercentile() bool {
+	_, ok := c.Quantile()
+	return ok
+}
+
+// Quantile returns the quantile that a percentile consolidator computes, and whether it is one
+func (c Consolidator) Quantile() (float64, bool) {
+	switch c {
+	case P50:
+		return 0.5, true
+	case P75:
+		return 0.75, true
+	case P90:
+		return 0.9, true
+	case P95:
+		return 0.95, true
+	case P99:
+		return 0.99, true
+	}
+	return 0, false
+}
+
 // map the consolidation to the respective aggregation function, if applicable.
 func GetAggFunc(consolidator Consolidator) batch.AggFunc {
 	var consFunc batch.AggFunc
@@ -116,8 +177,12 @@ func GetAggFunc(consolidator Consolidator) batch.AggFunc {
 		consFunc = batch.Min
 	case Max:
 		consFunc = batch.Max
-	case Sum:
+	case Sum, Inc:
+		// the increase over several points is the sum of their increases
 		consFunc = batch.Sum
+	case P50, P75, P90, P95, P99:
+		q, _ := consolidator.Quantile()
+		consFunc = batch.Percentile(q)
 	}
 	return consFunc
 }
@@ -126,5 +191,8 @@ func Validate(fn string) error {
 	if fn == "avg" || fn == "average" || fn == "last" || fn == "min" || fn == "max" || fn == "sum" {
 		return nil
 	}
+	if FromConsolidateBy(fn).IsPercentile() {
+		return nil
+	}
 	return errUnknownConsolidationFunction
 }
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+
+	"github.com/grafana/metrictank/consolidation"
 )
 
 //go:generate stringer -type=exprType
@@ -29,6 +31,9 @@ type expr struct {
 	args      []*expr          // for etFunc: positional args which itself are expressions
 	namedArgs map[string]*expr // for etFunc: named args which itself are expressions
 	argsStr   string           // for etFunc: literal string of how all the args were specified
+
+	req      Req                        // for etName: the request for the series, set when planning
+	pushDown consolidation.Consolidator // for etName: the aggregation of the function consuming the series, if it can be pushed down. see pushable
 }
 
 func (e expr) Print(indent int) string {
@@ -64,6 +64,7 @@ func (s *FuncDivideSeries) Exec(cache map[Req][]models.Series) ([]models.Series,
 			Datapoints:   out,
 			Interval:     divisor.Interval,
 			Consolidator: dividend.Consolidator,
+			XFilesFactor: dividend.XFilesFactor,
 			QueryCons:    dividend.QueryCons,
 		}
 		cache[Req{}] = append(cache[Req{}], output)
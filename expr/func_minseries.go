@@ -0,0 +1,72 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncMinSeries struct {
+	in []GraphiteFunc
+}
+
+func NewMinSeries() GraphiteFunc {
+	return &FuncMinSeries{}
+}
+
+func (s *FuncMinSeries) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesLists{val: &s.in},
+	}, []Arg{ArgSeries{}}
+}
+
+func (s *FuncMinSeries) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncMinSeries) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, queryPatts, err := consumeFuncs(cache, s.in)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(series) == 0 {
+		return series, nil
+	}
+
+	if len(series) == 1 {
+		name := fmt.Sprintf("minSeries(%s)", series[0].QueryPatt)
+		series[0].Target = name
+		series[0].QueryPatt = name
+		return series, nil
+	}
+	out := pointSlicePool.Get().([]schema.Point)
+	for i := 0; i < len(series[0].Datapoints); i++ {
+		point := schema.Point{
+			Ts:  series[0].Datapoints[i].Ts,
+			Val: math.NaN(),
+		}
+		for j := 0; j < len(series); j++ {
+			p := series[j].Datapoints[i].Val
+			if !math.IsNaN(p) && (math.IsNaN(point.Val) || p < point.Val) {
+				point.Val = p
+			}
+		}
+		out = append(out, point)
+	}
+	name := fmt.Sprintf("minSeries(%s)", strings.Join(queryPatts, ","))
+	cons, queryCons := summarizeCons(series)
+	output := models.Series{
+		Target:       name,
+		QueryPatt:    name,
+		Datapoints:   out,
+		Interval:     series[0].Interval,
+		Consolidator: cons,
+		QueryCons:    queryCons,
+	}
+	cache[Req{}] = append(cache[Req{}], output)
+	return []models.Series{output}, nil
+}
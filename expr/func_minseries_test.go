@@ -0,0 +1,200 @@
+package expr
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/test"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestMinSeriesIdentity(t *testing.T) {
+	testMinSeries(
+		"identity",
+		[][]models.Series{
+			{
+				{
+					QueryPatt:  "single",
+					Target:     "single",
+					Datapoints: getCopy(a),
+				},
+			},
+		},
+		models.Series{
+			QueryPatt:  "minSeries(single)",
+			Datapoints: getCopy(a),
+		},
+		t,
+	)
+}
+func TestMinSeriesQueryToSingle(t *testing.T) {
+	testMinSeries(
+		"query-to-single",
+		[][]models.Series{
+			{
+				{
+					QueryPatt:  "foo.*",
+					Target:     "foo",
+					Datapoints: getCopy(a),
+				},
+			},
+		},
+		models.Series{
+			QueryPatt:  "minSeries(foo.*)",
+			Datapoints: getCopy(a),
+		},
+		t,
+	)
+}
+func TestMinSeriesMultipleSameQuery(t *testing.T) {
+	testMinSeries(
+		"min-multiple-series",
+		[][]models.Series{
+			{
+				{
+					QueryPatt:  "foo.*",
+					Target:     "foo.a",
+					Datapoints: getCopy(a),
+				},
+				{
+					QueryPatt:  "foo.*",
+					Target:     "foo.b",
+					Datapoints: getCopy(b),
+				},
+			},
+		},
+		models.Series{
+			QueryPatt:  "minSeries(foo.*)",
+			Datapoints: getCopy(minab),
+		},
+		t,
+	)
+}
+func TestMinSeriesMultipleDiffQuery(t *testing.T) {
+	testMinSeries(
+		"min-multiple-serieslists",
+		[][]models.Series{
+			{
+				{
+					QueryPatt:  "foo.*",
+					Target:     "foo.a",
+					Datapoints: getCopy(a),
+				},
+				{
+					QueryPatt:  "foo.*",
+					Target:     "foo.b",
+					Datapoints: getCopy(b),
+				},
+			},
+			{
+				{
+					QueryPatt:  "movingAverage(bar, '1min')",
+					Target:     "movingAverage(bar, '1min')",
+					Datapoints: getCopy(c),
+				},
+			},
+		},
+		models.Series{
+			QueryPatt:  "minSeries(foo.*,movingAverage(bar, '1min'))",
+			Datapoints: getCopy(minabc),
+		},
+		t,
+	)
+}
+
+func testMinSeries(name string, in [][]models.Series, out models.Series, t *testing.T) {
+	f := NewMinSeries()
+	min := f.(*FuncMinSeries)
+	for _, i := range in {
+		min.in = append(min.in, NewMock(i))
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %q: err should be nil. got %q", name, err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("case %q: minSeries output should be only 1 thing (a series) not %d", name, len(got))
+	}
+	g := got[0]
+	if g.QueryPatt != out.QueryPatt {
+		t.Fatalf("case %q: expected target %q, got %q", name, out.QueryPatt, g.QueryPatt)
+	}
+	if len(g.Datapoints) != len(out.Datapoints) {
+		t.Fatalf("case %q: len output expected %d, got %d", name, len(out.Datapoints), len(g.Datapoints))
+	}
+	for j, p := range g.Datapoints {
+		bothNaN := math.IsNaN(p.Val) && math.IsNaN(out.Datapoints[j].Val)
+		if (bothNaN || p.Val == out.Datapoints[j].Val) && p.Ts == out.Datapoints[j].Ts {
+			continue
+		}
+		t.Fatalf("case %q: output point %d - expected %v got %v", name, j, out.Datapoints[j], p)
+	}
+}
+
+func BenchmarkMinSeries10k_1NoNulls(b *testing.B) {
+	benchmarkMinSeries(b, 1, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkMinSeries10k_10NoNulls(b *testing.B) {
+	benchmarkMinSeries(b, 10, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkMinSeries10k_100NoNulls(b *testing.B) {
+	benchmarkMinSeries(b, 100, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkMinSeries10k_1000NoNulls(b *testing.B) {
+	benchmarkMinSeries(b, 1000, test.RandFloats10k, test.RandFloats10k)
+}
+
+func BenchmarkMinSeries10k_1SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkMinSeries(b, 1, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkMinSeries10k_10SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkMinSeries(b, 10, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkMinSeries10k_100SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkMinSeries(b, 100, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkMinSeries10k_1000SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkMinSeries(b, 1000, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+
+func BenchmarkMinSeries10k_1AllSeriesHalfNulls(b *testing.B) {
+	benchmarkMinSeries(b, 1, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkMinSeries10k_10AllSeriesHalfNulls(b *testing.B) {
+	benchmarkMinSeries(b, 10, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkMinSeries10k_100AllSeriesHalfNulls(b *testing.B) {
+	benchmarkMinSeries(b, 100, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkMinSeries10k_1000AllSeriesHalfNulls(b *testing.B) {
+	benchmarkMinSeries(b, 1000, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+
+func benchmarkMinSeries(b *testing.B, numSeries int, fn0, fn1 func() []schema.Point) {
+	var input []models.Series
+	for i := 0; i < numSeries; i++ {
+		series := models.Series{
+			QueryPatt: strconv.Itoa(i),
+		}
+		if i%1 == 0 {
+			series.Datapoints = fn0()
+		} else {
+			series.Datapoints = fn1()
+		}
+		input = append(input, series)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := NewMinSeries()
+		min := f.(*FuncMinSeries)
+		min.in = append(min.in, NewMock(input))
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+		results = got
+	}
+	b.SetBytes(int64(numSeries * len(input[0].Datapoints) * 12))
+}
@@ -5,6 +5,7 @@ import (
 	"math"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/consolidation"
 	"gopkg.in/raintank/schema.v1"
 )
 
@@ -26,8 +27,15 @@ func (s *FuncPerSecond) Signature() ([]Arg, []Arg) {
 		}
 }
 
+// Context asks for the increase of counters, so that the rate is correct across counter resets,
+// and at rollup resolutions, where the values of a counter lose the resets in between them.
+// series that aren't counters are returned with their default consolidation instead.
+// the increase treats every decrease as a reset to 0, so with maxValue, which means decreases are
+// wraps of the counter, we ask for the counter values instead.
 func (s *FuncPerSecond) Context(context Context) Context {
-	context.consol = 0
+	if s.maxValue <= 0 {
+		context.consol = consolidation.Inc
+	}
 	return context
 }
 
@@ -43,26 +51,34 @@ func (s *FuncPerSecond) Exec(cache map[Req][]models.Series) ([]models.Series, er
 	var outputs []models.Series
 	for _, serie := range series {
 		out := pointSlicePool.Get().([]schema.Point)
-		for i, v := range serie.Datapoints {
-			out = append(out, schema.Point{Ts: v.Ts})
-			if i == 0 || math.IsNaN(v.Val) || math.IsNaN(serie.Datapoints[i-1].Val) {
-				out[i].Val = math.NaN()
-				continue
+		if serie.Consolidator == consolidation.Inc {
+			// the values are already the increases of the counter, corrected for resets
+			for _, v := range serie.Datapoints {
+				out = append(out, schema.Point{Val: v.Val / float64(serie.Interval), Ts: v.Ts})
 			}
-			diff := v.Val - serie.Datapoints[i-1].Val
-			if diff >= 0 {
-				out[i].Val = diff / float64(serie.Interval)
-			} else if !math.IsNaN(maxValue) && maxValue >= v.Val {
-				out[i].Val = (maxValue + diff + 1) / float64(serie.Interval)
-			} else {
-				out[i].Val = math.NaN()
+		} else {
+			for i, v := range serie.Datapoints {
+				out = append(out, schema.Point{Ts: v.Ts})
+				if i == 0 || math.IsNaN(v.Val) || math.IsNaN(serie.Datapoints[i-1].Val) {
+					out[i].Val = math.NaN()
+					continue
+				}
+				diff := v.Val - serie.Datapoints[i-1].Val
+				if diff >= 0 {
+					out[i].Val = diff / float64(serie.Interval)
+				} else if !math.IsNaN(maxValue) && maxValue >= v.Val {
+					out[i].Val = (maxValue + diff + 1) / float64(serie.Interval)
+				} else {
+					out[i].Val = math.NaN()
+				}
 			}
 		}
 		s := models.Series{
-			Target:     fmt.Sprintf("perSecond(%s)", serie.Target),
-			QueryPatt:  fmt.Sprintf("perSecond(%s)", serie.QueryPatt),
-			Datapoints: out,
-			Interval:   serie.Interval,
+			Target:       fmt.Sprintf("perSecond(%s)", serie.Target),
+			QueryPatt:    fmt.Sprintf("perSecond(%s)", serie.QueryPatt),
+			Datapoints:   out,
+			Interval:     serie.Interval,
+			XFilesFactor: serie.XFilesFactor,
 		}
 		outputs = append(outputs, s)
 		cache[Req{}] = append(cache[Req{}], s)
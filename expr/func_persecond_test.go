@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/consolidation"
 	"gopkg.in/raintank/schema.v1"
 )
 
@@ -92,6 +93,78 @@ func TestPerSecondSingleMaxValue(t *testing.T) {
 	)
 }
 
+func TestPerSecondIncrease(t *testing.T) {
+	// the increases of a counter, from its increase rollups
+	inc := []schema.Point{
+		{Val: math.NaN(), Ts: 60},
+		{Val: 120, Ts: 120},
+		{Val: 30, Ts: 180},
+		{Val: 0, Ts: 240},
+	}
+	testPerSecond(
+		"increase",
+		[][]models.Series{
+			{
+				{
+					Interval:     60,
+					QueryPatt:    "counter",
+					Consolidator: consolidation.Inc,
+					Datapoints:   getCopy(inc),
+				},
+			},
+		},
+		[]models.Series{
+			{
+				Interval:  60,
+				QueryPatt: "perSecond(counter)",
+				Datapoints: []schema.Point{
+					{Val: math.NaN(), Ts: 60},
+					{Val: 2, Ts: 120},
+					{Val: 0.5, Ts: 180},
+					{Val: 0, Ts: 240},
+				},
+			},
+		},
+		0,
+		t,
+	)
+}
+
+func TestPerSecondWrappingCounter(t *testing.T) {
+	f := NewPerSecond()
+	ps := f.(*FuncPerSecond)
+	if consol := ps.Context(Context{}).consol; consol != consolidation.Inc {
+		t.Fatalf("expected perSecond to ask for the increase, got %s", consol)
+	}
+	// the increase would treat the wrap as a reset to 0, so we need the counter values
+	ps.maxValue = 255
+	if consol := ps.Context(Context{}).consol; consol != 0 {
+		t.Fatalf("expected perSecond with maxValue to keep the default consolidation, got %s", consol)
+	}
+	testPerSecond(
+		"wrapping-counter",
+		[][]models.Series{
+			{
+				{
+					Interval:     10,
+					QueryPatt:    "counter8bit",
+					Consolidator: ps.Context(Context{}).consol,
+					Datapoints:   getCopy(d),
+				},
+			},
+		},
+		[]models.Series{
+			{
+				Interval:   10,
+				QueryPatt:  "perSecond(counter8bit)",
+				Datapoints: getCopy(dPerSecondMax255),
+			},
+		},
+		255,
+		t,
+	)
+}
+
 func TestPerSecondMulti(t *testing.T) {
 	testPerSecond(
 		"multiple-series",
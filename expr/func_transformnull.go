@@ -53,6 +53,7 @@ func (s *FuncTransformNull) Exec(cache map[Req][]models.Series) ([]models.Series
 			Datapoints:   pointSlicePool.Get().([]schema.Point),
 			Interval:     serie.Interval,
 			Consolidator: serie.Consolidator,
+			XFilesFactor: serie.XFilesFactor,
 			QueryCons:    serie.QueryCons,
 		}
 		for _, p := range serie.Datapoints {
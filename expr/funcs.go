@@ -55,6 +55,8 @@ func init() {
 		"divideSeries":   {NewDivideSeries, true},
 		"max":            {NewMaxSeries, true},
 		"maxSeries":      {NewMaxSeries, true},
+		"min":            {NewMinSeries, true},
+		"minSeries":      {NewMinSeries, true},
 		"movingAverage":  {NewMovingAverage, false},
 		"perSecond":      {NewPerSecond, true},
 		"scale":          {NewScale, true},
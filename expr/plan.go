@@ -96,6 +96,7 @@ func newplan(e *expr, context Context, stable bool, reqs []Req) (GraphiteFunc, [
 	}
 	if e.etype == etName {
 		req := NewReq(e.str, context.from, context.to, context.consol)
+		e.req = req
 		reqs = append(reqs, req)
 		return NewGet(req), reqs, nil
 	}
@@ -201,6 +202,14 @@ func newplanFunc(e *expr, fn GraphiteFunc, context Context, stable bool, reqs []
 			return reqs, err
 		}
 	}
+	if p, ok := fn.(pushable); ok {
+		agg := p.pushDown(len(e.args))
+		for _, arg := range e.args {
+			if arg.etype == etName {
+				arg.pushDown = agg
+			}
+		}
+	}
 	return reqs, err
 }
 
@@ -223,7 +232,7 @@ func (p Plan) Run(input map[Req][]models.Series) ([]models.Series, error) {
 			if o.Consolidator == 0 {
 				o.Consolidator = consolidation.Avg
 			}
-			out[i].Datapoints, out[i].Interval = consolidation.ConsolidateStable(o.Datapoints, o.Interval, p.MaxDataPoints, o.Consolidator)
+			out[i].Datapoints, out[i].Interval = consolidation.ConsolidateStable(o.Datapoints, o.Interval, p.MaxDataPoints, o.Consolidator, o.XFilesFactor)
 		}
 	}
 	return out, nil
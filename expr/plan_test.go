@@ -230,10 +230,10 @@ func TestConsolidateBy(t *testing.T) {
 			},
 		},
 		{
-			// perSecond changes data semantics, fetch consolidation should be reset to default
+			// perSecond changes data semantics, fetch consolidation should be the increase of counters
 			`consolidateBy(perSecond(a), "sum")`,
 			[]Req{
-				NewReq("a", from, to, 0),
+				NewReq("a", from, to, consolidation.Inc),
 			},
 			nil,
 			[]models.Series{
@@ -293,6 +293,10 @@ func TestConsolidateBy(t *testing.T) {
 				QueryPatt:    "a",
 				Consolidator: consolidation.Sum,
 			}},
+			NewReq("a", from, to, consolidation.Inc): {{
+				QueryPatt:    "a",
+				Consolidator: consolidation.Avg, // emulate the fact that a is not a counter, so it will use avg
+			}},
 			NewReq("b", from, to, consolidation.Max): {{
 				QueryPatt:    "b",
 				Consolidator: consolidation.Max,
@@ -0,0 +1,55 @@
+package expr
+
+// pointwise is implemented by functions whose output points only depend on the input points of the same timestamp,
+// such that their output for a time range is their output for the first part of the range followed by the rest.
+// see Plan.Pointwise
+type pointwise interface {
+	pointwise()
+}
+
+func (s *FuncAlias) pointwise()         {}
+func (s *FuncAliasByNode) pointwise()   {}
+func (s *FuncAliasSub) pointwise()      {}
+func (s *FuncAvgSeries) pointwise()     {}
+func (s *FuncConsolidateBy) pointwise() {}
+func (s *FuncDivideSeries) pointwise()  {}
+func (s *FuncMaxSeries) pointwise()     {}
+func (s *FuncMinSeries) pointwise()     {}
+func (s *FuncScale) pointwise()         {}
+func (s *FuncSumSeries) pointwise()     {}
+func (s *FuncTransformNull) pointwise() {}
+
+// Pointwise returns whether all functions of the plan are pointwise, such that the output of the plan for a time range
+// can be computed by pieces. Note that the pieces only line up if their series have the same interval.
+func (p Plan) Pointwise() bool {
+	var walk func(e *expr) bool
+	walk = func(e *expr) bool {
+		if e.etype != etFunc {
+			return true
+		}
+		fdef, ok := funcs[e.str]
+		if !ok {
+			return false
+		}
+		if _, ok := fdef.constr().(pointwise); !ok {
+			return false
+		}
+		for _, arg := range e.args {
+			if !walk(arg) {
+				return false
+			}
+		}
+		for _, arg := range e.namedArgs {
+			if !walk(arg) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, e := range p.exprs {
+		if !walk(e) {
+			return false
+		}
+	}
+	return true
+}
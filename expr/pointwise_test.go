@@ -0,0 +1,30 @@
+package expr
+
+import "testing"
+
+func TestPointwise(t *testing.T) {
+	cases := []struct {
+		targets []string
+		exp     bool
+	}{
+		{[]string{"foo.*"}, true},
+		{[]string{"sumSeries(foo.*)", "alias(scale(bar.*, 2), 'bar')"}, true},
+		{[]string{"divideSeries(foo.a, transformNull(bar.b, 0))"}, true},
+		{[]string{"consolidateBy(foo.*, 'max')"}, true},
+		{[]string{"foo.*", "perSecond(bar.*)"}, false},
+		{[]string{"sumSeries(movingAverage(foo.*, 10))"}, false},
+	}
+	for i, c := range cases {
+		exprs, err := ParseMany(c.targets)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		plan, err := NewPlan(exprs, 1000, 2000, 800, false, nil)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if got := plan.Pointwise(); got != c.exp {
+			t.Fatalf("case %d %v: expected %t, got %t", i, c.targets, c.exp, got)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package expr
+
+import (
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/consolidation"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// pushable is implemented by functions that aggregate all their input series into one,
+// with an aggregation that can be computed partially by the peers that have the series,
+// and combined afterwards. see Aggregate and Combine
+type pushable interface {
+	// pushDown returns the aggregation to push down to the series of each of the given number of args,
+	// or 0 if the function can't be computed from the aggregates of its args.
+	pushDown(numArgs int) consolidation.Consolidator
+}
+
+func (s *FuncSumSeries) pushDown(numArgs int) consolidation.Consolidator {
+	return consolidation.Sum
+}
+
+func (s *FuncMaxSeries) pushDown(numArgs int) consolidation.Consolidator {
+	return consolidation.Max
+}
+
+func (s *FuncMinSeries) pushDown(numArgs int) consolidation.Consolidator {
+	return consolidation.Min
+}
+
+// the average of the averages of several args is not the average of all series
+func (s *FuncAvgSeries) pushDown(numArgs int) consolidation.Consolidator {
+	if numArgs != 1 {
+		return 0
+	}
+	return consolidation.Avg
+}
+
+// PushDown returns the requests whose series are only consumed by functions that aggregate them,
+// along with the aggregation, such that the series of each request may be replaced by partial aggregates.
+func (p Plan) PushDown() map[Req]consolidation.Consolidator {
+	aggs := make(map[Req]consolidation.Consolidator)
+	var walk func(e *expr)
+	walk = func(e *expr) {
+		if e.etype == etName {
+			// a request that is used several times can only be pushed down if each use has the same aggregation
+			if agg, ok := aggs[e.req]; ok && agg != e.pushDown {
+				aggs[e.req] = 0
+				return
+			}
+			aggs[e.req] = e.pushDown
+			return
+		}
+		for _, arg := range e.args {
+			walk(arg)
+		}
+		for _, arg := range e.namedArgs {
+			walk(arg)
+		}
+	}
+	for _, e := range p.exprs {
+		walk(e)
+	}
+	for req, agg := range aggs {
+		if agg == 0 {
+			delete(aggs, req)
+		}
+	}
+	return aggs
+}
+
+// Aggregate computes the partial aggregate of the given series, which all belong to the same request.
+func Aggregate(agg consolidation.Consolidator, series []models.Series) models.PartialSeries {
+	cons, queryCons := summarizeCons(series)
+	partial := models.PartialSeries{
+		Aggregate: agg,
+		Series: models.Series{
+			Target:       series[0].QueryPatt,
+			QueryPatt:    series[0].QueryPatt,
+			QueryFrom:    series[0].QueryFrom,
+			QueryTo:      series[0].QueryTo,
+			QueryCons:    queryCons,
+			Consolidator: cons,
+			Interval:     series[0].Interval,
+		},
+	}
+	out := pointSlicePool.Get().([]schema.Point)
+	if agg == consolidation.Avg {
+		partial.Counts = make([]uint32, 0, len(series[0].Datapoints))
+	}
+	for i := 0; i < len(series[0].Datapoints); i++ {
+		var a aggregator
+		for j := 0; j < len(series); j++ {
+			a.add(agg, series[j].Datapoints[i].Val, 1)
+		}
+		if agg == consolidation.Avg {
+			partial.Counts = append(partial.Counts, a.num)
+		}
+		out = append(out, schema.Point{Ts: series[0].Datapoints[i].Ts, Val: a.val(agg, false)})
+	}
+	partial.Series.Datapoints = out
+	return partial
+}
+
+// Combine combines the partial aggregates of a request, as returned by Aggregate, with the remaining series of the request.
+// the result can be processed by the function that aggregates the series just like the original series,
+// with identical results. the series returned may only be consumed by that function.
+func Combine(agg consolidation.Consolidator, series []models.Series, partials []models.PartialSeries) []models.Series {
+	if len(partials) == 0 {
+		return series
+	}
+	in := make([]models.Series, 0, len(series)+len(partials))
+	in = append(in, series...)
+	for _, p := range partials {
+		in = append(in, p.Series)
+	}
+	cons, queryCons := summarizeCons(in)
+	out := pointSlicePool.Get().([]schema.Point)
+	for i := 0; i < len(in[0].Datapoints); i++ {
+		var a aggregator
+		for _, serie := range series {
+			a.add(agg, serie.Datapoints[i].Val, 1)
+		}
+		for _, p := range partials {
+			var num uint32 = 1
+			if agg == consolidation.Avg {
+				num = p.Counts[i]
+			}
+			a.add(agg, p.Series.Datapoints[i].Val, num)
+		}
+		out = append(out, schema.Point{Ts: in[0].Datapoints[i].Ts, Val: a.val(agg, true)})
+	}
+	// like the output of the functions, which have no meaningful query range, and don't consolidate by xFilesFactor
+	return []models.Series{
+		{
+			Target:       in[0].QueryPatt,
+			QueryPatt:    in[0].QueryPatt,
+			Datapoints:   out,
+			Interval:     in[0].Interval,
+			Consolidator: cons,
+			QueryCons:    queryCons,
+		},
+	}
+}
+
+// aggregator aggregates the values of one point across series, with the semantics of the functions that aggregate series.
+// NaN values are ignored, and the aggregate is NaN if all values are.
+type aggregator struct {
+	sum float64 // for Sum and Avg; the current value for Min and Max
+	num uint32  // number of non-NaN values, or the number they account for, for Avg
+}
+
+func (a *aggregator) add(agg consolidation.Consolidator, v float64, num uint32) {
+	if math.IsNaN(v) || num == 0 {
+		return
+	}
+	switch agg {
+	case consolidation.Sum, consolidation.Avg:
+		a.sum += v
+	case consolidation.Max:
+		// like maxSeries, the max of non-NaN values is at least 0
+		a.sum = math.Max(a.sum, v)
+	case consolidation.Min:
+		if a.num == 0 || v < a.sum {
+			a.sum = v
+		}
+	}
+	a.num += num
+}
+
+// val returns the aggregate. if final, the average is returned for Avg, rather than the sum.
+func (a *aggregator) val(agg consolidation.Consolidator, final bool) float64 {
+	if a.num == 0 {
+		return math.NaN()
+	}
+	if agg == consolidation.Avg && final {
+		return a.sum / float64(a.num)
+	}
+	return a.sum
+}
@@ -0,0 +1,167 @@
+package expr
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/consolidation"
+	"gopkg.in/raintank/schema.v1"
+)
+
+var negative = []schema.Point{
+	{Val: -1, Ts: 10},
+	{Val: -2, Ts: 20},
+	{Val: -3, Ts: 30},
+	{Val: math.NaN(), Ts: 40},
+	{Val: -5, Ts: 50},
+	{Val: -6, Ts: 60},
+}
+
+func TestPushDown(t *testing.T) {
+	from := uint32(1000)
+	to := uint32(2000)
+	foo := NewReq("foo.*", from, to, 0)
+	bar := NewReq("bar.*", from, to, 0)
+	cases := []struct {
+		targets []string
+		exp     map[Req]consolidation.Consolidator
+	}{
+		{[]string{"sumSeries(foo.*)"}, map[Req]consolidation.Consolidator{foo: consolidation.Sum}},
+		{[]string{"sum(foo.*, bar.*)"}, map[Req]consolidation.Consolidator{foo: consolidation.Sum, bar: consolidation.Sum}},
+		{[]string{"maxSeries(foo.*)", "minSeries(bar.*)"}, map[Req]consolidation.Consolidator{foo: consolidation.Max, bar: consolidation.Min}},
+		{[]string{"averageSeries(foo.*)"}, map[Req]consolidation.Consolidator{foo: consolidation.Avg}},
+		// the average of the averages of several args is not the average of all series
+		{[]string{"averageSeries(foo.*, bar.*)"}, map[Req]consolidation.Consolidator{}},
+		// the series are also used as is, or by another aggregation
+		{[]string{"sumSeries(foo.*)", "foo.*"}, map[Req]consolidation.Consolidator{}},
+		{[]string{"sumSeries(foo.*)", "maxSeries(foo.*)"}, map[Req]consolidation.Consolidator{}},
+		{[]string{"sumSeries(foo.*)", "sum(foo.*)"}, map[Req]consolidation.Consolidator{foo: consolidation.Sum}},
+		// the series are transformed before the aggregation
+		{[]string{"sumSeries(perSecond(foo.*))"}, map[Req]consolidation.Consolidator{}},
+		{[]string{"alias(sumSeries(foo.*), 'foo')"}, map[Req]consolidation.Consolidator{foo: consolidation.Sum}},
+	}
+	for i, c := range cases {
+		exprs, err := ParseMany(c.targets)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		plan, err := NewPlan(exprs, from, to, 800, true, nil)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if got := plan.PushDown(); !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("case %d %v: expected %v, got %v", i, c.targets, c.exp, got)
+		}
+	}
+}
+
+// the functions must return the same output for the partial aggregates and remaining series, as for all series
+func TestAggregateCombine(t *testing.T) {
+	serie := func(target string, points []schema.Point) models.Series {
+		return models.Series{
+			Target:       target,
+			QueryPatt:    "foo.*",
+			QueryFrom:    1000,
+			QueryTo:      2000,
+			Datapoints:   getCopy(points),
+			Interval:     10,
+			Consolidator: consolidation.Max,
+			XFilesFactor: 0.5,
+		}
+	}
+	foo := NewReq("foo.*", 1000, 2000, 0)
+	targets := []string{"sumSeries(foo.*)", "maxSeries(foo.*)", "minSeries(foo.*)", "averageSeries(foo.*)"}
+	splits := []struct {
+		name     string
+		partials [][]models.Series
+		series   []models.Series
+	}{
+		{
+			"single partial",
+			[][]models.Series{{serie("foo.a", a), serie("foo.c", c)}},
+			nil,
+		},
+		{
+			"partial and series",
+			[][]models.Series{{serie("foo.a", a), serie("foo.c", c)}},
+			[]models.Series{serie("foo.d", d), serie("foo.neg", negative)},
+		},
+		{
+			"partials",
+			[][]models.Series{{serie("foo.a", a), serie("foo.c", c)}, {serie("foo.d", d), serie("foo.neg", negative)}},
+			nil,
+		},
+		{
+			"negative partial",
+			[][]models.Series{{serie("foo.neg", negative), serie("foo.neg2", negative)}},
+			[]models.Series{serie("foo.c", c)},
+		},
+	}
+	for _, target := range targets {
+		for _, split := range splits {
+			all := append([]models.Series{}, split.series...)
+			for _, p := range split.partials {
+				all = append(all, p...)
+			}
+			exp := execTarget(t, target, map[Req][]models.Series{foo: all})
+
+			exprs, _ := ParseMany([]string{target})
+			plan, _ := NewPlan(exprs, 1000, 2000, 800, true, nil)
+			agg := plan.PushDown()[foo]
+			var partials []models.PartialSeries
+			for _, p := range split.partials {
+				partials = append(partials, Aggregate(agg, p))
+			}
+			got := execTarget(t, target, map[Req][]models.Series{foo: Combine(agg, split.series, partials)})
+			if !equalSeries(exp, got) {
+				t.Fatalf("%s with %s: expected %+v, got %+v", target, split.name, exp, got)
+			}
+		}
+	}
+}
+
+func execTarget(t *testing.T, target string, data map[Req][]models.Series) []models.Series {
+	in := make(map[Req][]models.Series)
+	for req, series := range data {
+		for _, s := range series {
+			s.Datapoints = getCopy(s.Datapoints)
+			in[req] = append(in[req], s)
+		}
+	}
+	exprs, err := ParseMany([]string{target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := NewPlan(exprs, 1000, 2000, 800, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := plan.Run(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func equalSeries(exp, got []models.Series) bool {
+	if len(exp) != len(got) {
+		return false
+	}
+	for i := range exp {
+		e, g := exp[i], got[i]
+		e.Datapoints, g.Datapoints = nil, nil
+		if !reflect.DeepEqual(e, g) || len(exp[i].Datapoints) != len(got[i].Datapoints) {
+			return false
+		}
+		for j, p := range exp[i].Datapoints {
+			q := got[i].Datapoints[j]
+			bothNaN := math.IsNaN(p.Val) && math.IsNaN(q.Val)
+			if p.Ts != q.Ts || (!bothNaN && p.Val != q.Val) {
+				return false
+			}
+		}
+	}
+	return true
+}
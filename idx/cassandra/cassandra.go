@@ -34,6 +34,33 @@ const TableSchema = `CREATE TABLE IF NOT EXISTS %s.metric_idx (
 ) WITH compaction = {'class': 'SizeTieredCompactionStrategy'}
     AND compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}`
 
+// UpdatesTableSchema is the table that tracks the ids of the defs saved to metric_idx,
+// by partition and bucket of their lastupdate. see trackBucketSize
+const UpdatesTableSchema = `CREATE TABLE IF NOT EXISTS %s.metric_idx_updates (
+    partition int,
+    bucket int,
+    id text,
+    PRIMARY KEY ((partition, bucket), id)
+) WITH compaction = {'class': 'org.apache.cassandra.db.compaction.TimeWindowCompactionStrategy'}
+    AND compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}`
+
+// DeletedTableSchema is the table that tracks the ids of the defs deleted from metric_idx,
+// by partition and bucket of the time of deletion. see trackBucketSize
+const DeletedTableSchema = `CREATE TABLE IF NOT EXISTS %s.metric_idx_deleted (
+    partition int,
+    bucket int,
+    id text,
+    PRIMARY KEY ((partition, bucket), id)
+) WITH compaction = {'class': 'org.apache.cassandra.db.compaction.TimeWindowCompactionStrategy'}
+    AND compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}`
+
+// the updates and deletes of defs are tracked in buckets of this many seconds, such that restoring
+// a snapshot only needs to read the buckets since the snapshot was taken, rather than scanning metric_idx.
+const trackBucketSize = 3600
+
+// max number of ids per query when loading defs by id
+const loadIdsBatchSize = 500
+
 var (
 	// metric idx.cassadra.query-insert.ok is how many insert queries for a metric completed successfully (triggered by an add or an update)
 	statQueryInsertOk = stats.NewCounter32("idx.cassandra.query-insert.ok")
@@ -61,7 +88,11 @@ var (
 	statDeleteDuration = stats.NewLatencyHistogram15s32("idx.cassandra.delete")
 	// metric idx.cassandra.save.skipped is how many saves have been skipped due to the writeQueue being full
 	statSaveSkipped = stats.NewCounter32("idx.cassandra.save.skipped")
-	errmetrics      = cassandra.NewErrMetrics("idx.cassandra")
+	// metric idx.cassandra.snapshot.save is the duration of saving a snapshot of the index to disk
+	statSnapshotSaveDuration = stats.NewLatencyHistogram15s32("idx.cassandra.snapshot.save")
+	// metric idx.cassandra.snapshot.restore is the duration of restoring the index from a snapshot, including catching up on defs from cassandra
+	statSnapshotRestoreDuration = stats.NewLatencyHistogram15s32("idx.cassandra.snapshot.restore")
+	errmetrics                  = cassandra.NewErrMetrics("idx.cassandra")
 
 	Enabled          bool
 	ssl              bool
@@ -83,6 +114,9 @@ var (
 	updateCassIdx    bool
 	updateInterval   time.Duration
 	updateInterval32 uint32
+	snapshotFile     string
+	snapshotInterval time.Duration
+	snapshotMaxAge   time.Duration
 )
 
 func ConfigSetup() *flag.FlagSet {
@@ -101,6 +135,9 @@ func ConfigSetup() *flag.FlagSet {
 	casIdx.DurationVar(&pruneInterval, "prune-interval", time.Hour*3, "Interval at which the index should be checked for stale series.")
 	casIdx.IntVar(&protoVer, "protocol-version", 4, "cql protocol version to use")
 	casIdx.BoolVar(&createKeyspace, "create-keyspace", true, "enable the creation of the index keyspace and tables, only one node needs this")
+	casIdx.StringVar(&snapshotFile, "snapshot-file", "", "path of a local file to periodically save a snapshot of the index to. on startup, the index is restored from it and only defs updated since are loaded from cassandra. (empty disables snapshots)")
+	casIdx.DurationVar(&snapshotInterval, "snapshot-interval", time.Minute*10, "frequency at which to save the index snapshot")
+	casIdx.DurationVar(&snapshotMaxAge, "snapshot-max-age", time.Hour*24, "on startup, ignore snapshots older than this and load all defs from cassandra")
 
 	casIdx.BoolVar(&ssl, "ssl", false, "enable SSL connection to cassandra")
 	casIdx.StringVar(&capath, "ca-path", "/etc/metrictank/ca.pem", "cassandra CA certficate path when using SSL")
@@ -127,6 +164,10 @@ type CasIdx struct {
 	writeQueue chan writeReq
 	shutdown   chan struct{}
 	wg         sync.WaitGroup
+
+	snapshotSave sync.Mutex // serializes saving of snapshots
+	snapshotLock sync.Mutex // protects snapshotGen and replacing/removing the snapshot
+	snapshotGen  uint64     // incremented whenever the snapshot is invalidated
 }
 
 func New() *CasIdx {
@@ -176,10 +217,12 @@ func (c *CasIdx) InitBare() error {
 			log.Error(3, "cassandra-idx failed to initialize cassandra keyspace. %s", err)
 			return err
 		}
-		err = tmpSession.Query(fmt.Sprintf(TableSchema, keyspace)).Exec()
-		if err != nil {
-			log.Error(3, "cassandra-idx failed to initialize cassandra table. %s", err)
-			return err
+		for _, table := range []string{TableSchema, UpdatesTableSchema, DeletedTableSchema} {
+			err = tmpSession.Query(fmt.Sprintf(table, keyspace)).Exec()
+			if err != nil {
+				log.Error(3, "cassandra-idx failed to initialize cassandra table. %s", err)
+				return err
+			}
 		}
 	} else {
 		var keyspaceMetadata *gocql.KeyspaceMetadata
@@ -192,7 +235,7 @@ func (c *CasIdx) InitBare() error {
 				}
 				time.Sleep(5 * time.Second)
 			} else {
-				if _, ok := keyspaceMetadata.Tables["metric_idx"]; ok {
+				if hasTables(keyspaceMetadata, "metric_idx", "metric_idx_updates", "metric_idx_deleted") {
 					break
 				} else {
 					log.Warn("cassandra-idx cassandra table not found. retry attempt: %v", attempt)
@@ -219,6 +262,15 @@ func (c *CasIdx) InitBare() error {
 	return nil
 }
 
+func hasTables(keyspace *gocql.KeyspaceMetadata, tables ...string) bool {
+	for _, table := range tables {
+		if _, ok := keyspace.Tables[table]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // Init makes sure the needed keyspace, table, index in cassandra exists, creates the session,
 // rebuilds the in-memory index, sets up write queues, metrics and pruning routines
 func (c *CasIdx) Init() error {
@@ -240,7 +292,17 @@ func (c *CasIdx) Init() error {
 	}
 
 	//Rebuild the in-memory index.
-	c.rebuildIndex()
+	if !c.restoreSnapshot() {
+		c.rebuildIndex()
+	}
+
+	if snapshotFile != "" {
+		if snapshotInterval == 0 {
+			return fmt.Errorf("snapshotInterval must be greater then 0")
+		}
+		c.wg.Add(1)
+		go c.saveSnapshots()
+	}
 
 	if maxStale > 0 {
 		if pruneInterval == 0 {
@@ -259,7 +321,13 @@ func (c *CasIdx) Stop() {
 	if updateCassIdx {
 		close(c.writeQueue)
 	}
+	close(c.shutdown)
 	c.wg.Wait()
+	if snapshotFile != "" {
+		if err := c.saveSnapshot(); err != nil {
+			log.Error(3, "cassandra-idx: failed to save index snapshot. %s", err)
+		}
+	}
 	c.session.Close()
 }
 
@@ -351,6 +419,52 @@ func (c *CasIdx) LoadPartition(partition int32, defs []schema.MetricDefinition)
 	return c.load(defs, iter)
 }
 
+// LoadPartitionIds loads the defs of the partition with the given ids, if they exist
+func (c *CasIdx) LoadPartitionIds(partition int32, ids []string, defs []schema.MetricDefinition) []schema.MetricDefinition {
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > loadIdsBatchSize {
+			batch = batch[:loadIdsBatchSize]
+		}
+		ids = ids[len(batch):]
+		iter := c.session.Query("SELECT id, orgid, partition, name, metric, interval, unit, mtype, tags, lastupdate from metric_idx where partition=? AND id IN ?", partition, batch).Iter()
+		defs = c.load(defs, iter)
+	}
+	return defs
+}
+
+// loadTracked returns the ids tracked in the given table (metric_idx_updates or metric_idx_deleted)
+// for the partition, in the buckets from from to to
+func (c *CasIdx) loadTracked(table string, partition int32, from, to int64) map[string]struct{} {
+	ids := make(map[string]struct{})
+	for _, bucket := range trackBuckets(from, to) {
+		iter := c.session.Query(fmt.Sprintf("SELECT id from %s where partition=? AND bucket=?", table), partition, bucket).Iter()
+		var id string
+		for iter.Scan(&id) {
+			ids[id] = struct{}{}
+		}
+		if err := iter.Close(); err != nil {
+			log.Fatal(4, "Could not close iterator: %s", err.Error())
+		}
+	}
+	return ids
+}
+
+// trackBuckets returns the buckets that cover the time range from from to to
+func trackBuckets(from, to int64) []int64 {
+	var buckets []int64
+	for bucket := from - from%trackBucketSize; bucket <= to; bucket += trackBucketSize {
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
+
+// trackTTL is the ttl of the rows of metric_idx_updates and metric_idx_deleted, in seconds.
+// they are only needed to catch up after restoring a snapshot, which is at most snapshotMaxAge old.
+func trackTTL() int {
+	return int((snapshotMaxAge+snapshotCatchUpSlack)/time.Second) + trackBucketSize
+}
+
 func (c *CasIdx) load(defs []schema.MetricDefinition, iter *gocql.Iter) []schema.MetricDefinition {
 	mdef := schema.MetricDefinition{}
 	var id, name, metric, unit, mtype string
@@ -383,6 +497,7 @@ func (c *CasIdx) processWriteQueue() {
 	var err error
 	var req writeReq
 	qry := `INSERT INTO metric_idx (id, orgid, partition, name, metric, interval, unit, mtype, tags, lastupdate) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	trackQry := `INSERT INTO metric_idx_updates (partition, bucket, id) VALUES (?, ?, ?) USING TTL ?`
 	for req = range c.writeQueue {
 		if err != nil {
 			log.Error(3, "Failed to marshal metricDef. %s", err)
@@ -394,7 +509,7 @@ func (c *CasIdx) processWriteQueue() {
 		attempts = 0
 
 		for !success {
-			if err := c.session.Query(
+			err := c.session.Query(
 				qry,
 				req.def.Id,
 				req.def.OrgId,
@@ -405,7 +520,12 @@ func (c *CasIdx) processWriteQueue() {
 				req.def.Unit,
 				req.def.Mtype,
 				req.def.Tags,
-				req.def.LastUpdate).Exec(); err != nil {
+				req.def.LastUpdate).Exec()
+			if err == nil {
+				bucket := req.def.LastUpdate - req.def.LastUpdate%trackBucketSize
+				err = c.session.Query(trackQry, req.def.Partition, bucket, req.def.Id, trackTTL()).Exec()
+			}
+			if err != nil {
 
 				statQueryInsertFail.Inc()
 				errmetrics.Inc(err)
@@ -436,6 +556,9 @@ func (c *CasIdx) Delete(orgId int, pattern string) ([]idx.Archive, error) {
 	if err != nil {
 		return defs, err
 	}
+	if len(defs) > 0 {
+		c.invalidateSnapshot()
+	}
 	if updateCassIdx {
 		for _, def := range defs {
 			err = c.deleteDef(&def)
@@ -448,12 +571,36 @@ func (c *CasIdx) Delete(orgId int, pattern string) ([]idx.Archive, error) {
 	return defs, err
 }
 
+func (c *CasIdx) DeleteIds(orgId int, ids []string) []idx.Archive {
+	pre := time.Now()
+	defs := c.MemoryIdx.DeleteIds(orgId, ids)
+	if len(defs) > 0 {
+		c.invalidateSnapshot()
+	}
+	if updateCassIdx {
+		for _, def := range defs {
+			err := c.deleteDef(&def)
+			if err != nil {
+				log.Error(3, "cassandra-idx: %s", err.Error())
+			}
+		}
+	}
+	statDeleteDuration.Value(time.Since(pre))
+	return defs
+}
+
+// deleteDef deletes the def from metric_idx, and records the deletion in metric_idx_deleted,
+// for instances restoring a snapshot that holds the def.
 func (c *CasIdx) deleteDef(def *idx.Archive) error {
 	pre := time.Now()
+	bucket := pre.Unix() - pre.Unix()%trackBucketSize
 	attempts := 0
 	for attempts < 5 {
 		attempts++
-		err := c.session.Query("DELETE FROM metric_idx where partition=? AND id=?", def.Partition, def.Id).Exec()
+		err := c.session.Query("INSERT INTO metric_idx_deleted (partition, bucket, id) VALUES (?, ?, ?) USING TTL ?", def.Partition, bucket, def.Id, trackTTL()).Exec()
+		if err == nil {
+			err = c.session.Query("DELETE FROM metric_idx where partition=? AND id=?", def.Partition, def.Id).Exec()
+		}
 		if err != nil {
 			statQueryDeleteFail.Inc()
 			errmetrics.Inc(err)
@@ -471,6 +618,9 @@ func (c *CasIdx) deleteDef(def *idx.Archive) error {
 func (c *CasIdx) Prune(orgId int, oldest time.Time) ([]idx.Archive, error) {
 	pre := time.Now()
 	pruned, err := c.MemoryIdx.Prune(orgId, oldest)
+	if len(pruned) > 0 {
+		c.invalidateSnapshot()
+	}
 	if updateCassIdx {
 		// if an error was encountered then pruned is probably a partial list of metricDefs
 		// deleted, so lets still try and delete these from Cassandra.
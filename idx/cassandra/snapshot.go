@@ -0,0 +1,217 @@
+package cassandra
+
+import (
+	"os"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/raintank/worldping-api/pkg/log"
+	"github.com/tinylib/msgp/msgp"
+	"gopkg.in/raintank/schema.v1"
+)
+
+//go:generate msgp
+
+// snapshotVersion is the version of the snapshot format. snapshots of other versions are ignored
+const snapshotVersion = 1
+
+// defs are saved to cassandra with the timestamp of the point that triggered the save,
+// which may lag behind the wall clock. when catching up after restoring a snapshot, we also
+// load defs with a lastupdate up to this much before the snapshot was taken.
+const snapshotCatchUpSlack = time.Hour
+
+// Snapshot is a point in time copy of the index, saved to local disk
+// so that on startup we don't have to load all defs from cassandra.
+type Snapshot struct {
+	Version    int
+	Time       int64   // unix timestamp of when the snapshot was taken
+	Partitions []int32 // the partitions whose defs are in the snapshot
+	Defs       []schema.MetricDefinition
+}
+
+func readSnapshot(path string) (Snapshot, error) {
+	var snap Snapshot
+	f, err := os.Open(path)
+	if err != nil {
+		return snap, err
+	}
+	defer f.Close()
+	err = snap.DecodeMsg(msgp.NewReader(f))
+	return snap, err
+}
+
+func writeSnapshot(path string, snap *Snapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := msgp.NewWriter(f)
+	err = snap.EncodeMsg(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// saveSnapshots periodically saves a snapshot of the index, until the index is stopped
+func (c *CasIdx) saveSnapshots() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.shutdown:
+			return
+		case <-ticker.C:
+			if err := c.saveSnapshot(); err != nil {
+				log.Error(3, "cassandra-idx: failed to save index snapshot. %s", err)
+			}
+		}
+	}
+}
+
+// saveSnapshot saves a snapshot of the index to snapshotFile.
+// the snapshot is first written to a temporary file, which then replaces the previous snapshot,
+// unless defs were deleted from the index while the snapshot was being taken.
+func (c *CasIdx) saveSnapshot() error {
+	c.snapshotSave.Lock()
+	defer c.snapshotSave.Unlock()
+
+	pre := time.Now()
+	c.snapshotLock.Lock()
+	gen := c.snapshotGen
+	c.snapshotLock.Unlock()
+
+	archives := c.MemoryIdx.List(-1)
+	snap := Snapshot{
+		Version:    snapshotVersion,
+		Time:       pre.Unix(),
+		Partitions: cluster.Manager.GetPartitions(),
+		Defs:       make([]schema.MetricDefinition, len(archives)),
+	}
+	for i, archive := range archives {
+		snap.Defs[i] = archive.MetricDefinition
+	}
+
+	tmp := snapshotFile + ".tmp"
+	if err := writeSnapshot(tmp, &snap); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	c.snapshotLock.Lock()
+	defer c.snapshotLock.Unlock()
+	if c.snapshotGen != gen {
+		os.Remove(tmp)
+		log.Info("cassandra-idx: discarding index snapshot, defs were deleted while it was taken")
+		return nil
+	}
+	if err := os.Rename(tmp, snapshotFile); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	statSnapshotSaveDuration.Value(time.Since(pre))
+	log.Info("cassandra-idx: saved index snapshot with %d defs to %s. Took %s", len(snap.Defs), snapshotFile, time.Since(pre))
+	return nil
+}
+
+// invalidateSnapshot removes the snapshot, so that deleted defs don't get restored from it.
+// the next periodic snapshot will replace it.
+func (c *CasIdx) invalidateSnapshot() {
+	if snapshotFile == "" {
+		return
+	}
+	c.snapshotLock.Lock()
+	c.snapshotGen++
+	err := os.Remove(snapshotFile)
+	c.snapshotLock.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		log.Error(3, "cassandra-idx: failed to remove index snapshot %s. %s", snapshotFile, err)
+	}
+}
+
+// restoreSnapshot loads the in-memory index from the snapshot, and catches up on the defs
+// that were updated in cassandra since the snapshot was taken, as tracked in metric_idx_updates.
+// defs of the snapshot that were deleted from cassandra since (e.g. by another instance, or by pruning),
+// as tracked in metric_idx_deleted, are not restored.
+// it returns false if there is no usable snapshot, in which case the index must be rebuilt from cassandra.
+func (c *CasIdx) restoreSnapshot() bool {
+	if snapshotFile == "" {
+		return false
+	}
+	pre := time.Now()
+	snap, err := readSnapshot(snapshotFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Info("cassandra-idx: no index snapshot found at %s", snapshotFile)
+		} else {
+			log.Error(3, "cassandra-idx: failed to read index snapshot %s. %s", snapshotFile, err)
+		}
+		return false
+	}
+	if snap.Version != snapshotVersion {
+		log.Warn("cassandra-idx: ignoring index snapshot with unsupported version %d", snap.Version)
+		return false
+	}
+	age := pre.Sub(time.Unix(snap.Time, 0))
+	if age > snapshotMaxAge {
+		log.Info("cassandra-idx: ignoring index snapshot taken %s ago, it is older than %s", age, snapshotMaxAge)
+		return false
+	}
+
+	inSnapshot := make(map[int32]struct{})
+	for _, partition := range snap.Partitions {
+		inSnapshot[partition] = struct{}{}
+	}
+
+	// the defs loaded from cassandra are more recent than the ones in the snapshot, so they go first:
+	// when loading defs, the index ignores those with an id it has already seen.
+	since := snap.Time - int64(snapshotCatchUpSlack/time.Second)
+	var defs []schema.MetricDefinition
+	// the ids deleted since the snapshot was taken, of the partitions that are restored from the snapshot
+	deleted := make(map[int32]map[string]struct{})
+	for _, partition := range cluster.Manager.GetPartitions() {
+		if _, ok := inSnapshot[partition]; ok {
+			updated := c.loadTracked("metric_idx_updates", partition, since, pre.Unix())
+			ids := make([]string, 0, len(updated))
+			for id := range updated {
+				ids = append(ids, id)
+			}
+			defs = c.LoadPartitionIds(partition, ids, defs)
+			deleted[partition] = c.loadTracked("metric_idx_deleted", partition, since, pre.Unix())
+		} else {
+			defs = c.LoadPartition(partition, defs)
+		}
+	}
+	loaded := len(defs)
+	defs, skipped := appendSnapshotDefs(defs, snap.Defs, deleted)
+	num := c.MemoryIdx.Load(defs)
+	statSnapshotRestoreDuration.Value(time.Since(pre))
+	log.Info("cassandra-idx: restored Memory Index from snapshot taken %s ago and %d defs from Cassandra, skipping %d defs deleted since. Imported %d. Took %s", age, loaded, skipped, num, time.Since(pre))
+	return true
+}
+
+// appendSnapshotDefs appends the defs of the snapshot to defs, except for those of partitions
+// that are not restored from the snapshot, and those that were deleted, as given per partition.
+// it returns the defs and the number of deleted defs that were skipped.
+func appendSnapshotDefs(defs, snapDefs []schema.MetricDefinition, deleted map[int32]map[string]struct{}) ([]schema.MetricDefinition, int) {
+	var skipped int
+	for _, def := range snapDefs {
+		ids, ok := deleted[def.Partition]
+		if !ok {
+			continue
+		}
+		if _, ok := ids[def.Id]; ok {
+			skipped++
+			continue
+		}
+		defs = append(defs, def)
+	}
+	return defs, skipped
+}
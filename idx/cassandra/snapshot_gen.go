@@ -0,0 +1,242 @@
+package cassandra
+
+// NOTE: THIS FILE WAS PRODUCED BY THE
+// MSGP CODE GENERATION TOOL (github.com/tinylib/msgp)
+// DO NOT EDIT
+
+import (
+	"github.com/tinylib/msgp/msgp"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// DecodeMsg implements msgp.Decodable
+func (z *Snapshot) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Version":
+			z.Version, err = dc.ReadInt()
+			if err != nil {
+				return
+			}
+		case "Time":
+			z.Time, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		case "Partitions":
+			var zb0002 uint32
+			zb0002, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Partitions) >= int(zb0002) {
+				z.Partitions = (z.Partitions)[:zb0002]
+			} else {
+				z.Partitions = make([]int32, zb0002)
+			}
+			for za0001 := range z.Partitions {
+				z.Partitions[za0001], err = dc.ReadInt32()
+				if err != nil {
+					return
+				}
+			}
+		case "Defs":
+			var zb0003 uint32
+			zb0003, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Defs) >= int(zb0003) {
+				z.Defs = (z.Defs)[:zb0003]
+			} else {
+				z.Defs = make([]schema.MetricDefinition, zb0003)
+			}
+			for za0002 := range z.Defs {
+				err = z.Defs[za0002].DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *Snapshot) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 4
+	// write "Version"
+	err = en.Append(0x84, 0xa7, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.Version)
+	if err != nil {
+		return
+	}
+	// write "Time"
+	err = en.Append(0xa4, 0x54, 0x69, 0x6d, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt64(z.Time)
+	if err != nil {
+		return
+	}
+	// write "Partitions"
+	err = en.Append(0xaa, 0x50, 0x61, 0x72, 0x74, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Partitions)))
+	if err != nil {
+		return
+	}
+	for za0001 := range z.Partitions {
+		err = en.WriteInt32(z.Partitions[za0001])
+		if err != nil {
+			return
+		}
+	}
+	// write "Defs"
+	err = en.Append(0xa4, 0x44, 0x65, 0x66, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Defs)))
+	if err != nil {
+		return
+	}
+	for za0002 := range z.Defs {
+		err = z.Defs[za0002].EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z *Snapshot) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.Require(b, z.Msgsize())
+	// map header, size 4
+	// string "Version"
+	o = append(o, 0x84, 0xa7, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e)
+	o = msgp.AppendInt(o, z.Version)
+	// string "Time"
+	o = append(o, 0xa4, 0x54, 0x69, 0x6d, 0x65)
+	o = msgp.AppendInt64(o, z.Time)
+	// string "Partitions"
+	o = append(o, 0xaa, 0x50, 0x61, 0x72, 0x74, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Partitions)))
+	for za0001 := range z.Partitions {
+		o = msgp.AppendInt32(o, z.Partitions[za0001])
+	}
+	// string "Defs"
+	o = append(o, 0xa4, 0x44, 0x65, 0x66, 0x73)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Defs)))
+	for za0002 := range z.Defs {
+		o, err = z.Defs[za0002].MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *Snapshot) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Version":
+			z.Version, bts, err = msgp.ReadIntBytes(bts)
+			if err != nil {
+				return
+			}
+		case "Time":
+			z.Time, bts, err = msgp.ReadInt64Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Partitions":
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Partitions) >= int(zb0002) {
+				z.Partitions = (z.Partitions)[:zb0002]
+			} else {
+				z.Partitions = make([]int32, zb0002)
+			}
+			for za0001 := range z.Partitions {
+				z.Partitions[za0001], bts, err = msgp.ReadInt32Bytes(bts)
+				if err != nil {
+					return
+				}
+			}
+		case "Defs":
+			var zb0003 uint32
+			zb0003, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Defs) >= int(zb0003) {
+				z.Defs = (z.Defs)[:zb0003]
+			} else {
+				z.Defs = make([]schema.MetricDefinition, zb0003)
+			}
+			for za0002 := range z.Defs {
+				bts, err = z.Defs[za0002].UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			bts, err = msgp.Skip(bts)
+			if err != nil {
+				return
+			}
+		}
+	}
+	o = bts
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *Snapshot) Msgsize() (s int) {
+	s = 1 + 8 + msgp.IntSize + 5 + msgp.Int64Size + 11 + msgp.ArrayHeaderSize + (len(z.Partitions) * (msgp.Int32Size)) + 5 + msgp.ArrayHeaderSize
+	for za0002 := range z.Defs {
+		s += z.Defs[za0002].Msgsize()
+	}
+	return
+}
@@ -0,0 +1,120 @@
+package cassandra
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/idx/memory"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestSnapshotWriteRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrictank-idx-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+
+	if _, err := readSnapshot(path); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error for missing snapshot, got %v", err)
+	}
+
+	snap := Snapshot{
+		Version:    snapshotVersion,
+		Time:       time.Now().Unix(),
+		Partitions: []int32{0, 3},
+		Defs:       getMetricDefs(1, 1, 10, 10, "some.metric"),
+	}
+	if err := writeSnapshot(path, &snap); err != nil {
+		t.Fatalf("failed to write snapshot: %s", err)
+	}
+	read, err := readSnapshot(path)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %s", err)
+	}
+	if !reflect.DeepEqual(snap, read) {
+		t.Fatalf("expected snapshot %v, got %v", snap, read)
+	}
+}
+
+func getMetricDefs(orgId, depth, count, interval int, prefix string) []schema.MetricDefinition {
+	defs := make([]schema.MetricDefinition, count)
+	for i, data := range getMetricData(orgId, depth, count, interval, prefix) {
+		defs[i] = *schema.MetricDefinitionFromMetricData(data)
+		defs[i].Partition = int32(i % 4)
+		defs[i].Tags = []string{"some=tag"}
+	}
+	return defs
+}
+
+func TestSaveSnapshotsStop(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	dir, err := ioutil.TempDir("", "metrictank-idx-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(file string, interval time.Duration) {
+		snapshotFile, snapshotInterval = file, interval
+	}(snapshotFile, snapshotInterval)
+	snapshotFile = filepath.Join(dir, "snapshot")
+	snapshotInterval = time.Millisecond
+
+	ix := &CasIdx{MemoryIdx: *memory.New(), shutdown: make(chan struct{})}
+	ix.wg.Add(1)
+	go ix.saveSnapshots()
+	for {
+		if _, err := os.Stat(snapshotFile); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		close(ix.shutdown)
+		ix.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the snapshot saver to stop")
+	}
+}
+
+func TestTrackBuckets(t *testing.T) {
+	got := trackBuckets(3*trackBucketSize+10, 5*trackBucketSize)
+	exp := []int64{3 * trackBucketSize, 4 * trackBucketSize, 5 * trackBucketSize}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected buckets %v, got %v", exp, got)
+	}
+	if got := trackBuckets(10, 20); !reflect.DeepEqual(got, []int64{0}) {
+		t.Fatalf("expected bucket 0, got %v", got)
+	}
+}
+
+func TestAppendSnapshotDefs(t *testing.T) {
+	snapDefs := getMetricDefs(1, 1, 8, 10, "some.metric")
+	loaded := []schema.MetricDefinition{snapDefs[0]}
+	// partition 2 is not restored from the snapshot, and a def of partition 1 was deleted
+	deleted := map[int32]map[string]struct{}{
+		0: {},
+		1: {snapDefs[5].Id: {}},
+		3: {},
+	}
+	defs, skipped := appendSnapshotDefs(loaded, snapDefs, deleted)
+	if skipped != 1 {
+		t.Fatalf("expected 1 deleted def to be skipped, got %d", skipped)
+	}
+	exp := []schema.MetricDefinition{snapDefs[0], snapDefs[0], snapDefs[1], snapDefs[3], snapDefs[4], snapDefs[7]}
+	if !reflect.DeepEqual(defs, exp) {
+		t.Fatalf("expected defs %v, got %v", exp, defs)
+	}
+}
@@ -95,6 +95,11 @@ Interface
   "*", all items in the index should be deleted.  A copy of all of the
   metricDefinitions deleted are returned.
 
+* DeleteIds(int, []string) []Archive:
+  This method deletes the metricDefinitions with the given ids of the passed
+  OrgId from the index, but not the other metricDefinitions with the same name.
+  A copy of all of the metricDefinitions deleted are returned.
+
 * Prune(int, time.Time) ([]Archive, error):
   This method should delete all metrics from the index for the passed org where
   the last time the metric was seen is older then the passed timestamp. If the org
@@ -109,6 +114,7 @@ type MetricIndex interface {
 	Get(string) (Archive, bool)
 	GetPath(int, string) []Archive
 	Delete(int, string) ([]Archive, error)
+	DeleteIds(int, []string) []Archive
 	Find(int, string, int64) ([]Node, error)
 	List(int) []Archive
 	Prune(int, time.Time) ([]Archive, error)
@@ -0,0 +1,150 @@
+package memory
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"github.com/grafana/metrictank/stats"
+)
+
+var (
+	// metric idx.memory.find-cache.hit is the number of find patterns that were served from the find cache
+	statFindCacheHit = stats.NewCounter32("idx.memory.find-cache.hit")
+	// metric idx.memory.find-cache.miss is the number of find patterns that were not found in the find cache
+	statFindCacheMiss = stats.NewCounter32("idx.memory.find-cache.miss")
+	// metric idx.memory.find-cache.invalidate is the number of find cache entries invalidated due to index changes
+	statFindCacheInvalidate = stats.NewCounter32("idx.memory.find-cache.invalidate")
+)
+
+// findCache caches the nodes matching find patterns, per org.
+// the cached nodes are pointers into the tree, so changes to the defs of an existing node are
+// seen by all entries that reference it. entries only have to be invalidated when nodes are
+// added to or removed from the tree.
+type findCache struct {
+	sync.Mutex
+	size int // max number of entries per org. 0 disables the cache
+	orgs map[int]*orgFindCache
+}
+
+type orgFindCache struct {
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+}
+
+type findCacheEntry struct {
+	pattern string
+	branch  string // the path of the node where the search for the pattern starts
+	nodes   []*Node
+}
+
+func newFindCache(size int) *findCache {
+	return &findCache{
+		size: size,
+		orgs: make(map[int]*orgFindCache),
+	}
+}
+
+// Get returns the cached nodes matching the pattern in the given org
+func (c *findCache) Get(orgId int, pattern string) ([]*Node, bool) {
+	if c.size == 0 {
+		return nil, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	org, ok := c.orgs[orgId]
+	if !ok {
+		statFindCacheMiss.Inc()
+		return nil, false
+	}
+	e, ok := org.entries[pattern]
+	if !ok {
+		statFindCacheMiss.Inc()
+		return nil, false
+	}
+	org.lru.MoveToFront(e)
+	statFindCacheHit.Inc()
+	return e.Value.(*findCacheEntry).nodes, true
+}
+
+// Add caches the nodes matching the pattern in the given org,
+// evicting the least recently used entry of the org if it is full.
+func (c *findCache) Add(orgId int, pattern string, nodes []*Node) {
+	if c.size == 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	org, ok := c.orgs[orgId]
+	if !ok {
+		org = &orgFindCache{
+			entries: make(map[string]*list.Element),
+			lru:     list.New(),
+		}
+		c.orgs[orgId] = org
+	}
+	if e, ok := org.entries[pattern]; ok {
+		e.Value.(*findCacheEntry).nodes = nodes
+		org.lru.MoveToFront(e)
+		return
+	}
+	if org.lru.Len() >= c.size {
+		oldest := org.lru.Back()
+		org.lru.Remove(oldest)
+		delete(org.entries, oldest.Value.(*findCacheEntry).pattern)
+	}
+	org.entries[pattern] = org.lru.PushFront(&findCacheEntry{
+		pattern: pattern,
+		branch:  patternBranch(pattern),
+		nodes:   nodes,
+	})
+}
+
+// InvalidatePath removes the entries of the org whose results may change
+// when a node is added or removed at the given path.
+// those are the entries that start their search at the path, or at a branch above or below it.
+func (c *findCache) InvalidatePath(orgId int, path string) {
+	c.Lock()
+	defer c.Unlock()
+	org, ok := c.orgs[orgId]
+	if !ok {
+		return
+	}
+	for pattern, e := range org.entries {
+		branch := e.Value.(*findCacheEntry).branch
+		if isPathPrefix(branch, path) || isPathPrefix(path, branch) {
+			org.lru.Remove(e)
+			delete(org.entries, pattern)
+			statFindCacheInvalidate.Inc()
+		}
+	}
+}
+
+// Purge removes all entries of the org
+func (c *findCache) Purge(orgId int) {
+	c.Lock()
+	defer c.Unlock()
+	org, ok := c.orgs[orgId]
+	if !ok {
+		return
+	}
+	statFindCacheInvalidate.Add(len(org.entries))
+	delete(c.orgs, orgId)
+}
+
+// patternBranch returns the path of the branch where the search for the pattern starts:
+// all nodes of the pattern up to the first one with special chars.
+func patternBranch(pattern string) string {
+	nodes := strings.Split(pattern, ".")
+	for i := 0; i < len(nodes); i++ {
+		if strings.ContainsAny(nodes[i], "*{}[]?") {
+			return strings.Join(nodes[0:i], ".")
+		}
+	}
+	return pattern
+}
+
+// isPathPrefix returns whether the node at path a is, or is an ancestor of, the node at path b.
+func isPathPrefix(a, b string) bool {
+	return a == "" || a == b || strings.HasPrefix(b, a+".")
+}
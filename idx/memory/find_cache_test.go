@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"testing"
+
+	"gopkg.in/raintank/schema.v1"
+)
+
+func addTestMetric(ix *MemoryIdx, orgId int, name string) {
+	data := &schema.MetricData{
+		Name:     name,
+		Metric:   name,
+		OrgId:    orgId,
+		Interval: 10,
+	}
+	data.SetId()
+	ix.AddOrUpdate(data, 0)
+}
+
+func findPaths(t *testing.T, ix *MemoryIdx, orgId int, pattern string) map[string]struct{} {
+	nodes, err := ix.Find(orgId, pattern, 0)
+	if err != nil {
+		t.Fatalf("find %q failed: %s", pattern, err)
+	}
+	paths := make(map[string]struct{})
+	for _, n := range nodes {
+		paths[n.Path] = struct{}{}
+	}
+	return paths
+}
+
+func TestFindCacheInvalidation(t *testing.T) {
+	ix := New()
+	addTestMetric(ix, 1, "foo.a.x")
+	addTestMetric(ix, 1, "foo.b.x")
+	addTestMetric(ix, 1, "bar.a.x")
+
+	expect := func(pattern string, exp ...string) {
+		paths := findPaths(t, ix, 1, pattern)
+		if len(paths) != len(exp) {
+			t.Fatalf("find %q: expected %v, got %v", pattern, exp, paths)
+		}
+		for _, p := range exp {
+			if _, ok := paths[p]; !ok {
+				t.Fatalf("find %q: expected %v, got %v", pattern, exp, paths)
+			}
+		}
+	}
+
+	expect("foo.*.x", "foo.a.x", "foo.b.x")
+	expect("bar.*", "bar.a")
+	hits := statFindCacheHit.Peek()
+	expect("foo.*.x", "foo.a.x", "foo.b.x")
+	if statFindCacheHit.Peek() == hits {
+		t.Fatalf("expected repeated find to be served from the cache")
+	}
+
+	// adding under the searched branch invalidates, adding elsewhere doesn't
+	addTestMetric(ix, 1, "foo.c.x")
+	addTestMetric(ix, 1, "baz.x")
+	expect("foo.*.x", "foo.a.x", "foo.b.x", "foo.c.x")
+	hits = statFindCacheHit.Peek()
+	expect("bar.*", "bar.a")
+	if statFindCacheHit.Peek() == hits {
+		t.Fatalf("expected find under an unchanged branch to be served from the cache")
+	}
+
+	// adding another def to an existing leaf doesn't change the nodes, but their defs are seen
+	data := &schema.MetricData{Name: "foo.a.x", Metric: "foo.a.x", OrgId: 1, Interval: 60}
+	data.SetId()
+	ix.AddOrUpdate(data, 0)
+	nodes, _ := ix.Find(1, "foo.a.x", 0)
+	if len(nodes) != 1 || len(nodes[0].Defs) != 2 {
+		t.Fatalf("expected 1 node with 2 defs, got %v", nodes)
+	}
+
+	// deletes invalidate as well
+	if _, err := ix.Delete(1, "foo.b"); err != nil {
+		t.Fatalf("delete failed: %s", err)
+	}
+	expect("foo.*.x", "foo.a.x", "foo.c.x")
+	if _, err := ix.Delete(1, "foo"); err != nil {
+		t.Fatalf("delete failed: %s", err)
+	}
+	expect("foo.*.x")
+	expect("*", "bar", "baz")
+}
+
+func TestFindCacheSize(t *testing.T) {
+	c := newFindCache(2)
+	c.Add(1, "a.*", nil)
+	c.Add(1, "b.*", nil)
+	c.Get(1, "a.*")
+	c.Add(1, "c.*", nil)
+	if _, ok := c.Get(1, "b.*"); ok {
+		t.Fatalf("expected least recently used entry to be evicted")
+	}
+	for _, p := range []string{"a.*", "c.*"} {
+		if _, ok := c.Get(1, p); !ok {
+			t.Fatalf("expected entry %q to be cached", p)
+		}
+	}
+	c.Add(2, "b.*", nil)
+	if _, ok := c.Get(1, "a.*"); !ok {
+		t.Fatalf("expected entries of other orgs to not count towards the size limit")
+	}
+}
@@ -42,12 +42,14 @@ var (
 	// metric idx.metrics_active is the number of currently known metrics in the index
 	statMetricsActive = stats.NewGauge32("idx.metrics_active")
 
-	Enabled bool
+	Enabled       bool
+	findCacheSize = 1000
 )
 
 func ConfigSetup() {
 	memoryIdx := flag.NewFlagSet("memory-idx", flag.ExitOnError)
 	memoryIdx.BoolVar(&Enabled, "enabled", false, "")
+	memoryIdx.IntVar(&findCacheSize, "find-cache-size", 1000, "number of find patterns to cache per org. the cached results of a pattern are invalidated when series are added or deleted under the branch it searches. (0 disables the cache)")
 	globalconf.Register("memory-idx", memoryIdx)
 }
 
@@ -79,14 +81,16 @@ func (n *Node) String() string {
 // Implements the the "MetricIndex" interface
 type MemoryIdx struct {
 	sync.RWMutex
-	DefById map[string]*idx.Archive
-	Tree    map[int]*Tree
+	DefById   map[string]*idx.Archive
+	Tree      map[int]*Tree
+	findCache *findCache
 }
 
 func New() *MemoryIdx {
 	return &MemoryIdx{
-		DefById: make(map[string]*idx.Archive),
-		Tree:    make(map[int]*Tree),
+		DefById:   make(map[string]*idx.Archive),
+		Tree:      make(map[int]*Tree),
+		findCache: newFindCache(findCacheSize),
 	}
 }
 
@@ -159,7 +163,7 @@ func (m *MemoryIdx) Load(defs []schema.MetricDefinition) int {
 func (m *MemoryIdx) add(def *schema.MetricDefinition) idx.Archive {
 	path := def.Name
 	schemaId, _ := mdata.MatchSchema(def.Name, def.Interval)
-	aggId, _ := mdata.MatchAgg(def.Name)
+	aggId, _ := mdata.MatchAgg(def.Name, def.Mtype)
 	archive := &idx.Archive{
 		MetricDefinition: *def,
 		SchemaId:         schemaId,
@@ -192,6 +196,9 @@ func (m *MemoryIdx) add(def *schema.MetricDefinition) idx.Archive {
 		}
 	}
 
+	// we're adding new nodes to the tree, which may change the results of cached finds
+	m.findCache.InvalidatePath(def.OrgId, path)
+
 	// now walk backwards through the node path to find the first branch which exists that
 	// this path extends.
 	pos := strings.LastIndex(path, ".")
@@ -273,14 +280,17 @@ func (m *MemoryIdx) Find(orgId int, pattern string, from int64) ([]idx.Node, err
 	pre := time.Now()
 	m.RLock()
 	defer m.RUnlock()
-	matchedNodes, err := m.find(orgId, pattern)
+	orgNodes, err := m.findCached(orgId, pattern)
 	if err != nil {
 		return nil, err
 	}
-	publicNodes, err := m.find(-1, pattern)
+	publicNodes, err := m.findCached(-1, pattern)
 	if err != nil {
 		return nil, err
 	}
+	// the found nodes may be shared with the find cache, so we must not append to them
+	matchedNodes := make([]*Node, 0, len(orgNodes)+len(publicNodes))
+	matchedNodes = append(matchedNodes, orgNodes...)
 	matchedNodes = append(matchedNodes, publicNodes...)
 	log.Debug("memory-idx: %d nodes matching pattern %s found", len(matchedNodes), pattern)
 	results := make([]idx.Node, 0)
@@ -321,6 +331,20 @@ func (m *MemoryIdx) Find(orgId int, pattern string, from int64) ([]idx.Node, err
 	return results, nil
 }
 
+// findCached is like find, but serves the results from the find cache when possible.
+// the returned slice must not be modified.
+func (m *MemoryIdx) findCached(orgId int, pattern string) ([]*Node, error) {
+	if nodes, ok := m.findCache.Get(orgId, pattern); ok {
+		return nodes, nil
+	}
+	nodes, err := m.find(orgId, pattern)
+	if err != nil {
+		return nil, err
+	}
+	m.findCache.Add(orgId, pattern, nodes)
+	return nodes, nil
+}
+
 func (m *MemoryIdx) find(orgId int, pattern string) ([]*Node, error) {
 	var results []*Node
 	tree, ok := m.Tree[orgId]
@@ -445,6 +469,7 @@ func (m *MemoryIdx) Delete(orgId int, pattern string) ([]idx.Archive, error) {
 	}
 
 	for _, f := range found {
+		m.findCache.InvalidatePath(orgId, f.Path)
 		deleted := m.delete(orgId, f, true)
 		statMetricsActive.DecUint32(uint32(len(deleted)))
 		deletedDefs = append(deletedDefs, deleted...)
@@ -453,6 +478,50 @@ func (m *MemoryIdx) Delete(orgId int, pattern string) ([]idx.Archive, error) {
 	return deletedDefs, nil
 }
 
+// DeleteIds deletes the definitions with the given ids of the org from the index, leaving
+// the other definitions with the same name in place. ids that are not in the index are ignored.
+func (m *MemoryIdx) DeleteIds(orgId int, ids []string) []idx.Archive {
+	var deletedDefs []idx.Archive
+	pre := time.Now()
+	m.Lock()
+	defer m.Unlock()
+	tree, ok := m.Tree[orgId]
+	if !ok {
+		return nil
+	}
+	for _, id := range ids {
+		def, ok := m.DefById[id]
+		if !ok || def.OrgId != orgId {
+			continue
+		}
+		n, ok := tree.Items[def.Name]
+		if !ok {
+			log.Error(3, "memory-idx: node %s missing. Index is corrupt.", def.Name)
+			continue
+		}
+		m.findCache.InvalidatePath(orgId, n.Path)
+		if len(n.Defs) == 1 && !n.HasChildren() {
+			deleted := m.delete(orgId, n, true)
+			statMetricsActive.DecUint32(uint32(len(deleted)))
+			deletedDefs = append(deletedDefs, deleted...)
+			continue
+		}
+		defs := make([]string, 0, len(n.Defs)-1)
+		for _, d := range n.Defs {
+			if d != id {
+				defs = append(defs, d)
+			}
+		}
+		n.Defs = defs
+		log.Debug("memory-idx: deleting %s from index", id)
+		deletedDefs = append(deletedDefs, *def)
+		delete(m.DefById, id)
+		statMetricsActive.Dec()
+	}
+	statDeleteDuration.Value(time.Since(pre))
+	return deletedDefs
+}
+
 func (m *MemoryIdx) delete(orgId int, n *Node, deleteEmptyParents bool) []idx.Archive {
 	tree := m.Tree[orgId]
 	deletedDefs := make([]idx.Archive, 0)
@@ -561,6 +630,7 @@ func (m *MemoryIdx) Prune(orgId int, oldest time.Time) ([]idx.Archive, error) {
 			continue
 		}
 
+		prunedNodes := 0
 		for _, n := range tree.Items {
 			if !n.Leaf() {
 				continue
@@ -577,8 +647,12 @@ func (m *MemoryIdx) Prune(orgId int, oldest time.Time) ([]idx.Archive, error) {
 				defs := m.delete(org, n, true)
 				statMetricsActive.Dec()
 				pruned = append(pruned, defs...)
+				prunedNodes++
 			}
 		}
+		if prunedNodes > 0 {
+			m.findCache.Purge(org)
+		}
 		m.Unlock()
 	}
 	if orgId == -1 {
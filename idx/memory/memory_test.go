@@ -279,6 +279,52 @@ func TestDelete(t *testing.T) {
 	})
 }
 
+func TestDeleteIds(t *testing.T) {
+	ix := New()
+	ix.Init()
+
+	// the same names at two intervals
+	series10 := getMetricData(1, 2, 2, 10, "metric.ids")
+	series60 := make([]*schema.MetricData, len(series10))
+	for i, s := range series10 {
+		s60 := *s
+		s60.Interval = 60
+		s60.SetId()
+		series60[i] = &s60
+	}
+	for _, s := range append(series10, series60...) {
+		ix.AddOrUpdate(s, 1)
+	}
+	Convey("when deleting one of the ids of a name", t, func() {
+		defs := ix.DeleteIds(1, []string{series10[0].Id})
+		So(defs, ShouldHaveLength, 1)
+		So(defs[0].Id, ShouldEqual, series10[0].Id)
+		Convey("the other definitions of the name should remain", func() {
+			_, ok := ix.Get(series10[0].Id)
+			So(ok, ShouldEqual, false)
+			_, ok = ix.Get(series60[0].Id)
+			So(ok, ShouldEqual, true)
+			found, err := ix.Find(1, series10[0].Name, 0)
+			So(err, ShouldBeNil)
+			So(found, ShouldHaveLength, 1)
+			So(found[0].Defs, ShouldHaveLength, 1)
+		})
+	})
+	Convey("when deleting the last id of a name", t, func() {
+		defs := ix.DeleteIds(1, []string{series60[0].Id, series60[0].Id, "1.unknown"})
+		So(defs, ShouldHaveLength, 1)
+		Convey("the name should be deleted", func() {
+			found, err := ix.Find(1, series10[0].Name, 0)
+			So(err, ShouldBeNil)
+			So(found, ShouldHaveLength, 0)
+		})
+	})
+	Convey("when deleting ids of another org", t, func() {
+		defs := ix.DeleteIds(2, []string{series10[1].Id})
+		So(defs, ShouldHaveLength, 0)
+	})
+}
+
 func TestDeleteNodeWith100kChildren(t *testing.T) {
 	ix := New()
 	ix.Init()
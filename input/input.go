@@ -68,7 +68,26 @@ func (in DefaultHandler) Process(metric *schema.MetricData, partition int32) {
 	in.pressureIdx.Add(int(time.Since(pre).Nanoseconds()))
 
 	pre = time.Now()
-	m := in.metrics.GetOrCreate(metric.Id, metric.Name, archive.SchemaId, archive.AggId)
+	m := in.metrics.GetOrCreate(metric.Id, metric.Name, archive.Partition, archive.SchemaId, archive.AggId, archive.Interval)
 	m.Add(uint32(metric.Time), metric.Value)
 	in.pressureTank.Add(int(time.Since(pre).Nanoseconds()))
 }
+
+// WALHandler is a Handler that appends all metrics to a write-ahead log before processing them,
+// for inputs that can't replay their data after a restart.
+type WALHandler struct {
+	Handler
+	wal *mdata.WAL
+}
+
+func NewWALHandler(handler Handler, wal *mdata.WAL) WALHandler {
+	return WALHandler{handler, wal}
+}
+
+func (w WALHandler) Process(metric *schema.MetricData, partition int32) {
+	if metric == nil {
+		return
+	}
+	w.wal.Append(metric, partition)
+	w.Handler.Process(metric, partition)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/input"
 	"github.com/grafana/metrictank/kafka"
+	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/stats"
 	"gopkg.in/raintank/schema.v1"
 )
@@ -34,6 +35,13 @@ type KafkaMdm struct {
 
 	// signal to PartitionConsumers to shutdown
 	stopConsuming chan struct{}
+
+	// offsets to start consuming from instead of the configured offset, e.g. restored from a snapshot
+	startOffsets map[string]map[int32]int64
+
+	// the offsets of the next messages to consume, as of when the PartitionConsumers stopped
+	offsetsLock sync.Mutex
+	offsets     []mdata.PartitionOffset
 }
 
 func (k *KafkaMdm) Name() string {
@@ -199,6 +207,10 @@ func (k *KafkaMdm) Start(handler input.Handler) {
 	var err error
 	for _, topic := range topics {
 		for _, partition := range partitions {
+			if offset, ok := k.startOffsets[topic][partition]; ok {
+				go k.consumePartition(topic, partition, k.checkStartOffset(topic, partition, offset))
+				continue
+			}
 			var offset int64
 			switch offsetStr {
 			case "oldest":
@@ -218,6 +230,39 @@ func (k *KafkaMdm) Start(handler input.Handler) {
 	}
 }
 
+// SetStartOffsets makes the consumers of the given partitions start from the given offsets,
+// rather than from the configured offset. it must be called before Start.
+func (k *KafkaMdm) SetStartOffsets(offsets []mdata.PartitionOffset) {
+	k.startOffsets = make(map[string]map[int32]int64)
+	for _, o := range offsets {
+		if _, ok := k.startOffsets[o.Topic]; !ok {
+			k.startOffsets[o.Topic] = make(map[int32]int64)
+		}
+		k.startOffsets[o.Topic][o.Partition] = o.Offset
+	}
+}
+
+// Offsets returns the offsets of the next messages to consume from each partition, as of when the consumers stopped.
+// it should be called after Stop.
+func (k *KafkaMdm) Offsets() []mdata.PartitionOffset {
+	k.offsetsLock.Lock()
+	defer k.offsetsLock.Unlock()
+	return append([]mdata.PartitionOffset(nil), k.offsets...)
+}
+
+// checkStartOffset returns the given offset, or the oldest offset of the partition if the given one is no longer available
+func (k *KafkaMdm) checkStartOffset(topic string, partition int32, offset int64) int64 {
+	oldest, err := k.tryGetOffset(topic, partition, sarama.OffsetOldest, 7, time.Second*10)
+	if err != nil {
+		log.Fatal(3, "kafka-mdm %s", err)
+	}
+	if offset < oldest {
+		log.Warn("kafka-mdm: offset %d of %s:%d is no longer available, starting from the oldest offset %d", offset, topic, partition, oldest)
+		return oldest
+	}
+	return offset
+}
+
 // tryGetOffset will to query kafka repeatedly for the requested offset and give up after attempts unsuccesfull attempts
 // an error is returned when it had to give up
 func (k *KafkaMdm) tryGetOffset(topic string, partition int32, offset int64, attempts int, sleep time.Duration) (int64, error) {
@@ -287,6 +332,7 @@ func (k *KafkaMdm) consumePartition(topic string, partition int32, currentOffset
 	}
 	messages := pc.Messages()
 	ticker := time.NewTicker(offsetCommitInterval)
+	nextOffset := currentOffset
 	for {
 		select {
 		case msg := <-messages:
@@ -295,6 +341,7 @@ func (k *KafkaMdm) consumePartition(topic string, partition int32, currentOffset
 			}
 			k.handleMsg(msg.Value, partition)
 			currentOffset = msg.Offset
+			nextOffset = msg.Offset + 1
 		case ts := <-ticker.C:
 			if err := offsetMgr.Commit(topic, partition, currentOffset); err != nil {
 				log.Error(3, "kafka-mdm failed to commit offset for %s:%d, %s", topic, partition, err)
@@ -318,6 +365,9 @@ func (k *KafkaMdm) consumePartition(topic string, partition int32, currentOffset
 			if err := offsetMgr.Commit(topic, partition, currentOffset); err != nil {
 				log.Error(3, "kafka-mdm failed to commit offset for %s:%d, %s", topic, partition, err)
 			}
+			k.offsetsLock.Lock()
+			k.offsets = append(k.offsets, mdata.PartitionOffset{Topic: topic, Partition: partition, Offset: nextOffset})
+			k.offsetsLock.Unlock()
 			log.Info("kafka-mdm consumer for %s:%d ended.", topic, partition)
 			return
 		}
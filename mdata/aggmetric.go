@@ -11,7 +11,9 @@ import (
 	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/mdata/cache"
 	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/sketch"
 	"github.com/raintank/worldping-api/pkg/log"
+	"gopkg.in/raintank/schema.v1"
 )
 
 // AggMetric takes in new values, updates the in-memory data and streams the points to aggregators
@@ -26,6 +28,7 @@ type AggMetric struct {
 	cachePusher cache.CachePusher
 	sync.RWMutex
 	Key             string
+	partition       int32 // partition of the series. we only save chunks if we are primary for it
 	rob             *ReorderBuffer
 	CurrentChunkPos int    // element in []Chunks that is active. All others are either finished or nil.
 	NumChunks       uint32 // max size of the circular buffer
@@ -38,13 +41,17 @@ type AggMetric struct {
 	lastSaveStart   uint32 // last chunk T0 that was added to the write Queue.
 	lastSaveFinish  uint32 // last chunk T0 successfully written to Cassandra.
 	lastWrite       uint32
+	backfill        bool           // whether points that can't be added to their chunk are buffered to be backfilled
+	late            []schema.Point // points waiting to be backfilled. see Backfiller
 }
 
 // NewAggMetric creates a metric with given key, it retains the given number of chunks each chunkSpan seconds long
 // it optionally also creates aggregations with the given settings
 // the 0th retention is the native archive of this metric. if there's several others, we create aggregators, using agg.
 // it's the callers responsibility to make sure agg is not nil in that case!
-func NewAggMetric(store Store, cachePusher cache.CachePusher, key string, retentions conf.Retentions, reorderWindow uint32, agg *conf.Aggregation, dropFirstChunk bool) *AggMetric {
+// interval is the interval of the metric, which determines how many points the aggregators expect per aggregated point.
+// 0 means unknown, in which case aggregated points are written regardless of how many points they aggregate.
+func NewAggMetric(store Store, cachePusher cache.CachePusher, key string, retentions conf.Retentions, reorderWindow, interval uint32, agg *conf.Aggregation, dropFirstChunk bool) *AggMetric {
 
 	// note: during parsing of retentions, we assure there's at least 1.
 	ret := retentions[0]
@@ -66,13 +73,23 @@ func NewAggMetric(store Store, cachePusher cache.CachePusher, key string, retent
 		m.rob = NewReorderBuffer(reorderWindow, ret.SecondsPerPoint)
 	}
 
-	for _, ret := range retentions[1:] {
-		m.aggregators = append(m.aggregators, NewAggregator(store, cachePusher, key, ret, *agg, dropFirstChunk))
+	for _, aggRet := range retentions[1:] {
+		m.aggregators = append(m.aggregators, NewAggregator(store, cachePusher, key, interval, aggRet, *agg, dropFirstChunk))
 	}
 
 	return &m
 }
 
+// setPartition sets the partition of the series, for the metric and its rollups
+func (a *AggMetric) setPartition(partition int32) {
+	a.partition = partition
+	for _, agg := range a.aggregators {
+		for _, m := range agg.metrics() {
+			m.partition = partition
+		}
+	}
+}
+
 // Sync the saved state of a chunk by its T0.
 func (a *AggMetric) SyncChunkSaveState(ts uint32) {
 	a.Lock()
@@ -123,6 +140,16 @@ func (a *AggMetric) SyncAggregatedChunkSaveState(ts uint32, consolidator consoli
 					a.lstMetric.SyncChunkSaveState(ts)
 				}
 				return
+			case consolidation.P50, consolidation.P75, consolidation.P90, consolidation.P95, consolidation.P99:
+				if a.sketchMetric != nil {
+					a.sketchMetric.SyncChunkSaveState(ts)
+				}
+				return
+			case consolidation.Inc:
+				if a.incMetric != nil {
+					a.incMetric.SyncChunkSaveState(ts)
+				}
+				return
 			default:
 				panic(fmt.Sprintf("internal error: no such consolidator %q with span %d", consolidator, aggSpan))
 			}
@@ -130,6 +157,28 @@ func (a *AggMetric) SyncAggregatedChunkSaveState(ts uint32, consolidator consoli
 	}
 }
 
+// persisted returns whether the chunk holding the point at ts, as well as the chunks of its rollups, have been saved.
+// if we are not primary for the partition, chunks that ended before savedBefore are considered saved by the primary.
+func (a *AggMetric) persisted(ts, savedBefore uint32) bool {
+	t0 := ts - (ts % a.ChunkSpan)
+	if !cluster.Manager.IsPrimaryFor(a.partition) && t0+a.ChunkSpan <= savedBefore {
+		return true
+	}
+	a.RLock()
+	saved := a.lastSaveFinish >= t0
+	a.RUnlock()
+	if !saved {
+		return false
+	}
+	// no lock needed cause aggregators don't change at runtime
+	for _, agg := range a.aggregators {
+		if !agg.persisted(ts, savedBefore) {
+			return false
+		}
+	}
+	return true
+}
+
 func (a *AggMetric) getChunk(pos int) *chunk.Chunk {
 	if pos < 0 || pos >= len(a.Chunks) {
 		panic(fmt.Sprintf("aggmetric %s queried for chunk %d out of %d chunks", a.Key, pos, len(a.Chunks)))
@@ -156,6 +205,10 @@ func (a *AggMetric) GetAggregated(consolidator consolidation.Consolidator, aggSp
 				return a.maxMetric.Get(from, to)
 			case consolidation.Sum:
 				return a.sumMetric.Get(from, to)
+			case consolidation.P50, consolidation.P75, consolidation.P90, consolidation.P95, consolidation.P99:
+				return a.sketchMetric.Get(from, to)
+			case consolidation.Inc:
+				return a.incMetric.Get(from, to)
 			}
 			panic(fmt.Sprintf("AggMetric.GetAggregated(): unknown consolidator %q", consolidator))
 		}
@@ -242,10 +295,10 @@ func (a *AggMetric) Get(from, to uint32) Result {
 		return result
 	}
 
-	// The first chunk is likely only a partial chunk. If we are not the primary node
+	// The first chunk is likely only a partial chunk. If we are not the primary node for the partition
 	// we should not serve data from this chunk, and should instead get the chunk from cassandra.
 	// if we are the primary node, then there is likely no data in Cassandra anyway.
-	if !cluster.Manager.IsPrimary() && oldestChunk.T0 == a.firstChunkT0 {
+	if !cluster.Manager.IsPrimaryFor(a.partition) && oldestChunk.T0 == a.firstChunkT0 {
 		oldestPos++
 		if oldestPos >= len(a.Chunks) {
 			oldestPos = 0
@@ -304,7 +357,7 @@ func (a *AggMetric) Get(from, to uint32) Result {
 	// now just start at oldestPos and move through the Chunks circular Buffer to newestPos
 	for {
 		c := a.getChunk(oldestPos)
-		result.Iters = append(result.Iters, chunk.NewIter(c.Iter()))
+		result.Iters = append(result.Iters, c.NewIter())
 
 		if oldestPos == newestPos {
 			break
@@ -336,11 +389,7 @@ func (a *AggMetric) pushToCache(c *chunk.Chunk) {
 	go a.cachePusher.CacheIfHot(
 		a.Key,
 		0,
-		*chunk.NewBareIterGen(
-			c.Bytes(),
-			c.T0,
-			a.ChunkSpan,
-		),
+		c.IterGen(a.ChunkSpan),
 	)
 }
 
@@ -434,6 +483,10 @@ func (a *AggMetric) Add(ts uint32, val float64) {
 		// write directly
 		a.add(ts, val)
 	} else {
+		if a.backfill && a.rob.tooOld(ts) {
+			a.addLate(ts, val)
+			return
+		}
 		// write through reorder buffer
 		res := a.rob.Add(ts, val)
 		for _, p := range res {
@@ -445,6 +498,28 @@ func (a *AggMetric) Add(ts uint32, val float64) {
 // don't ever call with a ts of 0, cause we use 0 to mean not initialized!
 // assumes a write lock is held by the call-site
 func (a *AggMetric) add(ts uint32, val float64) {
+	a.addPoint(ts, val, nil)
+}
+
+// AddSketch adds a point with its sketch to the series of a percentile rollup.
+// don't ever call with a ts of 0, cause we use 0 to mean not initialized!
+func (a *AggMetric) AddSketch(ts uint32, s *sketch.Sketch) {
+	a.Lock()
+	defer a.Unlock()
+	a.addPoint(ts, float64(s.Count()), s)
+}
+
+// push adds the point to the chunk, along with its sketch if it has one
+func push(c *chunk.Chunk, ts uint32, val float64, s *sketch.Sketch) error {
+	if s != nil {
+		return c.PushSketch(ts, s)
+	}
+	return c.Push(ts, val)
+}
+
+// addPoint adds the point, which has a sketch if this is the series of a percentile rollup.
+// assumes a write lock is held by the call-site
+func (a *AggMetric) addPoint(ts uint32, val float64, s *sketch.Sketch) {
 	t0 := ts - (ts % a.ChunkSpan)
 
 	if len(a.Chunks) == 0 {
@@ -456,7 +531,7 @@ func (a *AggMetric) add(ts uint32, val float64) {
 		// so we keep a record of it.
 		a.firstChunkT0 = t0
 
-		if err := a.Chunks[0].Push(ts, val); err != nil {
+		if err := push(a.Chunks[0], ts, val, s); err != nil {
 			panic(fmt.Sprintf("FATAL ERROR: this should never happen. Pushing initial value <%d,%f> to new chunk at pos 0 failed: %q", ts, val, err))
 		}
 
@@ -477,11 +552,19 @@ func (a *AggMetric) add(ts uint32, val float64) {
 		if currentChunk.Closed {
 			// if we've already 'finished' the chunk, it means it has the end-of-stream marker and any new points behind it wouldn't be read by an iterator
 			// you should monitor this metric closely, it indicates that maybe your GC settings don't match how you actually send data (too late)
+			if a.backfill {
+				a.addLate(ts, val)
+				return
+			}
 			addToClosedChunk.Inc()
 			return
 		}
 
-		if err := currentChunk.Push(ts, val); err != nil {
+		if err := push(currentChunk, ts, val, s); err != nil {
+			if a.backfill {
+				a.addLate(ts, val)
+				return
+			}
 			log.Debug("AM failed to add metric to chunk for %s. %s", a.Key, err)
 			metricsTooOld.Inc()
 			return
@@ -489,6 +572,10 @@ func (a *AggMetric) add(ts uint32, val float64) {
 		a.lastWrite = uint32(time.Now().Unix())
 		log.Debug("AM %s Add(): pushed new value to last chunk: %v", a.Key, a.Chunks[0])
 	} else if t0 < currentChunk.T0 {
+		if a.backfill {
+			a.addLate(ts, val)
+			return
+		}
 		log.Debug("AM Point at %d has t0 %d, goes back into previous chunk. CurrentChunk t0: %d, LastTs: %d", ts, t0, currentChunk.T0, currentChunk.LastTs)
 		metricsTooOld.Inc()
 		return
@@ -501,8 +588,8 @@ func (a *AggMetric) add(ts uint32, val float64) {
 		}
 
 		a.pushToCache(currentChunk)
-		// If we are a primary node, then add the chunk to the write queue to be saved to Cassandra
-		if cluster.Manager.IsPrimary() {
+		// If we are the primary node for the partition, then add the chunk to the write queue to be saved to Cassandra
+		if cluster.Manager.IsPrimaryFor(a.partition) {
 			if LogLevel < 2 {
 				log.Debug("AM persist(): node is primary, saving chunk. %s T0: %d", a.Key, currentChunk.T0)
 			}
@@ -518,7 +605,7 @@ func (a *AggMetric) add(ts uint32, val float64) {
 		chunkCreate.Inc()
 		if len(a.Chunks) < int(a.NumChunks) {
 			a.Chunks = append(a.Chunks, chunk.New(t0))
-			if err := a.Chunks[a.CurrentChunkPos].Push(ts, val); err != nil {
+			if err := push(a.Chunks[a.CurrentChunkPos], ts, val, s); err != nil {
 				panic(fmt.Sprintf("FATAL ERROR: this should never happen. Pushing initial value <%d,%f> to new chunk at pos %d failed: %q", ts, val, a.CurrentChunkPos, err))
 			}
 			log.Debug("AM %s Add(): added new chunk to buffer. now %d chunks. and added the new point: %s", a.Key, a.CurrentChunkPos+1, a.Chunks[a.CurrentChunkPos])
@@ -526,7 +613,7 @@ func (a *AggMetric) add(ts uint32, val float64) {
 			chunkClear.Inc()
 			a.Chunks[a.CurrentChunkPos].Clear()
 			a.Chunks[a.CurrentChunkPos] = chunk.New(t0)
-			if err := a.Chunks[a.CurrentChunkPos].Push(ts, val); err != nil {
+			if err := push(a.Chunks[a.CurrentChunkPos], ts, val, s); err != nil {
 				panic(fmt.Sprintf("FATAL ERROR: this should never happen. Pushing initial value <%d,%f> to new chunk at pos %d failed: %q", ts, val, a.CurrentChunkPos, err))
 			}
 			log.Debug("AM %s Add(): cleared chunk at %d of %d and replaced with new. and added the new point: %s", a.Key, a.CurrentChunkPos, len(a.Chunks), a.Chunks[a.CurrentChunkPos])
@@ -560,10 +647,10 @@ func (a *AggMetric) GC(chunkMinTs, metricMinTs uint32) bool {
 			}
 		} else {
 			// chunk hasn't been written to in a while, and is not yet closed. Let's close it and persist it if
-			// we are a primary
+			// we are the primary for the partition
 			log.Debug("Found stale Chunk, adding end-of-stream bytes. key: %s T0: %d", a.Key, currentChunk.T0)
 			currentChunk.Finish()
-			if cluster.Manager.IsPrimary() {
+			if cluster.Manager.IsPrimaryFor(a.partition) {
 				if LogLevel < 2 {
 					log.Debug("AM persist(): node is primary, saving chunk. %s T0: %d", a.Key, currentChunk.T0)
 				}
@@ -2,6 +2,7 @@ package mdata
 
 import (
 	"fmt"
+	"reflect"
 	"regexp"
 	"sort"
 	"testing"
@@ -11,6 +12,7 @@ import (
 	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/mdata/cache"
 	"github.com/grafana/metrictank/test"
+	"gopkg.in/raintank/schema.v1"
 )
 
 var dnstore = NewDevnullStore()
@@ -123,7 +125,7 @@ func testMetricPersistOptionalPrimary(t *testing.T, primary bool) {
 
 	numChunks, chunkAddCount, chunkSpan := uint32(5), uint32(10), uint32(300)
 	ret := []conf.Retention{conf.NewRetentionMT(1, 1, chunkSpan, numChunks, true)}
-	agg := NewAggMetric(dnstore, &mockCache, "foo", ret, 0, nil, false)
+	agg := NewAggMetric(dnstore, &mockCache, "foo", ret, 0, 0, nil, false)
 
 	for ts := chunkSpan; ts <= chunkSpan*chunkAddCount; ts += chunkSpan {
 		agg.Add(ts, 1)
@@ -159,7 +161,7 @@ func TestAggMetric(t *testing.T) {
 	cluster.Init("default", "test", time.Now(), "http", 6060)
 
 	ret := []conf.Retention{conf.NewRetentionMT(1, 1, 100, 5, true)}
-	c := NewChecker(t, NewAggMetric(dnstore, &cache.MockCache{}, "foo", ret, 0, nil, false))
+	c := NewChecker(t, NewAggMetric(dnstore, &cache.MockCache{}, "foo", ret, 0, 0, nil, false))
 
 	// basic case, single range
 	c.Add(101, 101)
@@ -237,7 +239,7 @@ func TestAggMetricWithReorderBuffer(t *testing.T) {
 		AggregationMethod: []conf.Method{conf.Avg},
 	}
 	ret := []conf.Retention{conf.NewRetentionMT(1, 1, 100, 5, true)}
-	c := NewChecker(t, NewAggMetric(dnstore, &cache.MockCache{}, "foo", ret, 10, &agg, false))
+	c := NewChecker(t, NewAggMetric(dnstore, &cache.MockCache{}, "foo", ret, 10, 0, &agg, false))
 
 	// basic adds and verifies with test data
 	c.Add(101, 101)
@@ -277,7 +279,7 @@ func TestAggMetricDropFirstChunk(t *testing.T) {
 	chunkSpan := uint32(10)
 	numChunks := uint32(5)
 	ret := []conf.Retention{conf.NewRetentionMT(1, 1, chunkSpan, numChunks, true)}
-	m := NewAggMetric(store, &cache.MockCache{}, "foo", ret, 0, nil, true)
+	m := NewAggMetric(store, &cache.MockCache{}, "foo", ret, 0, 0, nil, true)
 	m.Add(10, 10)
 	m.Add(11, 11)
 	m.Add(12, 12)
@@ -328,7 +330,7 @@ func BenchmarkAggMetrics1000Metrics1Day(b *testing.B) {
 	for t := uint32(1); t < maxT; t += 10 {
 		for metricI := 0; metricI < 1000; metricI++ {
 			k := keys[metricI]
-			m := metrics.GetOrCreate(k, k, 0, 0)
+			m := metrics.GetOrCreate(k, k, 0, 0, 0, 0)
 			m.Add(t, float64(t))
 		}
 	}
@@ -357,7 +359,7 @@ func BenchmarkAggMetrics1kSeries2Chunks1kQueueSize(b *testing.B) {
 	for t := uint32(1); t < maxT; t += 10 {
 		for metricI := 0; metricI < 1000; metricI++ {
 			k := keys[metricI]
-			m := metrics.GetOrCreate(k, k, 0, 0)
+			m := metrics.GetOrCreate(k, k, 0, 0, 0, 0)
 			m.Add(t, float64(t))
 		}
 	}
@@ -386,7 +388,7 @@ func BenchmarkAggMetrics10kSeries2Chunks10kQueueSize(b *testing.B) {
 	for t := uint32(1); t < maxT; t += 10 {
 		for metricI := 0; metricI < 10000; metricI++ {
 			k := keys[metricI]
-			m := metrics.GetOrCreate(k, k, 0, 0)
+			m := metrics.GetOrCreate(k, k, 0, 0, 0, 0)
 			m.Add(t, float64(t))
 		}
 	}
@@ -415,8 +417,41 @@ func BenchmarkAggMetrics100kSeries2Chunks100kQueueSize(b *testing.B) {
 	for t := uint32(1); t < maxT; t += 10 {
 		for metricI := 0; metricI < 100000; metricI++ {
 			k := keys[metricI]
-			m := metrics.GetOrCreate(k, k, 0, 0)
+			m := metrics.GetOrCreate(k, k, 0, 0, 0, 0)
 			m.Add(t, float64(t))
 		}
 	}
 }
+
+// a metric that is coarser than the raw retention of its schema still gets its rollups, as it sends all the points it is expected to
+func TestAggMetricCoarseInterval(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+	defer cluster.Manager.SetPrimary(false)
+
+	agg := conf.Aggregation{
+		Name:              "Default",
+		Pattern:           regexp.MustCompile(".*"),
+		XFilesFactor:      0.5,
+		AggregationMethod: []conf.Method{conf.Sum},
+	}
+	ret := []conf.Retention{
+		conf.NewRetentionMT(10, 3600, 600, 5, true),
+		conf.NewRetentionMT(600, 86400, 21600, 2, true),
+	}
+	m := NewAggMetric(dnstore, &cache.MockCache{}, "foo", ret, 0, 30, &agg, false)
+	for ts := uint32(630); ts <= 1830; ts += 30 {
+		m.Add(ts, 1)
+	}
+	var got []schema.Point
+	for _, it := range m.aggregators[0].sumMetric.Get(0, 2000).Iters {
+		for it.Next() {
+			ts, val := it.Values()
+			got = append(got, schema.Point{Val: val, Ts: ts})
+		}
+	}
+	exp := []schema.Point{{Val: 20, Ts: 1200}, {Val: 20, Ts: 1800}}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected rollup points %v, got %v", exp, got)
+	}
+}
@@ -81,16 +81,49 @@ func (ms *AggMetrics) Get(key string) (Metric, bool) {
 	return m, ok
 }
 
-func (ms *AggMetrics) GetOrCreate(key, name string, schemaId, aggId uint16) Metric {
+// GetOrCreate returns the metric with the given key, creating it if it doesn't exist.
+// interval is the interval of the metric as it is in the index, 0 if it is unknown. see NewAggMetric
+func (ms *AggMetrics) GetOrCreate(key, name string, partition int32, schemaId, aggId uint16, interval int) Metric {
 	ms.Lock()
 	m, ok := ms.Metrics[key]
 	if !ok {
 		agg := Aggregations.Get(aggId)
 		schema := Schemas.Get(schemaId)
-		m = NewAggMetric(ms.store, ms.cachePusher, key, schema.Retentions, schema.ReorderWindow, &agg, ms.dropFirstChunk)
+		m = NewAggMetric(ms.store, ms.cachePusher, key, schema.Retentions, schema.ReorderWindow, uint32(interval), &agg, ms.dropFirstChunk)
+		m.backfill = schema.Backfill
+		m.setPartition(partition)
 		ms.Metrics[key] = m
 		metricsActive.Set(len(ms.Metrics))
 	}
 	ms.Unlock()
 	return m
 }
+
+// Persisted returns whether the point at ts of the metric with the given key has been saved, including its rollups.
+// metrics that are not in memory (anymore) are considered saved.
+// nodes that are not primary for the partition of the metric only learn about saved chunks through the persist
+// notifiers. so that they don't depend on those, they consider a chunk saved once the primary must have saved it:
+// when the chunk ended, plus the time it takes the GC of the primary to persist the chunk if it went stale.
+func (ms *AggMetrics) Persisted(key string, ts uint32) bool {
+	ms.RLock()
+	m, ok := ms.Metrics[key]
+	ms.RUnlock()
+	if !ok {
+		return true
+	}
+	savedBefore := uint32(time.Now().Add(-ms.gcInterval).Unix()) - ms.chunkMaxStale
+	return m.persisted(ts, savedBefore)
+}
+
+// Delete removes the metric with the given key, including its rollups, from memory.
+// it returns whether the metric was present
+func (ms *AggMetrics) Delete(key string) bool {
+	ms.Lock()
+	_, ok := ms.Metrics[key]
+	if ok {
+		delete(ms.Metrics, key)
+		metricsActive.Set(len(ms.Metrics))
+	}
+	ms.Unlock()
+	return ok
+}
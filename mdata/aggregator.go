@@ -2,9 +2,12 @@ package mdata
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/sketch"
 )
 
 // AggBoundary returns ts if it is a boundary, or the next boundary otherwise.
@@ -22,57 +25,122 @@ func AggBoundary(ts uint32, span uint32) uint32 {
 type Aggregator struct {
 	key             string // of the metric this aggregator corresponds to
 	span            uint32
-	currentBoundary uint32 // working on this chunk
+	rawInterval     uint32  // interval of the raw series. 0 if unknown
+	xFilesFactor    float64 // minimum ratio of raw points that must be known for an aggregated point to be written
+	currentBoundary uint32  // working on this chunk
 	agg             *Aggregation
 	minMetric       *AggMetric
 	maxMetric       *AggMetric
 	sumMetric       *AggMetric
 	cntMetric       *AggMetric
 	lstMetric       *AggMetric
+	sketchMetric    *AggMetric     // series of the percentile rollups, which has a sketch per point
+	sketch          *sketch.Sketch // sketch of the values of the current boundary, if there is a sketchMetric
+	incMetric       *AggMetric     // series of the increase rollups of counters
+	inc             float64        // increase of the counter in the current boundary, corrected for resets
+	prev            float64        // last value of the counter, which may be from a previous boundary. NaN if unknown
 }
 
-func NewAggregator(store Store, cachePusher cache.CachePusher, key string, ret conf.Retention, agg conf.Aggregation, dropFirstChunk bool) *Aggregator {
+func NewAggregator(store Store, cachePusher cache.CachePusher, key string, rawInterval uint32, ret conf.Retention, agg conf.Aggregation, dropFirstChunk bool) *Aggregator {
 	if len(agg.AggregationMethod) == 0 {
 		panic("NewAggregator called without aggregations. this should never happen")
 	}
 	span := uint32(ret.SecondsPerPoint)
 	aggregator := &Aggregator{
-		key:  key,
-		span: span,
-		agg:  NewAggregation(),
+		key:          key,
+		span:         span,
+		rawInterval:  rawInterval,
+		xFilesFactor: agg.XFilesFactor,
+		agg:          NewAggregation(),
+		prev:         math.NaN(),
 	}
 	for _, agg := range agg.AggregationMethod {
 		switch agg {
 		case conf.Avg:
 			if aggregator.sumMetric == nil {
-				aggregator.sumMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_sum_%d", key, span), conf.Retentions{ret}, 0, nil, dropFirstChunk)
+				aggregator.sumMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_sum_%d", key, span), conf.Retentions{ret}, 0, 0, nil, dropFirstChunk)
 			}
 			if aggregator.cntMetric == nil {
-				aggregator.cntMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_cnt_%d", key, span), conf.Retentions{ret}, 0, nil, dropFirstChunk)
+				aggregator.cntMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_cnt_%d", key, span), conf.Retentions{ret}, 0, 0, nil, dropFirstChunk)
 			}
 		case conf.Sum:
 			if aggregator.sumMetric == nil {
-				aggregator.sumMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_sum_%d", key, span), conf.Retentions{ret}, 0, nil, dropFirstChunk)
+				aggregator.sumMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_sum_%d", key, span), conf.Retentions{ret}, 0, 0, nil, dropFirstChunk)
 			}
 		case conf.Lst:
 			if aggregator.lstMetric == nil {
-				aggregator.lstMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_lst_%d", key, span), conf.Retentions{ret}, 0, nil, dropFirstChunk)
+				aggregator.lstMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_lst_%d", key, span), conf.Retentions{ret}, 0, 0, nil, dropFirstChunk)
 			}
 		case conf.Max:
 			if aggregator.maxMetric == nil {
-				aggregator.maxMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_max_%d", key, span), conf.Retentions{ret}, 0, nil, dropFirstChunk)
+				aggregator.maxMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_max_%d", key, span), conf.Retentions{ret}, 0, 0, nil, dropFirstChunk)
 			}
 		case conf.Min:
 			if aggregator.minMetric == nil {
-				aggregator.minMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_min_%d", key, span), conf.Retentions{ret}, 0, nil, dropFirstChunk)
+				aggregator.minMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_min_%d", key, span), conf.Retentions{ret}, 0, 0, nil, dropFirstChunk)
+			}
+		case conf.P50, conf.P75, conf.P90, conf.P95, conf.P99:
+			// all percentiles are computed from the same sketches
+			if aggregator.sketchMetric == nil {
+				aggregator.sketchMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_sketch_%d", key, span), conf.Retentions{ret}, 0, 0, nil, dropFirstChunk)
+				aggregator.sketch = sketch.New()
+			}
+		case conf.Inc:
+			if aggregator.incMetric == nil {
+				aggregator.incMetric = NewAggMetric(store, cachePusher, fmt.Sprintf("%s_inc_%d", key, span), conf.Retentions{ret}, 0, 0, nil, dropFirstChunk)
 			}
 		}
 	}
 	return aggregator
 }
 
+// metrics returns the aggregation-series of the aggregator
+func (agg *Aggregator) metrics() []*AggMetric {
+	var metrics []*AggMetric
+	for _, m := range []*AggMetric{agg.minMetric, agg.maxMetric, agg.sumMetric, agg.cntMetric, agg.lstMetric, agg.sketchMetric, agg.incMetric} {
+		if m != nil {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// persisted returns whether the aggregated points that the point at ts contributes to have been saved.
+// see AggMetric.persisted for savedBefore
+func (agg *Aggregator) persisted(ts, savedBefore uint32) bool {
+	boundary := AggBoundary(ts, agg.span)
+	for _, m := range agg.metrics() {
+		if !m.persisted(boundary, savedBefore) {
+			return false
+		}
+	}
+	return true
+}
+
+// known returns whether enough raw points, out of the number of points expected in a span, went into an aggregation
+// for the aggregated point to be written. like in graphite, an aggregated point is null if the ratio of known points is below xFilesFactor.
+func (agg *Aggregator) known(a *Aggregation) bool {
+	if a.Cnt == 0 {
+		return false
+	}
+	if agg.rawInterval == 0 || agg.xFilesFactor <= 0 {
+		return true
+	}
+	expected := agg.span / agg.rawInterval
+	if expected == 0 {
+		expected = 1
+	}
+	return a.Cnt/float64(expected) >= agg.xFilesFactor
+}
+
 // flush adds points to the aggregation-series and resets aggregation state
+// if too few raw points are known, no points are added, which results in nulls.
 func (agg *Aggregator) flush() {
+	if !agg.known(agg.agg) {
+		aggregatorXFilesFactorNull.Inc()
+		agg.reset()
+		return
+	}
 	if agg.minMetric != nil {
 		agg.minMetric.Add(agg.currentBoundary, agg.agg.Min)
 	}
@@ -88,15 +156,51 @@ func (agg *Aggregator) flush() {
 	if agg.lstMetric != nil {
 		agg.lstMetric.Add(agg.currentBoundary, agg.agg.Lst)
 	}
+	if agg.sketchMetric != nil {
+		agg.sketchMetric.AddSketch(agg.currentBoundary, agg.sketch)
+	}
+	if agg.incMetric != nil {
+		agg.incMetric.Add(agg.currentBoundary, agg.inc)
+	}
 	//msg := fmt.Sprintf("flushed cnt %v sum %f min %f max %f, reset the block", agg.agg.cnt, agg.agg.sum, agg.agg.min, agg.agg.max)
+	agg.reset()
+}
+
+// add adds the value to the aggregation of the current boundary
+func (agg *Aggregator) add(val float64) {
+	agg.agg.Add(val)
+	if agg.sketch != nil {
+		agg.sketch.Add(val)
+	}
+}
+
+// addIncrease adds the increase of the counter since its last value to the current boundary, if there are increase rollups.
+// the increase since the last value of the previous boundary belongs to this one.
+func (agg *Aggregator) addIncrease(val float64) {
+	if agg.incMetric == nil {
+		return
+	}
+	if !math.IsNaN(agg.prev) {
+		agg.inc += consolidation.CounterDelta(agg.prev, val)
+	}
+	agg.prev = val
+}
+
+// reset resets the aggregation of the current boundary
+func (agg *Aggregator) reset() {
 	agg.agg.Reset()
+	if agg.sketch != nil {
+		agg.sketch = sketch.New()
+	}
+	agg.inc = 0
 }
 
 func (agg *Aggregator) Add(ts uint32, val float64) {
 	boundary := AggBoundary(ts, agg.span)
 
 	if boundary == agg.currentBoundary {
-		agg.agg.Add(val)
+		agg.add(val)
+		agg.addIncrease(val)
 		if ts == boundary {
 			agg.flush()
 		}
@@ -107,7 +211,8 @@ func (agg *Aggregator) Add(ts uint32, val float64) {
 			agg.flush()
 		}
 		agg.currentBoundary = boundary
-		agg.agg.Add(val)
+		agg.add(val)
+		agg.addIncrease(val)
 	} else {
 		panic("aggregator: boundary < agg.currentBoundary. ts > lastSeen should already have been asserted")
 	}
@@ -1,12 +1,14 @@
 package mdata
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/sketch"
 	"gopkg.in/raintank/schema.v1"
 )
 
@@ -70,13 +72,13 @@ func TestAggregator(t *testing.T) {
 		AggregationMethod: []conf.Method{conf.Avg, conf.Min, conf.Max, conf.Sum, conf.Lst},
 	}
 
-	agg := NewAggregator(dnstore, &cache.MockCache{}, "test", ret, aggs, false)
+	agg := NewAggregator(dnstore, &cache.MockCache{}, "test", 10, ret, aggs, false)
 	agg.Add(100, 123.4)
 	agg.Add(110, 5)
 	expected := []schema.Point{}
 	compare("simple-min-unfinished", agg.minMetric, expected)
 
-	agg = NewAggregator(dnstore, &cache.MockCache{}, "test", ret, aggs, false)
+	agg = NewAggregator(dnstore, &cache.MockCache{}, "test", 10, ret, aggs, false)
 	agg.Add(100, 123.4)
 	agg.Add(110, 5)
 	agg.Add(130, 130)
@@ -85,7 +87,7 @@ func TestAggregator(t *testing.T) {
 	}
 	compare("simple-min-one-block", agg.minMetric, expected)
 
-	agg = NewAggregator(dnstore, &cache.MockCache{}, "test", ret, aggs, false)
+	agg = NewAggregator(dnstore, &cache.MockCache{}, "test", 10, ret, aggs, false)
 	agg.Add(100, 123.4)
 	agg.Add(110, 5)
 	agg.Add(120, 4)
@@ -94,7 +96,7 @@ func TestAggregator(t *testing.T) {
 	}
 	compare("simple-min-one-block-done-cause-last-point-just-right", agg.minMetric, expected)
 
-	agg = NewAggregator(dnstore, &cache.MockCache{}, "test", ret, aggs, false)
+	agg = NewAggregator(dnstore, &cache.MockCache{}, "test", 10, ret, aggs, false)
 	agg.Add(100, 123.4)
 	agg.Add(110, 5)
 	agg.Add(150, 1.123)
@@ -105,7 +107,7 @@ func TestAggregator(t *testing.T) {
 	}
 	compare("simple-min-two-blocks-done-cause-last-point-just-right", agg.minMetric, expected)
 
-	agg = NewAggregator(dnstore, &cache.MockCache{}, "test", ret, aggs, false)
+	agg = NewAggregator(dnstore, &cache.MockCache{}, "test", 10, ret, aggs, false)
 	agg.Add(100, 123.4)
 	agg.Add(110, 5)
 	agg.Add(190, 2451.123)
@@ -133,4 +135,106 @@ func TestAggregator(t *testing.T) {
 		{Val: 2451.123 + 1451.123 + 978894.445, Ts: 240},
 	})
 
+	// with a raw interval of 10, each aggregated point needs at least 3 out of 6 raw points
+	aggs.XFilesFactor = 0.5
+	agg = NewAggregator(dnstore, &cache.MockCache{}, "test", 10, ret, aggs, false)
+	agg.Add(100, 1)
+	agg.Add(110, 2)
+	agg.Add(130, 3)
+	agg.Add(140, 4)
+	agg.Add(150, 5)
+	agg.Add(190, 6)
+	agg.Add(200, 7)
+	compare("xfilesfactor-sum", agg.sumMetric, []schema.Point{
+		{Val: 12, Ts: 180},
+	})
+	compare("xfilesfactor-cnt", agg.cntMetric, []schema.Point{
+		{Val: 3, Ts: 180},
+	})
+}
+
+func TestAggregatorSketch(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+	defer cluster.Manager.SetPrimary(false)
+	ret := conf.NewRetentionMT(60, 86400, 120, 10, true)
+	aggs := conf.Aggregation{
+		AggregationMethod: []conf.Method{conf.P50, conf.Max, conf.P99},
+	}
+	agg := NewAggregator(dnstore, &cache.MockCache{}, "test", 1, ret, aggs, false)
+	if agg.sketchMetric == nil || agg.maxMetric == nil || agg.sumMetric != nil {
+		t.Fatalf("expected a sketch and a max series only")
+	}
+	exp := make(map[uint32]*sketch.Sketch)
+	for ts := uint32(1); ts <= 240; ts++ {
+		boundary := AggBoundary(ts, 60)
+		if exp[boundary] == nil {
+			exp[boundary] = sketch.New()
+		}
+		val := float64(ts % 60 * ts % 7)
+		exp[boundary].Add(val)
+		agg.Add(ts, val)
+	}
+
+	res := agg.sketchMetric.Get(0, 1000)
+	n := 0
+	for _, it := range res.Iters {
+		for it.Next() {
+			ts, val := it.Values()
+			s, err := it.Sketch()
+			if err != nil {
+				t.Fatalf("failed to get sketch of point %d: %s", ts, err)
+			}
+			if val != 60 || s.Count() != 60 {
+				t.Fatalf("expected point %d to have a count of 60, got %f with a sketch of %d values", ts, val, s.Count())
+			}
+			for _, q := range []float64{0.5, 0.99} {
+				if s.Quantile(q) != exp[ts].Quantile(q) {
+					t.Fatalf("expected quantile %f of point %d to be %f, got %f", q, ts, exp[ts].Quantile(q), s.Quantile(q))
+				}
+			}
+			n++
+		}
+	}
+	if n != 4 {
+		t.Fatalf("expected 4 points, got %d", n)
+	}
+}
+
+func TestAggregatorIncrease(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+	defer cluster.Manager.SetPrimary(false)
+	ret := conf.NewRetentionMT(60, 86400, 120, 10, true)
+	aggs := conf.Aggregation{
+		AggregationMethod: []conf.Method{conf.Max, conf.Inc},
+	}
+	agg := NewAggregator(dnstore, &cache.MockCache{}, "test", 10, ret, aggs, false)
+	// the counter goes up by 1 every 10s, and gets reset to 0 at 150 and at 240, right on a boundary
+	val := float64(100)
+	for ts := uint32(10); ts <= 300; ts += 10 {
+		val++
+		if ts == 150 || ts == 240 {
+			val = 0
+		}
+		agg.Add(ts, val)
+	}
+	// the first point has no previous value, and the resets lose 1 each
+	exp := []schema.Point{
+		{Val: 5, Ts: 60},
+		{Val: 6, Ts: 120},
+		{Val: 5, Ts: 180},
+		{Val: 5, Ts: 240},
+		{Val: 6, Ts: 300},
+	}
+	got := make([]schema.Point, 0, len(exp))
+	for _, it := range agg.incMetric.Get(0, 1000).Iters {
+		for it.Next() {
+			ts, val := it.Values()
+			got = append(got, schema.Point{Val: val, Ts: ts})
+		}
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected increases %v, got %v", exp, got)
+	}
 }
@@ -0,0 +1,422 @@
+package mdata
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/stats"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/worldping-api/pkg/log"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// backfilling allows adding data to chunks that are already closed or saved, for metrics of schemas with backfill enabled.
+//
+// points that can't be added to their chunk, because they're too old or out of order, are buffered per metric.
+// every backfill interval, the buffered points are merged with the points of their chunk, and the chunk is re-encoded.
+// chunks that are in the ring buffer are replaced in memory, other chunks are read from the chunk cache or the store.
+// the rewritten chunk replaces the saved one if it had already been saved, and the cache.
+// chunks that can't be found while they may still be in the write queue are retried on the next run, so as not to overwrite them.
+// finally the rollups of the spans that received late points get recomputed, and their chunks are rewritten the same way.
+
+var errChunkPending = errors.New("chunk is not saved yet")
+
+var (
+	// metric tank.backfill.points is the number of late points buffered to be backfilled
+	backfillPoints = stats.NewCounter32("tank.backfill.points")
+	// metric tank.backfill.chunks_rewritten is the number of chunks, including rollup chunks, rewritten to backfill late points
+	backfillChunksRewritten = stats.NewCounter32("tank.backfill.chunks_rewritten")
+	// metric tank.backfill.fail is the number of chunks that could not be rewritten, their late points are dropped
+	backfillFail = stats.NewCounter32("tank.backfill.fail")
+	// metric tank.backfill.duration is the duration of a backfill run
+	backfillDuration = stats.NewLatencyHistogram15s32("tank.backfill.duration")
+)
+
+// addLate buffers a point that can't be added to its chunk, to be backfilled.
+// points that are older than the TTL are dropped.
+// assumes a write lock is held by the call-site
+func (a *AggMetric) addLate(ts uint32, val float64) {
+	if now := uint32(time.Now().Unix()); now > a.ttl && ts < now-a.ttl {
+		metricsTooOld.Inc()
+		return
+	}
+	a.late = append(a.late, schema.Point{Val: val, Ts: ts})
+	backfillPoints.Inc()
+}
+
+// requeueLate buffers late points again, to be backfilled on the next run
+func (a *AggMetric) requeueLate(points []schema.Point) {
+	a.Lock()
+	a.late = append(a.late, points...)
+	a.Unlock()
+}
+
+// takeLate returns the buffered late points and empties the buffer
+func (a *AggMetric) takeLate() []schema.Point {
+	a.Lock()
+	points := a.late
+	a.late = nil
+	a.Unlock()
+	return points
+}
+
+// memChunkPoints returns the points of the chunk with the given t0, if it is in the ring buffer.
+// just like Get, secondaries don't use the first chunk, as it is likely only a partial chunk.
+func (a *AggMetric) memChunkPoints(t0 uint32) ([]schema.Point, bool) {
+	a.RLock()
+	defer a.RUnlock()
+	for _, c := range a.Chunks {
+		if c.T0 == t0 {
+			if !cluster.Manager.IsPrimaryFor(a.partition) && t0 == a.firstChunkT0 {
+				return nil, false
+			}
+			return iterPoints(chunk.NewIter(c.Iter())), true
+		}
+	}
+	return nil, false
+}
+
+// mergeChunk merges the points into the chunk with the given t0 and replaces it, if it is in the ring buffer.
+// it returns the new chunk and its points, and whether the chunk had already been (sent to be) saved,
+// in which case it needs to be saved again.
+func (a *AggMetric) mergeChunk(t0 uint32, points []schema.Point) (*chunk.Chunk, []schema.Point, bool) {
+	a.Lock()
+	defer a.Unlock()
+	for i, old := range a.Chunks {
+		if old.T0 != t0 {
+			continue
+		}
+		merged := mergePoints(iterPoints(chunk.NewIter(old.Iter())), points)
+		c := encodeChunk(t0, merged, old.Closed)
+		a.Chunks[i] = c
+		old.Clear()
+		return c, merged, t0 <= a.lastSaveStart
+	}
+	return nil, nil, false
+}
+
+// rollups returns the series of the aggregator, except for the ones of the percentile and increase rollups
+func (agg *Aggregator) rollups() []*AggMetric {
+	var metrics []*AggMetric
+	for _, m := range []*AggMetric{agg.minMetric, agg.maxMetric, agg.sumMetric, agg.cntMetric, agg.lstMetric} {
+		if m != nil {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// rollupPoints returns the points of the aggregator's series for the given aggregation, in the same order as rollups
+func (agg *Aggregator) rollupPoints(ts uint32, a *Aggregation) []schema.Point {
+	var points []schema.Point
+	if agg.minMetric != nil {
+		points = append(points, schema.Point{Val: a.Min, Ts: ts})
+	}
+	if agg.maxMetric != nil {
+		points = append(points, schema.Point{Val: a.Max, Ts: ts})
+	}
+	if agg.sumMetric != nil {
+		points = append(points, schema.Point{Val: a.Sum, Ts: ts})
+	}
+	if agg.cntMetric != nil {
+		points = append(points, schema.Point{Val: a.Cnt, Ts: ts})
+	}
+	if agg.lstMetric != nil {
+		points = append(points, schema.Point{Val: a.Lst, Ts: ts})
+	}
+	return points
+}
+
+// Backfiller periodically merges the late points of metrics with backfill enabled into their chunks
+type Backfiller struct {
+	metrics  *AggMetrics
+	cache    cache.Cache
+	tracer   opentracing.Tracer
+	interval time.Duration
+}
+
+// NewBackfiller creates a backfiller for the given metrics. cache may be nil.
+func NewBackfiller(metrics *AggMetrics, cache cache.Cache, tracer opentracing.Tracer, interval time.Duration) *Backfiller {
+	return &Backfiller{
+		metrics:  metrics,
+		cache:    cache,
+		tracer:   tracer,
+		interval: interval,
+	}
+}
+
+// Run backfills the late points every interval
+func (b *Backfiller) Run() {
+	ticker := time.NewTicker(b.interval)
+	for range ticker.C {
+		b.Flush()
+	}
+}
+
+// Flush backfills the late points of all metrics
+func (b *Backfiller) Flush() {
+	pre := time.Now()
+	span := b.tracer.StartSpan("backfill")
+	defer span.Finish()
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+
+	b.metrics.RLock()
+	metrics := make([]*AggMetric, 0, len(b.metrics.Metrics))
+	for _, m := range b.metrics.Metrics {
+		metrics = append(metrics, m)
+	}
+	b.metrics.RUnlock()
+
+	var num int
+	for _, m := range metrics {
+		points := m.takeLate()
+		if len(points) == 0 {
+			continue
+		}
+		b.backfill(ctx, m, points)
+		num++
+	}
+	span.SetTag("metrics", num)
+	if num > 0 {
+		log.Debug("backfill: backfilled late points of %d metrics. Took %s", num, time.Since(pre))
+	}
+	backfillDuration.Value(time.Since(pre))
+}
+
+// backfill merges the points into the raw chunks of the metric, and recomputes the rollups they contribute to
+func (b *Backfiller) backfill(ctx context.Context, a *AggMetric, points []schema.Point) {
+	points = sortPoints(points)
+
+	// the points of all raw chunks we rewrote or read, so that we can compute the rollups from them
+	chunks := make(map[uint32][]schema.Point)
+	var backfilled []schema.Point
+	for _, group := range groupPoints(points, a.ChunkSpan) {
+		t0 := group[0].Ts - group[0].Ts%a.ChunkSpan
+		merged, err := b.rewriteChunk(ctx, a, t0, group)
+		if err == errChunkPending {
+			// try again on the next run, once the chunk is saved
+			log.Debug("backfill: chunk %d of %s is not saved yet, retrying its late points later", t0, a.Key)
+			a.requeueLate(group)
+			continue
+		}
+		if err != nil {
+			log.Error(3, "backfill: failed to rewrite chunk %d of %s: %s", t0, a.Key, err)
+			backfillFail.Inc()
+			continue
+		}
+		chunks[t0] = merged
+		backfilled = append(backfilled, group...)
+	}
+
+	// no lock needed cause aggregators don't change at runtime
+	for _, agg := range a.aggregators {
+		b.backfillAggregator(ctx, a, agg, backfilled, chunks)
+	}
+}
+
+// backfillAggregator recomputes the aggregated points that the late points contribute to.
+// late points that fall in the span the aggregator is currently working on are simply added to it.
+// the sketches of the percentile rollups are not recomputed: only late points in the current span make it into them.
+// late points never contribute to the increase rollups, as they are out of order with the values the increases were computed from.
+func (b *Backfiller) backfillAggregator(ctx context.Context, a *AggMetric, agg *Aggregator, points []schema.Point, chunks map[uint32][]schema.Point) {
+	var boundaries []uint32
+	a.Lock()
+	for _, p := range points {
+		boundary := AggBoundary(p.Ts, agg.span)
+		if boundary == agg.currentBoundary && agg.agg.Cnt != 0 {
+			agg.add(p.Val)
+			continue
+		}
+		if len(boundaries) == 0 || boundaries[len(boundaries)-1] != boundary {
+			boundaries = append(boundaries, boundary)
+		}
+	}
+	a.Unlock()
+
+	rollups := agg.rollups()
+	updates := make([][]schema.Point, len(rollups))
+	for _, boundary := range boundaries {
+		from := boundary - agg.span + 1
+		aggregation := NewAggregation()
+		for t0 := from - from%a.ChunkSpan; t0 <= boundary; t0 += a.ChunkSpan {
+			raw, ok := chunks[t0]
+			if !ok {
+				var err error
+				raw, _, err = b.chunkPoints(ctx, a, t0)
+				if err != nil {
+					log.Error(3, "backfill: failed to read chunk %d of %s to recompute its rollups: %s", t0, a.Key, err)
+					backfillFail.Inc()
+					return
+				}
+				chunks[t0] = raw
+			}
+			for _, p := range raw {
+				if p.Ts >= from && p.Ts <= boundary {
+					aggregation.Add(p.Val)
+				}
+			}
+		}
+		if !agg.known(aggregation) {
+			continue
+		}
+		for i, p := range agg.rollupPoints(boundary, aggregation) {
+			updates[i] = append(updates[i], p)
+		}
+	}
+
+	for i, m := range rollups {
+		for _, group := range groupPoints(updates[i], m.ChunkSpan) {
+			t0 := group[0].Ts - group[0].Ts%m.ChunkSpan
+			if _, err := b.rewriteChunk(ctx, m, t0, group); err != nil {
+				log.Error(3, "backfill: failed to rewrite chunk %d of %s: %s", t0, m.Key, err)
+				backfillFail.Inc()
+			}
+		}
+	}
+}
+
+// rewriteChunk merges the points into the chunk of the metric with the given t0, and returns all points of the new chunk.
+// the new chunk replaces the chunk in the ring buffer, the store and the cache, as needed.
+// it returns errChunkPending if the chunk can't be found, but may be in the write queue.
+func (b *Backfiller) rewriteChunk(ctx context.Context, a *AggMetric, t0 uint32, points []schema.Point) ([]schema.Point, error) {
+	c, merged, saved := a.mergeChunk(t0, points)
+	if c == nil {
+		existing, found, err := b.chunkPoints(ctx, a, t0)
+		if err != nil {
+			return nil, err
+		}
+		// a chunk with only the late points would overwrite the chunk once it is saved
+		if !found && a.pending(t0) {
+			return nil, errChunkPending
+		}
+		merged = mergePoints(existing, points)
+		c = encodeChunk(t0, merged, true)
+		saved = true
+	}
+
+	if saved && cluster.Manager.IsPrimaryFor(a.partition) {
+		cwr := NewChunkWriteRequest(nil, a.Key, c, a.ttl, a.ChunkSpan, time.Now())
+		a.store.Add(&cwr)
+	}
+	// only closed chunks get cached. adding the new chunk also makes sure that the next backfill of this chunk
+	// sees it, even if the store hasn't written it yet.
+	if c.Closed && b.cache != nil {
+		b.cache.Replace(a.Key, *chunk.NewBareIterGen(c.Bytes(), t0, a.ChunkSpan))
+	}
+	backfillChunksRewritten.Inc()
+	return merged, nil
+}
+
+// chunkPoints returns the points of the chunk of the metric with the given t0, and whether the chunk was found.
+// it looks in the ring buffer first, then in the cache and finally in the store.
+func (b *Backfiller) chunkPoints(ctx context.Context, a *AggMetric, t0 uint32) ([]schema.Point, bool, error) {
+	if points, ok := a.memChunkPoints(t0); ok {
+		return points, true, nil
+	}
+	if b.cache != nil {
+		res := b.cache.Search(ctx, a.Key, t0, t0+1)
+		for _, itgen := range res.Start {
+			if itgen.Ts == t0 {
+				return itgenPoints(itgen)
+			}
+		}
+	}
+	itgens, err := a.store.Search(ctx, a.Key, a.ttl, t0, t0+1)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, itgen := range itgens {
+		if itgen.Ts == t0 {
+			return itgenPoints(itgen)
+		}
+	}
+	return nil, false, nil
+}
+
+func itgenPoints(itgen chunk.IterGen) ([]schema.Point, bool, error) {
+	it, err := itgen.Get()
+	if err != nil {
+		return nil, true, err
+	}
+	return iterPoints(*it), true, nil
+}
+
+// pending returns whether the chunk with the given t0 may have been queued for saving, without having been saved yet
+func (a *AggMetric) pending(t0 uint32) bool {
+	a.RLock()
+	defer a.RUnlock()
+	return t0 <= a.lastSaveStart && t0 > a.lastSaveFinish
+}
+
+func iterPoints(it chunk.Iter) []schema.Point {
+	var points []schema.Point
+	for it.Next() {
+		ts, val := it.Values()
+		points = append(points, schema.Point{Val: val, Ts: ts})
+	}
+	return points
+}
+
+// encodeChunk returns a chunk holding the given points, which must be sorted and unique
+func encodeChunk(t0 uint32, points []schema.Point, finish bool) *chunk.Chunk {
+	c := chunk.New(t0)
+	for _, p := range points {
+		c.Push(p.Ts, p.Val)
+	}
+	if finish {
+		c.Finish()
+	}
+	return c
+}
+
+// sortPoints sorts the points by timestamp. of points with the same timestamp, only the last one is kept.
+func sortPoints(points []schema.Point) []schema.Point {
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Ts < points[j].Ts })
+	out := points[:0]
+	for i, p := range points {
+		if i+1 < len(points) && points[i+1].Ts == p.Ts {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// mergePoints merges two sorted lists of unique points. points of late replace the points of existing with the same timestamp.
+func mergePoints(existing, late []schema.Point) []schema.Point {
+	merged := make([]schema.Point, 0, len(existing)+len(late))
+	i, j := 0, 0
+	for i < len(existing) || j < len(late) {
+		switch {
+		case j == len(late) || (i < len(existing) && existing[i].Ts < late[j].Ts):
+			merged = append(merged, existing[i])
+			i++
+		case i == len(existing) || late[j].Ts < existing[i].Ts:
+			merged = append(merged, late[j])
+			j++
+		default:
+			merged = append(merged, late[j])
+			i++
+			j++
+		}
+	}
+	return merged
+}
+
+// groupPoints splits the sorted points into groups of points that belong to the same chunk
+func groupPoints(points []schema.Point, chunkSpan uint32) [][]schema.Point {
+	var groups [][]schema.Point
+	start := 0
+	for i := 1; i <= len(points); i++ {
+		if i == len(points) || points[i].Ts-points[i].Ts%chunkSpan != points[start].Ts-points[start].Ts%chunkSpan {
+			groups = append(groups, points[start:i])
+			start = i
+		}
+	}
+	return groups
+}
@@ -0,0 +1,168 @@
+package mdata
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/test"
+	opentracing "github.com/opentracing/opentracing-go"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// storedPoints returns the points of the chunk with the given t0 in the store
+func storedPoints(t *testing.T, store Store, key string, t0 uint32) []schema.Point {
+	itgens, err := store.Search(test.NewContext(), key, 0, t0, t0+1)
+	if err != nil {
+		t.Fatalf("failed to search %s: %s", key, err)
+	}
+	for _, itgen := range itgens {
+		if itgen.Ts == t0 {
+			points, _, err := itgenPoints(itgen)
+			if err != nil {
+				t.Fatalf("failed to decode chunk %d of %s: %s", t0, key, err)
+			}
+			return points
+		}
+	}
+	t.Fatalf("chunk %d of %s not found", t0, key)
+	return nil
+}
+
+func pointAt(points []schema.Point, ts uint32) (float64, bool) {
+	for _, p := range points {
+		if p.Ts == ts {
+			return p.Val, true
+		}
+	}
+	return 0, false
+}
+
+func TestBackfill(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+	SetSingleAgg(conf.Avg)
+	SetSingleSchema(
+		conf.NewRetentionMT(10, 86400, 600, 2, true),
+		conf.NewRetentionMT(300, 86400, 3600, 2, true),
+	)
+	Schemas.DefaultSchema.Backfill = true
+	Schemas.BuildIndex()
+	defer SetSingleSchema(conf.NewRetentionMT(10, 3600, 600, 2, true))
+
+	store := NewMockStore()
+	metrics := NewAggMetrics(store, &cache.MockCache{}, false, 0, 0, 0)
+	m := metrics.GetOrCreate("a", "a", 0, 0, 0, 0).(*AggMetric)
+	// the chunks up to 3000 get saved, 3000 and 3600 are in the ring buffer.
+	// the rollup chunk at 0 is saved and in memory
+	now := uint32(time.Now().Unix())
+	base := now - now%3600 - 2*3600
+	for ts := base + 10; ts <= base+3600; ts += 10 {
+		if ts == base+100 || ts == base+2000 {
+			continue
+		}
+		m.Add(ts, 1)
+	}
+
+	// a point in a saved chunk that is no longer in memory, one in a saved chunk in the ring buffer,
+	// and one that replaces an existing point in the closed chunk in the ring buffer
+	m.Add(base+100, 5)
+	m.Add(base+2000, 5)
+	m.Add(base+3050, 5)
+	if len(m.late) != 3 {
+		t.Fatalf("expected 3 late points, got %d", len(m.late))
+	}
+
+	NewBackfiller(metrics, nil, opentracing.NoopTracer{}, time.Minute).Flush()
+	if len(m.late) != 0 {
+		t.Fatalf("expected the late points to be backfilled, got %d left", len(m.late))
+	}
+
+	for ts, t0 := range map[uint32]uint32{base + 100: base, base + 2000: base + 1800, base + 3050: base + 3000} {
+		if val, ok := pointAt(storedPoints(t, store, "a", t0), ts); !ok || val != 5 {
+			t.Fatalf("expected stored point %d to be 5, got %f (found: %t)", ts, val, ok)
+		}
+	}
+	if val, ok := pointAt(resultPoints(m.Get(base+3000, base+3600)), base+3050); !ok || val != 5 {
+		t.Fatalf("expected point %d in memory to be 5, got %f (found: %t)", base+3050, val, ok)
+	}
+
+	// every span has 30 points, the late points replace a 1, or fill a gap
+	exp := map[uint32][2]float64{
+		base + 300:  {34, 30},
+		base + 600:  {30, 30},
+		base + 2100: {34, 30},
+		base + 3300: {34, 30},
+	}
+	sums := resultPoints(m.GetAggregated(consolidation.Sum, 300, base, base+3600))
+	cnts := resultPoints(m.GetAggregated(consolidation.Cnt, 300, base, base+3600))
+	storedSums := storedPoints(t, store, "a_sum_300", base)
+	for ts, e := range exp {
+		sum, _ := pointAt(sums, ts)
+		cnt, _ := pointAt(cnts, ts)
+		if sum != e[0] || cnt != e[1] {
+			t.Fatalf("expected rollup at %d to have sum %f and cnt %f, got %f and %f", ts, e[0], e[1], sum, cnt)
+		}
+		if stored, _ := pointAt(storedSums, ts); stored != e[0] {
+			t.Fatalf("expected stored rollup at %d to have sum %f, got %f", ts, e[0], stored)
+		}
+	}
+
+	// new points continue to be added to the rewritten chunks
+	m.Add(base+3610, 1)
+	if got := resultPoints(m.Get(base+3600, base+3620)); !reflect.DeepEqual(got, []schema.Point{{Val: 1, Ts: base + 3600}, {Val: 1, Ts: base + 3610}}) {
+		t.Fatalf("unexpected points in the current chunk: %v", got)
+	}
+}
+
+// late points for a chunk that may still be in the write queue are retried, rather than overwriting the chunk
+func TestBackfillPending(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+	SetSingleAgg(conf.Avg)
+	SetSingleSchema(conf.NewRetentionMT(10, 86400, 600, 2, true))
+	Schemas.DefaultSchema.Backfill = true
+	Schemas.BuildIndex()
+	defer SetSingleSchema(conf.NewRetentionMT(10, 3600, 600, 2, true))
+
+	store := NewMockStore()
+	metrics := NewAggMetrics(store, &cache.MockCache{}, false, 0, 0, 0)
+	m := metrics.GetOrCreate("a", "a", 0, 0, 0, 0).(*AggMetric)
+	now := uint32(time.Now().Unix())
+	base := now - now%3600 - 2*3600
+	c := encodeChunk(base+1200, []schema.Point{{Val: 1, Ts: base + 1210}}, true)
+	cwr := NewChunkWriteRequest(nil, "a", c, m.ttl, m.ChunkSpan, time.Now())
+	store.Add(&cwr)
+
+	// the chunk at base was queued, but is not saved
+	m.lastSaveStart = base + 600
+	m.late = []schema.Point{{Val: 5, Ts: base + 10}}
+	NewBackfiller(metrics, nil, opentracing.NoopTracer{}, time.Minute).Flush()
+	if len(m.late) != 1 {
+		t.Fatalf("expected the late point to be retried, got %d late points", len(m.late))
+	}
+	if itgens, _ := store.Search(test.NewContext(), "a", m.ttl, base, base+1); len(itgens) != 0 {
+		t.Fatalf("expected the pending chunk not to be overwritten, got %d chunks", len(itgens))
+	}
+
+	// once the chunk is saved, the late point is merged into it
+	store.Add(&ChunkWriteRequest{key: "a", chunk: encodeChunk(base, []schema.Point{{Val: 1, Ts: base + 20}}, true), ttl: m.ttl, span: m.ChunkSpan})
+	m.SyncChunkSaveState(base + 600)
+	NewBackfiller(metrics, nil, opentracing.NoopTracer{}, time.Minute).Flush()
+	if exp, got := []schema.Point{{Val: 5, Ts: base + 10}, {Val: 1, Ts: base + 20}}, storedPoints(t, store, "a", base); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected stored points %v, got %v", exp, got)
+	}
+}
+
+func TestMergePoints(t *testing.T) {
+	existing := []schema.Point{{Val: 1, Ts: 10}, {Val: 1, Ts: 20}, {Val: 1, Ts: 40}}
+	late := sortPoints([]schema.Point{{Val: 2, Ts: 50}, {Val: 2, Ts: 20}, {Val: 3, Ts: 5}, {Val: 4, Ts: 20}})
+	exp := []schema.Point{{Val: 3, Ts: 5}, {Val: 1, Ts: 10}, {Val: 4, Ts: 20}, {Val: 1, Ts: 40}, {Val: 2, Ts: 50}}
+	if got := mergePoints(existing, late); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
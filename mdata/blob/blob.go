@@ -0,0 +1,22 @@
+// Package blob provides object stores for immutable blobs, used to offload cold chunks from the primary store.
+package blob
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store stores immutable blobs by name.
+// names are slash separated paths, e.g. "2419200/1.2345_sum_3600/1500"
+type Store interface {
+	// Put saves the blob, replacing any existing blob of the same name
+	Put(ctx context.Context, name string, data []byte) error
+	// Get returns the blob, or ErrNotFound if it doesn't exist
+	Get(ctx context.Context, name string) ([]byte, error)
+	// List returns the names of all blobs starting with the given prefix, in lexical order
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes the blob. deleting a blob that doesn't exist is not an error
+	Delete(ctx context.Context, name string) error
+}
@@ -0,0 +1,102 @@
+package blob
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const tmpSuffix = ".tmp"
+
+// DirStore stores blobs as files in a local directory
+type DirStore struct {
+	dir string
+}
+
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+func (d *DirStore) path(name string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(name))
+}
+
+// Put writes the blob to a temporary file first, so that readers never see a partial blob
+func (d *DirStore) Put(ctx context.Context, name string, data []byte) error {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + tmpSuffix
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (d *DirStore) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(d.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (d *DirStore) List(ctx context.Context, prefix string) ([]string, error) {
+	names := make([]string, 0)
+	// only walk the directory the prefix points into
+	root := d.dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = d.path(prefix[:i])
+	}
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return names, nil
+	}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, tmpSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+func (d *DirStore) Delete(ctx context.Context, name string) error {
+	err := os.Remove(d.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
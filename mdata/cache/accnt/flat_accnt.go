@@ -51,6 +51,7 @@ type FlatAccntMet struct {
 // event types to be used in FlatAccntEvent
 const evnt_hit_chnk uint8 = 4
 const evnt_add_chnk uint8 = 5
+const evnt_del_met uint8 = 6
 const evnt_stop uint8 = 100
 const evnt_reset uint8 = 101
 
@@ -72,6 +73,11 @@ type HitPayload struct {
 	ts     uint32
 }
 
+// payload to be sent with a delete metric event
+type DelMetPayload struct {
+	metric string
+}
+
 func NewFlatAccnt(maxSize uint64) *FlatAccnt {
 	accnt := FlatAccnt{
 		metrics: make(map[string]*FlatAccntMet),
@@ -94,6 +100,10 @@ func (a *FlatAccnt) HitChunk(metric string, ts uint32) {
 	a.act(evnt_hit_chnk, &HitPayload{metric, ts})
 }
 
+func (a *FlatAccnt) DelMetric(metric string) {
+	a.act(evnt_del_met, &DelMetPayload{metric})
+}
+
 func (a *FlatAccnt) Stop() {
 	a.act(evnt_stop, nil)
 }
@@ -139,6 +149,9 @@ func (a *FlatAccnt) eventLoop() {
 						Ts:     payload.ts,
 					},
 				)
+			case evnt_del_met:
+				payload := event.pl.(*DelMetPayload)
+				a.delMet(payload.metric)
 			case evnt_stop:
 				return
 			case evnt_reset:
@@ -168,8 +181,12 @@ func (a *FlatAccnt) add(metric string, ts uint32, size uint64) {
 		cacheMetricAdd.Inc()
 	}
 
-	if _, ok = met.chunks[ts]; ok {
-		// we already have that chunk
+	if old, ok := met.chunks[ts]; ok {
+		// we already have that chunk, but it may have been replaced by a chunk of another size
+		met.chunks[ts] = size
+		met.total = met.total - old + size
+		a.total = a.total - old + size
+		cacheSizeUsed.SetUint64(a.total)
 		return
 	}
 
@@ -179,6 +196,27 @@ func (a *FlatAccnt) add(metric string, ts uint32, size uint64) {
 	cacheSizeUsed.SetUint64(a.total)
 }
 
+// delMet removes all accounting for the given metric.
+// unlike evict, it does not request the chunks to be evicted from the cache,
+// as the cache has already removed them.
+func (a *FlatAccnt) delMet(metric string) {
+	met, ok := a.metrics[metric]
+	if !ok {
+		return
+	}
+	for ts := range met.chunks {
+		a.lru.del(
+			EvictTarget{
+				Metric: metric,
+				Ts:     ts,
+			},
+		)
+	}
+	a.total = a.total - met.total
+	cacheSizeUsed.SetUint64(a.total)
+	delete(a.metrics, metric)
+}
+
 func (a *FlatAccnt) evict() {
 	var met *FlatAccntMet
 	var targets []uint32
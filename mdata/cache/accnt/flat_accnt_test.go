@@ -104,3 +104,45 @@ func TestLRUOrdering(t *testing.T) {
 		t.Fatalf("Expected evict counter to be at 1, got %d", peek)
 	}
 }
+
+func TestDelMetric(t *testing.T) {
+	a := NewFlatAccnt(10)
+	cacheChunkEvict.SetUint32(0)
+	evictQ := a.GetEvictQ()
+
+	var et *EvictTarget
+	var metric1 string = "metric1"
+	var metric2 string = "metric2"
+	var ts1 uint32 = 1
+	var ts2 uint32 = 2
+
+	a.AddChunk(metric1, ts1, 3) // total size now 3
+	a.AddChunk(metric1, ts2, 3) // total size now 6
+	a.AddChunk(metric2, ts1, 3) // total size now 9
+	a.DelMetric(metric1)        // total size now 3
+	a.AddChunk(metric2, ts2, 5) // total size now 8
+
+	select {
+	case et := <-evictQ:
+		t.Fatalf("Expected the EvictQ to be empty, got %+v", et)
+	default:
+	}
+
+	a.AddChunk(metric2, 3, 5) // total size now 13
+
+	// metric1 is not known anymore, so the oldest chunk of metric2 is evicted first
+	et = <-evictQ // total size now 10
+	if et.Metric != metric2 || et.Ts != ts1 {
+		t.Fatalf("Returned evict target is not as expected, got %+v", et)
+	}
+
+	select {
+	case et := <-evictQ:
+		t.Fatalf("Expected the EvictQ to be empty, got %+v", et)
+	default:
+	}
+
+	if peek := cacheChunkEvict.Peek(); peek != 1 {
+		t.Fatalf("Expected evict counter to be at 1, got %d", peek)
+	}
+}
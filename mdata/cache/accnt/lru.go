@@ -36,6 +36,13 @@ func (l *LRU) pop() interface{} {
 	return e
 }
 
+func (l *LRU) del(key interface{}) {
+	if ent, ok := l.items[key]; ok {
+		l.list.Remove(ent)
+		delete(l.items, key)
+	}
+}
+
 func (l *LRU) reset() {
 	for {
 		if l.pop() == nil {
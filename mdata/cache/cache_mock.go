@@ -12,6 +12,8 @@ type MockCache struct {
 	CacheIfHotCb    func()
 	StopCount       int
 	SearchCount     int
+	DelMetricCount  int
+	ReplaceCount    int
 }
 
 func (mc *MockCache) Add(m string, t uint32, i chunk.IterGen) {
@@ -20,6 +22,12 @@ func (mc *MockCache) Add(m string, t uint32, i chunk.IterGen) {
 	mc.AddCount++
 }
 
+func (mc *MockCache) Replace(m string, i chunk.IterGen) {
+	mc.Lock()
+	defer mc.Unlock()
+	mc.ReplaceCount++
+}
+
 func (mc *MockCache) CacheIfHot(m string, t uint32, i chunk.IterGen) {
 	mc.Lock()
 	defer mc.Unlock()
@@ -41,3 +49,10 @@ func (mc *MockCache) Search(m string, f uint32, u uint32) *CCSearchResult {
 	mc.SearchCount++
 	return nil
 }
+
+func (mc *MockCache) DelMetric(m string) int {
+	mc.Lock()
+	defer mc.Unlock()
+	mc.DelMetricCount++
+	return 0
+}
@@ -112,6 +112,41 @@ func (c *CCache) Add(metric string, prev uint32, itergen chunk.IterGen) {
 	c.accnt.AddChunk(metric, itergen.Ts, itergen.Size())
 }
 
+// Replace adds the chunk to the cache of the metric, replacing the cached chunk with the same ts, if any.
+// unlike Add, which keeps the cached chunk, it is meant for chunks that were rewritten.
+func (c *CCache) Replace(metric string, itergen chunk.IterGen) {
+	c.Lock()
+	defer c.Unlock()
+
+	if ccm, ok := c.metricCache[metric]; !ok {
+		ccm = NewCCacheMetric()
+		ccm.Init(0, itergen)
+		c.metricCache[metric] = ccm
+	} else {
+		ccm.Replace(itergen)
+	}
+
+	c.accnt.AddChunk(metric, itergen.Ts, itergen.Size())
+}
+
+// DelMetric removes all cached chunks of the given metric
+// and returns how many chunks were removed
+func (c *CCache) DelMetric(metric string) int {
+	c.Lock()
+	defer c.Unlock()
+
+	cm, ok := c.metricCache[metric]
+	if !ok {
+		return 0
+	}
+	cm.RLock()
+	deleted := len(cm.chunks)
+	cm.RUnlock()
+	delete(c.metricCache, metric)
+	c.accnt.DelMetric(metric)
+	return deleted
+}
+
 func (cc *CCache) Reset() {
 	cc.accnt.Reset()
 	cc.Lock()
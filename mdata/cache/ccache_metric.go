@@ -110,6 +110,19 @@ func (mc *CCacheMetric) Add(prev uint32, itergen chunk.IterGen) {
 	return
 }
 
+// Replace replaces the chunk with the same ts, keeping its links to the previous and next chunks,
+// or adds it if there is no such chunk
+func (mc *CCacheMetric) Replace(itergen chunk.IterGen) {
+	mc.Lock()
+	if c, ok := mc.chunks[itergen.Ts]; ok {
+		c.Itgen = itergen
+		mc.Unlock()
+		return
+	}
+	mc.Unlock()
+	mc.Add(0, itergen)
+}
+
 // generate sorted slice of all chunk timestamps
 // assumes we have at least read lock
 func (mc *CCacheMetric) generateKeys() {
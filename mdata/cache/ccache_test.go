@@ -206,6 +206,33 @@ func TestConsecutiveAdding(t *testing.T) {
 	}
 }
 
+// tests that replacing a chunk keeps the other chunks of the metric and its links to them
+func TestReplace(t *testing.T) {
+	metric := "metric1"
+	cc := getConnectedChunks(t, metric)
+
+	itgen := getItgen(t, []uint32{6, 7, 8, 9, 10}, 1005, false)
+	cc.Replace(metric, itgen)
+
+	mc := cc.metricCache[metric]
+	if len(mc.chunks) != 5 {
+		t.Fatalf("expected 5 chunks to remain cached, got %d", len(mc.chunks))
+	}
+	replaced := mc.chunks[1005]
+	if !bytes.Equal(replaced.Itgen.B, itgen.B) {
+		t.Fatalf("expected chunk 1005 to be replaced")
+	}
+	if replaced.Prev != 1000 || replaced.Next != 1010 {
+		t.Fatalf("expected chunk 1005 to remain linked to 1000 and 1010, got %d and %d", replaced.Prev, replaced.Next)
+	}
+
+	// chunks that are not cached yet get added
+	cc.Replace(metric, getItgen(t, []uint32{1, 2, 3, 4, 5}, 1025, false))
+	if chunk, ok := mc.chunks[1025]; !ok || chunk.Prev != 1020 {
+		t.Fatalf("expected chunk 1025 to be added after 1020")
+	}
+}
+
 // tests if chunks get connected to previous even if it is is not specified, based on span
 func TestDisconnectedAdding(t *testing.T) {
 	metric := "metric1"
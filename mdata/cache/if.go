@@ -8,9 +8,11 @@ import (
 
 type Cache interface {
 	Add(string, uint32, chunk.IterGen)
+	Replace(string, chunk.IterGen)
 	CacheIfHot(string, uint32, chunk.IterGen)
 	Stop()
 	Search(context.Context, string, uint32, uint32) *CCSearchResult
+	DelMetric(string) int
 }
 
 type CachePusher interface {
@@ -16,6 +16,7 @@ type Chunk struct {
 	LastTs    uint32 // last TS seen, not computed or anything
 	NumPoints uint32
 	Closed    bool
+	Sketches  [][]byte // the marshaled sketch of every point, for chunks of percentile rollups. see sketch.go
 }
 
 func New(t0 uint32) *Chunk {
@@ -50,3 +51,29 @@ func (c *Chunk) Finish() {
 	c.Closed = true
 	c.Series.Finish()
 }
+
+// Encode returns the data of the finished chunk prefixed with the header of its format, as it is saved in the stores.
+// it uses the most compact format for the data: if IntervalIntFormat is enabled, series of integers at a constant interval
+// are stored as FormatIntervalIntWithSpan if that's smaller, all others as FormatStandardGoTszWithSpan.
+// chunks with sketches are stored as FormatSketchWithSpan.
+func (c *Chunk) Encode(span uint32) []byte {
+	data := c.Series.Bytes()
+	if c.Sketches != nil {
+		return encode(FormatSketchWithSpan, span, encodeSketches(data, c.Sketches))
+	}
+	if !IntervalIntFormat {
+		return encode(FormatStandardGoTszWithSpan, span, data)
+	}
+	ts := make([]uint32, 0, c.NumPoints)
+	vals := make([]float64, 0, c.NumPoints)
+	it := c.Series.Iter()
+	for it.Next() {
+		t, v := it.Values()
+		ts = append(ts, t)
+		vals = append(vals, v)
+	}
+	if compact, ok := encodeIntervalInt(ts, vals); ok && len(compact) < len(data) {
+		return encode(FormatIntervalIntWithSpan, span, compact)
+	}
+	return encode(FormatStandardGoTszWithSpan, span, data)
+}
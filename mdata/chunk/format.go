@@ -11,4 +11,6 @@ type Format uint8
 const (
 	FormatStandardGoTsz Format = iota
 	FormatStandardGoTszWithSpan
+	FormatIntervalIntWithSpan // integer values at a constant interval. see interval.go
+	FormatSketchWithSpan      // a quantile sketch per point, for percentile rollups. see sketch.go
 )
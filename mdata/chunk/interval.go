@@ -0,0 +1,319 @@
+package chunk
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// FormatIntervalIntWithSpan stores series of integer values at a constant interval, like most counters, compactly.
+//
+// the timestamps are not stored individually: the data starts with the number of points, the first timestamp and the interval,
+// followed by the number of intervals between the first and last point. if that is more than the number of points, the series has gaps,
+// and a bitmap marks which intervals have a point. all of these are uvarints, except the bitmap.
+//
+// this is followed by the first value as a varint, and a bitstream with the delta-of-delta of every subsequent value:
+//
+//	'0'                 delta-of-delta is 0
+//	'10'   + 7 bits     delta-of-delta between -64 and 63
+//	'110'  + 16 bits    delta-of-delta between -32768 and 32767
+//	'1110' + 32 bits    delta-of-delta between -2^31 and 2^31-1
+//	'1111' + 64 bits    any other delta-of-delta
+//
+// so a counter that increases at a steady rate takes 1 bit per point, and a constant gauge as well.
+
+// IntervalIntFormat enables storing chunks in FormatIntervalIntWithSpan.
+// versions that don't know the format can't read such chunks, so it should only be enabled once all nodes
+// of the cluster, and the tools that read chunks, have been upgraded.
+var IntervalIntFormat = false
+
+// maxIntervalInt is the biggest absolute value we encode. beyond it, not all integers can be represented by a float64
+const maxIntervalInt = 1 << 53
+
+// maxIntervalIntSlotsFactor limits the size of the bitmap of series with gaps, relative to the number of points.
+// series that are more sparse, or that don't have a common interval at all, are better off with go-tsz
+const maxIntervalIntSlotsFactor = 8
+
+var errIntervalIntCorrupt = errors.New("corrupt data, interval int chunk ends unexpectedly")
+
+// isIntervalInt returns whether the value can be stored in FormatIntervalIntWithSpan
+func isIntervalInt(val float64) bool {
+	return val == math.Trunc(val) && math.Abs(val) < maxIntervalInt && !(val == 0 && math.Signbit(val))
+}
+
+func gcd(a, b uint32) uint32 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// encodeIntervalInt encodes the points in FormatIntervalIntWithSpan, without the format header.
+// it returns false if the points can't, or shouldn't, be stored in that format.
+func encodeIntervalInt(ts []uint32, vals []float64) ([]byte, bool) {
+	if len(ts) == 0 {
+		return nil, false
+	}
+	var interval uint32
+	for i, val := range vals {
+		if !isIntervalInt(val) {
+			return nil, false
+		}
+		if i > 0 {
+			interval = gcd(interval, ts[i]-ts[i-1])
+		}
+	}
+	slots := uint32(1)
+	if interval > 0 {
+		slots = (ts[len(ts)-1]-ts[0])/interval + 1
+	}
+	if slots > uint32(len(ts))*maxIntervalIntSlotsFactor {
+		return nil, false
+	}
+
+	w := &bitWriter{}
+	w.writeUvarint(uint64(len(ts)))
+	w.writeUvarint(uint64(ts[0]))
+	w.writeUvarint(uint64(interval))
+	w.writeUvarint(uint64(slots))
+	if slots != uint32(len(ts)) {
+		bitmap := make([]byte, (slots+7)/8)
+		for _, t := range ts {
+			slot := (t - ts[0]) / interval
+			bitmap[slot/8] |= 0x80 >> (slot % 8)
+		}
+		w.b = append(w.b, bitmap...)
+	}
+	w.writeVarint(int64(vals[0]))
+
+	var prev, delta int64 = int64(vals[0]), 0
+	for _, val := range vals[1:] {
+		v := int64(val)
+		dod := v - prev - delta
+		switch {
+		case dod == 0:
+			w.writeBits(0, 1)
+		case dod >= -(1<<6) && dod < 1<<6:
+			w.writeBits(0x2, 2)
+			w.writeBits(uint64(dod), 7)
+		case dod >= -(1<<15) && dod < 1<<15:
+			w.writeBits(0x6, 3)
+			w.writeBits(uint64(dod), 16)
+		case dod >= -(1<<31) && dod < 1<<31:
+			w.writeBits(0xe, 4)
+			w.writeBits(uint64(dod), 32)
+		default:
+			w.writeBits(0xf, 4)
+			w.writeBits(uint64(dod), 64)
+		}
+		delta = v - prev
+		prev = v
+	}
+	return w.b, true
+}
+
+// intervalIntIter iterates over the points of a chunk in FormatIntervalIntWithSpan
+type intervalIntIter struct {
+	r        bitReader
+	n        uint32 // number of points left
+	first    uint32
+	interval uint32
+	slots    uint32
+	bitmap   []byte
+
+	slot    uint32 // slot of the current point
+	started bool
+	val     int64
+	delta   int64
+	err     error
+}
+
+func newIntervalIntIter(b []byte) (*intervalIntIter, error) {
+	r := bitReader{b: b}
+	var hdr [4]uint64
+	for i := range hdr {
+		v, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if v > math.MaxUint32 {
+			return nil, errIntervalIntCorrupt
+		}
+		hdr[i] = v
+	}
+	n, first, interval, slots := uint32(hdr[0]), uint32(hdr[1]), uint32(hdr[2]), uint32(hdr[3])
+	if n == 0 || slots < n || (slots > 1 && interval == 0) {
+		return nil, errIntervalIntCorrupt
+	}
+	it := &intervalIntIter{
+		n:        n,
+		first:    first,
+		interval: interval,
+		slots:    slots,
+	}
+	var err error
+	if slots != n {
+		it.bitmap, err = r.readBytes(int((slots + 7) / 8))
+		if err != nil {
+			return nil, err
+		}
+	}
+	it.val, err = r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	it.r = r
+	return it, nil
+}
+
+// Next advances to the next point and returns whether there is one
+func (it *intervalIntIter) Next() bool {
+	if it.err != nil || it.n == 0 {
+		return false
+	}
+	it.n--
+	if !it.started {
+		it.started = true
+		return true
+	}
+	it.slot++
+	for it.bitmap != nil && it.slot < it.slots && it.bitmap[it.slot/8]&(0x80>>(it.slot%8)) == 0 {
+		it.slot++
+	}
+	if it.slot >= it.slots {
+		it.err = errIntervalIntCorrupt
+		return false
+	}
+	dod, err := it.readDod()
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.delta += dod
+	it.val += it.delta
+	return true
+}
+
+// readDod reads the delta-of-delta of the next value
+func (it *intervalIntIter) readDod() (int64, error) {
+	ones := 0
+	for ones < 4 {
+		bit, err := it.r.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 0 {
+			break
+		}
+		ones++
+	}
+	if ones == 0 {
+		return 0, nil
+	}
+	nbits := []uint{7, 16, 32, 64}[ones-1]
+	v, err := it.r.readBits(nbits)
+	if err != nil {
+		return 0, err
+	}
+	// sign extend
+	if nbits < 64 && v&(1<<(nbits-1)) != 0 {
+		v |= ^uint64(0) << nbits
+	}
+	return int64(v), nil
+}
+
+// Values returns the timestamp and value of the current point
+func (it *intervalIntIter) Values() (uint32, float64) {
+	return it.first + it.slot*it.interval, float64(it.val)
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *intervalIntIter) Err() error {
+	return it.err
+}
+
+// bitWriter appends bits to a byte slice, most significant bit first.
+// it can also append varints, as long as no bits have been written yet.
+type bitWriter struct {
+	b     []byte
+	count uint // number of bits still available in the last byte
+}
+
+func (w *bitWriter) writeBits(v uint64, nbits uint) {
+	for nbits > 0 {
+		if w.count == 0 {
+			w.b = append(w.b, 0)
+			w.count = 8
+		}
+		n := nbits
+		if n > w.count {
+			n = w.count
+		}
+		bits := byte(v>>(nbits-n)) & byte(1<<n-1)
+		w.b[len(w.b)-1] |= bits << (w.count - n)
+		w.count -= n
+		nbits -= n
+	}
+}
+
+func (w *bitWriter) writeUvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.b = append(w.b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func (w *bitWriter) writeVarint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.b = append(w.b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+// bitReader reads the data written by bitWriter
+type bitReader struct {
+	b   []byte
+	pos uint // position of the next bit to read
+}
+
+func (r *bitReader) readBits(nbits uint) (uint64, error) {
+	if r.pos+nbits > uint(len(r.b))*8 {
+		return 0, errIntervalIntCorrupt
+	}
+	var v uint64
+	for nbits > 0 {
+		avail := 8 - r.pos%8
+		n := nbits
+		if n > avail {
+			n = avail
+		}
+		bits := (r.b[r.pos/8] >> (avail - n)) & byte(1<<n-1)
+		v = v<<n | uint64(bits)
+		r.pos += n
+		nbits -= n
+	}
+	return v, nil
+}
+
+func (r *bitReader) readUvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.b[r.pos/8:])
+	if n <= 0 {
+		return 0, errIntervalIntCorrupt
+	}
+	r.pos += uint(n) * 8
+	return v, nil
+}
+
+func (r *bitReader) readVarint() (int64, error) {
+	v, n := binary.Varint(r.b[r.pos/8:])
+	if n <= 0 {
+		return 0, errIntervalIntCorrupt
+	}
+	r.pos += uint(n) * 8
+	return v, nil
+}
+
+func (r *bitReader) readBytes(n int) ([]byte, error) {
+	start := int(r.pos / 8)
+	if start+n > len(r.b) {
+		return nil, errIntervalIntCorrupt
+	}
+	r.pos += uint(n) * 8
+	return r.b[start : start+n], nil
+}
@@ -0,0 +1,223 @@
+package chunk
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/dgryski/go-tsz/testdata"
+)
+
+type point struct {
+	ts  uint32
+	val float64
+}
+
+func newTestChunk(t0 uint32, points []point) *Chunk {
+	c := New(t0)
+	for _, p := range points {
+		c.Push(p.ts, p.val)
+	}
+	c.Finish()
+	return c
+}
+
+func decodeTestChunk(t testing.TB, data []byte, t0 uint32) (Format, []point) {
+	itgen, err := NewGen(data, t0)
+	if err != nil {
+		t.Fatalf("failed to create itergen: %s", err)
+	}
+	it, err := itgen.Get()
+	if err != nil {
+		t.Fatalf("failed to get iterator: %s", err)
+	}
+	var points []point
+	for it.Next() {
+		ts, val := it.Values()
+		points = append(points, point{ts, val})
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("failed to iterate: %s", err)
+	}
+	return itgen.Format, points
+}
+
+// datasets returns series that are typical for their kind, each spanning a 2 hour chunk starting at t0
+func datasets(t0 uint32) map[string][]point {
+	r := rand.New(rand.NewSource(1))
+	sets := make(map[string][]point)
+
+	var counter, steady, gaps, walk, cpu, zero []point
+	var c, s, g, w float64 = 1e6, 0, 5000, 100
+	for ts := t0 + 10; ts < t0+7200; ts += 10 {
+		// a requests counter with a varying rate
+		c += float64(90 + r.Intn(20))
+		counter = append(counter, point{ts, c})
+		// a counter that increases at a steady rate
+		s += 60
+		steady = append(steady, point{ts, s})
+		// a counter whose agent misses some intervals
+		g += float64(r.Intn(5))
+		if r.Intn(20) != 0 {
+			gaps = append(gaps, point{ts, g})
+		}
+		// a gauge, like the number of open connections
+		w += float64(r.Intn(11) - 5)
+		walk = append(walk, point{ts, w})
+		// a float gauge, like cpu usage
+		cpu = append(cpu, point{ts, math.Floor(r.Float64()*10000) / 100})
+		// an error counter that stays at 0
+		zero = append(zero, point{ts, 0})
+	}
+	sets["counter"] = counter
+	sets["steady-counter"] = steady
+	sets["counter-with-gaps"] = gaps
+	sets["int-gauge"] = walk
+	sets["float-gauge"] = cpu
+	sets["zero"] = zero
+
+	// real world data: an integer gauge at a 60s interval
+	var real []point
+	for _, p := range testdata.TwoHoursData {
+		real = append(real, point{t0 + (p.T - testdata.TwoHoursData[0].T), p.V})
+	}
+	sets["tsz-testdata"] = real
+	return sets
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	IntervalIntFormat = true
+	defer func() { IntervalIntFormat = false }()
+	t0 := uint32(1440583200)
+	exp := map[string]Format{
+		"counter":           FormatIntervalIntWithSpan,
+		"steady-counter":    FormatIntervalIntWithSpan,
+		"counter-with-gaps": FormatIntervalIntWithSpan,
+		"int-gauge":         FormatIntervalIntWithSpan,
+		"float-gauge":       FormatStandardGoTszWithSpan,
+		"zero":              FormatIntervalIntWithSpan,
+		"tsz-testdata":      FormatIntervalIntWithSpan,
+	}
+	sets := datasets(t0)
+	sets["single-point"] = []point{{t0 + 5, 42}}
+	exp["single-point"] = FormatIntervalIntWithSpan
+	sets["irregular"] = []point{{t0 + 1, 1}, {t0 + 1000, 2}, {t0 + 7199, 3}}
+	exp["irregular"] = FormatStandardGoTszWithSpan
+
+	for name, points := range sets {
+		c := newTestChunk(t0, points)
+		format, got := decodeTestChunk(t, c.Encode(7200), t0)
+		if format != exp[name] {
+			t.Fatalf("%s: expected format %d, got %d", name, exp[name], format)
+		}
+		if !reflect.DeepEqual(got, points) {
+			t.Fatalf("%s: expected points %v, got %v", name, points, got)
+		}
+	}
+}
+
+func TestEncodeIntervalIntEligible(t *testing.T) {
+	cases := []struct {
+		ts   []uint32
+		vals []float64
+		ok   bool
+	}{
+		{[]uint32{10, 20, 30}, []float64{1, 2, 3}, true},
+		{[]uint32{10, 20, 30}, []float64{1, 2.5, 3}, false},
+		{[]uint32{10, 20, 30}, []float64{1, math.NaN(), 3}, false},
+		{[]uint32{10, 20, 30}, []float64{1, math.Inf(1), 3}, false},
+		{[]uint32{10, 20, 30}, []float64{1, math.Copysign(0, -1), 3}, false},
+		{[]uint32{10, 20, 30}, []float64{1, 1 << 53, 3}, false},
+		// delta-of-deltas of all sizes
+		{[]uint32{10, 20, 30, 40, 50, 60, 70, 80}, []float64{-1 << 52, 1 << 52, -3, 1<<40 + 7, -1 << 52, 5, 5, 70000}, true},
+		// gcd of 10 and 15 is 5, so only 5 out of 13 slots are used
+		{[]uint32{10, 20, 35, 45, 70}, []float64{1, 2, 3, 4, 5}, true},
+		// too sparse
+		{[]uint32{10, 11, 1000}, []float64{1, 2, 3}, false},
+	}
+	for i, c := range cases {
+		data, ok := encodeIntervalInt(c.ts, c.vals)
+		if ok != c.ok {
+			t.Fatalf("case %d: expected ok %t, got %t", i, c.ok, ok)
+		}
+		if !ok {
+			continue
+		}
+		it, err := newIntervalIntIter(data)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		for j := range c.ts {
+			if !it.Next() {
+				t.Fatalf("case %d: expected point %d, got end of data (err: %v)", i, j, it.Err())
+			}
+			if ts, val := it.Values(); ts != c.ts[j] || val != c.vals[j] {
+				t.Fatalf("case %d: expected point %d to be %d:%f, got %d:%f", i, j, c.ts[j], c.vals[j], ts, val)
+			}
+		}
+		if it.Next() {
+			t.Fatalf("case %d: expected end of data", i)
+		}
+	}
+}
+
+func TestIntervalIntCorrupt(t *testing.T) {
+	data, _ := encodeIntervalInt([]uint32{10, 20, 30, 40}, []float64{1, 200, 3, 4000000})
+	for i := 0; i < len(data); i++ {
+		it, err := newIntervalIntIter(data[:i])
+		if err != nil {
+			continue
+		}
+		for it.Next() {
+		}
+		if it.Err() == nil {
+			t.Fatalf("expected truncating the data to %d bytes to result in an error", i)
+		}
+	}
+}
+
+// BenchmarkCompression reports the bytes per point of the chunks of typical series.
+// run with -benchtime 1x to only see the sizes.
+func BenchmarkCompression(b *testing.B) {
+	IntervalIntFormat = true
+	defer func() { IntervalIntFormat = false }()
+	t0 := uint32(1440583200)
+	for name, points := range datasets(t0) {
+		c := newTestChunk(t0, points)
+		b.Run(name, func(b *testing.B) {
+			var data []byte
+			for i := 0; i < b.N; i++ {
+				data = c.Encode(7200)
+			}
+			b.ReportMetric(float64(len(c.Series.Bytes()))/float64(len(points)), "tsz-bytes/point")
+			b.ReportMetric(float64(len(data)-2)/float64(len(points)), "bytes/point")
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	IntervalIntFormat = true
+	defer func() { IntervalIntFormat = false }()
+	t0 := uint32(1440583200)
+	for name, points := range datasets(t0) {
+		data := newTestChunk(t0, points).Encode(7200)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				decodeTestChunk(b, data, t0)
+			}
+		})
+	}
+}
+
+func TestEncodeIntervalIntDisabled(t *testing.T) {
+	t0 := uint32(1440583200)
+	points := datasets(t0)["counter"]
+	format, got := decodeTestChunk(t, newTestChunk(t0, points).Encode(7200), t0)
+	if format != FormatStandardGoTszWithSpan {
+		t.Fatalf("expected format %d when the interval int format is disabled, got %d", FormatStandardGoTszWithSpan, format)
+	}
+	if !reflect.DeepEqual(got, points) {
+		t.Fatalf("expected points %v, got %v", points, got)
+	}
+}
@@ -2,14 +2,35 @@ package chunk
 
 import (
 	"github.com/dgryski/go-tsz"
+	"github.com/grafana/metrictank/sketch"
 )
 
+// Iter iterates over the points of a chunk, whatever its format
 type Iter struct {
-	*tsz.Iter
+	iter
+	T0 uint32
+}
+
+type iter interface {
+	Next() bool
+	Values() (uint32, float64)
+	Err() error
 }
 
 func NewIter(i *tsz.Iter) Iter {
 	return Iter{
 		i,
+		i.T0,
+	}
+}
+
+// Sketch returns the sketch of the current point, for chunks of percentile rollups
+func (it Iter) Sketch() (*sketch.Sketch, error) {
+	s, ok := it.iter.(interface {
+		Sketch() (*sketch.Sketch, error)
+	})
+	if !ok {
+		return nil, errNoSketch
 	}
+	return s.Sketch()
 }
@@ -2,6 +2,7 @@ package chunk
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/dgryski/go-tsz"
 )
@@ -13,18 +14,20 @@ var (
 
 //go:generate msgp
 type IterGen struct {
-	B    []byte
-	Ts   uint32
-	Span uint32
+	B      []byte
+	Ts     uint32
+	Span   uint32
+	Format Format // format of B. the zero value is go-tsz, which is what chunks in memory use
 }
 
 func NewGen(b []byte, ts uint32) (*IterGen, error) {
 	var span uint32 = 0
+	format := Format(b[0])
 
-	switch Format(b[0]) {
+	switch format {
 	case FormatStandardGoTsz:
 		b = b[1:]
-	case FormatStandardGoTszWithSpan:
+	case FormatStandardGoTszWithSpan, FormatIntervalIntWithSpan, FormatSketchWithSpan:
 		if int(b[1]) >= len(ChunkSpans) {
 			return nil, errUnknownSpanCode
 		}
@@ -38,14 +41,32 @@ func NewGen(b []byte, ts uint32) (*IterGen, error) {
 		b,
 		ts,
 		span,
+		format,
 	}, nil
 }
 
+// NewBareIterGen returns an IterGen for go-tsz data without format header, like the data of chunks in memory
 func NewBareIterGen(b []byte, ts uint32, span uint32) *IterGen {
-	return &IterGen{b, ts, span}
+	return &IterGen{b, ts, span, FormatStandardGoTsz}
 }
 
 func (ig *IterGen) Get() (*Iter, error) {
+	if ig.Format == FormatIntervalIntWithSpan {
+		// the data is only read, no need to copy it
+		it, err := newIntervalIntIter(ig.B)
+		if err != nil {
+			return nil, err
+		}
+		return &Iter{it, ig.Ts}, nil
+	}
+	if ig.Format == FormatSketchWithSpan {
+		it, err := newSketchIter(ig.B)
+		if err != nil {
+			return nil, err
+		}
+		return &Iter{it, it.T0}, nil
+	}
+
 	b := make([]byte, len(ig.B), len(ig.B))
 	copy(b, ig.B)
 	it, err := tsz.NewIterator(b)
@@ -53,7 +74,31 @@ func (ig *IterGen) Get() (*Iter, error) {
 		return nil, err
 	}
 
-	return &Iter{it}, nil
+	return &Iter{it, it.T0}, nil
+}
+
+// Encode returns the data prefixed with the header of its format, as it is saved in the stores
+func (ig IterGen) Encode() []byte {
+	if ig.Span == 0 && (ig.Format == FormatStandardGoTsz || ig.Format == FormatStandardGoTszWithSpan) {
+		return append([]byte{byte(FormatStandardGoTsz)}, ig.B...)
+	}
+	format := ig.Format
+	if format == FormatStandardGoTsz {
+		format = FormatStandardGoTszWithSpan
+	}
+	return encode(format, ig.Span, ig.B)
+}
+
+// encode returns the data prefixed with the format and the code of the span
+func encode(format Format, span uint32, data []byte) []byte {
+	spanCode, ok := RevChunkSpans[span]
+	if !ok {
+		// it's probably better to panic than to persist the chunk with a wrong length
+		panic(fmt.Sprintf("Chunk span invalid: %d", span))
+	}
+	buf := make([]byte, 0, len(data)+2)
+	buf = append(buf, byte(format), byte(spanCode))
+	return append(buf, data...)
 }
 
 func (ig *IterGen) Size() uint64 {
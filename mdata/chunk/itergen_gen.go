@@ -12,13 +12,13 @@ import (
 func (z *IterGen) DecodeMsg(dc *msgp.Reader) (err error) {
 	var field []byte
 	_ = field
-	var zxvk uint32
-	zxvk, err = dc.ReadMapHeader()
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
 	if err != nil {
 		return
 	}
-	for zxvk > 0 {
-		zxvk--
+	for zb0001 > 0 {
+		zb0001--
 		field, err = dc.ReadMapKeyPtr()
 		if err != nil {
 			return
@@ -39,6 +39,15 @@ func (z *IterGen) DecodeMsg(dc *msgp.Reader) (err error) {
 			if err != nil {
 				return
 			}
+		case "Format":
+			{
+				var zb0002 uint8
+				zb0002, err = dc.ReadUint8()
+				if err != nil {
+					return
+				}
+				z.Format = Format(zb0002)
+			}
 		default:
 			err = dc.Skip()
 			if err != nil {
@@ -51,11 +60,11 @@ func (z *IterGen) DecodeMsg(dc *msgp.Reader) (err error) {
 
 // EncodeMsg implements msgp.Encodable
 func (z *IterGen) EncodeMsg(en *msgp.Writer) (err error) {
-	// map header, size 3
+	// map header, size 4
 	// write "B"
-	err = en.Append(0x83, 0xa1, 0x42)
+	err = en.Append(0x84, 0xa1, 0x42)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteBytes(z.B)
 	if err != nil {
@@ -64,7 +73,7 @@ func (z *IterGen) EncodeMsg(en *msgp.Writer) (err error) {
 	// write "Ts"
 	err = en.Append(0xa2, 0x54, 0x73)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteUint32(z.Ts)
 	if err != nil {
@@ -73,21 +82,30 @@ func (z *IterGen) EncodeMsg(en *msgp.Writer) (err error) {
 	// write "Span"
 	err = en.Append(0xa4, 0x53, 0x70, 0x61, 0x6e)
 	if err != nil {
-		return err
+		return
 	}
 	err = en.WriteUint32(z.Span)
 	if err != nil {
 		return
 	}
+	// write "Format"
+	err = en.Append(0xa6, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint8(uint8(z.Format))
+	if err != nil {
+		return
+	}
 	return
 }
 
 // MarshalMsg implements msgp.Marshaler
 func (z *IterGen) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
-	// map header, size 3
+	// map header, size 4
 	// string "B"
-	o = append(o, 0x83, 0xa1, 0x42)
+	o = append(o, 0x84, 0xa1, 0x42)
 	o = msgp.AppendBytes(o, z.B)
 	// string "Ts"
 	o = append(o, 0xa2, 0x54, 0x73)
@@ -95,6 +113,9 @@ func (z *IterGen) MarshalMsg(b []byte) (o []byte, err error) {
 	// string "Span"
 	o = append(o, 0xa4, 0x53, 0x70, 0x61, 0x6e)
 	o = msgp.AppendUint32(o, z.Span)
+	// string "Format"
+	o = append(o, 0xa6, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74)
+	o = msgp.AppendUint8(o, uint8(z.Format))
 	return
 }
 
@@ -102,13 +123,13 @@ func (z *IterGen) MarshalMsg(b []byte) (o []byte, err error) {
 func (z *IterGen) UnmarshalMsg(bts []byte) (o []byte, err error) {
 	var field []byte
 	_ = field
-	var zbzg uint32
-	zbzg, bts, err = msgp.ReadMapHeaderBytes(bts)
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
 	if err != nil {
 		return
 	}
-	for zbzg > 0 {
-		zbzg--
+	for zb0001 > 0 {
+		zb0001--
 		field, bts, err = msgp.ReadMapKeyZC(bts)
 		if err != nil {
 			return
@@ -129,6 +150,15 @@ func (z *IterGen) UnmarshalMsg(bts []byte) (o []byte, err error) {
 			if err != nil {
 				return
 			}
+		case "Format":
+			{
+				var zb0002 uint8
+				zb0002, bts, err = msgp.ReadUint8Bytes(bts)
+				if err != nil {
+					return
+				}
+				z.Format = Format(zb0002)
+			}
 		default:
 			bts, err = msgp.Skip(bts)
 			if err != nil {
@@ -142,6 +172,6 @@ func (z *IterGen) UnmarshalMsg(bts []byte) (o []byte, err error) {
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *IterGen) Msgsize() (s int) {
-	s = 1 + 2 + msgp.BytesPrefixSize + len(z.B) + 3 + msgp.Uint32Size + 5 + msgp.Uint32Size
+	s = 1 + 2 + msgp.BytesPrefixSize + len(z.B) + 3 + msgp.Uint32Size + 5 + msgp.Uint32Size + 7 + msgp.Uint8Size
 	return
 }
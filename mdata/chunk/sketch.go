@@ -0,0 +1,141 @@
+package chunk
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/dgryski/go-tsz"
+	"github.com/grafana/metrictank/sketch"
+)
+
+// FormatSketchWithSpan stores the points of a percentile rollup, which each have a quantile sketch of the raw values they summarize.
+//
+// the data starts with the length of a go-tsz series as a uvarint, followed by that series, which holds the timestamps of the points
+// and the number of values in their sketch. this is followed by the sketch of every point, each prefixed with its length as a uvarint.
+// see the sketch package for the format of the sketches.
+
+var (
+	errSketchCorrupt = errors.New("corrupt data, sketch chunk ends unexpectedly")
+	errNoSketch      = errors.New("chunk has no sketches")
+)
+
+// PushSketch adds a point with the given sketch. the value of the point is the number of values in the sketch.
+// a chunk either holds sketches for all of its points or for none.
+func (c *Chunk) PushSketch(t uint32, s *sketch.Sketch) error {
+	if c.NumPoints != 0 && c.Sketches == nil {
+		return errors.New("can't push a sketch to a chunk without sketches")
+	}
+	if err := c.Push(t, float64(s.Count())); err != nil {
+		return err
+	}
+	c.Sketches = append(c.Sketches, s.Marshal())
+	return nil
+}
+
+// NewIter returns an iterator over the points of the chunk, which also provides their sketches if the chunk has them
+func (c *Chunk) NewIter() Iter {
+	it := c.Series.Iter()
+	if c.Sketches == nil {
+		return NewIter(it)
+	}
+	// points that get pushed after this don't modify the sketches we iterate over
+	sketches := c.Sketches[:len(c.Sketches):len(c.Sketches)]
+	return Iter{&sketchIter{Iter: it, sketches: sketches}, it.T0}
+}
+
+// IterGen returns an IterGen of the data of the chunk as it is in memory, for the cache
+func (c *Chunk) IterGen(span uint32) IterGen {
+	if c.Sketches == nil {
+		return *NewBareIterGen(c.Series.Bytes(), c.T0, span)
+	}
+	return IterGen{encodeSketches(c.Series.Bytes(), c.Sketches), c.T0, span, FormatSketchWithSpan}
+}
+
+// encodeSketches encodes the points in FormatSketchWithSpan, without the format header
+func encodeSketches(series []byte, sketches [][]byte) []byte {
+	size := binary.MaxVarintLen64 + len(series)
+	for _, s := range sketches {
+		size += binary.MaxVarintLen64 + len(s)
+	}
+	w := &bitWriter{b: make([]byte, 0, size)}
+	w.writeUvarint(uint64(len(series)))
+	w.b = append(w.b, series...)
+	for _, s := range sketches {
+		w.writeUvarint(uint64(len(s)))
+		w.b = append(w.b, s...)
+	}
+	return w.b
+}
+
+// sketchIter iterates over the points of a chunk in FormatSketchWithSpan
+type sketchIter struct {
+	*tsz.Iter
+	sketches [][]byte
+	pos      int // number of points iterated over
+	err      error
+}
+
+func newSketchIter(b []byte) (*sketchIter, error) {
+	r := bitReader{b: b}
+	n, err := r.readUvarint()
+	if err != nil {
+		return nil, errSketchCorrupt
+	}
+	if n > uint64(len(b)) {
+		return nil, errSketchCorrupt
+	}
+	series, err := r.readBytes(int(n))
+	if err != nil {
+		return nil, errSketchCorrupt
+	}
+	var sketches [][]byte
+	for r.pos < uint(len(b))*8 {
+		n, err := r.readUvarint()
+		if err != nil {
+			return nil, errSketchCorrupt
+		}
+		if n > uint64(len(b)) {
+			return nil, errSketchCorrupt
+		}
+		s, err := r.readBytes(int(n))
+		if err != nil {
+			return nil, errSketchCorrupt
+		}
+		sketches = append(sketches, s)
+	}
+	// the tsz iterator modifies its data
+	it, err := tsz.NewIterator(append([]byte(nil), series...))
+	if err != nil {
+		return nil, err
+	}
+	return &sketchIter{Iter: it, sketches: sketches}, nil
+}
+
+// Next advances to the next point and returns whether there is one
+func (it *sketchIter) Next() bool {
+	if it.err != nil || !it.Iter.Next() {
+		return false
+	}
+	if it.pos >= len(it.sketches) {
+		it.err = errSketchCorrupt
+		return false
+	}
+	it.pos++
+	return true
+}
+
+// Sketch returns the sketch of the current point
+func (it *sketchIter) Sketch() (*sketch.Sketch, error) {
+	if it.pos == 0 {
+		return nil, errSketchCorrupt
+	}
+	return sketch.Unmarshal(it.sketches[it.pos-1])
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *sketchIter) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.Iter.Err()
+}
@@ -0,0 +1,115 @@
+package chunk
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/sketch"
+)
+
+func newTestSketchChunk(t *testing.T, t0 uint32, n int) (*Chunk, []*sketch.Sketch) {
+	c := New(t0)
+	var sketches []*sketch.Sketch
+	for i := 0; i < n; i++ {
+		s := sketch.New()
+		for j := 0; j <= i; j++ {
+			s.Add(float64(i * j))
+		}
+		if err := c.PushSketch(t0+uint32(i+1)*60, s); err != nil {
+			t.Fatalf("failed to push sketch %d: %s", i, err)
+		}
+		sketches = append(sketches, s)
+	}
+	return c, sketches
+}
+
+func checkSketchIter(t *testing.T, name string, it Iter, t0 uint32, exp []*sketch.Sketch) {
+	i := 0
+	for it.Next() {
+		ts, val := it.Values()
+		if ts != t0+uint32(i+1)*60 || val != float64(exp[i].Count()) {
+			t.Fatalf("%s: expected point %d to be %d:%d, got %d:%f", name, i, t0+uint32(i+1)*60, exp[i].Count(), ts, val)
+		}
+		s, err := it.Sketch()
+		if err != nil {
+			t.Fatalf("%s: failed to get sketch %d: %s", name, i, err)
+		}
+		if !reflect.DeepEqual(s, exp[i]) {
+			t.Fatalf("%s: sketch %d doesn't match", name, i)
+		}
+		i++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("%s: failed to iterate: %s", name, err)
+	}
+	if i != len(exp) {
+		t.Fatalf("%s: expected %d points, got %d", name, len(exp), i)
+	}
+}
+
+func TestSketchChunk(t *testing.T) {
+	t0 := uint32(1440583200)
+	c, sketches := newTestSketchChunk(t, t0, 20)
+	checkSketchIter(t, "memory", c.NewIter(), t0, sketches)
+
+	// points pushed after creating an iterator are not seen by it
+	it := c.NewIter()
+	c.PushSketch(t0+21*60, sketch.New())
+	checkSketchIter(t, "memory-before-push", it, t0, sketches)
+	sketches = append(sketches, sketch.New())
+	c.Finish()
+
+	itgen, err := NewGen(c.Encode(7200), t0)
+	if err != nil {
+		t.Fatalf("failed to create itergen: %s", err)
+	}
+	if itgen.Format != FormatSketchWithSpan || itgen.Span != 7200 {
+		t.Fatalf("expected format %d with span 7200, got %d with span %d", FormatSketchWithSpan, itgen.Format, itgen.Span)
+	}
+	it2, err := itgen.Get()
+	if err != nil {
+		t.Fatalf("failed to get iterator: %s", err)
+	}
+	checkSketchIter(t, "encoded", *it2, t0, sketches)
+
+	cached := c.IterGen(7200)
+	if !reflect.DeepEqual(cached.Encode(), c.Encode(7200)) {
+		t.Fatalf("expected the cached itergen to encode to the same data as the chunk")
+	}
+
+	if err := New(t0).PushSketch(t0+60, sketch.New()); err != nil {
+		t.Fatalf("failed to push sketch to new chunk: %s", err)
+	}
+	plain := New(t0)
+	plain.Push(t0+60, 1)
+	if err := plain.PushSketch(t0+120, sketch.New()); err == nil {
+		t.Fatalf("expected pushing a sketch to a chunk without sketches to fail")
+	}
+	plain.Finish()
+	plainGen, _ := NewGen(plain.Encode(7200), t0)
+	it3, _ := plainGen.Get()
+	it3.Next()
+	if _, err := it3.Sketch(); err != errNoSketch {
+		t.Fatalf("expected %q for a chunk without sketches, got %v", errNoSketch, err)
+	}
+}
+
+func TestSketchChunkCorrupt(t *testing.T) {
+	t0 := uint32(1440583200)
+	c, _ := newTestSketchChunk(t, t0, 3)
+	c.Finish()
+	data := c.Encode(7200)[2:]
+	for i := 0; i < len(data); i++ {
+		it, err := newSketchIter(data[:i])
+		if err != nil {
+			continue
+		}
+		n := 0
+		for it.Next() {
+			n++
+		}
+		if it.Err() == nil && n == 3 {
+			t.Fatalf("expected truncating the data to %d bytes to result in an error", i)
+		}
+	}
+}
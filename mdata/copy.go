@@ -0,0 +1,95 @@
+package mdata
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CopyChunks reads all chunks of the series stored under src from the store and adds them to
+// the store again under dst, as they are stored, such that chunks of any format (e.g. with sketches) are copied unchanged.
+// chunks that don't specify their span are saved with the chunkspan of dst.
+// the copies are kept for what remains of the TTL of dst, counted from the t0 of the chunks, rather than for the
+// full TTL from the time of copying, and chunks that already passed the TTL of dst are not copied.
+// note that the chunks are only queued for saving (see WaitForChunks), and that data which has not been saved yet
+// (e.g. the chunks still in memory) is not copied.
+// it returns the t0s of the chunks queued, and the end (exclusive) of the last one.
+func CopyChunks(ctx context.Context, store Store, src, dst StoreKey) ([]uint32, uint32, error) {
+	now := uint32(time.Now().Unix())
+	start := uint32(0)
+	if now > src.TTL {
+		start = now - src.TTL
+	}
+	itgens, err := store.Search(ctx, src.Key, src.TTL, start, now+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	t0s := make([]uint32, 0, len(itgens))
+	var end uint32
+	for _, itgen := range itgens {
+		var age uint32
+		if now > itgen.Ts {
+			age = now - itgen.Ts
+		}
+		if age >= dst.TTL {
+			continue
+		}
+		if itgen.Span == 0 {
+			itgen.Span = dst.ChunkSpan
+		}
+		cwr := NewEncodedChunkWriteRequest(dst.Key, itgen.Ts, itgen.Encode(), dst.TTL, dst.TTL-age, time.Now())
+		store.Add(&cwr)
+		t0s = append(t0s, itgen.Ts)
+		if itgen.EndTs() > end {
+			end = itgen.EndTs()
+		}
+	}
+	return t0s, end, nil
+}
+
+// WaitForChunks waits until the chunks with the given t0s are saved under the key in the store.
+// it returns an error if they are not all saved within the timeout, or the context's error if it is cancelled.
+func WaitForChunks(ctx context.Context, store Store, key StoreKey, t0s []uint32, timeout time.Duration) error {
+	if len(t0s) == 0 {
+		return nil
+	}
+	start, end := t0s[0], t0s[0]+1
+	for _, t0 := range t0s {
+		if t0 < start {
+			start = t0
+		}
+		if t0 >= end {
+			end = t0 + 1
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		missing := len(t0s)
+		itgens, err := store.Search(ctx, key.Key, key.TTL, start, end)
+		if err == nil {
+			saved := make(map[uint32]struct{}, len(itgens))
+			for _, itgen := range itgens {
+				saved[itgen.Ts] = struct{}{}
+			}
+			missing = 0
+			for _, t0 := range t0s {
+				if _, ok := saved[t0]; !ok {
+					missing++
+				}
+			}
+			if missing == 0 {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d of %d chunks of %s not saved after %s", missing, len(t0s), key.Key, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package mdata
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/sketch"
+)
+
+func TestCopyChunks(t *testing.T) {
+	store := NewMockStore()
+	t0 := uint32(time.Now().Unix()) - 1200
+	t0 -= t0 % 600
+	for i := uint32(0); i < 2; i++ {
+		c := chunk.New(t0 + i*600)
+		c.Push(t0+i*600+10, float64(i))
+		c.Push(t0+i*600+20, float64(i+1))
+		c.Finish()
+		cwr := NewChunkWriteRequest(nil, "1.src", c, 3600, 600, time.Now())
+		store.Add(&cwr)
+	}
+
+	dst := StoreKey{Key: "1.dst", TTL: 7200, ChunkSpan: 600}
+	t0s, end, err := CopyChunks(context.Background(), store, StoreKey{Key: "1.src", TTL: 3600, ChunkSpan: 600}, dst)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(t0s) != 2 || end != t0+1200 {
+		t.Fatalf("expected 2 chunks copied up to %d, got %v up to %d", t0+1200, t0s, end)
+	}
+	if err := WaitForChunks(context.Background(), store, dst, t0s, time.Second); err != nil {
+		t.Fatalf("expected the copied chunks to be saved, got %s", err)
+	}
+	if err := WaitForChunks(context.Background(), store, dst, []uint32{t0 + 1200}, 0); err == nil {
+		t.Fatalf("expected an error for a chunk that is not saved")
+	}
+
+	itgens := store.results["1.dst"]
+	if len(itgens) != 2 {
+		t.Fatalf("expected 2 chunks stored for the copy, got %d", len(itgens))
+	}
+	for i, itgen := range itgens {
+		if itgen.Ts != t0+uint32(i)*600 || itgen.Span != 600 {
+			t.Fatalf("chunk %d: expected t0 %d and span 600, got %d and %d", i, t0+uint32(i)*600, itgen.Ts, itgen.Span)
+		}
+		it, err := itgen.Get()
+		if err != nil {
+			t.Fatalf("chunk %d: failed to get iterator: %s", i, err)
+		}
+		var points int
+		for it.Next() {
+			ts, val := it.Values()
+			if ts != t0+uint32(i)*600+uint32(points+1)*10 || val != float64(i+points) {
+				t.Fatalf("chunk %d: unexpected point %d: %d %f", i, points, ts, val)
+			}
+			points++
+		}
+		if points != 2 {
+			t.Fatalf("chunk %d: expected 2 points, got %d", i, points)
+		}
+	}
+}
+
+// keepRecorder is a store that records how long the chunks added to it are to be kept for
+type keepRecorder struct {
+	*MockStore
+	keep map[uint32]uint32
+}
+
+func (k *keepRecorder) Add(cwr *ChunkWriteRequest) {
+	k.keep[cwr.chunk.T0] = cwr.keepFor()
+	k.MockStore.Add(cwr)
+}
+
+func TestCopyChunksRemainingTTL(t *testing.T) {
+	store := &keepRecorder{NewMockStore(), make(map[uint32]uint32)}
+	now := uint32(time.Now().Unix())
+	old := now - 3*3600
+	old -= old % 600
+	recent := now - 1200
+	recent -= recent % 600
+	for _, t0 := range []uint32{old, recent} {
+		c := chunk.New(t0)
+		c.Push(t0+10, 1)
+		c.Finish()
+		cwr := NewChunkWriteRequest(nil, "1.src", c, 86400, 600, time.Now())
+		store.MockStore.Add(&cwr)
+	}
+
+	// the old chunk already passed the ttl of dst, the recent one is kept for the rest of it
+	dst := StoreKey{Key: "1.dst", TTL: 7200, ChunkSpan: 600}
+	t0s, _, err := CopyChunks(context.Background(), store, StoreKey{Key: "1.src", TTL: 86400, ChunkSpan: 600}, dst)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if !reflect.DeepEqual(t0s, []uint32{recent}) {
+		t.Fatalf("expected only chunk %d to be copied, got %v", recent, t0s)
+	}
+	if _, ok := store.keep[old]; ok {
+		t.Fatalf("expected the expired chunk not to be added")
+	}
+	// allow for the clock to tick during the copy
+	if keep := store.keep[recent]; keep > 7200-(now-recent) || keep < 7200-(now-recent)-2 {
+		t.Fatalf("expected the copy to be kept for %d, got %d", 7200-(now-recent), keep)
+	}
+}
+
+func TestCopyChunksSketches(t *testing.T) {
+	store := NewMockStore()
+	t0 := uint32(time.Now().Unix()) - 21600
+	t0 -= t0 % 21600
+	c := chunk.New(t0)
+	for i := uint32(1); i <= 3; i++ {
+		s := sketch.New()
+		s.Add(float64(i))
+		s.Add(float64(i * 10))
+		c.PushSketch(t0+i*600, s)
+	}
+	c.Finish()
+	cwr := NewChunkWriteRequest(nil, "1.src_sketch_600", c, 86400, 21600, time.Now())
+	store.Add(&cwr)
+
+	src := StoreKey{Key: "1.src_sketch_600", TTL: 86400, ChunkSpan: 21600, Span: 600}
+	dst := StoreKey{Key: "1.dst_sketch_600", TTL: 86400, ChunkSpan: 21600, Span: 600}
+	if _, _, err := CopyChunks(context.Background(), store, src, dst); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	exp := store.results[src.Key][0]
+	got := store.results[dst.Key]
+	if len(got) != 1 || got[0].Format != chunk.FormatSketchWithSpan || !reflect.DeepEqual(got[0].Encode(), exp.Encode()) {
+		t.Fatalf("expected the sketch chunk to be copied as is, got %v", got)
+	}
+}
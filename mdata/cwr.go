@@ -1,6 +1,7 @@
 package mdata
 
 import (
+	"context"
 	"time"
 
 	"github.com/grafana/metrictank/mdata/chunk"
@@ -13,6 +14,7 @@ type ChunkReadRequest struct {
 	p         []interface{}
 	timestamp time.Time
 	out       chan outcome
+	ctx       context.Context // the read is omitted if it is cancelled before it is executed
 }
 
 type ChunkWriteRequest struct {
@@ -22,8 +24,34 @@ type ChunkWriteRequest struct {
 	ttl       uint32
 	timestamp time.Time
 	span      uint32
+	data      []byte // the encoded chunk, for chunks that are saved as they were read. see NewEncodedChunkWriteRequest
+	keep      uint32 // if not 0, how long to keep the chunk for, instead of the ttl. see NewEncodedChunkWriteRequest
 }
 
 func NewChunkWriteRequest(metric *AggMetric, key string, chunk *chunk.Chunk, ttl, span uint32, ts time.Time) ChunkWriteRequest {
-	return ChunkWriteRequest{metric, key, chunk, ttl, ts, span}
+	return ChunkWriteRequest{metric, key, chunk, ttl, ts, span, nil, 0}
+}
+
+// NewEncodedChunkWriteRequest returns a request to save the chunk starting at t0, which is already encoded
+// with the header of its format, as is. its chunk only holds the t0.
+// the chunk is stored with the given ttl, but only kept for keep seconds, such that a copy of an older
+// chunk doesn't outlive it.
+func NewEncodedChunkWriteRequest(key string, t0 uint32, data []byte, ttl, keep uint32, ts time.Time) ChunkWriteRequest {
+	return ChunkWriteRequest{nil, key, chunk.New(t0), ttl, ts, 0, data, keep}
+}
+
+// keepFor returns how long the store should keep the chunk for
+func (cwr *ChunkWriteRequest) keepFor() uint32 {
+	if cwr.keep != 0 {
+		return cwr.keep
+	}
+	return cwr.ttl
+}
+
+// encode returns the chunk as it is saved in the stores
+func (cwr *ChunkWriteRequest) encode() []byte {
+	if cwr.data != nil {
+		return cwr.data
+	}
+	return cwr.chunk.Encode(cwr.span)
 }
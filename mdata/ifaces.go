@@ -6,7 +6,8 @@ import (
 
 type Metrics interface {
 	Get(key string) (Metric, bool)
-	GetOrCreate(key, name string, schemaId, aggId uint16) Metric
+	GetOrCreate(key, name string, partition int32, schemaId, aggId uint16, interval int) Metric
+	Delete(key string) bool
 }
 
 type Metric interface {
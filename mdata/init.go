@@ -38,6 +38,10 @@ var (
 	// your (infrequent) updates.  Any points revcieved for a chunk that has already been closed are discarded.
 	addToClosedChunk = stats.NewCounter32("tank.add_to_closed_chunk")
 
+	// metric tank.aggregator.xfilesfactor_null is the number of aggregated points that were not written,
+	// because the ratio of raw points received for them was below the xFilesFactor
+	aggregatorXFilesFactorNull = stats.NewCounter32("tank.aggregator.xfilesfactor_null")
+
 	// metric mem.to_iter is how long it takes to transform in-memory chunks to iterators
 	memToIterDuration = stats.NewLatencyHistogram15s32("mem.to_iter")
 
@@ -55,14 +59,16 @@ var (
 	Schemas      conf.Schemas
 	Aggregations conf.Aggregations
 
-	schemasFile = "/etc/metrictank/storage-schemas.conf"
-	aggFile     = "/etc/metrictank/storage-aggregation.conf"
+	schemasFile        = "/etc/metrictank/storage-schemas.conf"
+	aggFile            = "/etc/metrictank/storage-aggregation.conf"
+	counterAggregation = false
 )
 
 func ConfigSetup() {
 	retentionConf := flag.NewFlagSet("retention", flag.ExitOnError)
 	retentionConf.StringVar(&schemasFile, "schemas-file", "/etc/metrictank/storage-schemas.conf", "path to storage-schemas.conf file")
 	retentionConf.StringVar(&aggFile, "aggregations-file", "/etc/metrictank/storage-aggregation.conf", "path to storage-aggregation.conf file")
+	retentionConf.BoolVar(&counterAggregation, "counter-aggregation", false, "use max,increase rollups for metrics of mtype counter that don't match any rule in storage-aggregation.conf, rather than avg. see docs/consolidation.md before enabling it on existing data")
 	globalconf.Register("retention", retentionConf)
 }
 
@@ -98,4 +104,5 @@ func ConfigProcess() {
 		log.Info("Could not read %s: %s: using defaults", aggFile, err)
 		Aggregations = conf.NewAggregations()
 	}
+	Aggregations.CounterDefault = counterAggregation
 }
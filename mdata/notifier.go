@@ -32,12 +32,13 @@ type PersistMessageBatch struct {
 }
 
 type SavedChunk struct {
-	Key string `json:"key"`
-	T0  uint32 `json:"t0"`
+	Key       string `json:"key"`
+	Partition int32  `json:"partition"` // partition of the series, so that the message reaches the nodes consuming it
+	T0        uint32 `json:"t0"`
 }
 
-func SendPersistMessage(key string, t0 uint32) {
-	sc := SavedChunk{Key: key, T0: t0}
+func SendPersistMessage(key string, partition int32, t0 uint32) {
+	sc := SavedChunk{Key: key, Partition: partition, T0: t0}
 	for _, h := range notifierHandlers {
 		h.Send(sc)
 	}
@@ -76,7 +77,7 @@ func Handle(metrics Metrics, data []byte, idx idx.MetricIndex) {
 				log.Debug("notifier: skipping metric with id %s as it is not in the index", key[0])
 				continue
 			}
-			agg := metrics.GetOrCreate(key[0], def.Name, def.SchemaId, def.AggId)
+			agg := metrics.GetOrCreate(key[0], def.Name, def.Partition, def.SchemaId, def.AggId, def.Interval)
 			if len(key) == 3 {
 				agg.(*AggMetric).SyncAggregatedChunkSaveState(c.T0, consolidator, uint32(aggSpan))
 			} else {
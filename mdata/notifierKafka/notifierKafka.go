@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
-	"strings"
 	"sync"
 	"time"
 
@@ -223,16 +222,11 @@ func (c *NotifierKafka) flush() {
 		return
 	}
 
-	// In order to correctly route the saveMessages to the correct partition,
+	// In order to correctly route the saveMessages to the partition of their series,
 	// we cant send them in batches anymore.
 	payload := make([]*sarama.ProducerMessage, 0, len(c.buf))
 	var pMsg mdata.PersistMessageBatch
 	for i, msg := range c.buf {
-		def, ok := c.idx.Get(strings.SplitN(msg.Key, "_", 2)[0])
-		if !ok {
-			log.Error(3, "kafka-cluster: failed to lookup metricDef with id %s", msg.Key)
-			continue
-		}
 		buf := bytes.NewBuffer(c.bPool.Get())
 		binary.Write(buf, binary.LittleEndian, uint8(mdata.PersistMessageBatchV1))
 		encoder := json.NewEncoder(buf)
@@ -242,15 +236,10 @@ func (c *NotifierKafka) flush() {
 			log.Fatal(4, "kafka-cluster failed to marshal persistMessage to json.")
 		}
 		messagesSize.Value(buf.Len())
-		key := c.bPool.Get()
-		key, err = partitioner.GetPartitionKey(&def, key)
-		if err != nil {
-			log.Fatal(4, "Unable to get partitionKey for metricDef with id %s. %s", def.Id, err)
-		}
 		kafkaMsg := &sarama.ProducerMessage{
-			Topic: topic,
-			Value: sarama.ByteEncoder(buf.Bytes()),
-			Key:   sarama.ByteEncoder(key),
+			Topic:     topic,
+			Partition: msg.Partition,
+			Value:     sarama.ByteEncoder(buf.Bytes()),
 		}
 		payload = append(payload, kafkaMsg)
 	}
@@ -272,7 +261,6 @@ func (c *NotifierKafka) flush() {
 		messagesPublished.Add(len(payload))
 		// put our buffers back in the bufferPool
 		for _, msg := range payload {
-			c.bPool.Put([]byte(msg.Key.(sarama.ByteEncoder)))
 			c.bPool.Put([]byte(msg.Value.(sarama.ByteEncoder)))
 		}
 	}()
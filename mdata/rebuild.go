@@ -0,0 +1,143 @@
+package mdata
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata/chunk"
+)
+
+// rebuild describes the rebuild of the chunks of one rollup archive
+type rebuild struct {
+	aggregator *Aggregator
+	start, end uint32 // rollup chunks from start until end are rebuilt
+	rawFrom    uint32 // raw points from rawFrom (inclusive) until rawTo (exclusive) are aggregated into these chunks
+	rawTo      uint32
+	lastTs     uint32 // last raw point aggregated, or seen before rawFrom
+}
+
+// nopCachePusher doesn't push anything, the chunks of a rebuild don't go into the cache
+type nopCachePusher struct{}
+
+func (nopCachePusher) CacheIfHot(string, uint32, chunk.IterGen) {}
+
+// RebuildRollups recomputes the rollups of the series with the given key and interval (as in the index) from its raw data in the store,
+// using the given retentions and aggregation settings, and queues the rollup chunks for saving, replacing the chunks that are already there.
+// the raw points are aggregated by the same Aggregator that aggregates incoming data.
+// this is needed after changing the aggregation settings of a series, as rollups are otherwise only computed when data comes in.
+//
+// only whole rollup chunks are rebuilt: the range from - to is extended to the boundaries of the chunks it touches, except
+// for chunks for which the raw data has already expired, or may not have been saved yet. those are not touched.
+// it returns the number of chunks queued.
+func RebuildRollups(ctx context.Context, store Store, key string, interval uint32, rets conf.Retentions, agg conf.Aggregation, from, to uint32) (int, error) {
+	if len(rets) < 2 || len(agg.AggregationMethod) == 0 {
+		return 0, nil
+	}
+	raw := rets[0]
+	rawTTL := uint32(raw.MaxRetention())
+	now := uint32(time.Now().Unix())
+	// raw data older than oldest may have expired, raw data newer than newest may still be in memory or in the write queue
+	oldest := uint32(0)
+	if now > rawTTL {
+		oldest = now - rawTTL
+	}
+	newest := now - now%raw.ChunkSpan
+	if newest < raw.ChunkSpan {
+		return 0, nil
+	}
+	newest -= raw.ChunkSpan
+
+	var rebuilds []*rebuild
+	rawFrom, rawTo := uint32(0), uint32(0)
+	for _, ret := range rets[1:] {
+		span := uint32(ret.SecondsPerPoint)
+		// the rollup point at ts aggregates the raw points after ts-span, up to and including ts
+		start := from - from%ret.ChunkSpan
+		if start+1 < oldest+span {
+			start = AggBoundary(oldest+span, ret.ChunkSpan)
+		}
+		end := AggBoundary(to, ret.ChunkSpan)
+		if end > newest+span {
+			end = newest + span - (newest+span)%ret.ChunkSpan
+		}
+		if start >= end {
+			continue
+		}
+		r := &rebuild{
+			aggregator: NewAggregator(NewDevnullStore(), nopCachePusher{}, key, interval, ret, agg, false),
+			start:      start,
+			end:        end,
+			rawFrom:    start - span + 1,
+			rawTo:      end - span + 1,
+		}
+		// all chunks are kept in memory until we queue them below
+		for _, m := range r.aggregator.metrics() {
+			m.NumChunks = (end-start)/ret.ChunkSpan + 1
+		}
+		if len(rebuilds) == 0 || r.rawFrom < rawFrom {
+			rawFrom = r.rawFrom
+		}
+		if r.rawTo > rawTo {
+			rawTo = r.rawTo
+		}
+		rebuilds = append(rebuilds, r)
+	}
+	if len(rebuilds) == 0 {
+		return 0, nil
+	}
+
+	// we also read the last point before rawFrom: the increase of the first boundary includes the delta from it
+	itgens, err := store.Search(ctx, key, rawTTL, rawFrom-1, rawTo)
+	if err != nil {
+		return 0, err
+	}
+	for _, itgen := range itgens {
+		it, err := itgen.Get()
+		if err != nil {
+			return 0, err
+		}
+		for it.Next() {
+			ts, val := it.Values()
+			for _, r := range rebuilds {
+				// the aggregator requires points in order, we skip points of overlapping chunks
+				if ts >= r.rawTo || ts <= r.lastTs {
+					continue
+				}
+				if ts < r.rawFrom {
+					r.aggregator.prev = val
+					r.lastTs = ts
+					continue
+				}
+				r.aggregator.Add(ts, val)
+				r.lastTs = ts
+			}
+		}
+		if err := it.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	queued := 0
+	for _, r := range rebuilds {
+		if r.aggregator.agg.Cnt != 0 {
+			r.aggregator.flush()
+		}
+		for _, m := range r.aggregator.metrics() {
+			for _, c := range m.Chunks {
+				if c.T0 < r.start || c.T0 >= r.end {
+					continue
+				}
+				if !c.Closed {
+					c.Finish()
+				}
+				// the chunk only lives in the write queue, it doesn't count towards the points held in memory
+				c.Clear()
+				cwr := NewChunkWriteRequest(nil, m.Key, c, m.ttl, m.ChunkSpan, time.Now())
+				store.Add(&cwr)
+				queued++
+			}
+		}
+	}
+	return queued, nil
+}
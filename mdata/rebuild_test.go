@@ -0,0 +1,131 @@
+package mdata
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata/chunk"
+)
+
+func TestRebuildRollups(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	store := NewMockStore()
+	rets := conf.Retentions{
+		conf.NewRetentionMT(10, 86400, 600, 2, true),
+		conf.NewRetentionMT(60, 86400*7, 1800, 2, true),
+	}
+	agg := conf.Aggregation{
+		XFilesFactor:      0.5,
+		AggregationMethod: []conf.Method{conf.Sum, conf.Max, conf.Inc},
+	}
+	now := uint32(time.Now().Unix())
+	t0 := now - now%1800 - 3*1800
+
+	// raw points every 10s, with their timestamp as value
+	for c := t0 - 600; c < now-now%600-600; c += 600 {
+		ch := chunk.New(c)
+		for ts := c; ts < c+600; ts += 10 {
+			ch.Push(ts, float64(ts))
+		}
+		ch.Finish()
+		cwr := NewChunkWriteRequest(nil, "1.raw", ch, 86400, 600, time.Now())
+		store.Add(&cwr)
+	}
+	// a rollup chunk computed with other settings, to be replaced
+	old := chunk.New(t0 + 1800)
+	old.Push(t0+1860, -1)
+	old.Finish()
+	cwr := NewChunkWriteRequest(nil, "1.raw_sum_60", old, 86400*7, 1800, time.Now())
+	store.Add(&cwr)
+
+	n, err := RebuildRollups(context.Background(), store, "1.raw", 10, rets, agg, t0+1800+300, t0+3600)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 chunks queued, got %d", n)
+	}
+
+	check := func(key string, exp func(ts uint32) float64) {
+		itgens := store.results[key]
+		if len(itgens) != 1 || itgens[0].Ts != t0+1800 || itgens[0].Span != 1800 {
+			t.Fatalf("%s: expected one chunk at %d with span 1800, got %v", key, t0+1800, itgens)
+		}
+		it, err := itgens[0].Get()
+		if err != nil {
+			t.Fatalf("%s: failed to get iterator: %s", key, err)
+		}
+		points := 0
+		for it.Next() {
+			ts, val := it.Values()
+			expTs := t0 + 1800 + uint32(points)*60
+			if ts != expTs || val != exp(expTs) {
+				t.Fatalf("%s: expected point %d to be %d:%f, got %d:%f", key, points, expTs, exp(expTs), ts, val)
+			}
+			points++
+		}
+		if points != 30 {
+			t.Fatalf("%s: expected 30 points, got %d", key, points)
+		}
+	}
+	// each rollup point aggregates the 6 raw points after the previous one, up to and including its own timestamp
+	check("1.raw_sum_60", func(ts uint32) float64 { return 6*float64(ts) - 150 })
+	check("1.raw_max_60", func(ts uint32) float64 { return float64(ts) })
+	// including the first one, which gets the increase from the point before the first boundary
+	check("1.raw_inc_60", func(ts uint32) float64 { return 60 })
+
+	// chunks for which the raw data may not have been saved yet are not rebuilt
+	store.ResetMock()
+	n, err = RebuildRollups(context.Background(), store, "1.raw", 10, rets, agg, now-600, now+3600)
+	if err != nil || n != 0 {
+		t.Fatalf("expected no chunks rebuilt for the current chunk, got %d, %v", n, err)
+	}
+}
+
+// the xFilesFactor is based on the interval of the series, not on the raw retention
+func TestRebuildRollupsCoarseInterval(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	store := NewMockStore()
+	rets := conf.Retentions{
+		conf.NewRetentionMT(10, 86400, 600, 2, true),
+		conf.NewRetentionMT(60, 86400*7, 1800, 2, true),
+	}
+	agg := conf.Aggregation{
+		XFilesFactor:      0.5,
+		AggregationMethod: []conf.Method{conf.Max},
+	}
+	now := uint32(time.Now().Unix())
+	t0 := now - now%1800 - 3*1800
+
+	// raw points every 30s
+	for c := t0 - 600; c < now-now%600-600; c += 600 {
+		ch := chunk.New(c)
+		for ts := c; ts < c+600; ts += 30 {
+			ch.Push(ts, float64(ts))
+		}
+		ch.Finish()
+		cwr := NewChunkWriteRequest(nil, "1.raw", ch, 86400, 600, time.Now())
+		store.Add(&cwr)
+	}
+	if _, err := RebuildRollups(context.Background(), store, "1.raw", 30, rets, agg, t0+1800, t0+3600); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	itgens := store.results["1.raw_max_60"]
+	if len(itgens) != 1 {
+		t.Fatalf("expected one chunk, got %v", itgens)
+	}
+	it, err := itgens[0].Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := 0
+	for it.Next() {
+		points++
+	}
+	if points != 30 {
+		t.Fatalf("expected 30 points, got %d", points)
+	}
+}
@@ -28,7 +28,7 @@ func (rob *ReorderBuffer) Add(ts uint32, val float64) []schema.Point {
 	ts = AggBoundary(ts, rob.interval)
 
 	// out of order and too old
-	if rob.buf[rob.newest].Ts != 0 && ts <= rob.buf[rob.newest].Ts-(rob.len*rob.interval) {
+	if rob.tooOld(ts) {
 		metricsTooOld.Inc()
 		return nil
 	}
@@ -79,3 +79,9 @@ func (rob *ReorderBuffer) Get() []schema.Point {
 
 	return res
 }
+
+// tooOld returns whether the point at ts is out of order and older than the reorder window
+func (rob *ReorderBuffer) tooOld(ts uint32) bool {
+	ts = AggBoundary(ts, rob.interval)
+	return rob.buf[rob.newest].Ts != 0 && ts <= rob.buf[rob.newest].Ts-(rob.len*rob.interval)
+}
@@ -1,7 +1,10 @@
 package mdata
 
 import (
+	"fmt"
+
 	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/consolidation"
 )
 
 func MaxChunkSpan() uint32 {
@@ -18,10 +21,62 @@ func MatchSchema(key string, interval int) (uint16, conf.Schema) {
 	return Schemas.Match(key, interval)
 }
 
-// MatchAgg returns the aggregation definition for the given metric key, and the index of it (to efficiently reference it)
+// MatchAgg returns the aggregation definition for the given metric key and mtype, and the index of it (to efficiently reference it)
 // it will always find the aggregation definition because Aggregations has a catchall default
-func MatchAgg(key string) (uint16, conf.Aggregation) {
-	return Aggregations.Match(key)
+func MatchAgg(key, mtype string) (uint16, conf.Aggregation) {
+	return Aggregations.Match(key, mtype)
+}
+
+// StoreKey identifies a raw or rollup series in the backend store
+type StoreKey struct {
+	Key       string
+	TTL       uint32
+	ChunkSpan uint32
+	Span      uint32 // the span of the points of rollup series. 0 for the raw series
+}
+
+// LastPoint returns the timestamp of the last point of the series, for a metric that was last updated at lastUpdate
+func (k StoreKey) LastPoint(lastUpdate uint32) uint32 {
+	if k.Span == 0 {
+		return lastUpdate
+	}
+	return AggBoundary(lastUpdate, k.Span)
+}
+
+// StoreKeys returns the keys - and the ttls and chunkspans they are stored with - of the raw series
+// and all rollup series of the given metric key, according to the given schema and aggregation definition
+func StoreKeys(key string, schemaId, aggId uint16) []StoreKey {
+	rets := Schemas.Get(schemaId).Retentions
+	keys := []StoreKey{{Key: key, TTL: uint32(rets[0].MaxRetention()), ChunkSpan: rets[0].ChunkSpan}}
+	if len(rets) == 1 {
+		return keys
+	}
+	agg := Aggregations.Get(aggId)
+	for _, ret := range rets[1:] {
+		ttl := uint32(ret.MaxRetention())
+		// percentiles share an archive, so we dedupe by archive
+		seen := make(map[string]struct{})
+		for _, method := range agg.AggregationMethod {
+			// we use the same number assignments so we can cast them
+			cons := []consolidation.Consolidator{consolidation.Consolidator(method)}
+			if method == conf.Avg {
+				cons = []consolidation.Consolidator{consolidation.Sum, consolidation.Cnt}
+			}
+			for _, c := range cons {
+				if _, ok := seen[c.Archive()]; ok {
+					continue
+				}
+				seen[c.Archive()] = struct{}{}
+				keys = append(keys, StoreKey{
+					Key:       fmt.Sprintf("%s_%s_%d", key, c.Archive(), ret.SecondsPerPoint),
+					TTL:       ttl,
+					ChunkSpan: ret.ChunkSpan,
+					Span:      uint32(ret.SecondsPerPoint),
+				})
+			}
+		}
+	}
+	return keys
 }
 
 func SetSingleSchema(ret ...conf.Retention) {
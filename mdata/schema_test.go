@@ -0,0 +1,56 @@
+package mdata
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/conf"
+)
+
+func TestStoreKeys(t *testing.T) {
+	SetSingleSchema(
+		conf.NewRetentionMT(10, 3600, 600, 5, true),
+		conf.NewRetentionMT(600, 86400, 21600, 2, true),
+		conf.NewRetentionMT(7200, 864000, 21600, 2, true),
+	)
+	SetSingleAgg(conf.Avg, conf.Sum, conf.Max)
+
+	exp := []StoreKey{
+		{"1.abc", 3600, 600, 0},
+		{"1.abc_sum_600", 86400, 21600, 600},
+		{"1.abc_cnt_600", 86400, 21600, 600},
+		{"1.abc_max_600", 86400, 21600, 600},
+		{"1.abc_sum_7200", 864000, 21600, 7200},
+		{"1.abc_cnt_7200", 864000, 21600, 7200},
+		{"1.abc_max_7200", 864000, 21600, 7200},
+	}
+	keys := StoreKeys("1.abc", 0, 0)
+	if !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("expected %v, got %v", exp, keys)
+	}
+
+	// all percentiles share the sketch series
+	SetSingleSchema(
+		conf.NewRetentionMT(10, 3600, 600, 5, true),
+		conf.NewRetentionMT(600, 86400, 21600, 2, true),
+	)
+	SetSingleAgg(conf.P50, conf.Max, conf.P99)
+	exp = []StoreKey{
+		{"1.abc", 3600, 600, 0},
+		{"1.abc_sketch_600", 86400, 21600, 600},
+		{"1.abc_max_600", 86400, 21600, 600},
+	}
+	keys = StoreKeys("1.abc", 0, 0)
+	if !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("expected %v, got %v", exp, keys)
+	}
+
+	SetSingleSchema(conf.NewRetentionMT(10, 3600, 600, 5, true))
+	exp = []StoreKey{
+		{"1.abc", 3600, 600, 0},
+	}
+	keys = StoreKeys("1.abc", 0, 0)
+	if !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("expected %v, got %v", exp, keys)
+	}
+}
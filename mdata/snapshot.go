@@ -0,0 +1,389 @@
+package mdata
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/dgryski/go-tsz"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/sketch"
+	"github.com/raintank/worldping-api/pkg/log"
+	"github.com/tinylib/msgp/msgp"
+	"gopkg.in/raintank/schema.v1"
+)
+
+//go:generate msgp
+
+// SnapshotVersion is the version of the snapshot format. snapshots of other versions are ignored
+const SnapshotVersion = 1
+
+// Snapshot is the state of all metrics held in memory, saved on shutdown so that on startup
+// we don't have to replay hours of data to fill the ring buffers.
+type Snapshot struct {
+	Version int
+	Time    int64             // unix timestamp of when the snapshot was taken
+	Offsets []PartitionOffset // the offsets to resume consuming from, to get the data that arrived after the snapshot
+	Metrics []AggMetricSnapshot
+}
+
+// PartitionOffset is the offset of the next message to consume from a partition of a kafka topic
+type PartitionOffset struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// AggMetricSnapshot is the state of an AggMetric
+type AggMetricSnapshot struct {
+	Key             string
+	ChunkSpan       uint32
+	NumChunks       uint32
+	CurrentChunkPos int
+	Chunks          []ChunkSnapshot
+	FirstChunkT0    uint32
+	LastSaveStart   uint32
+	LastSaveFinish  uint32
+	LastWrite       uint32
+	RobInterval     uint32
+	RobNewest       uint32
+	Rob             []schema.Point // the contents of the reorder buffer, if any
+	Aggregators     []AggregatorSnapshot
+}
+
+// ChunkSnapshot is a chunk of an AggMetric. Data is a finished tsz series, even if the chunk was still open.
+type ChunkSnapshot struct {
+	T0       uint32
+	Closed   bool
+	Data     []byte
+	Sketches [][]byte // the sketches of the points, for chunks of percentile rollups
+}
+
+// AggregatorSnapshot is the state of an Aggregator: the partial aggregation of the current boundary and its series
+type AggregatorSnapshot struct {
+	Span            uint32
+	CurrentBoundary uint32
+	Min             float64
+	Max             float64
+	Sum             float64
+	Cnt             float64
+	Lst             float64
+	MinMetric       *AggMetricSnapshot
+	MaxMetric       *AggMetricSnapshot
+	SumMetric       *AggMetricSnapshot
+	CntMetric       *AggMetricSnapshot
+	LstMetric       *AggMetricSnapshot
+	Sketch          []byte // the marshaled sketch of the current boundary, if there are percentile rollups
+	SketchMetric    *AggMetricSnapshot
+	Inc             float64 // the increase of the counter in the current boundary, if there are increase rollups
+	Prev            float64 // the last value of the counter, if there are increase rollups
+	IncMetric       *AggMetricSnapshot
+}
+
+func ReadSnapshot(path string) (Snapshot, error) {
+	var snap Snapshot
+	f, err := os.Open(path)
+	if err != nil {
+		return snap, err
+	}
+	defer f.Close()
+	err = snap.DecodeMsg(msgp.NewReader(f))
+	return snap, err
+}
+
+// WriteSnapshot writes the snapshot to a temporary file first, which then replaces any previous snapshot
+func WriteSnapshot(path string, snap *Snapshot) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := msgp.NewWriter(f)
+	err = snap.EncodeMsg(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+var errSnapshotMismatch = errors.New("snapshot does not match the metric's retention")
+
+// Snapshot returns the state of all metrics, to be restored with Restore.
+// the inputs should be stopped while taking it, to get a consistent view of the data and the offsets.
+func (ms *AggMetrics) Snapshot(offsets []PartitionOffset) Snapshot {
+	ms.RLock()
+	metrics := make([]*AggMetric, 0, len(ms.Metrics))
+	for _, m := range ms.Metrics {
+		metrics = append(metrics, m)
+	}
+	ms.RUnlock()
+	snap := Snapshot{
+		Version: SnapshotVersion,
+		Offsets: offsets,
+		Metrics: make([]AggMetricSnapshot, 0, len(metrics)),
+	}
+	for _, m := range metrics {
+		snap.Metrics = append(snap.Metrics, m.snapshot())
+	}
+	return snap
+}
+
+// Restore recreates the metrics in the snapshot. lookup returns the schema, aggregation, partition and interval of a metric,
+// metrics it doesn't know are skipped. it returns the number of metrics restored.
+func (ms *AggMetrics) Restore(snap Snapshot, lookup func(key string) (schemaId, aggId uint16, partition int32, interval int, ok bool)) int {
+	restored := 0
+	for _, s := range snap.Metrics {
+		schemaId, aggId, partition, interval, ok := lookup(s.Key)
+		if !ok {
+			continue
+		}
+		m := ms.GetOrCreate(s.Key, "", partition, schemaId, aggId, interval).(*AggMetric)
+		if err := m.restore(s); err != nil {
+			log.Warn("AM failed to restore %s from snapshot: %s", s.Key, err)
+			ms.Delete(s.Key)
+			continue
+		}
+		restored++
+	}
+	return restored
+}
+
+func (a *AggMetric) snapshot() AggMetricSnapshot {
+	a.RLock()
+	defer a.RUnlock()
+	s := AggMetricSnapshot{
+		Key:             a.Key,
+		ChunkSpan:       a.ChunkSpan,
+		NumChunks:       a.NumChunks,
+		CurrentChunkPos: a.CurrentChunkPos,
+		Chunks:          make([]ChunkSnapshot, 0, len(a.Chunks)),
+		FirstChunkT0:    a.firstChunkT0,
+		LastSaveStart:   a.lastSaveStart,
+		LastSaveFinish:  a.lastSaveFinish,
+		LastWrite:       a.lastWrite,
+	}
+	for _, c := range a.Chunks {
+		s.Chunks = append(s.Chunks, snapshotChunk(c))
+	}
+	if a.rob != nil {
+		s.RobInterval = a.rob.interval
+		s.RobNewest = a.rob.newest
+		s.Rob = append([]schema.Point(nil), a.rob.buf...)
+	}
+	// no lock needed cause aggregators don't change at runtime
+	for _, agg := range a.aggregators {
+		s.Aggregators = append(s.Aggregators, agg.snapshot())
+	}
+	return s
+}
+
+// snapshotChunk returns the chunk with its data as a finished series.
+// the series of open chunks can't be serialized as is, so we encode their points into a new, finished, series.
+func snapshotChunk(c *chunk.Chunk) ChunkSnapshot {
+	// sketches are never modified, only appended to
+	var sketches [][]byte
+	if c.Sketches != nil {
+		sketches = c.Sketches[:len(c.Sketches):len(c.Sketches)]
+	}
+	if c.Closed {
+		return ChunkSnapshot{
+			T0:       c.T0,
+			Closed:   true,
+			Data:     append([]byte(nil), c.Series.Bytes()...),
+			Sketches: sketches,
+		}
+	}
+	series := tsz.New(c.T0)
+	it := c.Series.Iter()
+	for it.Next() {
+		series.Push(it.Values())
+	}
+	series.Finish()
+	return ChunkSnapshot{
+		T0:       c.T0,
+		Data:     series.Bytes(),
+		Sketches: sketches,
+	}
+}
+
+// restoreChunk pushes the points of the snapshot into a new chunk, which results in the same state as the original chunk
+func restoreChunk(s ChunkSnapshot) (*chunk.Chunk, error) {
+	it, err := tsz.NewIterator(s.Data)
+	if err != nil {
+		return nil, err
+	}
+	c := chunk.New(s.T0)
+	for it.Next() {
+		if err := c.Push(it.Values()); err != nil {
+			c.Clear()
+			return nil, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		c.Clear()
+		return nil, err
+	}
+	if s.Sketches != nil {
+		if len(s.Sketches) != int(c.NumPoints) {
+			c.Clear()
+			return nil, fmt.Errorf("chunk has %d points but %d sketches", c.NumPoints, len(s.Sketches))
+		}
+		c.Sketches = s.Sketches
+	}
+	if s.Closed {
+		c.Finish()
+	}
+	return c, nil
+}
+
+// restore sets the state of a newly created metric to the one of the snapshot.
+// aggregators that are not in the snapshot start from scratch.
+func (a *AggMetric) restore(s AggMetricSnapshot) error {
+	if s.ChunkSpan != a.ChunkSpan || s.NumChunks != a.NumChunks || len(s.Chunks) > int(a.NumChunks) ||
+		(len(s.Chunks) > 0 && (s.CurrentChunkPos < 0 || s.CurrentChunkPos >= len(s.Chunks))) {
+		return errSnapshotMismatch
+	}
+	chunks := make([]*chunk.Chunk, 0, a.NumChunks)
+	for _, cs := range s.Chunks {
+		c, err := restoreChunk(cs)
+		if err != nil {
+			for _, c := range chunks {
+				c.Clear()
+			}
+			return fmt.Errorf("failed to restore chunk %d: %s", cs.T0, err)
+		}
+		chunks = append(chunks, c)
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	for _, c := range a.Chunks {
+		c.Clear()
+	}
+	a.Chunks = chunks
+	a.CurrentChunkPos = s.CurrentChunkPos
+	a.firstChunkT0 = s.FirstChunkT0
+	a.lastSaveStart = s.LastSaveStart
+	a.lastSaveFinish = s.LastSaveFinish
+	a.lastWrite = s.LastWrite
+
+	for _, as := range s.Aggregators {
+		for _, agg := range a.aggregators {
+			if agg.span == as.Span {
+				agg.restore(as)
+			}
+		}
+	}
+
+	if len(s.Rob) == 0 {
+		return nil
+	}
+	if a.rob != nil && s.RobInterval == a.rob.interval && len(s.Rob) == len(a.rob.buf) && s.RobNewest < a.rob.len {
+		copy(a.rob.buf, s.Rob)
+		a.rob.newest = s.RobNewest
+		return nil
+	}
+	// the reorder window changed, write the buffered points through
+	points := make([]schema.Point, 0, len(s.Rob))
+	for _, p := range s.Rob {
+		if p.Ts != 0 {
+			points = append(points, p)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Ts < points[j].Ts })
+	for _, p := range points {
+		if a.rob == nil {
+			a.add(p.Ts, p.Val)
+			continue
+		}
+		for _, p := range a.rob.Add(p.Ts, p.Val) {
+			a.add(p.Ts, p.Val)
+		}
+	}
+	return nil
+}
+
+func (agg *Aggregator) snapshot() AggregatorSnapshot {
+	s := AggregatorSnapshot{
+		Span:            agg.span,
+		CurrentBoundary: agg.currentBoundary,
+		Min:             agg.agg.Min,
+		Max:             agg.agg.Max,
+		Sum:             agg.agg.Sum,
+		Cnt:             agg.agg.Cnt,
+		Lst:             agg.agg.Lst,
+	}
+	snapshotMetric := func(m *AggMetric) *AggMetricSnapshot {
+		if m == nil {
+			return nil
+		}
+		ms := m.snapshot()
+		return &ms
+	}
+	s.MinMetric = snapshotMetric(agg.minMetric)
+	s.MaxMetric = snapshotMetric(agg.maxMetric)
+	s.SumMetric = snapshotMetric(agg.sumMetric)
+	s.CntMetric = snapshotMetric(agg.cntMetric)
+	s.LstMetric = snapshotMetric(agg.lstMetric)
+	s.SketchMetric = snapshotMetric(agg.sketchMetric)
+	if agg.sketch != nil {
+		s.Sketch = agg.sketch.Marshal()
+	}
+	s.IncMetric = snapshotMetric(agg.incMetric)
+	if agg.incMetric != nil {
+		s.Inc = agg.inc
+		s.Prev = agg.prev
+	}
+	return s
+}
+
+// restore sets the state of the aggregator and its series to the one of the snapshot.
+// series that fail to be restored start from scratch.
+func (agg *Aggregator) restore(s AggregatorSnapshot) {
+	agg.currentBoundary = s.CurrentBoundary
+	agg.agg.Min = s.Min
+	agg.agg.Max = s.Max
+	agg.agg.Sum = s.Sum
+	agg.agg.Cnt = s.Cnt
+	agg.agg.Lst = s.Lst
+	restoreMetric := func(m *AggMetric, s *AggMetricSnapshot) {
+		if m == nil || s == nil {
+			return
+		}
+		if err := m.restore(*s); err != nil {
+			log.Warn("AM failed to restore %s from snapshot: %s", m.Key, err)
+		}
+	}
+	restoreMetric(agg.minMetric, s.MinMetric)
+	restoreMetric(agg.maxMetric, s.MaxMetric)
+	restoreMetric(agg.sumMetric, s.SumMetric)
+	restoreMetric(agg.cntMetric, s.CntMetric)
+	restoreMetric(agg.lstMetric, s.LstMetric)
+	restoreMetric(agg.sketchMetric, s.SketchMetric)
+	restoreMetric(agg.incMetric, s.IncMetric)
+	if agg.incMetric != nil && s.IncMetric != nil {
+		agg.inc = s.Inc
+		agg.prev = s.Prev
+	}
+	if agg.sketch != nil && s.Sketch != nil {
+		sk, err := sketch.Unmarshal(s.Sketch)
+		if err != nil {
+			log.Warn("AM failed to restore the sketch of %s from snapshot: %s", agg.key, err)
+			return
+		}
+		agg.sketch = sk
+	}
+}
@@ -0,0 +1,1889 @@
+package mdata
+
+// NOTE: THIS FILE WAS PRODUCED BY THE
+// MSGP CODE GENERATION TOOL (github.com/tinylib/msgp)
+// DO NOT EDIT
+
+import (
+	"github.com/tinylib/msgp/msgp"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// DecodeMsg implements msgp.Decodable
+func (z *AggMetricSnapshot) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Key":
+			z.Key, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "ChunkSpan":
+			z.ChunkSpan, err = dc.ReadUint32()
+			if err != nil {
+				return
+			}
+		case "NumChunks":
+			z.NumChunks, err = dc.ReadUint32()
+			if err != nil {
+				return
+			}
+		case "CurrentChunkPos":
+			z.CurrentChunkPos, err = dc.ReadInt()
+			if err != nil {
+				return
+			}
+		case "Chunks":
+			var zb0002 uint32
+			zb0002, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Chunks) >= int(zb0002) {
+				z.Chunks = (z.Chunks)[:zb0002]
+			} else {
+				z.Chunks = make([]ChunkSnapshot, zb0002)
+			}
+			for za0001 := range z.Chunks {
+				err = z.Chunks[za0001].DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		case "FirstChunkT0":
+			z.FirstChunkT0, err = dc.ReadUint32()
+			if err != nil {
+				return
+			}
+		case "LastSaveStart":
+			z.LastSaveStart, err = dc.ReadUint32()
+			if err != nil {
+				return
+			}
+		case "LastSaveFinish":
+			z.LastSaveFinish, err = dc.ReadUint32()
+			if err != nil {
+				return
+			}
+		case "LastWrite":
+			z.LastWrite, err = dc.ReadUint32()
+			if err != nil {
+				return
+			}
+		case "RobInterval":
+			z.RobInterval, err = dc.ReadUint32()
+			if err != nil {
+				return
+			}
+		case "RobNewest":
+			z.RobNewest, err = dc.ReadUint32()
+			if err != nil {
+				return
+			}
+		case "Rob":
+			var zb0003 uint32
+			zb0003, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Rob) >= int(zb0003) {
+				z.Rob = (z.Rob)[:zb0003]
+			} else {
+				z.Rob = make([]schema.Point, zb0003)
+			}
+			for za0002 := range z.Rob {
+				err = z.Rob[za0002].DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		case "Aggregators":
+			var zb0004 uint32
+			zb0004, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Aggregators) >= int(zb0004) {
+				z.Aggregators = (z.Aggregators)[:zb0004]
+			} else {
+				z.Aggregators = make([]AggregatorSnapshot, zb0004)
+			}
+			for za0003 := range z.Aggregators {
+				err = z.Aggregators[za0003].DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *AggMetricSnapshot) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 13
+	// write "Key"
+	err = en.Append(0x8d, 0xa3, 0x4b, 0x65, 0x79)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Key)
+	if err != nil {
+		return
+	}
+	// write "ChunkSpan"
+	err = en.Append(0xa9, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x53, 0x70, 0x61, 0x6e)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint32(z.ChunkSpan)
+	if err != nil {
+		return
+	}
+	// write "NumChunks"
+	err = en.Append(0xa9, 0x4e, 0x75, 0x6d, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint32(z.NumChunks)
+	if err != nil {
+		return
+	}
+	// write "CurrentChunkPos"
+	err = en.Append(0xaf, 0x43, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x50, 0x6f, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.CurrentChunkPos)
+	if err != nil {
+		return
+	}
+	// write "Chunks"
+	err = en.Append(0xa6, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Chunks)))
+	if err != nil {
+		return
+	}
+	for za0001 := range z.Chunks {
+		err = z.Chunks[za0001].EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "FirstChunkT0"
+	err = en.Append(0xac, 0x46, 0x69, 0x72, 0x73, 0x74, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x54, 0x30)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint32(z.FirstChunkT0)
+	if err != nil {
+		return
+	}
+	// write "LastSaveStart"
+	err = en.Append(0xad, 0x4c, 0x61, 0x73, 0x74, 0x53, 0x61, 0x76, 0x65, 0x53, 0x74, 0x61, 0x72, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint32(z.LastSaveStart)
+	if err != nil {
+		return
+	}
+	// write "LastSaveFinish"
+	err = en.Append(0xae, 0x4c, 0x61, 0x73, 0x74, 0x53, 0x61, 0x76, 0x65, 0x46, 0x69, 0x6e, 0x69, 0x73, 0x68)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint32(z.LastSaveFinish)
+	if err != nil {
+		return
+	}
+	// write "LastWrite"
+	err = en.Append(0xa9, 0x4c, 0x61, 0x73, 0x74, 0x57, 0x72, 0x69, 0x74, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint32(z.LastWrite)
+	if err != nil {
+		return
+	}
+	// write "RobInterval"
+	err = en.Append(0xab, 0x52, 0x6f, 0x62, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint32(z.RobInterval)
+	if err != nil {
+		return
+	}
+	// write "RobNewest"
+	err = en.Append(0xa9, 0x52, 0x6f, 0x62, 0x4e, 0x65, 0x77, 0x65, 0x73, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint32(z.RobNewest)
+	if err != nil {
+		return
+	}
+	// write "Rob"
+	err = en.Append(0xa3, 0x52, 0x6f, 0x62)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Rob)))
+	if err != nil {
+		return
+	}
+	for za0002 := range z.Rob {
+		err = z.Rob[za0002].EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "Aggregators"
+	err = en.Append(0xab, 0x41, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x6f, 0x72, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Aggregators)))
+	if err != nil {
+		return
+	}
+	for za0003 := range z.Aggregators {
+		err = z.Aggregators[za0003].EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z *AggMetricSnapshot) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.Require(b, z.Msgsize())
+	// map header, size 13
+	// string "Key"
+	o = append(o, 0x8d, 0xa3, 0x4b, 0x65, 0x79)
+	o = msgp.AppendString(o, z.Key)
+	// string "ChunkSpan"
+	o = append(o, 0xa9, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x53, 0x70, 0x61, 0x6e)
+	o = msgp.AppendUint32(o, z.ChunkSpan)
+	// string "NumChunks"
+	o = append(o, 0xa9, 0x4e, 0x75, 0x6d, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x73)
+	o = msgp.AppendUint32(o, z.NumChunks)
+	// string "CurrentChunkPos"
+	o = append(o, 0xaf, 0x43, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x50, 0x6f, 0x73)
+	o = msgp.AppendInt(o, z.CurrentChunkPos)
+	// string "Chunks"
+	o = append(o, 0xa6, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x73)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Chunks)))
+	for za0001 := range z.Chunks {
+		o, err = z.Chunks[za0001].MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	// string "FirstChunkT0"
+	o = append(o, 0xac, 0x46, 0x69, 0x72, 0x73, 0x74, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x54, 0x30)
+	o = msgp.AppendUint32(o, z.FirstChunkT0)
+	// string "LastSaveStart"
+	o = append(o, 0xad, 0x4c, 0x61, 0x73, 0x74, 0x53, 0x61, 0x76, 0x65, 0x53, 0x74, 0x61, 0x72, 0x74)
+	o = msgp.AppendUint32(o, z.LastSaveStart)
+	// string "LastSaveFinish"
+	o = append(o, 0xae, 0x4c, 0x61, 0x73, 0x74, 0x53, 0x61, 0x76, 0x65, 0x46, 0x69, 0x6e, 0x69, 0x73, 0x68)
+	o = msgp.AppendUint32(o, z.LastSaveFinish)
+	// string "LastWrite"
+	o = append(o, 0xa9, 0x4c, 0x61, 0x73, 0x74, 0x57, 0x72, 0x69, 0x74, 0x65)
+	o = msgp.AppendUint32(o, z.LastWrite)
+	// string "RobInterval"
+	o = append(o, 0xab, 0x52, 0x6f, 0x62, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c)
+	o = msgp.AppendUint32(o, z.RobInterval)
+	// string "RobNewest"
+	o = append(o, 0xa9, 0x52, 0x6f, 0x62, 0x4e, 0x65, 0x77, 0x65, 0x73, 0x74)
+	o = msgp.AppendUint32(o, z.RobNewest)
+	// string "Rob"
+	o = append(o, 0xa3, 0x52, 0x6f, 0x62)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Rob)))
+	for za0002 := range z.Rob {
+		o, err = z.Rob[za0002].MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	// string "Aggregators"
+	o = append(o, 0xab, 0x41, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x6f, 0x72, 0x73)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Aggregators)))
+	for za0003 := range z.Aggregators {
+		o, err = z.Aggregators[za0003].MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *AggMetricSnapshot) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Key":
+			z.Key, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				return
+			}
+		case "ChunkSpan":
+			z.ChunkSpan, bts, err = msgp.ReadUint32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "NumChunks":
+			z.NumChunks, bts, err = msgp.ReadUint32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "CurrentChunkPos":
+			z.CurrentChunkPos, bts, err = msgp.ReadIntBytes(bts)
+			if err != nil {
+				return
+			}
+		case "Chunks":
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Chunks) >= int(zb0002) {
+				z.Chunks = (z.Chunks)[:zb0002]
+			} else {
+				z.Chunks = make([]ChunkSnapshot, zb0002)
+			}
+			for za0001 := range z.Chunks {
+				bts, err = z.Chunks[za0001].UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		case "FirstChunkT0":
+			z.FirstChunkT0, bts, err = msgp.ReadUint32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "LastSaveStart":
+			z.LastSaveStart, bts, err = msgp.ReadUint32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "LastSaveFinish":
+			z.LastSaveFinish, bts, err = msgp.ReadUint32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "LastWrite":
+			z.LastWrite, bts, err = msgp.ReadUint32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "RobInterval":
+			z.RobInterval, bts, err = msgp.ReadUint32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "RobNewest":
+			z.RobNewest, bts, err = msgp.ReadUint32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Rob":
+			var zb0003 uint32
+			zb0003, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Rob) >= int(zb0003) {
+				z.Rob = (z.Rob)[:zb0003]
+			} else {
+				z.Rob = make([]schema.Point, zb0003)
+			}
+			for za0002 := range z.Rob {
+				bts, err = z.Rob[za0002].UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		case "Aggregators":
+			var zb0004 uint32
+			zb0004, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Aggregators) >= int(zb0004) {
+				z.Aggregators = (z.Aggregators)[:zb0004]
+			} else {
+				z.Aggregators = make([]AggregatorSnapshot, zb0004)
+			}
+			for za0003 := range z.Aggregators {
+				bts, err = z.Aggregators[za0003].UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			bts, err = msgp.Skip(bts)
+			if err != nil {
+				return
+			}
+		}
+	}
+	o = bts
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *AggMetricSnapshot) Msgsize() (s int) {
+	s = 1 + 4 + msgp.StringPrefixSize + len(z.Key) + 10 + msgp.Uint32Size + 10 + msgp.Uint32Size + 16 + msgp.IntSize + 7 + msgp.ArrayHeaderSize
+	for za0001 := range z.Chunks {
+		s += z.Chunks[za0001].Msgsize()
+	}
+	s += 13 + msgp.Uint32Size + 14 + msgp.Uint32Size + 15 + msgp.Uint32Size + 10 + msgp.Uint32Size + 12 + msgp.Uint32Size + 10 + msgp.Uint32Size + 4 + msgp.ArrayHeaderSize
+	for za0002 := range z.Rob {
+		s += z.Rob[za0002].Msgsize()
+	}
+	s += 12 + msgp.ArrayHeaderSize
+	for za0003 := range z.Aggregators {
+		s += z.Aggregators[za0003].Msgsize()
+	}
+	return
+}
+
+// DecodeMsg implements msgp.Decodable
+func (z *AggregatorSnapshot) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Span":
+			z.Span, err = dc.ReadUint32()
+			if err != nil {
+				return
+			}
+		case "CurrentBoundary":
+			z.CurrentBoundary, err = dc.ReadUint32()
+			if err != nil {
+				return
+			}
+		case "Min":
+			z.Min, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "Max":
+			z.Max, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "Sum":
+			z.Sum, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "Cnt":
+			z.Cnt, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "Lst":
+			z.Lst, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "MinMetric":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					return
+				}
+				z.MinMetric = nil
+			} else {
+				if z.MinMetric == nil {
+					z.MinMetric = new(AggMetricSnapshot)
+				}
+				err = z.MinMetric.DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		case "MaxMetric":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					return
+				}
+				z.MaxMetric = nil
+			} else {
+				if z.MaxMetric == nil {
+					z.MaxMetric = new(AggMetricSnapshot)
+				}
+				err = z.MaxMetric.DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		case "SumMetric":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					return
+				}
+				z.SumMetric = nil
+			} else {
+				if z.SumMetric == nil {
+					z.SumMetric = new(AggMetricSnapshot)
+				}
+				err = z.SumMetric.DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		case "CntMetric":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					return
+				}
+				z.CntMetric = nil
+			} else {
+				if z.CntMetric == nil {
+					z.CntMetric = new(AggMetricSnapshot)
+				}
+				err = z.CntMetric.DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		case "LstMetric":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					return
+				}
+				z.LstMetric = nil
+			} else {
+				if z.LstMetric == nil {
+					z.LstMetric = new(AggMetricSnapshot)
+				}
+				err = z.LstMetric.DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		case "Sketch":
+			z.Sketch, err = dc.ReadBytes(z.Sketch)
+			if err != nil {
+				return
+			}
+		case "SketchMetric":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					return
+				}
+				z.SketchMetric = nil
+			} else {
+				if z.SketchMetric == nil {
+					z.SketchMetric = new(AggMetricSnapshot)
+				}
+				err = z.SketchMetric.DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		case "Inc":
+			z.Inc, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "Prev":
+			z.Prev, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "IncMetric":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					return
+				}
+				z.IncMetric = nil
+			} else {
+				if z.IncMetric == nil {
+					z.IncMetric = new(AggMetricSnapshot)
+				}
+				err = z.IncMetric.DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *AggregatorSnapshot) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 17
+	// write "Span"
+	err = en.Append(0xde, 0x0, 0x11, 0xa4, 0x53, 0x70, 0x61, 0x6e)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint32(z.Span)
+	if err != nil {
+		return
+	}
+	// write "CurrentBoundary"
+	err = en.Append(0xaf, 0x43, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x42, 0x6f, 0x75, 0x6e, 0x64, 0x61, 0x72, 0x79)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint32(z.CurrentBoundary)
+	if err != nil {
+		return
+	}
+	// write "Min"
+	err = en.Append(0xa3, 0x4d, 0x69, 0x6e)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Min)
+	if err != nil {
+		return
+	}
+	// write "Max"
+	err = en.Append(0xa3, 0x4d, 0x61, 0x78)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Max)
+	if err != nil {
+		return
+	}
+	// write "Sum"
+	err = en.Append(0xa3, 0x53, 0x75, 0x6d)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Sum)
+	if err != nil {
+		return
+	}
+	// write "Cnt"
+	err = en.Append(0xa3, 0x43, 0x6e, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Cnt)
+	if err != nil {
+		return
+	}
+	// write "Lst"
+	err = en.Append(0xa3, 0x4c, 0x73, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Lst)
+	if err != nil {
+		return
+	}
+	// write "MinMetric"
+	err = en.Append(0xa9, 0x4d, 0x69, 0x6e, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if err != nil {
+		return
+	}
+	if z.MinMetric == nil {
+		err = en.WriteNil()
+		if err != nil {
+			return
+		}
+	} else {
+		err = z.MinMetric.EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "MaxMetric"
+	err = en.Append(0xa9, 0x4d, 0x61, 0x78, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if err != nil {
+		return
+	}
+	if z.MaxMetric == nil {
+		err = en.WriteNil()
+		if err != nil {
+			return
+		}
+	} else {
+		err = z.MaxMetric.EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "SumMetric"
+	err = en.Append(0xa9, 0x53, 0x75, 0x6d, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if err != nil {
+		return
+	}
+	if z.SumMetric == nil {
+		err = en.WriteNil()
+		if err != nil {
+			return
+		}
+	} else {
+		err = z.SumMetric.EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "CntMetric"
+	err = en.Append(0xa9, 0x43, 0x6e, 0x74, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if err != nil {
+		return
+	}
+	if z.CntMetric == nil {
+		err = en.WriteNil()
+		if err != nil {
+			return
+		}
+	} else {
+		err = z.CntMetric.EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "LstMetric"
+	err = en.Append(0xa9, 0x4c, 0x73, 0x74, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if err != nil {
+		return
+	}
+	if z.LstMetric == nil {
+		err = en.WriteNil()
+		if err != nil {
+			return
+		}
+	} else {
+		err = z.LstMetric.EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "Sketch"
+	err = en.Append(0xa6, 0x53, 0x6b, 0x65, 0x74, 0x63, 0x68)
+	if err != nil {
+		return
+	}
+	err = en.WriteBytes(z.Sketch)
+	if err != nil {
+		return
+	}
+	// write "SketchMetric"
+	err = en.Append(0xac, 0x53, 0x6b, 0x65, 0x74, 0x63, 0x68, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if err != nil {
+		return
+	}
+	if z.SketchMetric == nil {
+		err = en.WriteNil()
+		if err != nil {
+			return
+		}
+	} else {
+		err = z.SketchMetric.EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "Inc"
+	err = en.Append(0xa3, 0x49, 0x6e, 0x63)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Inc)
+	if err != nil {
+		return
+	}
+	// write "Prev"
+	err = en.Append(0xa4, 0x50, 0x72, 0x65, 0x76)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Prev)
+	if err != nil {
+		return
+	}
+	// write "IncMetric"
+	err = en.Append(0xa9, 0x49, 0x6e, 0x63, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if err != nil {
+		return
+	}
+	if z.IncMetric == nil {
+		err = en.WriteNil()
+		if err != nil {
+			return
+		}
+	} else {
+		err = z.IncMetric.EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z *AggregatorSnapshot) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.Require(b, z.Msgsize())
+	// map header, size 17
+	// string "Span"
+	o = append(o, 0xde, 0x0, 0x11, 0xa4, 0x53, 0x70, 0x61, 0x6e)
+	o = msgp.AppendUint32(o, z.Span)
+	// string "CurrentBoundary"
+	o = append(o, 0xaf, 0x43, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x42, 0x6f, 0x75, 0x6e, 0x64, 0x61, 0x72, 0x79)
+	o = msgp.AppendUint32(o, z.CurrentBoundary)
+	// string "Min"
+	o = append(o, 0xa3, 0x4d, 0x69, 0x6e)
+	o = msgp.AppendFloat64(o, z.Min)
+	// string "Max"
+	o = append(o, 0xa3, 0x4d, 0x61, 0x78)
+	o = msgp.AppendFloat64(o, z.Max)
+	// string "Sum"
+	o = append(o, 0xa3, 0x53, 0x75, 0x6d)
+	o = msgp.AppendFloat64(o, z.Sum)
+	// string "Cnt"
+	o = append(o, 0xa3, 0x43, 0x6e, 0x74)
+	o = msgp.AppendFloat64(o, z.Cnt)
+	// string "Lst"
+	o = append(o, 0xa3, 0x4c, 0x73, 0x74)
+	o = msgp.AppendFloat64(o, z.Lst)
+	// string "MinMetric"
+	o = append(o, 0xa9, 0x4d, 0x69, 0x6e, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if z.MinMetric == nil {
+		o = msgp.AppendNil(o)
+	} else {
+		o, err = z.MinMetric.MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	// string "MaxMetric"
+	o = append(o, 0xa9, 0x4d, 0x61, 0x78, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if z.MaxMetric == nil {
+		o = msgp.AppendNil(o)
+	} else {
+		o, err = z.MaxMetric.MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	// string "SumMetric"
+	o = append(o, 0xa9, 0x53, 0x75, 0x6d, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if z.SumMetric == nil {
+		o = msgp.AppendNil(o)
+	} else {
+		o, err = z.SumMetric.MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	// string "CntMetric"
+	o = append(o, 0xa9, 0x43, 0x6e, 0x74, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if z.CntMetric == nil {
+		o = msgp.AppendNil(o)
+	} else {
+		o, err = z.CntMetric.MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	// string "LstMetric"
+	o = append(o, 0xa9, 0x4c, 0x73, 0x74, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if z.LstMetric == nil {
+		o = msgp.AppendNil(o)
+	} else {
+		o, err = z.LstMetric.MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	// string "Sketch"
+	o = append(o, 0xa6, 0x53, 0x6b, 0x65, 0x74, 0x63, 0x68)
+	o = msgp.AppendBytes(o, z.Sketch)
+	// string "SketchMetric"
+	o = append(o, 0xac, 0x53, 0x6b, 0x65, 0x74, 0x63, 0x68, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if z.SketchMetric == nil {
+		o = msgp.AppendNil(o)
+	} else {
+		o, err = z.SketchMetric.MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	// string "Inc"
+	o = append(o, 0xa3, 0x49, 0x6e, 0x63)
+	o = msgp.AppendFloat64(o, z.Inc)
+	// string "Prev"
+	o = append(o, 0xa4, 0x50, 0x72, 0x65, 0x76)
+	o = msgp.AppendFloat64(o, z.Prev)
+	// string "IncMetric"
+	o = append(o, 0xa9, 0x49, 0x6e, 0x63, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63)
+	if z.IncMetric == nil {
+		o = msgp.AppendNil(o)
+	} else {
+		o, err = z.IncMetric.MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *AggregatorSnapshot) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Span":
+			z.Span, bts, err = msgp.ReadUint32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "CurrentBoundary":
+			z.CurrentBoundary, bts, err = msgp.ReadUint32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Min":
+			z.Min, bts, err = msgp.ReadFloat64Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Max":
+			z.Max, bts, err = msgp.ReadFloat64Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Sum":
+			z.Sum, bts, err = msgp.ReadFloat64Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Cnt":
+			z.Cnt, bts, err = msgp.ReadFloat64Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Lst":
+			z.Lst, bts, err = msgp.ReadFloat64Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "MinMetric":
+			if msgp.IsNil(bts) {
+				bts, err = msgp.ReadNilBytes(bts)
+				if err != nil {
+					return
+				}
+				z.MinMetric = nil
+			} else {
+				if z.MinMetric == nil {
+					z.MinMetric = new(AggMetricSnapshot)
+				}
+				bts, err = z.MinMetric.UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		case "MaxMetric":
+			if msgp.IsNil(bts) {
+				bts, err = msgp.ReadNilBytes(bts)
+				if err != nil {
+					return
+				}
+				z.MaxMetric = nil
+			} else {
+				if z.MaxMetric == nil {
+					z.MaxMetric = new(AggMetricSnapshot)
+				}
+				bts, err = z.MaxMetric.UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		case "SumMetric":
+			if msgp.IsNil(bts) {
+				bts, err = msgp.ReadNilBytes(bts)
+				if err != nil {
+					return
+				}
+				z.SumMetric = nil
+			} else {
+				if z.SumMetric == nil {
+					z.SumMetric = new(AggMetricSnapshot)
+				}
+				bts, err = z.SumMetric.UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		case "CntMetric":
+			if msgp.IsNil(bts) {
+				bts, err = msgp.ReadNilBytes(bts)
+				if err != nil {
+					return
+				}
+				z.CntMetric = nil
+			} else {
+				if z.CntMetric == nil {
+					z.CntMetric = new(AggMetricSnapshot)
+				}
+				bts, err = z.CntMetric.UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		case "LstMetric":
+			if msgp.IsNil(bts) {
+				bts, err = msgp.ReadNilBytes(bts)
+				if err != nil {
+					return
+				}
+				z.LstMetric = nil
+			} else {
+				if z.LstMetric == nil {
+					z.LstMetric = new(AggMetricSnapshot)
+				}
+				bts, err = z.LstMetric.UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		case "Sketch":
+			z.Sketch, bts, err = msgp.ReadBytesBytes(bts, z.Sketch)
+			if err != nil {
+				return
+			}
+		case "SketchMetric":
+			if msgp.IsNil(bts) {
+				bts, err = msgp.ReadNilBytes(bts)
+				if err != nil {
+					return
+				}
+				z.SketchMetric = nil
+			} else {
+				if z.SketchMetric == nil {
+					z.SketchMetric = new(AggMetricSnapshot)
+				}
+				bts, err = z.SketchMetric.UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		case "Inc":
+			z.Inc, bts, err = msgp.ReadFloat64Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Prev":
+			z.Prev, bts, err = msgp.ReadFloat64Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "IncMetric":
+			if msgp.IsNil(bts) {
+				bts, err = msgp.ReadNilBytes(bts)
+				if err != nil {
+					return
+				}
+				z.IncMetric = nil
+			} else {
+				if z.IncMetric == nil {
+					z.IncMetric = new(AggMetricSnapshot)
+				}
+				bts, err = z.IncMetric.UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			bts, err = msgp.Skip(bts)
+			if err != nil {
+				return
+			}
+		}
+	}
+	o = bts
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *AggregatorSnapshot) Msgsize() (s int) {
+	s = 3 + 5 + msgp.Uint32Size + 16 + msgp.Uint32Size + 4 + msgp.Float64Size + 4 + msgp.Float64Size + 4 + msgp.Float64Size + 4 + msgp.Float64Size + 4 + msgp.Float64Size + 10
+	if z.MinMetric == nil {
+		s += msgp.NilSize
+	} else {
+		s += z.MinMetric.Msgsize()
+	}
+	s += 10
+	if z.MaxMetric == nil {
+		s += msgp.NilSize
+	} else {
+		s += z.MaxMetric.Msgsize()
+	}
+	s += 10
+	if z.SumMetric == nil {
+		s += msgp.NilSize
+	} else {
+		s += z.SumMetric.Msgsize()
+	}
+	s += 10
+	if z.CntMetric == nil {
+		s += msgp.NilSize
+	} else {
+		s += z.CntMetric.Msgsize()
+	}
+	s += 10
+	if z.LstMetric == nil {
+		s += msgp.NilSize
+	} else {
+		s += z.LstMetric.Msgsize()
+	}
+	s += 7 + msgp.BytesPrefixSize + len(z.Sketch) + 13
+	if z.SketchMetric == nil {
+		s += msgp.NilSize
+	} else {
+		s += z.SketchMetric.Msgsize()
+	}
+	s += 4 + msgp.Float64Size + 5 + msgp.Float64Size + 10
+	if z.IncMetric == nil {
+		s += msgp.NilSize
+	} else {
+		s += z.IncMetric.Msgsize()
+	}
+	return
+}
+
+// DecodeMsg implements msgp.Decodable
+func (z *ChunkSnapshot) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "T0":
+			z.T0, err = dc.ReadUint32()
+			if err != nil {
+				return
+			}
+		case "Closed":
+			z.Closed, err = dc.ReadBool()
+			if err != nil {
+				return
+			}
+		case "Data":
+			z.Data, err = dc.ReadBytes(z.Data)
+			if err != nil {
+				return
+			}
+		case "Sketches":
+			var zb0002 uint32
+			zb0002, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Sketches) >= int(zb0002) {
+				z.Sketches = (z.Sketches)[:zb0002]
+			} else {
+				z.Sketches = make([][]byte, zb0002)
+			}
+			for za0001 := range z.Sketches {
+				z.Sketches[za0001], err = dc.ReadBytes(z.Sketches[za0001])
+				if err != nil {
+					return
+				}
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *ChunkSnapshot) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 4
+	// write "T0"
+	err = en.Append(0x84, 0xa2, 0x54, 0x30)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint32(z.T0)
+	if err != nil {
+		return
+	}
+	// write "Closed"
+	err = en.Append(0xa6, 0x43, 0x6c, 0x6f, 0x73, 0x65, 0x64)
+	if err != nil {
+		return
+	}
+	err = en.WriteBool(z.Closed)
+	if err != nil {
+		return
+	}
+	// write "Data"
+	err = en.Append(0xa4, 0x44, 0x61, 0x74, 0x61)
+	if err != nil {
+		return
+	}
+	err = en.WriteBytes(z.Data)
+	if err != nil {
+		return
+	}
+	// write "Sketches"
+	err = en.Append(0xa8, 0x53, 0x6b, 0x65, 0x74, 0x63, 0x68, 0x65, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Sketches)))
+	if err != nil {
+		return
+	}
+	for za0001 := range z.Sketches {
+		err = en.WriteBytes(z.Sketches[za0001])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z *ChunkSnapshot) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.Require(b, z.Msgsize())
+	// map header, size 4
+	// string "T0"
+	o = append(o, 0x84, 0xa2, 0x54, 0x30)
+	o = msgp.AppendUint32(o, z.T0)
+	// string "Closed"
+	o = append(o, 0xa6, 0x43, 0x6c, 0x6f, 0x73, 0x65, 0x64)
+	o = msgp.AppendBool(o, z.Closed)
+	// string "Data"
+	o = append(o, 0xa4, 0x44, 0x61, 0x74, 0x61)
+	o = msgp.AppendBytes(o, z.Data)
+	// string "Sketches"
+	o = append(o, 0xa8, 0x53, 0x6b, 0x65, 0x74, 0x63, 0x68, 0x65, 0x73)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Sketches)))
+	for za0001 := range z.Sketches {
+		o = msgp.AppendBytes(o, z.Sketches[za0001])
+	}
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *ChunkSnapshot) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "T0":
+			z.T0, bts, err = msgp.ReadUint32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Closed":
+			z.Closed, bts, err = msgp.ReadBoolBytes(bts)
+			if err != nil {
+				return
+			}
+		case "Data":
+			z.Data, bts, err = msgp.ReadBytesBytes(bts, z.Data)
+			if err != nil {
+				return
+			}
+		case "Sketches":
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Sketches) >= int(zb0002) {
+				z.Sketches = (z.Sketches)[:zb0002]
+			} else {
+				z.Sketches = make([][]byte, zb0002)
+			}
+			for za0001 := range z.Sketches {
+				z.Sketches[za0001], bts, err = msgp.ReadBytesBytes(bts, z.Sketches[za0001])
+				if err != nil {
+					return
+				}
+			}
+		default:
+			bts, err = msgp.Skip(bts)
+			if err != nil {
+				return
+			}
+		}
+	}
+	o = bts
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *ChunkSnapshot) Msgsize() (s int) {
+	s = 1 + 3 + msgp.Uint32Size + 7 + msgp.BoolSize + 5 + msgp.BytesPrefixSize + len(z.Data) + 9 + msgp.ArrayHeaderSize
+	for za0001 := range z.Sketches {
+		s += msgp.BytesPrefixSize + len(z.Sketches[za0001])
+	}
+	return
+}
+
+// DecodeMsg implements msgp.Decodable
+func (z *PartitionOffset) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Topic":
+			z.Topic, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "Partition":
+			z.Partition, err = dc.ReadInt32()
+			if err != nil {
+				return
+			}
+		case "Offset":
+			z.Offset, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z PartitionOffset) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 3
+	// write "Topic"
+	err = en.Append(0x83, 0xa5, 0x54, 0x6f, 0x70, 0x69, 0x63)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Topic)
+	if err != nil {
+		return
+	}
+	// write "Partition"
+	err = en.Append(0xa9, 0x50, 0x61, 0x72, 0x74, 0x69, 0x74, 0x69, 0x6f, 0x6e)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt32(z.Partition)
+	if err != nil {
+		return
+	}
+	// write "Offset"
+	err = en.Append(0xa6, 0x4f, 0x66, 0x66, 0x73, 0x65, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt64(z.Offset)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z PartitionOffset) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.Require(b, z.Msgsize())
+	// map header, size 3
+	// string "Topic"
+	o = append(o, 0x83, 0xa5, 0x54, 0x6f, 0x70, 0x69, 0x63)
+	o = msgp.AppendString(o, z.Topic)
+	// string "Partition"
+	o = append(o, 0xa9, 0x50, 0x61, 0x72, 0x74, 0x69, 0x74, 0x69, 0x6f, 0x6e)
+	o = msgp.AppendInt32(o, z.Partition)
+	// string "Offset"
+	o = append(o, 0xa6, 0x4f, 0x66, 0x66, 0x73, 0x65, 0x74)
+	o = msgp.AppendInt64(o, z.Offset)
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *PartitionOffset) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Topic":
+			z.Topic, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				return
+			}
+		case "Partition":
+			z.Partition, bts, err = msgp.ReadInt32Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Offset":
+			z.Offset, bts, err = msgp.ReadInt64Bytes(bts)
+			if err != nil {
+				return
+			}
+		default:
+			bts, err = msgp.Skip(bts)
+			if err != nil {
+				return
+			}
+		}
+	}
+	o = bts
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z PartitionOffset) Msgsize() (s int) {
+	s = 1 + 6 + msgp.StringPrefixSize + len(z.Topic) + 10 + msgp.Int32Size + 7 + msgp.Int64Size
+	return
+}
+
+// DecodeMsg implements msgp.Decodable
+func (z *Snapshot) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Version":
+			z.Version, err = dc.ReadInt()
+			if err != nil {
+				return
+			}
+		case "Time":
+			z.Time, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		case "Offsets":
+			var zb0002 uint32
+			zb0002, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Offsets) >= int(zb0002) {
+				z.Offsets = (z.Offsets)[:zb0002]
+			} else {
+				z.Offsets = make([]PartitionOffset, zb0002)
+			}
+			for za0001 := range z.Offsets {
+				var zb0003 uint32
+				zb0003, err = dc.ReadMapHeader()
+				if err != nil {
+					return
+				}
+				for zb0003 > 0 {
+					zb0003--
+					field, err = dc.ReadMapKeyPtr()
+					if err != nil {
+						return
+					}
+					switch msgp.UnsafeString(field) {
+					case "Topic":
+						z.Offsets[za0001].Topic, err = dc.ReadString()
+						if err != nil {
+							return
+						}
+					case "Partition":
+						z.Offsets[za0001].Partition, err = dc.ReadInt32()
+						if err != nil {
+							return
+						}
+					case "Offset":
+						z.Offsets[za0001].Offset, err = dc.ReadInt64()
+						if err != nil {
+							return
+						}
+					default:
+						err = dc.Skip()
+						if err != nil {
+							return
+						}
+					}
+				}
+			}
+		case "Metrics":
+			var zb0004 uint32
+			zb0004, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Metrics) >= int(zb0004) {
+				z.Metrics = (z.Metrics)[:zb0004]
+			} else {
+				z.Metrics = make([]AggMetricSnapshot, zb0004)
+			}
+			for za0002 := range z.Metrics {
+				err = z.Metrics[za0002].DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *Snapshot) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 4
+	// write "Version"
+	err = en.Append(0x84, 0xa7, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.Version)
+	if err != nil {
+		return
+	}
+	// write "Time"
+	err = en.Append(0xa4, 0x54, 0x69, 0x6d, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt64(z.Time)
+	if err != nil {
+		return
+	}
+	// write "Offsets"
+	err = en.Append(0xa7, 0x4f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Offsets)))
+	if err != nil {
+		return
+	}
+	for za0001 := range z.Offsets {
+		// map header, size 3
+		// write "Topic"
+		err = en.Append(0x83, 0xa5, 0x54, 0x6f, 0x70, 0x69, 0x63)
+		if err != nil {
+			return
+		}
+		err = en.WriteString(z.Offsets[za0001].Topic)
+		if err != nil {
+			return
+		}
+		// write "Partition"
+		err = en.Append(0xa9, 0x50, 0x61, 0x72, 0x74, 0x69, 0x74, 0x69, 0x6f, 0x6e)
+		if err != nil {
+			return
+		}
+		err = en.WriteInt32(z.Offsets[za0001].Partition)
+		if err != nil {
+			return
+		}
+		// write "Offset"
+		err = en.Append(0xa6, 0x4f, 0x66, 0x66, 0x73, 0x65, 0x74)
+		if err != nil {
+			return
+		}
+		err = en.WriteInt64(z.Offsets[za0001].Offset)
+		if err != nil {
+			return
+		}
+	}
+	// write "Metrics"
+	err = en.Append(0xa7, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Metrics)))
+	if err != nil {
+		return
+	}
+	for za0002 := range z.Metrics {
+		err = z.Metrics[za0002].EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z *Snapshot) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.Require(b, z.Msgsize())
+	// map header, size 4
+	// string "Version"
+	o = append(o, 0x84, 0xa7, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e)
+	o = msgp.AppendInt(o, z.Version)
+	// string "Time"
+	o = append(o, 0xa4, 0x54, 0x69, 0x6d, 0x65)
+	o = msgp.AppendInt64(o, z.Time)
+	// string "Offsets"
+	o = append(o, 0xa7, 0x4f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x73)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Offsets)))
+	for za0001 := range z.Offsets {
+		// map header, size 3
+		// string "Topic"
+		o = append(o, 0x83, 0xa5, 0x54, 0x6f, 0x70, 0x69, 0x63)
+		o = msgp.AppendString(o, z.Offsets[za0001].Topic)
+		// string "Partition"
+		o = append(o, 0xa9, 0x50, 0x61, 0x72, 0x74, 0x69, 0x74, 0x69, 0x6f, 0x6e)
+		o = msgp.AppendInt32(o, z.Offsets[za0001].Partition)
+		// string "Offset"
+		o = append(o, 0xa6, 0x4f, 0x66, 0x66, 0x73, 0x65, 0x74)
+		o = msgp.AppendInt64(o, z.Offsets[za0001].Offset)
+	}
+	// string "Metrics"
+	o = append(o, 0xa7, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x73)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Metrics)))
+	for za0002 := range z.Metrics {
+		o, err = z.Metrics[za0002].MarshalMsg(o)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *Snapshot) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Version":
+			z.Version, bts, err = msgp.ReadIntBytes(bts)
+			if err != nil {
+				return
+			}
+		case "Time":
+			z.Time, bts, err = msgp.ReadInt64Bytes(bts)
+			if err != nil {
+				return
+			}
+		case "Offsets":
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Offsets) >= int(zb0002) {
+				z.Offsets = (z.Offsets)[:zb0002]
+			} else {
+				z.Offsets = make([]PartitionOffset, zb0002)
+			}
+			for za0001 := range z.Offsets {
+				var zb0003 uint32
+				zb0003, bts, err = msgp.ReadMapHeaderBytes(bts)
+				if err != nil {
+					return
+				}
+				for zb0003 > 0 {
+					zb0003--
+					field, bts, err = msgp.ReadMapKeyZC(bts)
+					if err != nil {
+						return
+					}
+					switch msgp.UnsafeString(field) {
+					case "Topic":
+						z.Offsets[za0001].Topic, bts, err = msgp.ReadStringBytes(bts)
+						if err != nil {
+							return
+						}
+					case "Partition":
+						z.Offsets[za0001].Partition, bts, err = msgp.ReadInt32Bytes(bts)
+						if err != nil {
+							return
+						}
+					case "Offset":
+						z.Offsets[za0001].Offset, bts, err = msgp.ReadInt64Bytes(bts)
+						if err != nil {
+							return
+						}
+					default:
+						bts, err = msgp.Skip(bts)
+						if err != nil {
+							return
+						}
+					}
+				}
+			}
+		case "Metrics":
+			var zb0004 uint32
+			zb0004, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Metrics) >= int(zb0004) {
+				z.Metrics = (z.Metrics)[:zb0004]
+			} else {
+				z.Metrics = make([]AggMetricSnapshot, zb0004)
+			}
+			for za0002 := range z.Metrics {
+				bts, err = z.Metrics[za0002].UnmarshalMsg(bts)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			bts, err = msgp.Skip(bts)
+			if err != nil {
+				return
+			}
+		}
+	}
+	o = bts
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *Snapshot) Msgsize() (s int) {
+	s = 1 + 8 + msgp.IntSize + 5 + msgp.Int64Size + 8 + msgp.ArrayHeaderSize
+	for za0001 := range z.Offsets {
+		s += 1 + 6 + msgp.StringPrefixSize + len(z.Offsets[za0001].Topic) + 10 + msgp.Int32Size + 7 + msgp.Int64Size
+	}
+	s += 8 + msgp.ArrayHeaderSize
+	for za0002 := range z.Metrics {
+		s += z.Metrics[za0002].Msgsize()
+	}
+	return
+}
@@ -0,0 +1,127 @@
+package mdata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/mdata/cache"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func resultPoints(res Result) []schema.Point {
+	var points []schema.Point
+	for _, it := range res.Iters {
+		for it.Next() {
+			ts, val := it.Values()
+			points = append(points, schema.Point{Val: val, Ts: ts})
+		}
+	}
+	return append(points, res.Points...)
+}
+
+// resultSketches returns the sketches of the points of a percentile rollup, marshaled to be easy to compare
+func resultSketches(t *testing.T, res Result) [][]byte {
+	var sketches [][]byte
+	for _, it := range res.Iters {
+		for it.Next() {
+			s, err := it.Sketch()
+			if err != nil {
+				t.Fatalf("failed to get sketch: %s", err)
+			}
+			sketches = append(sketches, s.Marshal())
+		}
+	}
+	return sketches
+}
+
+// snapshotT0 is the start of the data in the snapshot tests. timestamps must be realistic for the reorder buffer to accept them
+const snapshotT0 = 1499997600
+
+func compareSnapshotMetrics(t *testing.T, desc string, exp, got Metric) {
+	if e, g := resultPoints(exp.Get(snapshotT0, snapshotT0+10000)), resultPoints(got.Get(snapshotT0, snapshotT0+10000)); !reflect.DeepEqual(e, g) {
+		t.Fatalf("%s: expected raw points %v, got %v", desc, e, g)
+	}
+	for _, cons := range []consolidation.Consolidator{consolidation.Sum, consolidation.Cnt, consolidation.Max, consolidation.P95, consolidation.Inc} {
+		e := resultPoints(exp.GetAggregated(cons, 300, snapshotT0, snapshotT0+10000))
+		g := resultPoints(got.GetAggregated(cons, 300, snapshotT0, snapshotT0+10000))
+		if !reflect.DeepEqual(e, g) {
+			t.Fatalf("%s: expected %s points %v, got %v", desc, cons, e, g)
+		}
+	}
+	e := resultSketches(t, exp.GetAggregated(consolidation.P95, 300, snapshotT0, snapshotT0+10000))
+	g := resultSketches(t, got.GetAggregated(consolidation.P95, 300, snapshotT0, snapshotT0+10000))
+	if len(e) == 0 || !reflect.DeepEqual(e, g) {
+		t.Fatalf("%s: expected %d sketches, got %d, or they don't match", desc, len(e), len(g))
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	SetSingleAgg(conf.Avg, conf.Max, conf.P95, conf.Inc)
+	SetSingleSchema(
+		conf.NewRetentionMT(10, 3600, 600, 5, true),
+		conf.NewRetentionMT(300, 86400, 3600, 2, true),
+	)
+	Schemas.DefaultSchema.ReorderWindow = 3
+	Schemas.BuildIndex()
+
+	dir, err := ioutil.TempDir("", "metrictank-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	metrics := NewAggMetrics(NewMockStore(), &cache.MockCache{}, false, 0, 0, 0)
+	m := metrics.GetOrCreate("a", "a", 0, 0, 0, 0)
+	// the raw series has a closed and an open chunk, the rollup an open chunk and a partial aggregation
+	for ts := uint32(snapshotT0 + 10); ts <= snapshotT0+1000; ts += 10 {
+		m.Add(ts, float64(ts%70))
+	}
+	// an out of order point that is still in the reorder buffer
+	m.Add(snapshotT0+1020, 1)
+	m.Add(snapshotT0+1010, 2)
+
+	path := filepath.Join(dir, "snapshot")
+	snap := metrics.Snapshot([]PartitionOffset{{Topic: "mdm", Partition: 3, Offset: 1234}})
+	if err := WriteSnapshot(path, &snap); err != nil {
+		t.Fatalf("failed to write snapshot: %s", err)
+	}
+	read, err := ReadSnapshot(path)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %s", err)
+	}
+	if !reflect.DeepEqual(read.Offsets, snap.Offsets) {
+		t.Fatalf("expected offsets %v, got %v", snap.Offsets, read.Offsets)
+	}
+
+	restored := NewAggMetrics(NewMockStore(), &cache.MockCache{}, false, 0, 0, 0)
+	num := restored.Restore(read, func(key string) (uint16, uint16, int32, int, bool) {
+		return 0, 0, 0, 10, key == "a"
+	})
+	if num != 1 {
+		t.Fatalf("expected 1 metric to be restored, got %d", num)
+	}
+	r, ok := restored.Get("a")
+	if !ok {
+		t.Fatal("expected metric a to be restored")
+	}
+	compareSnapshotMetrics(t, "after restore", m, r)
+
+	// the restored metric continues exactly where the original left off
+	for ts := uint32(snapshotT0 + 1030); ts <= snapshotT0+2000; ts += 10 {
+		m.Add(ts, float64(ts%70))
+		r.Add(ts, float64(ts%70))
+	}
+	compareSnapshotMetrics(t, "after adding more data", m, r)
+
+	if num := restored.Restore(read, func(key string) (uint16, uint16, int32, int, bool) { return 0, 0, 0, 0, false }); num != 0 {
+		t.Fatalf("expected metrics unknown to the index to be skipped, got %d restored", num)
+	}
+}
@@ -9,5 +9,7 @@ import (
 type Store interface {
 	Add(cwr *ChunkWriteRequest)
 	Search(ctx context.Context, key string, ttl, start, end uint32) ([]chunk.IterGen, error)
+	// Delete removes all chunks of the given key stored with the given ttl
+	Delete(ctx context.Context, key string, ttl uint32) error
 	Stop()
 }
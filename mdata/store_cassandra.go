@@ -1,9 +1,7 @@
 package mdata
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
@@ -53,8 +51,12 @@ var (
 	cassPutExecDuration = stats.NewLatencyHistogram15s32("store.cassandra.put.exec")
 	// metric store.cassandra.put.wait is the duration of a put in the wait queue
 	cassPutWaitDuration = stats.NewLatencyHistogram12h32("store.cassandra.put.wait")
+	// metric store.cassandra.delete.exec is the duration of deleting a row from cassandra store
+	cassDeleteExecDuration = stats.NewLatencyHistogram15s32("store.cassandra.delete.exec")
 	// reads that were already too old to be executed
 	cassOmitOldRead = stats.NewCounter32("store.cassandra.omit_read.too_old")
+	// reads whose query was cancelled, or timed out, before they were executed
+	cassOmitCanceledRead = stats.NewCounter32("store.cassandra.omit_read.canceled")
 	// reads that could not be pushed into the queue because it was full
 	cassReadQueueFull = stats.NewCounter32("store.cassandra.omit_read.queue_full")
 
@@ -107,22 +109,6 @@ func ttlUnits(ttl uint32) float64 {
 	return float64(ttl) / (60 * 60)
 }
 
-func PrepareChunkData(span uint32, data []byte) []byte {
-	chunkSizeAtSave.Value(len(data))
-	version := chunk.FormatStandardGoTszWithSpan
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, version)
-
-	spanCode, ok := chunk.RevChunkSpans[span]
-	if !ok {
-		// it's probably better to panic than to persist the chunk with a wrong length
-		panic(fmt.Sprintf("Chunk span invalid: %d", span))
-	}
-	binary.Write(buf, binary.LittleEndian, spanCode)
-	buf.Write(data)
-	return buf.Bytes()
-}
-
 func GetTTLTables(ttls []uint32, windowFactor int, nameFormat string) TTLTables {
 	tables := make(TTLTables)
 	for _, ttl := range ttls {
@@ -332,16 +318,20 @@ func (c *CassandraStore) processWriteQueue(queue chan *ChunkWriteRequest, meter
 			//log how long the chunk waited in the queue before we attempted to save to cassandra
 			cassPutWaitDuration.Value(time.Now().Sub(cwr.timestamp))
 
-			buf := PrepareChunkData(cwr.span, cwr.chunk.Series.Bytes())
+			buf := cwr.encode()
+			chunkSizeAtSave.Value(len(buf))
 			success := false
 			attempts := 0
 			for !success {
-				err := c.insertChunk(cwr.key, cwr.chunk.T0, cwr.ttl, buf)
+				err := c.insertChunk(cwr.key, cwr.chunk.T0, cwr.ttl, cwr.keepFor(), buf)
 
 				if err == nil {
 					success = true
-					cwr.metric.SyncChunkSaveState(cwr.chunk.T0)
-					SendPersistMessage(cwr.key, cwr.chunk.T0)
+					// chunks that don't belong to an in-memory metric (e.g. copied chunks) have no save state to sync
+					if cwr.metric != nil {
+						cwr.metric.SyncChunkSaveState(cwr.chunk.T0)
+						SendPersistMessage(cwr.key, cwr.metric.partition, cwr.chunk.T0)
+					}
 					log.Debug("CS: save complete. %s:%d %v", cwr.key, cwr.chunk.T0, cwr.chunk)
 					chunkSaveOk.Inc()
 				} else {
@@ -382,8 +372,10 @@ func (c *CassandraStore) getTable(ttl uint32) (string, error) {
 //
 // key: is the metric_id
 // ts: is the start of the aggregated time range.
+// ttl: selects the table.
+// keep: is the cassandra TTL of the chunk.
 // data: is the payload as bytes.
-func (c *CassandraStore) insertChunk(key string, t0, ttl uint32, data []byte) error {
+func (c *CassandraStore) insertChunk(key string, t0, ttl, keep uint32, data []byte) error {
 	// for unit tests
 	if c.Session == nil {
 		return nil
@@ -394,7 +386,7 @@ func (c *CassandraStore) insertChunk(key string, t0, ttl uint32, data []byte) er
 		return err
 	}
 
-	query := fmt.Sprintf("INSERT INTO %s (key, ts, data) values(?,?,?) USING TTL %d", table, ttl)
+	query := fmt.Sprintf("INSERT INTO %s (key, ts, data) values(?,?,?) USING TTL %d", table, keep)
 	row_key := fmt.Sprintf("%s_%d", key, t0/Month_sec) // "month number" based on unix timestamp (rounded down)
 	pre := time.Now()
 	ret := c.Session.Query(query, row_key, t0, data).Exec()
@@ -423,8 +415,13 @@ func (c *CassandraStore) processReadQueue() {
 			crr.out <- outcome{omitted: true}
 			continue
 		}
+		if crr.ctx.Err() != nil {
+			cassOmitCanceledRead.Inc()
+			crr.out <- outcome{omitted: true}
+			continue
+		}
 		pre := time.Now()
-		iter := outcome{crr.month, crr.sortKey, c.Session.Query(crr.q, crr.p...).Iter(), false}
+		iter := outcome{crr.month, crr.sortKey, c.Session.Query(crr.q, crr.p...).WithContext(crr.ctx).Iter(), false}
 		cassGetExecDuration.Value(time.Since(pre))
 		crr.out <- iter
 	}
@@ -460,7 +457,7 @@ func (c *CassandraStore) SearchTable(ctx context.Context, key, table string, sta
 	crrs := make([]*ChunkReadRequest, 0)
 
 	query := func(month, sortKey uint32, q string, p ...interface{}) {
-		crrs = append(crrs, &ChunkReadRequest{month, sortKey, q, p, pre, nil})
+		crrs = append(crrs, &ChunkReadRequest{month, sortKey, q, p, pre, nil, ctx})
 	}
 
 	start_month := start - (start % Month_sec)       // starting row has to be at, or before, requested start
@@ -513,20 +510,28 @@ func (c *CassandraStore) SearchTable(ctx context.Context, key, table string, sta
 	}
 	outcomes := make([]outcome, 0, numQueries)
 
-	seen := 0
-	for o := range results {
+	for len(outcomes) < numQueries {
+		var o outcome
+		select {
+		case <-ctx.Done():
+			// the queries still in the queue will be omitted
+			tracing.Failure(span)
+			tracing.Error(span, ctx.Err())
+			return nil, ctx.Err()
+		case o = <-results:
+		}
 		if o.omitted {
+			err := errReadTooOld
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
 			tracing.Failure(span)
-			tracing.Error(span, errReadTooOld)
-			return nil, errReadTooOld
+			tracing.Error(span, err)
+			return nil, err
 		}
-		seen += 1
 		outcomes = append(outcomes, o)
-		if seen == numQueries {
-			close(results)
-			break
-		}
 	}
+	close(results)
 	cassGetChunksDuration.Value(time.Since(pre))
 	pre = time.Now()
 	// we have all of the results, but they could have arrived in any order.
@@ -568,6 +573,86 @@ func (c *CassandraStore) SearchTable(ctx context.Context, key, table string, sta
 	return itgens, nil
 }
 
+// Delete removes all chunks of the given key from the table for the given ttl.
+// chunks are stored in rows per "month number", so we delete every row that may
+// still hold data that has not expired yet.
+func (c *CassandraStore) Delete(ctx context.Context, key string, ttl uint32) error {
+	_, span := tracing.NewSpan(ctx, c.tracer, "CassandraStore.Delete")
+	defer span.Finish()
+	tags.SpanKindRPCClient.Set(span)
+	tags.PeerService.Set(span, "cassandra")
+
+	// for unit tests
+	if c.Session == nil {
+		return nil
+	}
+
+	table, err := c.getTable(ttl)
+	if err != nil {
+		tracing.Failure(span)
+		tracing.Error(span, err)
+		return err
+	}
+
+	now := uint32(time.Now().Unix())
+	var start uint32
+	if ttl < now {
+		start = now - ttl
+	}
+	// chunks get their TTL when they are saved, which is after their t0,
+	// so the row before the one holding start may still have live chunks.
+	startMonth := start / Month_sec
+	if startMonth > 0 {
+		startMonth--
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE key = ?", table)
+	for month := startMonth; month <= now/Month_sec; month++ {
+		row_key := fmt.Sprintf("%s_%d", key, month)
+		pre := time.Now()
+		err := c.Session.Query(query, row_key).WithContext(ctx).Exec()
+		cassDeleteExecDuration.Value(time.Since(pre))
+		if err != nil {
+			errmetrics.Inc(err)
+			tracing.Failure(span)
+			tracing.Error(span, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMonth removes the chunks of the given key stored with the given ttl in the row of the given month
+func (c *CassandraStore) DeleteMonth(ctx context.Context, key string, ttl, month uint32) error {
+	_, span := tracing.NewSpan(ctx, c.tracer, "CassandraStore.DeleteMonth")
+	defer span.Finish()
+	tags.SpanKindRPCClient.Set(span)
+	tags.PeerService.Set(span, "cassandra")
+
+	// for unit tests
+	if c.Session == nil {
+		return nil
+	}
+
+	table, err := c.getTable(ttl)
+	if err != nil {
+		tracing.Failure(span)
+		tracing.Error(span, err)
+		return err
+	}
+
+	row_key := fmt.Sprintf("%s_%d", key, month/Month_sec)
+	pre := time.Now()
+	err = c.Session.Query(fmt.Sprintf("DELETE FROM %s WHERE key = ?", table), row_key).WithContext(ctx).Exec()
+	cassDeleteExecDuration.Value(time.Since(pre))
+	if err != nil {
+		errmetrics.Inc(err)
+		tracing.Failure(span)
+		tracing.Error(span, err)
+	}
+	return err
+}
+
 func (c *CassandraStore) Stop() {
 	c.Session.Close()
 }
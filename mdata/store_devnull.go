@@ -27,5 +27,9 @@ func (c *devnullStore) Search(ctx context.Context, key string, ttl, start, end u
 	return nil, nil
 }
 
+func (c *devnullStore) Delete(ctx context.Context, key string, ttl uint32) error {
+	return nil
+}
+
 func (c *devnullStore) Stop() {
 }
@@ -0,0 +1,700 @@
+package mdata
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/tracing"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/worldping-api/pkg/log"
+)
+
+// store chunks in files on local disk.
+//
+// similar to the cassandra tables, series are bucketed by TTL, and each bucket is a directory.
+// within a bucket, chunks are stored in files per time window, based on their t0.
+// each window has a data file, which holds the chunks, and an index file, which holds
+// a record of the key, t0, offset and size of each chunk, as well as tombstones for deleted keys.
+// the index files of all windows are loaded in memory at startup, and windows are removed
+// as a whole once all their data has passed the TTL.
+//
+// the writers take all queued chunks of a window (up to fileStoreMaxBatch) along, write their data, sync the
+// data file, then write their index records and sync the index file, and only then acknowledge the chunks.
+// the files are synced without holding the bucket lock, so that reads and the other writers don't wait for it.
+
+const fileStoreExpireInterval = 10 * time.Minute
+
+// max number of chunks a writer takes from its queue to write in one go
+const fileStoreMaxBatch = 1000
+
+// offset of tombstone records in the index files. they remove all earlier records of the key in the window
+const fileStoreTombstone = -1
+
+var (
+	errFileStoreStopped = errors.New("the file store is stopped")
+	errFileWindowClosed = errors.New("the window is closed")
+
+	// metric store.file.get.exec is the duration of getting chunks from the file store
+	fileGetExecDuration = stats.NewLatencyHistogram15s32("store.file.get.exec")
+	// metric store.file.put.exec is the duration of writing a batch of chunks of a window to the file store, including syncing the files
+	filePutExecDuration = stats.NewLatencyHistogram15s32("store.file.put.exec")
+	// metric store.file.put.wait is the duration of a put in the wait queue
+	filePutWaitDuration = stats.NewLatencyHistogram12h32("store.file.put.wait")
+	// metric store.file.chunk_operations.save_ok is counter of successful saves to the file store
+	fileChunkSaveOk = stats.NewCounter32("store.file.chunk_operations.save_ok")
+	// metric store.file.chunk_operations.save_fail is counter of failed saves to the file store
+	fileChunkSaveFail = stats.NewCounter32("store.file.chunk_operations.save_fail")
+	// metric store.file.windows_expired is the number of window files removed because their data passed the TTL
+	fileWindowsExpired = stats.NewCounter32("store.file.windows_expired")
+)
+
+// fileEntry is the location of a chunk in the file store
+type fileEntry struct {
+	ts     uint32
+	window uint32 // start of the window the chunk is stored in
+	offset int64
+	size   uint32
+}
+
+// fileWrite is a chunk to be written to the file store
+type fileWrite struct {
+	key  string
+	t0   uint32
+	data []byte
+}
+
+// fileWindow holds the files of one time window of a bucket.
+// its lock protects writing the files, which happens without holding the bucket lock.
+type fileWindow struct {
+	sync.Mutex
+	start  uint32
+	data   *os.File
+	index  *os.File
+	size   int64 // size of the data file
+	isize  int64 // size of the index file
+	closed bool
+}
+
+// fileBucket holds the chunks of series with similar TTLs
+type fileBucket struct {
+	sync.RWMutex
+	dir        string
+	ttl        uint32 // the largest TTL of the series stored in this bucket
+	windowSize uint32
+	windows    map[uint32]*fileWindow
+	keys       map[string][]fileEntry // the chunks of each key, sorted by ts
+	stopped    bool
+}
+
+type FileStore struct {
+	dir              string
+	buckets          map[uint32]*fileBucket // by ttl. several ttls may share a bucket
+	writeQueues      []chan *ChunkWriteRequest
+	writeQueueMeters []*stats.Range32
+	tracer           opentracing.Tracer
+}
+
+func NewFileStore(dir string, writers, writeqsize, windowFactor int, ttls []uint32) (*FileStore, error) {
+	stats.NewGauge32("store.file.write_queue.size").Set(writeqsize)
+	stats.NewGauge32("store.file.num_writers").Set(writers)
+
+	c := &FileStore{
+		dir:              dir,
+		buckets:          make(map[uint32]*fileBucket),
+		writeQueues:      make([]chan *ChunkWriteRequest, writers),
+		writeQueueMeters: make([]*stats.Range32, writers),
+		tracer:           opentracing.NoopTracer{},
+	}
+
+	byName := make(map[string]*fileBucket)
+	for ttl, table := range GetTTLTables(ttls, windowFactor, "ttl_%d") {
+		b, ok := byName[table.Table]
+		if !ok {
+			b = &fileBucket{
+				dir:        filepath.Join(dir, table.Table),
+				windowSize: table.WindowSize * 60 * 60,
+				windows:    make(map[uint32]*fileWindow),
+				keys:       make(map[string][]fileEntry),
+			}
+			byName[table.Table] = b
+		}
+		if ttl > b.ttl {
+			b.ttl = ttl
+		}
+		c.buckets[ttl] = b
+	}
+	for _, b := range byName {
+		if err := b.load(); err != nil {
+			return nil, err
+		}
+	}
+	log.Info("FS: loaded file store from %s with %d buckets", dir, len(byName))
+
+	for i := 0; i < writers; i++ {
+		c.writeQueues[i] = make(chan *ChunkWriteRequest, writeqsize)
+		c.writeQueueMeters[i] = stats.NewRange32(fmt.Sprintf("store.file.write_queue.%d.items", i+1))
+		go c.processWriteQueue(c.writeQueues[i], c.writeQueueMeters[i])
+	}
+	go c.expire()
+
+	return c, nil
+}
+
+func (c *FileStore) SetTracer(t opentracing.Tracer) {
+	c.tracer = t
+}
+
+func (c *FileStore) getBucket(ttl uint32) (*fileBucket, error) {
+	b, ok := c.buckets[ttl]
+	if !ok {
+		return nil, errTableNotFound
+	}
+	return b, nil
+}
+
+func (c *FileStore) Add(cwr *ChunkWriteRequest) {
+	sum := 0
+	for _, char := range cwr.key {
+		sum += int(char)
+	}
+	which := sum % len(c.writeQueues)
+	c.writeQueueMeters[which].Value(len(c.writeQueues[which]))
+	c.writeQueues[which] <- cwr
+}
+
+func (c *FileStore) processWriteQueue(queue chan *ChunkWriteRequest, meter *stats.Range32) {
+	tick := time.Tick(time.Duration(1) * time.Second)
+	for {
+		select {
+		case <-tick:
+			meter.Value(len(queue))
+		case cwr := <-queue:
+			cwrs := []*ChunkWriteRequest{cwr}
+			for more := true; more && len(cwrs) < fileStoreMaxBatch; {
+				select {
+				case cwr := <-queue:
+					cwrs = append(cwrs, cwr)
+				default:
+					more = false
+				}
+			}
+			meter.Value(len(queue))
+			c.save(cwrs)
+		}
+	}
+}
+
+// save writes the chunks per window, and acknowledges them once they are synced to disk
+func (c *FileStore) save(cwrs []*ChunkWriteRequest) {
+	type window struct {
+		b     *fileBucket
+		start uint32
+	}
+	var windows []window
+	batches := make(map[window][]*ChunkWriteRequest)
+	for _, cwr := range cwrs {
+		log.Debug("FS: starting to save %s:%d %v", cwr.key, cwr.chunk.T0, cwr.chunk)
+		filePutWaitDuration.Value(time.Now().Sub(cwr.timestamp))
+		b, err := c.getBucket(cwr.ttl)
+		if err != nil {
+			fileChunkSaveFail.Inc()
+			log.Error(3, "FS: failed to save chunk %v of %s: %s", cwr.chunk, cwr.key, err)
+			continue
+		}
+		w := window{b, cwr.chunk.T0 - cwr.chunk.T0%b.windowSize}
+		if _, ok := batches[w]; !ok {
+			windows = append(windows, w)
+		}
+		batches[w] = append(batches[w], cwr)
+	}
+
+	for _, w := range windows {
+		batch := batches[w]
+		writes := make([]fileWrite, len(batch))
+		for i, cwr := range batch {
+			writes[i] = fileWrite{key: cwr.key, t0: cwr.chunk.T0, data: cwr.encode()}
+		}
+		attempts := 0
+		for {
+			err := w.b.insert(w.start, writes)
+			if err == nil {
+				for _, cwr := range batch {
+					if cwr.metric != nil {
+						cwr.metric.SyncChunkSaveState(cwr.chunk.T0)
+						SendPersistMessage(cwr.key, cwr.metric.partition, cwr.chunk.T0)
+					}
+					log.Debug("FS: save complete. %s:%d %v", cwr.key, cwr.chunk.T0, cwr.chunk)
+				}
+				fileChunkSaveOk.Add(len(batch))
+				break
+			}
+			fileChunkSaveFail.Add(len(batch))
+			if err == errFileStoreStopped {
+				log.Error(3, "FS: failed to save %d chunks of window %d of %s: %s", len(batch), w.start, w.b.dir, err)
+				break
+			}
+			if (attempts % 20) == 0 {
+				log.Warn("FS: failed to save %d chunks of window %d of %s to disk after %d attempts. %s", len(batch), w.start, w.b.dir, attempts+1, err)
+			}
+			sleepTime := 100 * attempts
+			if sleepTime > 2000 {
+				sleepTime = 2000
+			}
+			time.Sleep(time.Duration(sleepTime) * time.Millisecond)
+			attempts++
+		}
+	}
+}
+
+func (c *FileStore) Search(ctx context.Context, key string, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	_, span := tracing.NewSpan(ctx, c.tracer, "FileStore.Search")
+	defer span.Finish()
+	itgens := make([]chunk.IterGen, 0)
+	if start > end {
+		tracing.Failure(span)
+		tracing.Error(span, errStartBeforeEnd)
+		return itgens, errStartBeforeEnd
+	}
+	b, err := c.getBucket(ttl)
+	if err != nil {
+		tracing.Failure(span)
+		tracing.Error(span, err)
+		return itgens, err
+	}
+	pre := time.Now()
+	b.RLock()
+	defer b.RUnlock()
+
+	// like the cassandra store, we need all chunks with a t0 > start and < end,
+	// as well as the last chunk with a t0 <= start.
+	entries := b.keys[key]
+	first := sort.Search(len(entries), func(i int) bool { return entries[i].ts > start })
+	if first > 0 {
+		first--
+	}
+	last := sort.Search(len(entries), func(i int) bool { return entries[i].ts >= end })
+	for _, e := range entries[first:last] {
+		w, ok := b.windows[e.window]
+		if !ok {
+			continue
+		}
+		buf := make([]byte, e.size)
+		if _, err := w.data.ReadAt(buf, e.offset); err != nil {
+			tracing.Failure(span)
+			tracing.Error(span, err)
+			return itgens, err
+		}
+		itgen, err := chunk.NewGen(buf, e.ts)
+		if err != nil {
+			tracing.Failure(span)
+			tracing.Error(span, err)
+			return itgens, err
+		}
+		itgens = append(itgens, *itgen)
+	}
+	fileGetExecDuration.Value(time.Since(pre))
+	span.SetTag("num_chunks", len(itgens))
+	return itgens, nil
+}
+
+// Delete removes all chunks of the given key by recording a tombstone in the index file of each window
+// that holds chunks of the key. the data itself is removed when the windows expire.
+func (c *FileStore) Delete(ctx context.Context, key string, ttl uint32) error {
+	b, err := c.getBucket(ttl)
+	if err != nil {
+		return err
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.stopped {
+		return errFileStoreStopped
+	}
+	seen := make(map[uint32]struct{})
+	for _, e := range b.keys[key] {
+		if _, ok := seen[e.window]; ok {
+			continue
+		}
+		seen[e.window] = struct{}{}
+		w, ok := b.windows[e.window]
+		if !ok {
+			continue
+		}
+		tombstone := fileEntry{ts: e.ts, window: e.window, offset: fileStoreTombstone}
+		w.Lock()
+		err := w.writeIndex(encodeFileIndexRecord(key, tombstone))
+		w.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	delete(b.keys, key)
+	return nil
+}
+
+// expire periodically removes the windows of which all data has passed the TTL
+func (c *FileStore) expire() {
+	seen := make(map[*fileBucket]struct{})
+	var buckets []*fileBucket
+	for _, b := range c.buckets {
+		if _, ok := seen[b]; !ok {
+			seen[b] = struct{}{}
+			buckets = append(buckets, b)
+		}
+	}
+	ticker := time.NewTicker(fileStoreExpireInterval)
+	for now := range ticker.C {
+		for _, b := range buckets {
+			b.expire(uint32(now.Unix()))
+		}
+	}
+}
+
+func (c *FileStore) Stop() {
+	for _, b := range c.buckets {
+		b.Lock()
+		if !b.stopped {
+			b.stopped = true
+			for _, w := range b.windows {
+				w.close()
+			}
+		}
+		b.Unlock()
+	}
+}
+
+// load opens the windows of the bucket and loads their index files
+func (b *fileBucket) load() error {
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+	var starts []uint32
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".index") {
+			continue
+		}
+		start, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), ".index"), 10, 32)
+		if err != nil {
+			log.Warn("FS: ignoring unexpected file %s in %s", f.Name(), b.dir)
+			continue
+		}
+		starts = append(starts, uint32(start))
+	}
+	// records of later windows must be applied later, as they may overwrite earlier ones
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for _, start := range starts {
+		w, err := b.getWindow(start)
+		if err != nil {
+			return err
+		}
+		if err := b.loadIndex(w); err != nil {
+			return fmt.Errorf("failed to load index of %s: %s", w.index.Name(), err)
+		}
+	}
+	return nil
+}
+
+// loadIndex reads all records of the index file of the window.
+// a partially written record at the end (e.g. due to a crash) is truncated.
+func (b *fileBucket) loadIndex(w *fileWindow) error {
+	if _, err := w.index.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(w.index)
+	var valid int64
+	for {
+		key, e, n, err := decodeFileIndexRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			log.Warn("FS: truncating partially written record at the end of %s", w.index.Name())
+			if err := w.index.Truncate(valid); err != nil {
+				return err
+			}
+			w.isize = valid
+			break
+		}
+		if err != nil {
+			return err
+		}
+		valid += int64(n)
+		e.window = w.start
+		if e.offset == fileStoreTombstone {
+			b.removeEntries(key, w.start)
+			continue
+		}
+		if e.offset+int64(e.size) > w.size {
+			// the chunk data didn't make it to disk
+			continue
+		}
+		b.addEntry(key, e)
+	}
+	return nil
+}
+
+// insert writes the chunks to the window starting at start, and records them once they are synced to disk.
+// the bucket lock is only held to get the window and to record the chunks, not while writing the files.
+func (b *fileBucket) insert(start uint32, writes []fileWrite) error {
+	pre := time.Now()
+	b.Lock()
+	if b.stopped {
+		b.Unlock()
+		return errFileStoreStopped
+	}
+	w, err := b.getWindow(start)
+	b.Unlock()
+	if err != nil {
+		return err
+	}
+	entries, err := w.write(writes)
+	if err != nil {
+		return err
+	}
+	b.Lock()
+	// if the window expired in the meantime, so did the chunks
+	if b.windows[start] == w {
+		for i, e := range entries {
+			b.addEntry(writes[i].key, e)
+		}
+	}
+	b.Unlock()
+	filePutExecDuration.Value(time.Since(pre))
+	return nil
+}
+
+// getWindow returns the window starting at start, opening or creating its files if needed.
+// the caller must hold the lock.
+func (b *fileBucket) getWindow(start uint32) (*fileWindow, error) {
+	if w, ok := b.windows[start]; ok {
+		return w, nil
+	}
+	base := filepath.Join(b.dir, strconv.FormatUint(uint64(start), 10))
+	data, err := os.OpenFile(base+".data", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(base+".index", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	stat, err := data.Stat()
+	if err != nil {
+		data.Close()
+		index.Close()
+		return nil, err
+	}
+	istat, err := index.Stat()
+	if err != nil {
+		data.Close()
+		index.Close()
+		return nil, err
+	}
+	w := &fileWindow{
+		start: start,
+		data:  data,
+		index: index,
+		size:  stat.Size(),
+		isize: istat.Size(),
+	}
+	b.windows[start] = w
+	return w, nil
+}
+
+// addEntry records the chunk for the key, replacing any chunk with the same ts.
+// the caller must hold the lock.
+func (b *fileBucket) addEntry(key string, e fileEntry) {
+	entries := b.keys[key]
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].ts >= e.ts })
+	if i < len(entries) && entries[i].ts == e.ts {
+		entries[i] = e
+		return
+	}
+	entries = append(entries, fileEntry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = e
+	b.keys[key] = entries
+}
+
+// removeEntries removes the chunks of the key that are stored in the given window.
+// the caller must hold the lock.
+func (b *fileBucket) removeEntries(key string, window uint32) {
+	entries := b.keys[key]
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.window != window {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == 0 {
+		delete(b.keys, key)
+		return
+	}
+	b.keys[key] = kept
+}
+
+// expire removes the windows whose data has all passed the TTL
+func (b *fileBucket) expire(now uint32) {
+	b.Lock()
+	defer b.Unlock()
+	if b.stopped {
+		return
+	}
+	expired := make(map[uint32]struct{})
+	for start, w := range b.windows {
+		if start+b.windowSize+b.ttl >= now {
+			continue
+		}
+		log.Info("FS: removing expired window %d of %s", start, b.dir)
+		w.close()
+		os.Remove(w.data.Name())
+		os.Remove(w.index.Name())
+		delete(b.windows, start)
+		expired[start] = struct{}{}
+		fileWindowsExpired.Inc()
+	}
+	if len(expired) == 0 {
+		return
+	}
+	for key, entries := range b.keys {
+		kept := entries[:0]
+		for _, e := range entries {
+			if _, ok := expired[e.window]; !ok {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			delete(b.keys, key)
+		} else {
+			b.keys[key] = kept
+		}
+	}
+}
+
+// write appends the data of the chunks to the data file and records them in the index file.
+// the data file is synced before the index records are written, such that a record never refers to data
+// that is not on disk, and the index file is synced before returning, such that the chunks can be acknowledged.
+// if a write fails, the files are truncated to their previous size, such that a partial write
+// doesn't shift the offsets of later chunks, and a retry doesn't store the chunks twice.
+func (w *fileWindow) write(writes []fileWrite) ([]fileEntry, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return nil, errFileWindowClosed
+	}
+	entries := make([]fileEntry, len(writes))
+	isize := w.isize
+	var data, records []byte
+	for i, fw := range writes {
+		entries[i] = fileEntry{
+			ts:     fw.t0,
+			window: w.start,
+			offset: w.size + int64(len(data)),
+			size:   uint32(len(fw.data)),
+		}
+		data = append(data, fw.data...)
+		records = append(records, encodeFileIndexRecord(fw.key, entries[i])...)
+	}
+	_, err := w.data.Write(data)
+	if err == nil {
+		err = w.data.Sync()
+	}
+	if err == nil {
+		err = w.writeIndex(records)
+	}
+	if err == nil {
+		err = w.index.Sync()
+	}
+	if err != nil {
+		w.truncate(w.data, w.size)
+		if w.isize != isize {
+			w.truncate(w.index, isize)
+			w.isize = isize
+		}
+		return nil, err
+	}
+	w.size += int64(len(data))
+	return entries, nil
+}
+
+// writeIndex appends the record(s) to the index file. if the write fails, the file is truncated to its
+// previous size, such that a partial record doesn't corrupt the records written after it.
+// the caller must hold the lock.
+func (w *fileWindow) writeIndex(records []byte) error {
+	if _, err := w.index.Write(records); err != nil {
+		w.truncate(w.index, w.isize)
+		return err
+	}
+	w.isize += int64(len(records))
+	return nil
+}
+
+func (w *fileWindow) truncate(f *os.File, size int64) {
+	if err := f.Truncate(size); err != nil {
+		log.Error(3, "FS: failed to truncate %s to %d after failed write: %s", f.Name(), size, err)
+	}
+}
+
+func (w *fileWindow) close() {
+	w.Lock()
+	defer w.Unlock()
+	w.closed = true
+	for _, f := range []*os.File{w.data, w.index} {
+		if err := f.Sync(); err != nil {
+			log.Error(3, "FS: failed to sync %s: %s", f.Name(), err)
+		}
+		f.Close()
+	}
+}
+
+// encodeFileIndexRecord encodes an index record as
+// key length (uint16), key, ts (uint32), offset (int64), size (uint32)
+func encodeFileIndexRecord(key string, e fileEntry) []byte {
+	buf := make([]byte, 2+len(key)+4+8+4)
+	binary.LittleEndian.PutUint16(buf, uint16(len(key)))
+	pos := 2 + copy(buf[2:], key)
+	binary.LittleEndian.PutUint32(buf[pos:], e.ts)
+	binary.LittleEndian.PutUint64(buf[pos+4:], uint64(e.offset))
+	binary.LittleEndian.PutUint32(buf[pos+12:], e.size)
+	return buf
+}
+
+// decodeFileIndexRecord reads an index record. it returns the number of bytes read,
+// io.EOF if there are no more records and io.ErrUnexpectedEOF for a partial record.
+func decodeFileIndexRecord(r io.Reader) (string, fileEntry, int, error) {
+	var e fileEntry
+	var keyLen [2]byte
+	if _, err := io.ReadFull(r, keyLen[:]); err != nil {
+		return "", e, 0, err
+	}
+	buf := make([]byte, int(binary.LittleEndian.Uint16(keyLen[:]))+4+8+4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", e, 0, err
+	}
+	pos := len(buf) - 16
+	e.ts = binary.LittleEndian.Uint32(buf[pos:])
+	e.offset = int64(binary.LittleEndian.Uint64(buf[pos+4:]))
+	e.size = binary.LittleEndian.Uint32(buf[pos+12:])
+	return string(buf[:pos]), e, 2 + len(buf), nil
+}
@@ -0,0 +1,210 @@
+package mdata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/test"
+)
+
+func newTestFileStore(t *testing.T, dir string) *FileStore {
+	store, err := NewFileStore(dir, 1, 10, 20, []uint32{3600, 86400})
+	if err != nil {
+		t.Fatalf("failed to create file store: %s", err)
+	}
+	return store
+}
+
+func insertTestChunk(t *testing.T, store *FileStore, key string, t0, ttl uint32, val float64) {
+	if err := insertFileTestChunk(store, key, t0, ttl, val); err != nil {
+		t.Fatalf("failed to insert chunk %s:%d: %s", key, t0, err)
+	}
+}
+
+func insertFileTestChunk(store *FileStore, key string, t0, ttl uint32, val float64) error {
+	c := chunk.New(t0)
+	c.Push(t0+1, val)
+	c.Finish()
+	b := store.buckets[ttl]
+	return b.insert(t0-t0%b.windowSize, []fileWrite{{key: key, t0: t0, data: c.Encode(600)}})
+}
+
+func searchTestChunks(t *testing.T, store *FileStore, key string, ttl, start, end uint32) []uint32 {
+	itgens, err := store.Search(test.NewContext(), key, ttl, start, end)
+	if err != nil {
+		t.Fatalf("search %s %d-%d failed: %s", key, start, end, err)
+	}
+	var ts []uint32
+	for _, itgen := range itgens {
+		if itgen.Span != 600 {
+			t.Fatalf("expected span 600, got %d", itgen.Span)
+		}
+		it, err := itgen.Get()
+		if err != nil {
+			t.Fatalf("failed to get iterator: %s", err)
+		}
+		if !it.Next() {
+			t.Fatalf("expected chunk %d to have a point", itgen.Ts)
+		}
+		ts = append(ts, itgen.Ts)
+	}
+	return ts
+}
+
+func expectTs(t *testing.T, desc string, got []uint32, exp ...uint32) {
+	if len(got) != len(exp) {
+		t.Fatalf("%s: expected chunks %v, got %v", desc, exp, got)
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Fatalf("%s: expected chunks %v, got %v", desc, exp, got)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrictank-file-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newTestFileStore(t, dir)
+	// chunks span several windows of 1 hour
+	for t0 := uint32(3000); t0 <= 9000; t0 += 600 {
+		insertTestChunk(t, store, "a", t0, 3600, float64(t0))
+	}
+	insertTestChunk(t, store, "b", 3000, 3600, 1)
+	insertTestChunk(t, store, "a", 3000, 86400, 2)
+
+	expectTs(t, "all", searchTestChunks(t, store, "a", 3600, 0, 10000), 3000, 3600, 4200, 4800, 5400, 6000, 6600, 7200, 7800, 8400, 9000)
+	expectTs(t, "range", searchTestChunks(t, store, "a", 3600, 4000, 6000), 3600, 4200, 4800, 5400)
+	expectTs(t, "other ttl", searchTestChunks(t, store, "a", 86400, 0, 10000), 3000)
+	if _, err := store.Search(test.NewContext(), "a", 60, 0, 10000); err != errTableNotFound {
+		t.Fatalf("expected errTableNotFound for unknown ttl, got %v", err)
+	}
+
+	if err := store.Delete(test.NewContext(), "a", 3600); err != nil {
+		t.Fatalf("delete failed: %s", err)
+	}
+	insertTestChunk(t, store, "a", 9600, 3600, 3)
+	expectTs(t, "after delete", searchTestChunks(t, store, "a", 3600, 0, 10000), 9600)
+	expectTs(t, "other key after delete", searchTestChunks(t, store, "b", 3600, 0, 10000), 3000)
+	store.Stop()
+
+	// simulate a crash while writing an index record
+	index, err := os.OpenFile(filepath.Join(dir, "ttl_1", "7200.index"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Write(encodeFileIndexRecord("c", fileEntry{ts: 7200, size: 10})[:5])
+	index.Close()
+
+	store = newTestFileStore(t, dir)
+	expectTs(t, "after reload", searchTestChunks(t, store, "a", 3600, 0, 10000), 9600)
+	expectTs(t, "other key after reload", searchTestChunks(t, store, "b", 3600, 0, 10000), 3000)
+	expectTs(t, "other ttl after reload", searchTestChunks(t, store, "a", 86400, 0, 10000), 3000)
+	insertTestChunk(t, store, "c", 7200, 3600, 4)
+	expectTs(t, "write after truncated record", searchTestChunks(t, store, "c", 3600, 0, 10000), 7200)
+
+	// the windows of the 1h bucket before 7200 only hold data that is older than the ttl
+	store.buckets[3600].expire(7200 + 3600 + 3600)
+	expectTs(t, "after expire", searchTestChunks(t, store, "b", 3600, 0, 10000))
+	expectTs(t, "after expire", searchTestChunks(t, store, "a", 3600, 0, 10000), 9600)
+	if _, err := os.Stat(filepath.Join(dir, "ttl_1", "3600.data")); !os.IsNotExist(err) {
+		t.Fatalf("expected expired window file to be removed, got %v", err)
+	}
+	store.Stop()
+}
+
+func TestFileStoreFailedWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrictank-file-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newTestFileStore(t, dir)
+	insertTestChunk(t, store, "a", 3600, 3600, 1)
+	b := store.buckets[3600]
+	w := b.windows[3600]
+	size := w.size
+
+	// make writing the index record fail, after the data was written
+	index := w.index
+	ro, err := os.Open(index.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.index = ro
+	if err := insertFileTestChunk(store, "a", 4200, 3600, 2); err == nil {
+		t.Fatal("expected insert to fail")
+	}
+	ro.Close()
+	w.index = index
+	stat, err := w.data.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.size != size || stat.Size() != size {
+		t.Fatalf("expected data file to be truncated to %d, got size %d and file size %d", size, w.size, stat.Size())
+	}
+
+	// the retry must store the chunk at the right offset
+	insertTestChunk(t, store, "a", 4200, 3600, 2)
+	expectTs(t, "after retry", searchTestChunks(t, store, "a", 3600, 0, 10000), 3600, 4200)
+	store.Stop()
+
+	store = newTestFileStore(t, dir)
+	expectTs(t, "after reload", searchTestChunks(t, store, "a", 3600, 0, 10000), 3600, 4200)
+	store.Stop()
+}
+
+func TestFileStoreBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrictank-file-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newTestFileStore(t, dir)
+	var cwrs []*ChunkWriteRequest
+	for _, t0 := range []uint32{3600, 4200, 7200, 7800} {
+		for _, ttl := range []uint32{3600, 86400} {
+			c := chunk.New(t0)
+			c.Push(t0+1, float64(t0))
+			c.Finish()
+			cwr := NewChunkWriteRequest(nil, "a", c, ttl, 600, time.Now())
+			cwrs = append(cwrs, &cwr)
+		}
+	}
+	// chunks of a ttl that is not configured are dropped, without failing the others
+	c := chunk.New(3600)
+	c.Push(3601, 1)
+	c.Finish()
+	cwr := NewChunkWriteRequest(nil, "a", c, 60, 600, time.Now())
+	cwrs = append(cwrs, &cwr)
+	store.save(cwrs)
+
+	expectTs(t, "1h bucket", searchTestChunks(t, store, "a", 3600, 0, 10000), 3600, 4200, 7200, 7800)
+	expectTs(t, "1d bucket", searchTestChunks(t, store, "a", 86400, 0, 10000), 3600, 4200, 7200, 7800)
+	for _, w := range store.buckets[3600].windows {
+		stat, err := w.index.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.isize != stat.Size() || w.isize != int64(2*len(encodeFileIndexRecord("a", fileEntry{}))) {
+			t.Fatalf("expected index of window %d to hold 2 records, got size %d and file size %d", w.start, w.isize, stat.Size())
+		}
+	}
+	store.Stop()
+
+	store = newTestFileStore(t, dir)
+	expectTs(t, "1h bucket after reload", searchTestChunks(t, store, "a", 3600, 0, 10000), 3600, 4200, 7200, 7800)
+	expectTs(t, "1d bucket after reload", searchTestChunks(t, store, "a", 86400, 0, 10000), 3600, 4200, 7200, 7800)
+	store.Stop()
+}
@@ -21,10 +21,25 @@ func (c *MockStore) ResetMock() {
 	c.results = make(map[string][]chunk.IterGen)
 }
 
-// Add adds a chunk to the store
+// Add adds a chunk to the store. like in the real stores, it replaces a chunk with the same t0
 func (c *MockStore) Add(cwr *ChunkWriteRequest) {
-	itgen := chunk.NewBareIterGen(cwr.chunk.Series.Bytes(), cwr.chunk.Series.T0, cwr.span)
-	c.results[cwr.key] = append(c.results[cwr.key], *itgen)
+	var itgen chunk.IterGen
+	if cwr.data != nil {
+		ig, err := chunk.NewGen(cwr.data, cwr.chunk.T0)
+		if err != nil {
+			panic(err)
+		}
+		itgen = *ig
+	} else {
+		itgen = cwr.chunk.IterGen(cwr.span)
+	}
+	for i, existing := range c.results[cwr.key] {
+		if existing.Ts == itgen.Ts {
+			c.results[cwr.key][i] = itgen
+			return
+		}
+	}
+	c.results[cwr.key] = append(c.results[cwr.key], itgen)
 }
 
 // searches through the mock results and returns the right ones according to start / end
@@ -47,5 +62,11 @@ func (c *MockStore) Search(ctx context.Context, metric string, ttl, start, end u
 	return res, nil
 }
 
+// Delete removes all chunks of the given metric
+func (c *MockStore) Delete(ctx context.Context, metric string, ttl uint32) error {
+	delete(c.results, metric)
+	return nil
+}
+
 func (c *MockStore) Stop() {
 }
@@ -0,0 +1,643 @@
+package mdata
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/mdata/blob"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/tracing"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/worldping-api/pkg/log"
+)
+
+// tiered storage keeps recent chunks in the primary store, and offloads older chunks to a blob store.
+//
+// chunks are offloaded per key and month (the cassandra row), oldest month first, once the whole month
+// is older than the configured min age. each month becomes a single immutable blob object, which is removed
+// by the offload runs once all of its data has passed the TTL.
+// per key, we track up to which month the data has been offloaded: reads for older data are served from
+// the blob store, reads for more recent data from the primary store.
+// once offloaded, the chunks are removed from the primary store (if it supports deleting single months),
+// but only in the next offload run, to give the other cluster nodes time to pick up the new blobs.
+//
+// every offload run also writes a manifest: a blob listing the range of offloaded months of each key.
+// at startup, nodes list all blobs to find the offloaded months, but after that they only apply the manifests
+// they haven't seen yet, so that they don't have to list all blobs every interval.
+
+const tieredBlobVersion = 1
+
+// prefix of the names of the manifest blobs, followed by the unix time in nanoseconds of the offload run
+const tieredManifestPrefix = "offloads/"
+
+// manifests are removed once they are older than this many offload intervals.
+// nodes that haven't refreshed since, e.g. because they were down, list all blobs when they start.
+const tieredManifestRuns = 10
+
+var (
+	errTieredBlobVersion = errors.New("unsupported tiered blob version")
+	errTieredBlobCorrupt = errors.New("corrupt tiered blob")
+
+	// metric store.tiered.get.exec is the duration of getting chunks from the blob store
+	tieredGetExecDuration = stats.NewLatencyHistogram15s32("store.tiered.get.exec")
+	// metric store.tiered.get.chunks is the number of chunks read from the blob store
+	tieredGetChunks = stats.NewCounter32("store.tiered.get.chunks")
+	// metric store.tiered.offload.objects is the number of blob objects created by offloading chunks
+	tieredOffloadObjects = stats.NewCounter32("store.tiered.offload.objects")
+	// metric store.tiered.offload.chunks is the number of chunks offloaded to the blob store
+	tieredOffloadChunks = stats.NewCounter32("store.tiered.offload.chunks")
+	// metric store.tiered.offload.fail is the number of keys that could not be offloaded
+	tieredOffloadFail = stats.NewCounter32("store.tiered.offload.fail")
+	// metric store.tiered.offload.expired is the number of offloaded months removed from the blob store because their data passed the TTL
+	tieredOffloadExpired = stats.NewCounter32("store.tiered.offload.expired")
+	// metric store.tiered.offload.primary_deletes is the number of offloaded months removed from the primary store
+	tieredOffloadPrimaryDeletes = stats.NewCounter32("store.tiered.offload.primary_deletes")
+	// metric store.tiered.offload.keys_total is the number of keys to process in the current offload run
+	tieredOffloadKeysTotal = stats.NewGauge32("store.tiered.offload.keys_total")
+	// metric store.tiered.offload.keys_done is the number of keys processed so far in the current offload run
+	tieredOffloadKeysDone = stats.NewGauge32("store.tiered.offload.keys_done")
+	// metric store.tiered.offload.duration is the duration of the last offload run
+	tieredOffloadDuration = stats.NewGauge32("store.tiered.offload.duration")
+)
+
+// monthDeleter is implemented by stores that can remove the chunks of a key for a single month
+type monthDeleter interface {
+	DeleteMonth(ctx context.Context, key string, ttl, month uint32) error
+}
+
+// tieredMonth is a month of a key that has been offloaded
+type tieredMonth struct {
+	key   StoreKey
+	month uint32
+}
+
+// TieredStore is a Store that offloads chunks older than minAge from the primary store to a blob store
+type TieredStore struct {
+	primary Store
+	blobs   blob.Store
+	minAge  uint32
+	tracer  opentracing.Tracer
+
+	sync.RWMutex
+	until   map[string]uint32 // per ttl and key, the end of the last offloaded month
+	from    map[string]uint32 // per ttl and key, the start of the oldest month that may still have a blob
+	pending []tieredMonth     // offloaded months still to be removed from the primary store
+	now     func() uint32     // returns the current unix time. replaced in tests
+
+	unannounced []tieredMonth       // offloaded months for which saving the manifest failed. only used by the offload routine
+	manifests   map[string]struct{} // the manifests we have applied. only used by the offload routine
+}
+
+func NewTieredStore(primary Store, blobs blob.Store, minAge uint32) (*TieredStore, error) {
+	t := &TieredStore{
+		primary: primary,
+		blobs:   blobs,
+		minAge:  minAge,
+		tracer:  opentracing.NoopTracer{},
+		until:   make(map[string]uint32),
+		from:    make(map[string]uint32),
+		now:     func() uint32 { return uint32(time.Now().Unix()) },
+	}
+	if err := t.load(context.Background()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *TieredStore) SetTracer(tracer opentracing.Tracer) {
+	t.tracer = tracer
+}
+
+func tieredKey(key string, ttl uint32) string {
+	return fmt.Sprintf("%d/%s", ttl, key)
+}
+
+func tieredBlobName(key string, ttl, month uint32) string {
+	return tieredMonthBlob(tieredKey(key, ttl), month)
+}
+
+// tieredMonthBlob returns the name of the blob of the month of the key as returned by tieredKey
+func tieredMonthBlob(k string, month uint32) string {
+	return fmt.Sprintf("%s/%d", k, month/Month_sec)
+}
+
+// tieredKeyTTL returns the ttl of the key as returned by tieredKey
+func tieredKeyTTL(k string) (uint32, error) {
+	ttl, err := strconv.ParseUint(k[:strings.Index(k, "/")], 10, 32)
+	return uint32(ttl), err
+}
+
+// tieredRange is the range of months of a key that may have blobs
+type tieredRange struct {
+	from  uint32 // start of the oldest month
+	until uint32 // end of the last month
+}
+
+// load finds the offloaded months by listing all blobs in the blob store
+func (t *TieredStore) load(ctx context.Context) error {
+	// the blobs of the manifests listed here were written before them, so they're included in the listing below
+	manifests, err := t.blobs.List(ctx, tieredManifestPrefix)
+	if err != nil {
+		return err
+	}
+	names, err := t.blobs.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	t.manifests = make(map[string]struct{}, len(manifests))
+	for _, name := range manifests {
+		t.manifests[name] = struct{}{}
+	}
+	until := make(map[string]uint32)
+	from := make(map[string]uint32)
+	for _, name := range names {
+		if strings.HasPrefix(name, tieredManifestPrefix) {
+			continue
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			log.Warn("tiered-store: ignoring unexpected blob %q", name)
+			continue
+		}
+		month, err := strconv.ParseUint(name[i+1:], 10, 32)
+		if err != nil {
+			log.Warn("tiered-store: ignoring unexpected blob %q", name)
+			continue
+		}
+		start := uint32(month) * Month_sec
+		if end := start + Month_sec; end > until[name[:i]] {
+			until[name[:i]] = end
+		}
+		if f, ok := from[name[:i]]; !ok || start < f {
+			from[name[:i]] = start
+		}
+	}
+	t.Lock()
+	t.until = until
+	t.from = from
+	t.Unlock()
+	return nil
+}
+
+// refresh applies the manifests written by other nodes since the last refresh,
+// so that we see the months they offloaded.
+func (t *TieredStore) refresh(ctx context.Context) error {
+	names, err := t.blobs.List(ctx, tieredManifestPrefix)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		seen[name] = struct{}{}
+		if _, ok := t.manifests[name]; ok {
+			continue
+		}
+		data, err := t.blobs.Get(ctx, name)
+		if err == blob.ErrNotFound {
+			// it was removed since we listed it
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ranges, err := decodeTieredManifest(data)
+		if err != nil {
+			log.Warn("tiered-store: ignoring manifest %q: %s", name, err)
+			continue
+		}
+		t.Lock()
+		for k, r := range ranges {
+			if r.until > t.until[k] {
+				t.until[k] = r.until
+			}
+			if from, ok := t.from[k]; !ok || r.from < from {
+				t.from[k] = r.from
+			}
+		}
+		t.Unlock()
+	}
+	t.manifests = seen
+	return nil
+}
+
+// pruneManifests removes the manifests of offload runs before the given time
+func (t *TieredStore) pruneManifests(ctx context.Context, before time.Time) {
+	names, err := t.blobs.List(ctx, tieredManifestPrefix)
+	if err != nil {
+		log.Error(3, "tiered-store: failed to list manifests: %s", err)
+		return
+	}
+	for _, name := range names {
+		nanos, err := strconv.ParseInt(strings.TrimPrefix(name, tieredManifestPrefix), 10, 64)
+		if err != nil || nanos >= before.UnixNano() {
+			continue
+		}
+		if err := t.blobs.Delete(ctx, name); err != nil {
+			log.Error(3, "tiered-store: failed to remove manifest %q: %s", name, err)
+		}
+	}
+}
+
+func (t *TieredStore) getUntil(key string, ttl uint32) uint32 {
+	t.RLock()
+	defer t.RUnlock()
+	return t.until[tieredKey(key, ttl)]
+}
+
+func (t *TieredStore) getRange(k string) tieredRange {
+	t.RLock()
+	defer t.RUnlock()
+	return tieredRange{from: t.from[k], until: t.until[k]}
+}
+
+// Add adds the chunk to the primary store
+func (t *TieredStore) Add(cwr *ChunkWriteRequest) {
+	t.primary.Add(cwr)
+}
+
+// Search returns the chunks of the key from the blob store for the offloaded months,
+// and from the primary store for the rest of the range.
+func (t *TieredStore) Search(ctx context.Context, key string, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	until := t.getUntil(key, ttl)
+	if start >= until {
+		return t.primary.Search(ctx, key, ttl, start, end)
+	}
+	ctx, span := tracing.NewSpan(ctx, t.tracer, "TieredStore.Search")
+	defer span.Finish()
+	if start > end {
+		tracing.Failure(span)
+		tracing.Error(span, errStartBeforeEnd)
+		return nil, errStartBeforeEnd
+	}
+
+	blobEnd := end
+	if blobEnd > until {
+		blobEnd = until
+	}
+	itgens, err := t.searchBlobs(ctx, key, ttl, start, blobEnd)
+	if err != nil {
+		tracing.Failure(span)
+		tracing.Error(span, err)
+		return nil, err
+	}
+	if end > until {
+		recent, err := t.primary.Search(ctx, key, ttl, until, end)
+		if err != nil {
+			tracing.Failure(span)
+			tracing.Error(span, err)
+			return nil, err
+		}
+		// the primary may still hold chunks of the last offloaded month
+		for _, itgen := range recent {
+			if itgen.Ts >= until {
+				itgens = append(itgens, itgen)
+			}
+		}
+	}
+	span.SetTag("num_chunks", len(itgens))
+	return itgens, nil
+}
+
+// searchBlobs returns the chunks with a t0 > start and < end, as well as the last chunk with a t0 <= start.
+// because chunkspans divide months, the latter is always in the month that holds start.
+func (t *TieredStore) searchBlobs(ctx context.Context, key string, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	pre := time.Now()
+	itgens := make([]chunk.IterGen, 0)
+	// like the primary store, we don't return data that passed the TTL, which may not have been removed yet
+	if now := t.now(); now > ttl && start < now-ttl {
+		start = now - ttl
+	}
+	if start >= end {
+		return itgens, nil
+	}
+	startMonth := start - (start % Month_sec)
+	for month := startMonth; month < end; month += Month_sec {
+		data, err := t.blobs.Get(ctx, tieredBlobName(key, ttl, month))
+		if err == blob.ErrNotFound {
+			// no data for this month
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		monthGens, err := decodeTieredBlob(data)
+		if err != nil {
+			return nil, err
+		}
+		first := 0
+		if month == startMonth {
+			first = sort.Search(len(monthGens), func(i int) bool { return monthGens[i].Ts > start })
+			if first > 0 {
+				first--
+			}
+		}
+		last := sort.Search(len(monthGens), func(i int) bool { return monthGens[i].Ts >= end })
+		itgens = append(itgens, monthGens[first:last]...)
+	}
+	tieredGetExecDuration.Value(time.Since(pre))
+	tieredGetChunks.Add(len(itgens))
+	return itgens, nil
+}
+
+// Delete removes the chunks of the key from the primary store and the blob store
+func (t *TieredStore) Delete(ctx context.Context, key string, ttl uint32) error {
+	if err := t.primary.Delete(ctx, key, ttl); err != nil {
+		return err
+	}
+	prefix := tieredKey(key, ttl) + "/"
+	names, err := t.blobs.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := t.blobs.Delete(ctx, name); err != nil {
+			return err
+		}
+	}
+	t.Lock()
+	delete(t.until, tieredKey(key, ttl))
+	delete(t.from, tieredKey(key, ttl))
+	t.Unlock()
+	return nil
+}
+
+func (t *TieredStore) Stop() {
+	t.primary.Stop()
+}
+
+// Run offloads the chunks of the keys returned by keys every interval.
+// keys must only return the keys of the partitions we are primary for, so that every key is offloaded by a single node.
+// the other nodes pick up the blobs from the manifests of the offload runs.
+func (t *TieredStore) Run(interval time.Duration, keys func() []StoreKey) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		span := t.tracer.StartSpan("tiered store offload")
+		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		if err := t.refresh(ctx); err != nil {
+			log.Error(3, "tiered-store: failed to refresh offloaded months: %s", err)
+		} else {
+			t.Offload(ctx, keys(), uint32(time.Now().Unix()))
+			t.pruneManifests(ctx, time.Now().Add(-tieredManifestRuns*interval))
+		}
+		span.Finish()
+	}
+}
+
+// Offload removes the months offloaded in the previous run from the primary store, and the blobs of which
+// all data passed the TTL from the blob store. then it offloads all months of the given keys that are older than minAge.
+// finally it writes the manifest of the run, for the other nodes to pick up the offloaded months.
+func (t *TieredStore) Offload(ctx context.Context, keys []StoreKey, now uint32) {
+	pre := time.Now()
+	t.deletePending(ctx)
+	t.expire(ctx, now)
+
+	if now < t.minAge {
+		return
+	}
+	cutoff := now - t.minAge
+	tieredOffloadKeysTotal.Set(len(keys))
+	tieredOffloadKeysDone.Set(0)
+	var months, chunks int
+	var announce []tieredMonth
+	offloaded := make(map[string]tieredRange)
+	for i, key := range keys {
+		from := t.getUntil(key.Key, key.TTL)
+		m, c, err := t.offloadKey(ctx, key, cutoff, now, &announce)
+		if err != nil {
+			log.Error(3, "tiered-store: failed to offload %s: %s", key.Key, err)
+			tieredOffloadFail.Inc()
+		}
+		k := tieredKey(key.Key, key.TTL)
+		if r := t.getRange(k); r.until != from {
+			offloaded[k] = r
+		}
+		months += m
+		chunks += c
+		tieredOffloadKeysDone.Set(i + 1)
+	}
+	t.announce(ctx, offloaded, announce)
+	tieredOffloadDuration.Set(int(time.Since(pre) / time.Millisecond))
+	log.Info("tiered-store: offloaded %d chunks in %d months of %d keys. Took %s", chunks, months, len(keys), time.Since(pre))
+}
+
+// announce saves the manifest of the months offloaded in this run, and marks the months for removal
+// from the primary store in the next run. if saving the manifest fails, the months are announced in a later run,
+// and they stay in the primary store until then.
+func (t *TieredStore) announce(ctx context.Context, offloaded map[string]tieredRange, months []tieredMonth) {
+	months = append(t.unannounced, months...)
+	for _, m := range t.unannounced {
+		k := tieredKey(m.key.Key, m.key.TTL)
+		offloaded[k] = t.getRange(k)
+	}
+	if len(offloaded) == 0 {
+		return
+	}
+	name := fmt.Sprintf("%s%020d", tieredManifestPrefix, time.Now().UnixNano())
+	if err := t.blobs.Put(ctx, name, encodeTieredManifest(offloaded)); err != nil {
+		log.Error(3, "tiered-store: failed to save manifest %q: %s", name, err)
+		t.unannounced = months
+		return
+	}
+	t.manifests[name] = struct{}{}
+	t.unannounced = nil
+	t.Lock()
+	t.pending = append(t.pending, months...)
+	t.Unlock()
+}
+
+// offloadKey offloads the months of the key that end before cutoff and have not been offloaded yet.
+// the offloaded months that have data are added to months. it returns the number of months and chunks offloaded.
+func (t *TieredStore) offloadKey(ctx context.Context, key StoreKey, cutoff, now uint32, months *[]tieredMonth) (int, int, error) {
+	from := t.getUntil(key.Key, key.TTL)
+	if from == 0 && now > key.TTL {
+		// anything older has passed the TTL
+		from = now - key.TTL
+		from -= from % Month_sec
+	}
+	var num, chunks int
+	for month := from; month+Month_sec <= cutoff; month += Month_sec {
+		itgens, err := t.primary.Search(ctx, key.Key, key.TTL, month, month+Month_sec)
+		if err != nil {
+			return num, chunks, err
+		}
+		var buf bytes.Buffer
+		buf.WriteByte(tieredBlobVersion)
+		n := 0
+		for _, itgen := range itgens {
+			if itgen.Ts < month {
+				continue
+			}
+			encodeTieredChunk(&buf, itgen)
+			n++
+		}
+		if n > 0 {
+			if err := t.blobs.Put(ctx, tieredBlobName(key.Key, key.TTL, month), buf.Bytes()); err != nil {
+				return num, chunks, err
+			}
+			tieredOffloadObjects.Inc()
+			tieredOffloadChunks.Add(n)
+			num++
+			chunks += n
+			*months = append(*months, tieredMonth{key, month})
+		}
+		t.Lock()
+		k := tieredKey(key.Key, key.TTL)
+		if _, ok := t.from[k]; !ok {
+			t.from[k] = month
+		}
+		t.until[k] = month + Month_sec
+		t.Unlock()
+	}
+	return num, chunks, nil
+}
+
+// expire removes the blobs of the months of which all data passed the TTL.
+// series may be removed from the index while they still have blobs, so it covers all keys with blobs,
+// not just the ones we offload. several nodes may remove the same blobs, which is harmless.
+func (t *TieredStore) expire(ctx context.Context, now uint32) {
+	type expiry struct {
+		k        string
+		from, to uint32 // the months from until to have expired
+	}
+	var expired []expiry
+	t.RLock()
+	for k, from := range t.from {
+		ttl, err := tieredKeyTTL(k)
+		if err != nil || now < ttl {
+			continue
+		}
+		to := now - ttl
+		to -= to % Month_sec
+		if until := t.until[k]; to > until {
+			to = until
+		}
+		if from < to {
+			expired = append(expired, expiry{k, from, to})
+		}
+	}
+	t.RUnlock()
+
+	for _, e := range expired {
+		month := e.from
+		for ; month < e.to; month += Month_sec {
+			if err := t.blobs.Delete(ctx, tieredMonthBlob(e.k, month)); err != nil {
+				log.Error(3, "tiered-store: failed to remove expired blob %q: %s", tieredMonthBlob(e.k, month), err)
+				break
+			}
+			tieredOffloadExpired.Inc()
+		}
+		t.Lock()
+		if from, ok := t.from[e.k]; ok && from < month {
+			t.from[e.k] = month
+		}
+		t.Unlock()
+	}
+}
+
+// deletePending removes the months offloaded in the previous run from the primary store.
+// months that fail to be deleted are retried in the next run.
+func (t *TieredStore) deletePending(ctx context.Context) {
+	t.Lock()
+	pending := t.pending
+	t.pending = nil
+	t.Unlock()
+
+	deleter, ok := t.primary.(monthDeleter)
+	if !ok {
+		// the chunks will remain in the primary store until they expire
+		return
+	}
+	var failed []tieredMonth
+	for _, p := range pending {
+		if err := deleter.DeleteMonth(ctx, p.key.Key, p.key.TTL, p.month); err != nil {
+			log.Error(3, "tiered-store: failed to delete offloaded month %d of %s from the primary store: %s", p.month, p.key.Key, err)
+			failed = append(failed, p)
+			continue
+		}
+		tieredOffloadPrimaryDeletes.Inc()
+	}
+	t.Lock()
+	t.pending = append(t.pending, failed...)
+	t.Unlock()
+}
+
+// encodeTieredManifest encodes, per ttl and key, the end of the last offloaded month as one line per key
+func encodeTieredManifest(ranges map[string]tieredRange) []byte {
+	var buf bytes.Buffer
+	for k, r := range ranges {
+		fmt.Fprintf(&buf, "%s %d %d\n", k, r.from, r.until)
+	}
+	return buf.Bytes()
+}
+
+func decodeTieredManifest(data []byte) (map[string]tieredRange, error) {
+	ranges := make(map[string]tieredRange)
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, errTieredBlobCorrupt
+		}
+		from, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, errTieredBlobCorrupt
+		}
+		until, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, errTieredBlobCorrupt
+		}
+		ranges[fields[0]] = tieredRange{uint32(from), uint32(until)}
+	}
+	return ranges, nil
+}
+
+// encodeTieredChunk appends the t0, size and data of the chunk to the blob.
+// the data is encoded the same way as in the primary stores.
+func encodeTieredChunk(buf *bytes.Buffer, itgen chunk.IterGen) {
+	data := itgen.Encode()
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], itgen.Ts)
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(len(data)))
+	buf.Write(hdr[:])
+	buf.Write(data)
+}
+
+func decodeTieredBlob(data []byte) ([]chunk.IterGen, error) {
+	if len(data) == 0 {
+		return nil, errTieredBlobCorrupt
+	}
+	if data[0] != tieredBlobVersion {
+		return nil, errTieredBlobVersion
+	}
+	data = data[1:]
+	itgens := make([]chunk.IterGen, 0)
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errTieredBlobCorrupt
+		}
+		ts := binary.LittleEndian.Uint32(data[0:4])
+		size := binary.LittleEndian.Uint32(data[4:8])
+		data = data[8:]
+		if uint32(len(data)) < size || size == 0 {
+			return nil, errTieredBlobCorrupt
+		}
+		itgen, err := chunk.NewGen(data[:size], ts)
+		if err != nil {
+			return nil, err
+		}
+		itgens = append(itgens, *itgen)
+		data = data[size:]
+	}
+	return itgens, nil
+}
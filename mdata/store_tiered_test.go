@@ -0,0 +1,236 @@
+package mdata
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/mdata/blob"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/test"
+)
+
+const day = 24 * 3600
+
+// monthMockStore is a MockStore that supports deleting single months
+type monthMockStore struct {
+	*MockStore
+}
+
+func (m monthMockStore) DeleteMonth(ctx context.Context, key string, ttl, month uint32) error {
+	var keep []chunk.IterGen
+	for _, itgen := range m.results[key] {
+		if itgen.Ts < month || itgen.Ts >= month+Month_sec {
+			keep = append(keep, itgen)
+		}
+	}
+	m.results[key] = keep
+	return nil
+}
+
+func addTieredTestChunk(store Store, key string, t0 uint32) {
+	c := chunk.New(t0)
+	c.Push(t0+1, float64(t0))
+	c.Finish()
+	cwr := NewChunkWriteRequest(nil, key, c, 10*Month_sec, day, time.Now())
+	store.Add(&cwr)
+}
+
+func searchTieredTestChunks(t *testing.T, store Store, desc string, start, end uint32, exp ...uint32) {
+	itgens, err := store.Search(test.NewContext(), "a", 10*Month_sec, start, end)
+	if err != nil {
+		t.Fatalf("%s: search %d-%d failed: %s", desc, start, end, err)
+	}
+	var got []uint32
+	for _, itgen := range itgens {
+		if itgen.Span != day {
+			t.Fatalf("%s: expected span %d, got %d", desc, day, itgen.Span)
+		}
+		it, err := itgen.Get()
+		if err != nil {
+			t.Fatalf("%s: failed to get iterator: %s", desc, err)
+		}
+		if !it.Next() {
+			t.Fatalf("%s: expected chunk %d to have a point", desc, itgen.Ts)
+		}
+		got = append(got, itgen.Ts)
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("%s: expected chunks %v, got %v", desc, exp, got)
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Fatalf("%s: expected chunks %v, got %v", desc, exp, got)
+		}
+	}
+}
+
+func TestTieredStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrictank-tiered-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blobs, err := blob.NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := monthMockStore{NewMockStore()}
+	var all []uint32
+	for month := uint32(0); month < 5; month++ {
+		for _, t0 := range []uint32{month * Month_sec, month*Month_sec + 10*day} {
+			addTieredTestChunk(primary, "a", t0)
+			all = append(all, t0)
+		}
+	}
+	store, err := NewTieredStore(primary, blobs, Month_sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := StoreKey{Key: "a", TTL: 10 * Month_sec, ChunkSpan: day}
+	now := uint32(5*Month_sec + 100)
+	store.now = func() uint32 { return now }
+
+	// a node that is running before the blobs are created
+	running, err := NewTieredStore(monthMockStore{NewMockStore()}, blobs, Month_sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running.now = store.now
+
+	// months 0 to 3 are older than the min age
+	store.Offload(test.NewContext(), []StoreKey{key}, now)
+	prefix := tieredKey("a", 10*Month_sec) + "/"
+	if names, _ := blobs.List(context.Background(), prefix); len(names) != 4 {
+		t.Fatalf("expected 4 blobs, got %v", names)
+	}
+	if names, _ := blobs.List(context.Background(), tieredManifestPrefix); len(names) != 1 {
+		t.Fatalf("expected 1 manifest, got %v", names)
+	}
+	if len(primary.results["a"]) != 10 {
+		t.Fatalf("expected the primary to keep the offloaded chunks until the next run, got %d chunks", len(primary.results["a"]))
+	}
+	searchTieredTestChunks(t, store, "all", 0, now, all...)
+	searchTieredTestChunks(t, store, "offloaded", Month_sec+5, Month_sec+10*day+1, Month_sec, Month_sec+10*day)
+	searchTieredTestChunks(t, store, "federated", 3*Month_sec+10*day+5, 4*Month_sec+10*day+1, 3*Month_sec+10*day, 4*Month_sec, 4*Month_sec+10*day)
+	searchTieredTestChunks(t, store, "recent", 4*Month_sec+5, now, 4*Month_sec, 4*Month_sec+10*day)
+
+	// the second run removes the offloaded chunks from the primary
+	store.Offload(test.NewContext(), []StoreKey{key}, now)
+	if len(primary.results["a"]) != 2 {
+		t.Fatalf("expected the primary to only keep the recent chunks, got %d chunks", len(primary.results["a"]))
+	}
+	searchTieredTestChunks(t, store, "all after primary delete", 0, now, all...)
+
+	// another node picks up the blobs
+	other, err := NewTieredStore(monthMockStore{NewMockStore()}, blobs, Month_sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.now = store.now
+	searchTieredTestChunks(t, other, "other node", 0, 4*Month_sec, all[:8]...)
+
+	// the running node picks up the blobs from the manifest, without listing them
+	listing := &listRecorder{Store: blobs}
+	running.blobs = listing
+	if err := running.refresh(test.NewContext()); err != nil {
+		t.Fatalf("refresh failed: %s", err)
+	}
+	if len(listing.prefixes) != 1 || listing.prefixes[0] != tieredManifestPrefix {
+		t.Fatalf("expected refresh to only list the manifests, got %q", listing.prefixes)
+	}
+	searchTieredTestChunks(t, running, "running node", 0, 4*Month_sec, all[:8]...)
+
+	running.pruneManifests(test.NewContext(), time.Now())
+	if names, _ := blobs.List(context.Background(), tieredManifestPrefix); len(names) != 0 {
+		t.Fatalf("expected no manifests after pruning, got %v", names)
+	}
+
+	if err := store.Delete(test.NewContext(), "a", 10*Month_sec); err != nil {
+		t.Fatalf("delete failed: %s", err)
+	}
+	if names, _ := blobs.List(context.Background(), ""); len(names) != 0 {
+		t.Fatalf("expected no blobs after delete, got %v", names)
+	}
+}
+
+func TestTieredStoreExpire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrictank-tiered-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blobs, err := blob.NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := monthMockStore{NewMockStore()}
+	var all []uint32
+	for month := uint32(0); month < 5; month++ {
+		for _, t0 := range []uint32{month * Month_sec, month*Month_sec + 10*day} {
+			addTieredTestChunk(primary, "a", t0)
+			all = append(all, t0)
+		}
+	}
+	store, err := NewTieredStore(primary, blobs, Month_sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := StoreKey{Key: "a", TTL: 10 * Month_sec, ChunkSpan: day}
+	now := uint32(5*Month_sec + 100)
+	store.now = func() uint32 { return now }
+
+	store.Offload(test.NewContext(), []StoreKey{key}, now)
+	prefix := tieredKey("a", 10*Month_sec) + "/"
+	if names, _ := blobs.List(context.Background(), prefix); len(names) != 4 {
+		t.Fatalf("expected 4 blobs, got %v", names)
+	}
+
+	// the data of months 0 and 1 passed the TTL, but their blobs are only removed by the next run
+	now = 12*Month_sec + 100
+	searchTieredTestChunks(t, store, "expired, not removed", 0, 4*Month_sec, all[4:8]...)
+
+	store.Offload(test.NewContext(), []StoreKey{key}, now)
+	names, _ := blobs.List(context.Background(), prefix)
+	exp := []string{tieredBlobName("a", 10*Month_sec, 2*Month_sec), tieredBlobName("a", 10*Month_sec, 3*Month_sec), tieredBlobName("a", 10*Month_sec, 4*Month_sec)}
+	if len(names) != len(exp) {
+		t.Fatalf("expected blobs %v, got %v", exp, names)
+	}
+	for i := range exp {
+		if names[i] != exp[i] {
+			t.Fatalf("expected blobs %v, got %v", exp, names)
+		}
+	}
+	if from := store.from[tieredKey("a", 10*Month_sec)]; from != 2*Month_sec {
+		t.Fatalf("expected the oldest month to be %d, got %d", 2*Month_sec, from)
+	}
+	searchTieredTestChunks(t, store, "expired and removed", 0, now, all[4:]...)
+
+	// another node starts with the remaining blobs, and the next run has nothing to remove
+	other, err := NewTieredStore(monthMockStore{NewMockStore()}, blobs, Month_sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from := other.from[tieredKey("a", 10*Month_sec)]; from != 2*Month_sec {
+		t.Fatalf("expected the other node to find oldest month %d, got %d", 2*Month_sec, from)
+	}
+	store.Offload(test.NewContext(), []StoreKey{key}, now)
+	if names, _ := blobs.List(context.Background(), prefix); len(names) != 3 {
+		t.Fatalf("expected 3 blobs, got %v", names)
+	}
+}
+
+// listRecorder is a blob store that records the prefixes listed
+type listRecorder struct {
+	blob.Store
+	prefixes []string
+}
+
+func (l *listRecorder) List(ctx context.Context, prefix string) ([]string, error) {
+	l.prefixes = append(l.prefixes, prefix)
+	return l.Store.List(ctx, prefix)
+}
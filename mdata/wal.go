@@ -0,0 +1,343 @@
+package mdata
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/worldping-api/pkg/log"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// the write-ahead log holds the metrics received by inputs that can't replay their data after a restart, like carbon.
+//
+// metrics are appended to the current segment, and the segment is flushed and synced to disk every sync interval.
+// once a segment reaches its max size, a new one is started.
+// a segment is removed once the chunks holding all of its points, including their rollups, have been saved.
+// (see AggMetrics.Persisted for how nodes that don't save the chunks themselves decide that)
+// at startup, all segments are replayed before the inputs start, to restore the data that was only held in memory.
+//
+// each record is the length and crc32 of its payload, followed by the payload: the partition and the msgp encoded metric.
+
+const (
+	walSegmentSuffix   = ".wal"
+	walRecordHeaderLen = 8
+	// how often we check whether segments can be removed
+	walTruncateInterval = time.Minute
+	// segments are only removed once they are closed for this long, so that the metrics
+	// appended just before closing them have made it into memory
+	walTruncateGrace = time.Minute
+)
+
+var (
+	errWALRecordCorrupt = errors.New("corrupt wal record")
+
+	// metric tank.wal.records is the number of metrics appended to the write-ahead log
+	walRecords = stats.NewCounter32("tank.wal.records")
+	// metric tank.wal.write_errors is the number of metrics that could not be appended to the write-ahead log
+	walWriteErrors = stats.NewCounter32("tank.wal.write_errors")
+	// metric tank.wal.replayed is the number of metrics replayed from the write-ahead log at startup
+	walReplayed = stats.NewCounter32("tank.wal.replayed")
+	// metric tank.wal.segments is the number of segments of the write-ahead log on disk
+	walSegments = stats.NewGauge32("tank.wal.segments")
+	// metric tank.wal.segments_removed is the number of write-ahead log segments removed because all their data was saved
+	walSegmentsRemoved = stats.NewCounter32("tank.wal.segments_removed")
+)
+
+// walSegment is a file of the write-ahead log
+type walSegment struct {
+	seq    uint64
+	path   string
+	keys   map[string]uint32 // the highest ts of each metric in the segment
+	closed time.Time
+}
+
+// WAL is a write-ahead log for incoming metrics
+type WAL struct {
+	sync.Mutex
+	dir          string
+	segmentSize  int64
+	syncInterval time.Duration
+	metrics      *AggMetrics
+
+	segments []*walSegment // closed segments, oldest first
+	current  *walSegment
+	file     *os.File
+	w        *bufio.Writer
+	size     int64
+	buf      []byte
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewWAL opens the write-ahead log in dir. existing segments must be replayed with Replay before calling Start.
+func NewWAL(dir string, segmentSize int64, syncInterval time.Duration, metrics *AggMetrics) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	w := &WAL{
+		dir:          dir,
+		segmentSize:  segmentSize,
+		syncInterval: syncInterval,
+		metrics:      metrics,
+		shutdown:     make(chan struct{}),
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), walSegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), walSegmentSuffix), 10, 64)
+		if err != nil {
+			log.Warn("wal: ignoring unexpected file %s", f.Name())
+			continue
+		}
+		w.segments = append(w.segments, &walSegment{
+			seq:    seq,
+			path:   filepath.Join(dir, f.Name()),
+			keys:   make(map[string]uint32),
+			closed: time.Now(),
+		})
+	}
+	sort.Slice(w.segments, func(i, j int) bool { return w.segments[i].seq < w.segments[j].seq })
+	walSegments.Set(len(w.segments))
+	return w, nil
+}
+
+// Replay passes all metrics in the existing segments to process, oldest first.
+// a corrupt record, typically the result of a crash while writing it, ends the replay of its segment.
+func (w *WAL) Replay(process func(md *schema.MetricData, partition int32)) error {
+	pre := time.Now()
+	total := 0
+	for _, seg := range w.segments {
+		n, err := replayWALSegment(seg, process)
+		total += n
+		if err == errWALRecordCorrupt {
+			log.Warn("wal: segment %s ends with a corrupt record, ignoring the rest of it", seg.path)
+		} else if err != nil {
+			return err
+		}
+	}
+	walReplayed.Add(total)
+	log.Info("wal: replayed %d metrics from %d segments. Took %s", total, len(w.segments), time.Since(pre))
+	return nil
+}
+
+func replayWALSegment(seg *walSegment, process func(md *schema.MetricData, partition int32)) (int, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var hdr [walRecordHeaderLen]byte
+	n := 0
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, errWALRecordCorrupt
+		}
+		payload := make([]byte, binary.LittleEndian.Uint32(hdr[0:4]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return n, errWALRecordCorrupt
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(hdr[4:8]) || len(payload) < 4 {
+			return n, errWALRecordCorrupt
+		}
+		partition := int32(binary.LittleEndian.Uint32(payload[0:4]))
+		md := &schema.MetricData{}
+		if _, err := md.UnmarshalMsg(payload[4:]); err != nil {
+			return n, errWALRecordCorrupt
+		}
+		seg.add(md)
+		process(md, partition)
+		n++
+	}
+}
+
+func (s *walSegment) add(md *schema.MetricData) {
+	ts := uint32(md.Time)
+	if ts > s.keys[md.Id] {
+		s.keys[md.Id] = ts
+	}
+}
+
+// Start opens a new segment and starts the background syncing and removal of segments
+func (w *WAL) Start() error {
+	w.Lock()
+	err := w.rotate()
+	w.Unlock()
+	if err != nil {
+		return err
+	}
+	w.wg.Add(1)
+	go w.run()
+	return nil
+}
+
+func (w *WAL) run() {
+	defer w.wg.Done()
+	syncTicker := time.NewTicker(w.syncInterval)
+	defer syncTicker.Stop()
+	truncateTicker := time.NewTicker(walTruncateInterval)
+	defer truncateTicker.Stop()
+	for {
+		select {
+		case <-w.shutdown:
+			return
+		case <-syncTicker.C:
+			w.Lock()
+			if err := w.sync(); err != nil {
+				log.Error(3, "wal: failed to sync segment %s: %s", w.current.path, err)
+			}
+			w.Unlock()
+		case <-truncateTicker.C:
+			w.truncate()
+		}
+	}
+}
+
+// Append adds the metric to the current segment. it is written to disk at the next sync.
+func (w *WAL) Append(md *schema.MetricData, partition int32) {
+	w.Lock()
+	defer w.Unlock()
+	if w.w == nil {
+		walWriteErrors.Inc()
+		return
+	}
+	var err error
+	w.buf = append(w.buf[:0], make([]byte, walRecordHeaderLen+4)...)
+	binary.LittleEndian.PutUint32(w.buf[walRecordHeaderLen:], uint32(partition))
+	w.buf, err = md.MarshalMsg(w.buf)
+	if err != nil {
+		log.Error(3, "wal: failed to encode metric %s: %s", md.Id, err)
+		walWriteErrors.Inc()
+		return
+	}
+	payload := w.buf[walRecordHeaderLen:]
+	binary.LittleEndian.PutUint32(w.buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(w.buf[4:8], crc32.ChecksumIEEE(payload))
+	if _, err := w.w.Write(w.buf); err != nil {
+		log.Error(3, "wal: failed to write to segment %s: %s", w.current.path, err)
+		walWriteErrors.Inc()
+		return
+	}
+	w.current.add(md)
+	w.size += int64(len(w.buf))
+	walRecords.Inc()
+	if w.size >= w.segmentSize {
+		if err := w.rotate(); err != nil {
+			log.Error(3, "wal: failed to start a new segment: %s", err)
+		}
+	}
+}
+
+// sync flushes and syncs the current segment. it must be called while holding the lock.
+func (w *WAL) sync() error {
+	if w.w == nil {
+		return nil
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// rotate closes the current segment, if any, and starts a new one. it must be called while holding the lock.
+func (w *WAL) rotate() error {
+	seq := uint64(0)
+	if w.current != nil {
+		seq = w.current.seq + 1
+		err := w.sync()
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Error(3, "wal: failed to close segment %s: %s", w.current.path, err)
+		}
+		w.current.closed = time.Now()
+		w.segments = append(w.segments, w.current)
+		w.current, w.file, w.w = nil, nil, nil
+	} else if len(w.segments) > 0 {
+		seq = w.segments[len(w.segments)-1].seq + 1
+	}
+
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d%s", seq, walSegmentSuffix))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w.current = &walSegment{
+		seq:  seq,
+		path: path,
+		keys: make(map[string]uint32),
+	}
+	w.file = f
+	w.w = bufio.NewWriter(f)
+	w.size = 0
+	walSegments.Set(len(w.segments) + 1)
+	return nil
+}
+
+// truncate removes the oldest closed segments for which all data has been saved
+func (w *WAL) truncate() {
+	for {
+		w.Lock()
+		if len(w.segments) == 0 || time.Since(w.segments[0].closed) < walTruncateGrace {
+			w.Unlock()
+			return
+		}
+		seg := w.segments[0]
+		w.Unlock()
+
+		for key, ts := range seg.keys {
+			if !w.metrics.Persisted(key, ts) {
+				return
+			}
+		}
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			log.Error(3, "wal: failed to remove segment %s: %s", seg.path, err)
+			return
+		}
+		log.Debug("wal: removed segment %s, all its data has been saved", seg.path)
+		walSegmentsRemoved.Inc()
+
+		w.Lock()
+		w.segments = w.segments[1:]
+		walSegments.Set(len(w.segments) + 1)
+		w.Unlock()
+	}
+}
+
+// Stop stops the background routines and syncs and closes the current segment
+func (w *WAL) Stop() {
+	close(w.shutdown)
+	w.wg.Wait()
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return
+	}
+	if err := w.sync(); err != nil {
+		log.Error(3, "wal: failed to sync segment %s: %s", w.current.path, err)
+	}
+	w.file.Close()
+	w.file, w.w = nil, nil
+}
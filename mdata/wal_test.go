@@ -0,0 +1,144 @@
+package mdata
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata/cache"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func replayTestWAL(t *testing.T, w *WAL) []schema.MetricData {
+	var got []schema.MetricData
+	err := w.Replay(func(md *schema.MetricData, partition int32) {
+		if partition != 3 {
+			t.Fatalf("expected partition 3, got %d", partition)
+		}
+		got = append(got, *md)
+	})
+	if err != nil {
+		t.Fatalf("replay failed: %s", err)
+	}
+	return got
+}
+
+func TestWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrictank-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+	metrics := NewAggMetrics(NewMockStore(), &cache.MockCache{}, false, 0, 0, 0)
+
+	w, err := NewWAL(dir, 1024*1024, time.Second, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := replayTestWAL(t, w); len(got) != 0 {
+		t.Fatalf("expected empty wal, got %v", got)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var exp []schema.MetricData
+	for i := 0; i < 3; i++ {
+		md := schema.MetricData{OrgId: 1, Name: "a.b", Metric: "a.b", Interval: 10, Value: float64(i), Time: int64(1000 + 100*i), Mtype: "gauge", Tags: []string{"a=b"}}
+		md.SetId()
+		w.Append(&md, 3)
+		exp = append(exp, md)
+	}
+	w.Stop()
+
+	// simulate a crash while writing a record
+	f, err := os.OpenFile(w.current.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{50, 0, 0, 0, 1, 2})
+	f.Close()
+
+	w, err = NewWAL(dir, 1024*1024, time.Second, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := replayTestWAL(t, w)
+	if len(got) != len(exp) {
+		t.Fatalf("expected %d metrics, got %d", len(exp), len(got))
+	}
+	for i := range exp {
+		if got[i].Id != exp[i].Id || got[i].Time != exp[i].Time || got[i].Value != exp[i].Value {
+			t.Fatalf("metric %d: expected %v, got %v", i, exp[i], got[i])
+		}
+	}
+
+	// the segment can't be removed until the chunk holding its last point, at 1200, has been saved
+	ret := conf.Retentions{conf.NewRetentionMT(10, 3600, 600, 2, true)}
+	metrics.Metrics[exp[0].Id] = NewAggMetric(metrics.store, metrics.cachePusher, exp[0].Id, ret, 0, 0, nil, false)
+	w.segments[0].closed = time.Now().Add(-2 * walTruncateGrace)
+	w.truncate()
+	if len(w.segments) != 1 {
+		t.Fatalf("expected the segment to be kept, got %d segments", len(w.segments))
+	}
+	metrics.Metrics[exp[0].Id].SyncChunkSaveState(600)
+	w.truncate()
+	if len(w.segments) != 1 {
+		t.Fatalf("expected the segment to be kept, got %d segments", len(w.segments))
+	}
+	metrics.Metrics[exp[0].Id].SyncChunkSaveState(1200)
+	w.truncate()
+	if len(w.segments) != 0 {
+		t.Fatalf("expected the segment to be removed, got %d segments", len(w.segments))
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Fatalf("expected no files left, got %d", len(files))
+	}
+}
+
+func TestWALSecondary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrictank-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(false)
+	chunkMaxStale := uint32(3600)
+	metrics := NewAggMetrics(NewMockStore(), &cache.MockCache{}, false, chunkMaxStale, 0, 0)
+
+	w, err := NewWAL(dir, 1024*1024, time.Second, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	ret := conf.Retentions{conf.NewRetentionMT(10, 86400, 600, 2, true)}
+	add := func(ts uint32) {
+		md := schema.MetricData{OrgId: 1, Name: "a.b", Metric: "a.b", Interval: 10, Value: 1, Time: int64(ts), Mtype: "gauge", Tags: []string{"a=b"}}
+		md.SetId()
+		if _, ok := metrics.Metrics[md.Id]; !ok {
+			metrics.Metrics[md.Id] = NewAggMetric(metrics.store, metrics.cachePusher, md.Id, ret, 0, 0, nil, false)
+		}
+		w.Append(&md, 3)
+		w.Lock()
+		w.rotate()
+		w.segments[len(w.segments)-1].closed = time.Now().Add(-2 * walTruncateGrace)
+		w.Unlock()
+	}
+	// without persist notifications, the chunks of a secondary are considered saved once the primary must have saved them
+	add(now - chunkMaxStale - 1200)
+	add(now)
+	w.truncate()
+	if len(w.segments) != 1 {
+		t.Fatalf("expected only the segment with recent data to be kept, got %d segments", len(w.segments))
+	}
+	w.Stop()
+}
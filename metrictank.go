@@ -27,7 +27,9 @@ import (
 	inCarbon "github.com/grafana/metrictank/input/carbon"
 	inKafkaMdm "github.com/grafana/metrictank/input/kafkamdm"
 	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/blob"
 	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/grafana/metrictank/mdata/notifierKafka"
 	"github.com/grafana/metrictank/mdata/notifierNsq"
 	"github.com/grafana/metrictank/stats"
@@ -54,11 +56,33 @@ var (
 	confFile    = flag.String("config", "/etc/metrictank/metrictank.ini", "configuration file path")
 
 	// Data:
-	dropFirstChunk    = flag.Bool("drop-first-chunk", false, "forego persisting of first received (and typically incomplete) chunk")
-	chunkMaxStaleStr  = flag.String("chunk-max-stale", "1h", "max age for a chunk before to be considered stale and to be persisted to Cassandra.")
-	metricMaxStaleStr = flag.String("metric-max-stale", "6h", "max age for a metric before to be considered stale and to be purged from memory.")
-	gcIntervalStr     = flag.String("gc-interval", "1h", "Interval to run garbage collection job.")
-	warmUpPeriodStr   = flag.String("warm-up-period", "1h", "duration before secondary nodes start serving requests")
+	dropFirstChunk      = flag.Bool("drop-first-chunk", false, "forego persisting of first received (and typically incomplete) chunk")
+	chunkMaxStaleStr    = flag.String("chunk-max-stale", "1h", "max age for a chunk before to be considered stale and to be persisted to Cassandra.")
+	metricMaxStaleStr   = flag.String("metric-max-stale", "6h", "max age for a metric before to be considered stale and to be purged from memory.")
+	gcIntervalStr       = flag.String("gc-interval", "1h", "Interval to run garbage collection job.")
+	backfillIntervalStr = flag.String("backfill-interval", "1min", "interval to merge the late points of metrics with backfill enabled into their chunks")
+	chunkIntervalInt    = flag.Bool("chunk-interval-int-format", false, "save chunks of integer values at a constant interval in the compact interval-int format. only enable once all nodes and tools have been upgraded to a version that can read it")
+	warmUpPeriodStr     = flag.String("warm-up-period", "1h", "duration before secondary nodes start serving requests")
+
+	ringbufferSnapshotFile      = flag.String("ringbuffer-snapshot-file", "", "file to save the in-memory data to on shutdown, and to restore it from on startup. empty disables it")
+	ringbufferSnapshotMaxAgeStr = flag.String("ringbuffer-snapshot-max-age", "10m", "don't restore snapshots older than this, but fill the ring buffers by consuming from the configured offset")
+
+	walDir             = flag.String("wal-dir", "", "directory for the write-ahead log of metrics received via carbon. empty disables it")
+	walSegmentSize     = flag.Int64("wal-segment-size", 64*1024*1024, "max size in bytes of a write-ahead log segment")
+	walSyncIntervalStr = flag.String("wal-sync-interval", "1s", "interval to sync the write-ahead log to disk at")
+
+	// Chunk store:
+	storeType                 = flag.String("store-type", "cassandra", "where to store chunks: cassandra or file")
+	fileStoreDir              = flag.String("file-store-dir", "/var/lib/metrictank/chunks", "directory to store chunks in, when using the file store")
+	fileStoreWriteConcurrency = flag.Int("file-store-write-concurrency", 4, "max number of concurrent writes to the file store")
+	fileStoreWriteQueueSize   = flag.Int("file-store-write-queue-size", 100000, "write queue size per file store worker")
+	fileStoreWindowFactor     = flag.Int("file-store-window-factor", 20, "size of the time windows stored per file, relative to TTL")
+
+	// Tiered storage:
+	tieredStoreEnabled     = flag.Bool("tiered-store-enabled", false, "offload old chunks from the chunk store to a blob store")
+	tieredStoreDir         = flag.String("tiered-store-dir", "/var/lib/metrictank/blobs", "directory to store the offloaded chunks in")
+	tieredStoreMinAgeStr   = flag.String("tiered-store-min-age", "90d", "only offload chunks of months that are entirely older than this")
+	tieredStoreIntervalStr = flag.String("tiered-store-offload-interval", "1h", "interval to offload old chunks at")
 
 	// Cassandra:
 	cassandraAddrs               = flag.String("cassandra-addrs", "localhost", "cassandra host (may be given multiple times as comma-separated list)")
@@ -158,6 +182,7 @@ func main() {
 	***********************************/
 	log.NewLogger(0, "console", fmt.Sprintf(`{"level": %d, "formatting":false}`, logLevel))
 	mdata.LogLevel = logLevel
+	chunk.IntervalIntFormat = *chunkIntervalInt
 	inKafkaMdm.LogLevel = logLevel
 	api.LogLevel = logLevel
 	// workaround for https://github.com/grafana/grafana/issues/4055
@@ -223,6 +248,8 @@ func main() {
 	chunkMaxStale := dur.MustParseNDuration("chunk-max-stale", *chunkMaxStaleStr)
 	metricMaxStale := dur.MustParseNDuration("metric-max-stale", *metricMaxStaleStr)
 	gcInterval := time.Duration(dur.MustParseNDuration("gc-interval", *gcIntervalStr)) * time.Second
+	backfillInterval := time.Duration(dur.MustParseNDuration("backfill-interval", *backfillIntervalStr)) * time.Second
+	walSyncInterval := time.Duration(dur.MustParseNDuration("wal-sync-interval", *walSyncIntervalStr)) * time.Second
 
 	proftrigFreq := dur.MustParseDuration("proftrigger-freq", *proftrigFreqStr)
 	proftrigMinDiff := int(dur.MustParseNDuration("proftrigger-min-diff", *proftrigMinDiffStr))
@@ -266,11 +293,39 @@ func main() {
 	/***********************************
 		Initialize our backendStore
 	***********************************/
-	store, err := mdata.NewCassandraStore(*cassandraAddrs, *cassandraKeyspace, *cassandraConsistency, *cassandraCaPath, *cassandraUsername, *cassandraPassword, *cassandraHostSelectionPolicy, *cassandraTimeout, *cassandraReadConcurrency, *cassandraWriteConcurrency, *cassandraReadQueueSize, *cassandraWriteQueueSize, *cassandraRetries, *cqlProtocolVersion, *cassandraWindowFactor, *cassandraOmitReadTimeout, *cassandraSSL, *cassandraAuth, *cassandraHostVerification, *cassandraCreateKeyspace, mdata.TTLs())
-	if err != nil {
-		log.Fatal(4, "failed to initialize cassandra. %s", err)
+	var store mdata.Store
+	switch *storeType {
+	case "cassandra":
+		cassandraStore, err := mdata.NewCassandraStore(*cassandraAddrs, *cassandraKeyspace, *cassandraConsistency, *cassandraCaPath, *cassandraUsername, *cassandraPassword, *cassandraHostSelectionPolicy, *cassandraTimeout, *cassandraReadConcurrency, *cassandraWriteConcurrency, *cassandraReadQueueSize, *cassandraWriteQueueSize, *cassandraRetries, *cqlProtocolVersion, *cassandraWindowFactor, *cassandraOmitReadTimeout, *cassandraSSL, *cassandraAuth, *cassandraHostVerification, *cassandraCreateKeyspace, mdata.TTLs())
+		if err != nil {
+			log.Fatal(4, "failed to initialize cassandra. %s", err)
+		}
+		cassandraStore.SetTracer(tracer)
+		store = cassandraStore
+	case "file":
+		fileStore, err := mdata.NewFileStore(*fileStoreDir, *fileStoreWriteConcurrency, *fileStoreWriteQueueSize, *fileStoreWindowFactor, mdata.TTLs())
+		if err != nil {
+			log.Fatal(4, "failed to initialize file store. %s", err)
+		}
+		fileStore.SetTracer(tracer)
+		store = fileStore
+	default:
+		log.Fatal(4, "unknown store-type %q", *storeType)
+	}
+	var tieredStore *mdata.TieredStore
+	if *tieredStoreEnabled {
+		blobs, err := blob.NewDirStore(*tieredStoreDir)
+		if err != nil {
+			log.Fatal(4, "failed to initialize blob store. %s", err)
+		}
+		minAge := dur.MustParseNDuration("tiered-store-min-age", *tieredStoreMinAgeStr)
+		tieredStore, err = mdata.NewTieredStore(store, blobs, minAge)
+		if err != nil {
+			log.Fatal(4, "failed to initialize tiered store. %s", err)
+		}
+		tieredStore.SetTracer(tracer)
+		store = tieredStore
 	}
-	store.SetTracer(tracer)
 
 	/***********************************
 		Initialize the Chunk Cache
@@ -283,6 +338,13 @@ func main() {
 	***********************************/
 	metrics = mdata.NewAggMetrics(store, ccache, *dropFirstChunk, chunkMaxStale, metricMaxStale, gcInterval)
 
+	for _, schema := range mdata.Schemas.List() {
+		if schema.Backfill {
+			go mdata.NewBackfiller(metrics, ccache, tracer, backfillInterval).Run()
+			break
+		}
+	}
+
 	/***********************************
 		Initialize our Inputs
 	***********************************/
@@ -353,6 +415,21 @@ func main() {
 	}
 	log.Info("metricIndex initialized in %s. starting data consumption", time.Now().Sub(pre))
 
+	if tieredStore != nil {
+		interval := time.Duration(dur.MustParseNDuration("tiered-store-offload-interval", *tieredStoreIntervalStr)) * time.Second
+		go tieredStore.Run(interval, func() []mdata.StoreKey {
+			var keys []mdata.StoreKey
+			for _, archive := range metricIndex.List(-1) {
+				// only the primary of the partition offloads its chunks
+				if !cluster.Manager.IsPrimaryFor(archive.Partition) {
+					continue
+				}
+				keys = append(keys, mdata.StoreKeys(archive.Id, archive.SchemaId, archive.AggId)...)
+			}
+			return keys
+		})
+	}
+
 	/***********************************
 		Initialize MetricPerrist notifiers
 	***********************************/
@@ -369,14 +446,44 @@ func main() {
 
 	mdata.InitPersistNotifier(handlers...)
 
+	/***********************************
+		Restore the ring buffer snapshot
+	***********************************/
+	restored := false
+	if *ringbufferSnapshotFile != "" {
+		maxAge := time.Duration(dur.MustParseNDuration("ringbuffer-snapshot-max-age", *ringbufferSnapshotMaxAgeStr)) * time.Second
+		restored = restoreSnapshot(*ringbufferSnapshotFile, maxAge, inputs)
+	}
+
+	/***********************************
+		Replay the write-ahead log
+	***********************************/
+	var wal *mdata.WAL
+	if *walDir != "" {
+		wal, err = mdata.NewWAL(*walDir, *walSegmentSize, walSyncInterval, metrics)
+		if err != nil {
+			log.Fatal(4, "failed to open write-ahead log: %s", err)
+		}
+		if err := wal.Replay(input.NewDefaultHandler(metrics, metricIndex, "wal").Process); err != nil {
+			log.Fatal(4, "failed to replay write-ahead log: %s", err)
+		}
+		if err := wal.Start(); err != nil {
+			log.Fatal(4, "failed to start write-ahead log: %s", err)
+		}
+	}
+
 	/***********************************
 		Start our inputs
 	***********************************/
 	for _, plugin := range inputs {
+		var handler input.Handler = input.NewDefaultHandler(metrics, metricIndex, plugin.Name())
 		if carbonPlugin, ok := plugin.(*inCarbon.Carbon); ok {
 			carbonPlugin.IntervalGetter(inCarbon.NewIndexIntervalGetter(metricIndex))
+			if wal != nil {
+				handler = input.NewWALHandler(handler, wal)
+			}
 		}
-		plugin.Start(input.NewDefaultHandler(metrics, metricIndex, plugin.Name()))
+		plugin.Start(handler)
 		plugin.MaintainPriority()
 	}
 
@@ -391,7 +498,7 @@ func main() {
 		Set our status so we can accept
 		requests from users.
 	***********************************/
-	if cluster.Manager.IsPrimary() {
+	if cluster.Manager.IsPrimary() || restored {
 		cluster.Manager.SetReady()
 	} else {
 		time.AfterFunc(warmupPeriod, cluster.Manager.SetReady)
@@ -430,8 +537,19 @@ func main() {
 	select {
 	case <-timer.C:
 		log.Warn("Plugins taking too long to shutdown, not waiting any longer.")
+		if *ringbufferSnapshotFile != "" {
+			log.Warn("not saving ring buffer snapshot, as the inputs may still be adding data")
+		}
 	case <-pluginsStopped:
 		timer.Stop()
+		if *ringbufferSnapshotFile != "" {
+			saveSnapshot(*ringbufferSnapshotFile, inputs)
+		}
+	}
+
+	if wal != nil {
+		log.Info("closing write-ahead log")
+		wal.Stop()
 	}
 
 	log.Info("closing store")
@@ -441,3 +559,61 @@ func main() {
 	log.Close()
 
 }
+
+// saveSnapshot saves the in-memory data, along with the offsets of the kafka-mdm input, so we can restore them at startup.
+// the inputs must have been stopped.
+func saveSnapshot(path string, inputs []input.Plugin) {
+	pre := time.Now()
+	var offsets []mdata.PartitionOffset
+	for _, plugin := range inputs {
+		if kafkaPlugin, ok := plugin.(*inKafkaMdm.KafkaMdm); ok {
+			offsets = kafkaPlugin.Offsets()
+		}
+	}
+	snap := metrics.Snapshot(offsets)
+	snap.Time = pre.Unix()
+	if err := mdata.WriteSnapshot(path, &snap); err != nil {
+		log.Error(3, "failed to save ring buffer snapshot to %s: %s", path, err)
+		return
+	}
+	log.Info("saved ring buffer snapshot with %d metrics to %s. Took %s", len(snap.Metrics), path, time.Since(pre))
+}
+
+// restoreSnapshot restores the in-memory data from the snapshot and makes the kafka-mdm input resume from the
+// offsets in the snapshot. the snapshot is removed, as it is only valid once.
+// it returns whether the data was restored.
+func restoreSnapshot(path string, maxAge time.Duration, inputs []input.Plugin) bool {
+	pre := time.Now()
+	snap, err := mdata.ReadSnapshot(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Info("no ring buffer snapshot found at %s", path)
+		} else {
+			log.Error(3, "failed to read ring buffer snapshot %s: %s", path, err)
+		}
+		return false
+	}
+	if err := os.Remove(path); err != nil {
+		log.Error(3, "failed to remove ring buffer snapshot %s: %s", path, err)
+	}
+	age := pre.Sub(time.Unix(snap.Time, 0))
+	if snap.Version != mdata.SnapshotVersion {
+		log.Warn("ignoring ring buffer snapshot with unsupported version %d", snap.Version)
+		return false
+	}
+	if age > maxAge {
+		log.Info("ignoring ring buffer snapshot taken %s ago, it is older than %s", age, maxAge)
+		return false
+	}
+	for _, plugin := range inputs {
+		if kafkaPlugin, ok := plugin.(*inKafkaMdm.KafkaMdm); ok {
+			kafkaPlugin.SetStartOffsets(snap.Offsets)
+		}
+	}
+	num := metrics.Restore(snap, func(key string) (uint16, uint16, int32, int, bool) {
+		archive, ok := metricIndex.Get(key)
+		return archive.SchemaId, archive.AggId, archive.Partition, archive.Interval, ok
+	})
+	log.Info("restored %d of %d metrics from ring buffer snapshot taken %s ago. Took %s", num, len(snap.Metrics), age, time.Since(pre))
+	return true
+}
@@ -0,0 +1,279 @@
+// Package sketch implements a mergeable quantile sketch, used for the percentile rollups.
+//
+// values are counted in buckets of exponentially increasing size, like in DDSketch:
+// the bucket with index i holds the values between gamma^(i-1) and gamma^i, so that any quantile is estimated
+// with a relative error of at most RelativeAccuracy. because the buckets are the same for every sketch,
+// merging sketches is as simple as adding up their buckets, and the merged sketch is as accurate as a sketch
+// of all the values would have been. this is what makes consolidating percentiles possible, unlike averaging them.
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// RelativeAccuracy is the maximum relative error of the estimated quantiles.
+// it is part of the serialization format: changing it makes previously saved sketches unreadable.
+const RelativeAccuracy = 0.01
+
+// maxBuckets is the maximum number of buckets for positive and for negative values.
+// when exceeded, the buckets of the smallest absolute values are collapsed, which only affects the low quantiles
+// of series with values ranging over more than 17 orders of magnitude.
+const maxBuckets = 2048
+
+// minValue is the smallest absolute value that is not counted as zero
+const minValue = 1e-9
+
+var (
+	gamma    = (1 + RelativeAccuracy) / (1 - RelativeAccuracy)
+	logGamma = math.Log(gamma)
+
+	errCorrupt = errors.New("corrupt sketch")
+)
+
+// Sketch estimates the quantiles of the values added to it. not concurrency safe.
+type Sketch struct {
+	pos   buckets // positive values
+	neg   buckets // negative values, by their absolute value
+	zero  uint64
+	count uint64
+	min   float64
+	max   float64
+}
+
+// New returns an empty sketch
+func New() *Sketch {
+	return &Sketch{
+		min: math.Inf(1),
+		max: math.Inf(-1),
+	}
+}
+
+func index(v float64) int32 {
+	return int32(math.Ceil(math.Log(v) / logGamma))
+}
+
+// value returns the value that represents bucket i, which is within RelativeAccuracy of all values in it
+func value(i int32) float64 {
+	return 2 * math.Pow(gamma, float64(i)) / (gamma + 1)
+}
+
+// Add adds a value. NaN values are ignored
+func (s *Sketch) Add(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	switch {
+	case v > minValue:
+		s.pos.add(index(v), 1)
+	case v < -minValue:
+		s.neg.add(index(-v), 1)
+	default:
+		s.zero++
+	}
+	s.count++
+	s.min = math.Min(s.min, v)
+	s.max = math.Max(s.max, v)
+}
+
+// Merge adds all values of o to s
+func (s *Sketch) Merge(o *Sketch) {
+	for i, c := range o.pos.counts {
+		if c != 0 {
+			s.pos.add(o.pos.offset+int32(i), c)
+		}
+	}
+	for i, c := range o.neg.counts {
+		if c != 0 {
+			s.neg.add(o.neg.offset+int32(i), c)
+		}
+	}
+	s.zero += o.zero
+	s.count += o.count
+	s.min = math.Min(s.min, o.min)
+	s.max = math.Max(s.max, o.max)
+}
+
+// Count returns the number of values added
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Quantile returns the estimated value at quantile q, which must be between 0 and 1.
+// it returns NaN if the sketch is empty.
+// the value returned is the one with rank floor(q * (count-1)) amongst the sorted values.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+	rank := uint64(q * float64(s.count-1))
+	var v float64
+	var seen uint64
+	found := false
+	// negative values, from the largest absolute value down
+	for i := len(s.neg.counts) - 1; i >= 0 && !found; i-- {
+		seen += s.neg.counts[i]
+		if seen > rank {
+			v, found = -value(s.neg.offset+int32(i)), true
+		}
+	}
+	if !found {
+		seen += s.zero
+		if seen > rank {
+			v, found = 0, true
+		}
+	}
+	for i := 0; i < len(s.pos.counts) && !found; i++ {
+		seen += s.pos.counts[i]
+		if seen > rank {
+			v, found = value(s.pos.offset+int32(i)), true
+		}
+	}
+	// the estimate can't be outside of the values we have seen
+	return math.Max(s.min, math.Min(s.max, v))
+}
+
+// Marshal returns the binary representation of the sketch
+func (s *Sketch) Marshal() []byte {
+	b := make([]byte, 16, 16+binary.MaxVarintLen64+s.pos.size()+s.neg.size())
+	binary.BigEndian.PutUint64(b, math.Float64bits(s.min))
+	binary.BigEndian.PutUint64(b[8:], math.Float64bits(s.max))
+	b = appendUvarint(b, s.zero)
+	b = s.pos.marshal(b)
+	return s.neg.marshal(b)
+}
+
+// Unmarshal returns the sketch of the binary representation returned by Marshal
+func Unmarshal(b []byte) (*Sketch, error) {
+	if len(b) < 16 {
+		return nil, errCorrupt
+	}
+	s := &Sketch{
+		min: math.Float64frombits(binary.BigEndian.Uint64(b)),
+		max: math.Float64frombits(binary.BigEndian.Uint64(b[8:])),
+	}
+	b = b[16:]
+	var n int
+	s.zero, n = binary.Uvarint(b)
+	if n <= 0 {
+		return nil, errCorrupt
+	}
+	b = b[n:]
+	b, err := s.pos.unmarshal(b)
+	if err != nil {
+		return nil, err
+	}
+	b, err = s.neg.unmarshal(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 0 {
+		return nil, errCorrupt
+	}
+	s.count = s.zero + s.pos.total() + s.neg.total()
+	return s, nil
+}
+
+// buckets holds the counts of a contiguous range of bucket indexes, starting at offset
+type buckets struct {
+	offset int32
+	counts []uint64
+}
+
+func (b *buckets) add(i int32, c uint64) {
+	if len(b.counts) == 0 {
+		b.offset = i
+		b.counts = append(b.counts, c)
+		return
+	}
+	if i < b.offset {
+		if int(b.offset-i)+len(b.counts) > maxBuckets {
+			// collapse into the lowest bucket we have
+			b.counts[0] += c
+			return
+		}
+		grown := make([]uint64, int(b.offset-i)+len(b.counts))
+		copy(grown[b.offset-i:], b.counts)
+		b.counts = grown
+		b.offset = i
+	}
+	for int(i-b.offset) >= len(b.counts) {
+		b.counts = append(b.counts, 0)
+	}
+	b.counts[i-b.offset] += c
+	if len(b.counts) > maxBuckets {
+		// collapse the lowest buckets
+		excess := len(b.counts) - maxBuckets
+		for _, c := range b.counts[:excess] {
+			b.counts[excess] += c
+		}
+		b.counts = append(b.counts[:0], b.counts[excess:]...)
+		b.offset += int32(excess)
+	}
+}
+
+func (b *buckets) total() uint64 {
+	var total uint64
+	for _, c := range b.counts {
+		total += c
+	}
+	return total
+}
+
+// size returns the upper bound of the size of the binary representation
+func (b *buckets) size() int {
+	return (2 + len(b.counts)) * binary.MaxVarintLen64
+}
+
+// marshal appends the offset, the number of buckets and their counts as varints
+func (b *buckets) marshal(buf []byte) []byte {
+	buf = appendVarint(buf, int64(b.offset))
+	buf = appendUvarint(buf, uint64(len(b.counts)))
+	for _, c := range b.counts {
+		buf = appendUvarint(buf, c)
+	}
+	return buf
+}
+
+func (b *buckets) unmarshal(buf []byte) ([]byte, error) {
+	offset, n := binary.Varint(buf)
+	if n <= 0 || offset < math.MinInt32 || offset > math.MaxInt32 {
+		return nil, errCorrupt
+	}
+	buf = buf[n:]
+	num, n := binary.Uvarint(buf)
+	if n <= 0 || num > maxBuckets || num > uint64(len(buf)) {
+		return nil, errCorrupt
+	}
+	buf = buf[n:]
+	b.offset = int32(offset)
+	b.counts = nil
+	if num > 0 {
+		b.counts = make([]uint64, num)
+	}
+	for i := range b.counts {
+		b.counts[i], n = binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, errCorrupt
+		}
+		buf = buf[n:]
+	}
+	return buf, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
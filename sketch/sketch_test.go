@@ -0,0 +1,146 @@
+package sketch
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func exactQuantile(sorted []float64, q float64) float64 {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func checkQuantiles(t *testing.T, name string, s *Sketch, values []float64) {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	for _, q := range []float64{0, 0.01, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 0.999, 1} {
+		exp := exactQuantile(sorted, q)
+		got := s.Quantile(q)
+		if math.Abs(got-exp) > RelativeAccuracy*math.Abs(exp)+1e-9 {
+			t.Fatalf("%s: expected quantile %f to be within %f of %f, got %f", name, q, RelativeAccuracy, exp, got)
+		}
+	}
+}
+
+func datasets() map[string][]float64 {
+	r := rand.New(rand.NewSource(1))
+	sets := make(map[string][]float64)
+	var latency, uniform, mixed []float64
+	for i := 0; i < 10000; i++ {
+		latency = append(latency, math.Exp(r.NormFloat64()+3))
+		uniform = append(uniform, r.Float64()*1000)
+		mixed = append(mixed, r.NormFloat64()*100)
+	}
+	sets["latency"] = latency
+	sets["uniform"] = uniform
+	sets["mixed-sign"] = append(mixed, 0, 0, 0)
+	sets["single"] = []float64{42}
+	sets["constant"] = []float64{7, 7, 7, 7}
+	return sets
+}
+
+func TestQuantile(t *testing.T) {
+	for name, values := range datasets() {
+		s := New()
+		for _, v := range values {
+			s.Add(v)
+		}
+		s.Add(math.NaN())
+		if s.Count() != uint64(len(values)) {
+			t.Fatalf("%s: expected count %d, got %d", name, len(values), s.Count())
+		}
+		checkQuantiles(t, name, s, values)
+	}
+	if q := New().Quantile(0.5); !math.IsNaN(q) {
+		t.Fatalf("expected the quantile of an empty sketch to be NaN, got %f", q)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	for name, values := range datasets() {
+		all := New()
+		merged := New()
+		// sketches of consecutive chunks of values, like the sketches of consecutive rollup points
+		for i := 0; i < len(values); i += 100 {
+			part := New()
+			for _, v := range values[i:int(math.Min(float64(i+100), float64(len(values))))] {
+				part.Add(v)
+				all.Add(v)
+			}
+			merged.Merge(part)
+		}
+		if !reflect.DeepEqual(merged, all) {
+			t.Fatalf("%s: expected merged sketch to equal the sketch of all values", name)
+		}
+		checkQuantiles(t, name, merged, values)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	for name, values := range datasets() {
+		s := New()
+		for _, v := range values {
+			s.Add(v)
+		}
+		got, err := Unmarshal(s.Marshal())
+		if err != nil {
+			t.Fatalf("%s: failed to unmarshal: %s", name, err)
+		}
+		if !reflect.DeepEqual(got, s) {
+			t.Fatalf("%s: expected unmarshaled sketch to equal the original", name)
+		}
+	}
+	data := New().Marshal()
+	if s, err := Unmarshal(data); err != nil || s.Count() != 0 {
+		t.Fatalf("expected an empty sketch, got %v, %v", s, err)
+	}
+	s := New()
+	s.Add(1)
+	s.Add(-1000)
+	data = s.Marshal()
+	for i := 0; i < len(data); i++ {
+		if _, err := Unmarshal(data[:i]); err == nil {
+			t.Fatalf("expected truncating the data to %d bytes to result in an error", i)
+		}
+	}
+}
+
+func TestMaxBuckets(t *testing.T) {
+	s := New()
+	values := []float64{1e-8, 1e12, 0.5}
+	for _, v := range values {
+		s.Add(v)
+	}
+	if len(s.pos.counts) > maxBuckets {
+		t.Fatalf("expected at most %d buckets, got %d", maxBuckets, len(s.pos.counts))
+	}
+	// the lowest value is collapsed into a bigger bucket, the others are still accurate
+	if got := s.Quantile(1); got != 1e12 {
+		t.Fatalf("expected the max to be 1e12, got %f", got)
+	}
+	if got := s.Quantile(0.5); math.Abs(got-0.5) > RelativeAccuracy*0.5 {
+		t.Fatalf("expected the median to be close to 0.5, got %f", got)
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	values := datasets()["latency"]
+	s := New()
+	for i := 0; i < b.N; i++ {
+		s.Add(values[i%len(values)])
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	s := New()
+	for _, v := range datasets()["latency"] {
+		s.Add(v)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Unmarshal(s.Marshal())
+	}
+	b.ReportMetric(float64(len(s.Marshal())), "bytes")
+}